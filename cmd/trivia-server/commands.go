@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/api/auth"
+)
+
+// commandServices are the services that commands run from the command line can use.
+type commandServices struct {
+	tokenJanitor *auth.TokenJanitor
+	users        trivia.UserService
+}
+
+type command struct {
+	usage       string
+	description string
+	run         func(args []string, services *commandServices) int
+}
+
+var commands = map[string]command{
+	"grant-admin": {
+		usage:       "grant-admin <username>",
+		description: "Gives a user the admin role, which is how the first admin is made.",
+		run:         runGrantAdmin,
+	},
+	"prune-tokens": {
+		usage:       "prune-tokens",
+		description: "Deletes every expired auth and refresh token.",
+		run:         runPruneTokens,
+	},
+	"rekey-passwords": {
+		usage:       "rekey-passwords [batch size]",
+		description: "Re-encrypts every stored password that isn't encrypted with the current pepper key.",
+		run:         runRekeyPasswords,
+	},
+}
+
+// runCommand runs a command given on the command line and returns the code that the program
+// should exit with.
+func runCommand(name string, args []string, services *commandServices) int {
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\ncommands:\n", name)
+		names := make([]string, 0, len(commands))
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", commands[n].usage, commands[n].description)
+		}
+		return 2
+	}
+	return cmd.run(args, services)
+}
+
+func runPruneTokens(args []string, services *commandServices) int {
+	authDeleted, refreshDeleted, err := services.tokenJanitor.Prune()
+	fmt.Printf("pruned %d expired auth tokens and %d expired refresh tokens\n", authDeleted, refreshDeleted)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error occurred while pruning tokens: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runRekeyPasswords(args []string, services *commandServices) int {
+	batchSize := auth.DefaultRekeyBatchSize
+	if len(args) > 0 {
+		size, err := strconv.Atoi(args[0])
+		if err != nil || size <= 0 {
+			fmt.Fprintf(os.Stderr, "batch size must be a positive integer\n")
+			return 2
+		}
+		batchSize = size
+	}
+
+	rekeyed, failed, err := auth.RekeyPasswords(services.users, batchSize)
+	fmt.Printf("re-encrypted %d passwords\n", rekeyed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error occurred while re-encrypting passwords: %s\n", err)
+		return 1
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d passwords are encrypted with pepper keys that aren't configured\n", failed)
+		return 1
+	}
+	return 0
+}
+
+func runGrantAdmin(args []string, services *commandServices) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: grant-admin <username>\n")
+		return 2
+	}
+
+	user, err := services.users.UserByUsername(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error occurred while finding user: %s\n", err)
+		return 1
+	}
+	if user == nil {
+		fmt.Fprintf(os.Stderr, "no user with the username %s\n", args[0])
+		return 1
+	}
+
+	if err = services.users.SetUserRole(user.ID, trivia.RoleAdmin); err != nil {
+		fmt.Fprintf(os.Stderr, "error occurred while granting admin: %s\n", err)
+		return 1
+	}
+	fmt.Printf("%s (%d) is now an admin\n", user.Username, user.ID)
+	return 0
+}
@@ -1,14 +1,28 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/api/auth"
+	"github.com/expixel/actual-trivia-server/trivia/game"
+	"github.com/expixel/actual-trivia-server/trivia/mail"
+	"github.com/expixel/actual-trivia-server/trivia/oidc"
+	"github.com/expixel/actual-trivia-server/trivia/postgres"
+	"github.com/expixel/actual-trivia-server/trivia/xp"
 )
 
 var configPathFlag = flag.String("config", "", "The location of the config file. If this argument is not provided the paths './trivia-config.json' and './config/trivia-config.json' are searched in that order.")
@@ -24,13 +38,62 @@ type triviaConfig struct {
 	} `json:"db"`
 
 	Auth struct {
-		Pepper256 string `json:"pepper256"`
+		Pepper256                 string            `json:"pepper256"`
+		PepperKeys                map[string]string `json:"pepperKeys"`
+		PepperVersion             string            `json:"pepperVersion"`
+		Argon2Memory              string            `json:"argon2Memory"`
+		Argon2Time                string            `json:"argon2Time"`
+		Argon2Threads             string            `json:"argon2Threads"`
+		AuthTokenLifetime         string            `json:"authTokenLifetime"`
+		RefreshTokenLifetime      string            `json:"refreshTokenLifetime"`
+		TokenPruneInterval        string            `json:"tokenPruneInterval"`
+		TokenPruneBatchSize       string            `json:"tokenPruneBatchSize"`
+		PasswordResetURL          string            `json:"passwordResetURL"`
+		PasswordResetLifetime     string            `json:"passwordResetLifetime"`
+		EmailVerificationURL      string            `json:"emailVerificationURL"`
+		EmailVerificationLifetime string            `json:"emailVerificationLifetime"`
+		UsernameChangeInterval    string            `json:"usernameChangeInterval"`
+		UsernameReservation       string            `json:"usernameReservation"`
+		RecentLoginWindow         string            `json:"recentLoginWindow"`
+		LoginLockoutFailures      string            `json:"loginLockoutFailures"`
+		LoginLockoutDuration      string            `json:"loginLockoutDuration"`
+		LoginAttemptStore         string            `json:"loginAttemptStore"`
+		TOTPIssuer                string            `json:"totpIssuer"`
 	} `json:"auth"`
 
+	Matchmaking struct {
+		RequireVerifiedEmail string `json:"requireVerifiedEmail"`
+	} `json:"matchmaking"`
+
+	OIDC struct {
+		Providers []struct {
+			Name         string `json:"name"`
+			Issuer       string `json:"issuer"`
+			ClientID     string `json:"clientID"`
+			ClientSecret string `json:"clientSecret"`
+			RedirectURL  string `json:"redirectURL"`
+			Scopes       string `json:"scopes"`
+		} `json:"providers"`
+	} `json:"oidc"`
+
+	Mail struct {
+		From         string `json:"from"`
+		SMTPAddr     string `json:"smtpAddr"`
+		SMTPUsername string `json:"smtpUsername"`
+		SMTPPassword string `json:"smtpPassword"`
+		OutboxDir    string `json:"outboxDir"`
+	} `json:"mail"`
+
 	Server struct {
 		Addr            string `json:"addr"`
 		ShutdownTimeout string `json:"shutdownTimeout"`
 	} `json:"server"`
+
+	XP struct {
+		LevelBase     string `json:"levelBase"`
+		LevelExponent string `json:"levelExponent"`
+		SoloDailyCap  string `json:"soloDailyCap"`
+	} `json:"xp"`
 }
 
 func loadConfig() *triviaConfig {
@@ -111,6 +174,366 @@ func requireStringValue(s string, def string, errString string) string {
 	return "" // picnic
 }
 
+// setPepperKeys installs the pepper keys that passwords are encrypted with from the config. pepper256
+// is the key of version 0, which passwords stored before keys had versions are encrypted with, and
+// pepperKeys holds any other versions. New passwords are encrypted with pepperVersion, which is the
+// highest version if it isn't set.
+func setPepperKeys(config *triviaConfig) {
+	keys := make(map[byte]string, len(config.Auth.PepperKeys)+1)
+	if key, ok := getStringValue(config.Auth.Pepper256); ok {
+		keys[0] = key
+	}
+
+	current := -1
+	for v, key := range config.Auth.PepperKeys {
+		version, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || version < 1 || version > 255 {
+			log.Fatalf("auth.pepperKeys versions must be integers from 1 to 255 (got %q).", v)
+		}
+		keys[byte(version)] = strings.TrimSpace(key)
+		if version > current {
+			current = version
+		}
+	}
+	if len(keys) == 0 {
+		log.Fatal("auth.pepper256 or auth.pepperKeys must contain a unique AES key.")
+	}
+	if current < 0 {
+		current = 0
+	}
+
+	if s, ok := getStringValue(config.Auth.PepperVersion); ok {
+		version, err := strconv.Atoi(s)
+		if err != nil || version < 0 || version > 255 {
+			log.Fatal("auth.pepperVersion must be an integer from 0 to 255.")
+		}
+		current = version
+	}
+
+	if err := auth.SetPepperKeyring(keys, byte(current)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// setArgon2Params sets the parameters that new passwords are hashed with from the config. argon2Memory
+// is in KiB.
+func setArgon2Params(config *triviaConfig) {
+	params := auth.DefaultArgon2Params()
+
+	if s, ok := getStringValue(config.Auth.Argon2Memory); ok {
+		memory, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			log.Fatal("auth.argon2Memory must be a positive integer number of KiB.")
+		}
+		params.Memory = uint32(memory)
+	}
+
+	if s, ok := getStringValue(config.Auth.Argon2Time); ok {
+		passes, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			log.Fatal("auth.argon2Time must be a positive integer.")
+		}
+		params.Time = uint32(passes)
+	}
+
+	if s, ok := getStringValue(config.Auth.Argon2Threads); ok {
+		threads, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			log.Fatal("auth.argon2Threads must be an integer from 1 to 255.")
+		}
+		params.Threads = uint8(threads)
+	}
+
+	if err := auth.SetArgon2Params(params); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// tokenLifetimes returns the lifetimes of auth and refresh tokens from the config. Lifetimes are
+// durations like "15m" or "720h" and default to auth.DefaultTokenLifetimes.
+func tokenLifetimes(config *triviaConfig) auth.TokenLifetimes {
+	lifetimes := auth.DefaultTokenLifetimes()
+
+	if s, ok := getStringValue(config.Auth.AuthTokenLifetime); ok {
+		lifetime, err := time.ParseDuration(s)
+		if err != nil || lifetime <= 0 {
+			log.Fatal("auth.authTokenLifetime must be a valid duration greater than 0.")
+		}
+		lifetimes.Auth = lifetime
+	}
+
+	if s, ok := getStringValue(config.Auth.RefreshTokenLifetime); ok {
+		lifetime, err := time.ParseDuration(s)
+		if err != nil || lifetime <= 0 {
+			log.Fatal("auth.refreshTokenLifetime must be a valid duration greater than 0.")
+		}
+		lifetimes.Refresh = lifetime
+	}
+
+	if lifetimes.Refresh < lifetimes.Auth {
+		log.Fatal("auth.refreshTokenLifetime cannot be shorter than auth.authTokenLifetime.")
+	}
+	return lifetimes
+}
+
+// tokenPruneSettings returns how often expired tokens are pruned and how many are deleted
+// from each table in a single batch.
+func tokenPruneSettings(config *triviaConfig) (time.Duration, int) {
+	interval := auth.DefaultPruneInterval
+	batchSize := auth.DefaultPruneBatchSize
+
+	if s, ok := getStringValue(config.Auth.TokenPruneInterval); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			log.Fatal("auth.tokenPruneInterval must be a valid duration greater than 0.")
+		}
+		interval = d
+	}
+
+	if s, ok := getStringValue(config.Auth.TokenPruneBatchSize); ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			log.Fatal("auth.tokenPruneBatchSize must be a valid number greater than 0.")
+		}
+		batchSize = n
+	}
+	return interval, batchSize
+}
+
+// xpSettings returns the level curve and XP rewards from the config. Anything that isn't
+// configured uses the defaults.
+func xpSettings(config *triviaConfig) (xp.Curve, xp.Rewards) {
+	curve := xp.DefaultCurve()
+	rewards := xp.DefaultRewards()
+
+	if s, ok := getStringValue(config.XP.LevelBase); ok {
+		base, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			log.Fatal("xp.levelBase must be a valid number.")
+		}
+		curve.Base = base
+	}
+
+	if s, ok := getStringValue(config.XP.LevelExponent); ok {
+		exponent, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			log.Fatal("xp.levelExponent must be a valid number.")
+		}
+		curve.Exponent = exponent
+	}
+
+	if !curve.Valid() {
+		log.Fatal("xp.levelBase and xp.levelExponent must be greater than 0.")
+	}
+
+	if s, ok := getStringValue(config.XP.SoloDailyCap); ok {
+		soloDailyCap, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || soloDailyCap < 0 {
+			log.Fatal("xp.soloDailyCap must be a valid number that is not negative.")
+		}
+		rewards.SoloDailyCap = soloDailyCap
+	}
+	return curve, rewards
+}
+
+// passwordResetOptions returns the options used for password resets from the config.
+func passwordResetOptions(config *triviaConfig) auth.PasswordResetOptions {
+	options := auth.DefaultPasswordResetOptions()
+	options.URL = requireStringValue(config.Auth.PasswordResetURL, "", "auth.passwordResetURL cannot be empty.")
+
+	if u, err := url.Parse(options.URL); err != nil || !u.IsAbs() {
+		log.Fatal("auth.passwordResetURL must be a valid absolute URL.")
+	}
+
+	if s, ok := getStringValue(config.Auth.PasswordResetLifetime); ok {
+		lifetime, err := time.ParseDuration(s)
+		if err != nil || lifetime <= 0 {
+			log.Fatal("auth.passwordResetLifetime must be a valid duration greater than 0.")
+		}
+		options.Lifetime = lifetime
+	}
+	return options
+}
+
+// emailVerificationOptions returns the options used for verifying email addresses from the config.
+func emailVerificationOptions(config *triviaConfig) auth.EmailVerificationOptions {
+	options := auth.DefaultEmailVerificationOptions()
+	options.URL = requireStringValue(config.Auth.EmailVerificationURL, "", "auth.emailVerificationURL cannot be empty.")
+
+	if u, err := url.Parse(options.URL); err != nil || !u.IsAbs() {
+		log.Fatal("auth.emailVerificationURL must be a valid absolute URL.")
+	}
+
+	if s, ok := getStringValue(config.Auth.EmailVerificationLifetime); ok {
+		lifetime, err := time.ParseDuration(s)
+		if err != nil || lifetime <= 0 {
+			log.Fatal("auth.emailVerificationLifetime must be a valid duration greater than 0.")
+		}
+		options.Lifetime = lifetime
+	}
+	return options
+}
+
+// accountOptions returns the options used for changing account details from the config.
+func accountOptions(config *triviaConfig) auth.AccountOptions {
+	options := auth.DefaultAccountOptions()
+
+	if s, ok := getStringValue(config.Auth.UsernameChangeInterval); ok {
+		interval, err := time.ParseDuration(s)
+		if err != nil || interval < 0 {
+			log.Fatal("auth.usernameChangeInterval must be a valid duration that is not negative.")
+		}
+		options.UsernameChangeInterval = interval
+	}
+
+	if s, ok := getStringValue(config.Auth.UsernameReservation); ok {
+		reservation, err := time.ParseDuration(s)
+		if err != nil || reservation < 0 {
+			log.Fatal("auth.usernameReservation must be a valid duration that is not negative.")
+		}
+		options.UsernameReservation = reservation
+	}
+
+	if s, ok := getStringValue(config.Auth.RecentLoginWindow); ok {
+		window, err := time.ParseDuration(s)
+		if err != nil || window < 0 {
+			log.Fatal("auth.recentLoginWindow must be a valid duration that is not negative.")
+		}
+		options.RecentLoginWindow = window
+	}
+	return options
+}
+
+// loginThrottleOptions returns the options used for limiting failed logins from the config.
+func loginThrottleOptions(config *triviaConfig) auth.LoginThrottleOptions {
+	options := auth.DefaultLoginThrottleOptions()
+
+	if s, ok := getStringValue(config.Auth.LoginLockoutFailures); ok {
+		failures, err := strconv.Atoi(s)
+		if err != nil || failures <= options.Account.FreeFailures {
+			log.Fatalf("auth.loginLockoutFailures must be an integer greater than %d.", options.Account.FreeFailures)
+		}
+		options.Account.LockoutFailures = failures
+	}
+
+	if s, ok := getStringValue(config.Auth.LoginLockoutDuration); ok {
+		duration, err := time.ParseDuration(s)
+		if err != nil || duration <= 0 || duration > options.ResetAfter {
+			log.Fatalf("auth.loginLockoutDuration must be a positive duration of at most %s.", options.ResetAfter)
+		}
+		options.Account.LockoutDuration = duration
+	}
+	return options
+}
+
+// loginAttemptService returns the store that failed logins are counted in. Counting them in memory
+// only works with a single server, so servers that share a database should use the postgres store.
+func loginAttemptService(config *triviaConfig, db *sql.DB) trivia.LoginAttemptService {
+	store := requireStringValue(config.Auth.LoginAttemptStore, "memory", "")
+	switch store {
+	case "memory":
+		return auth.NewMemoryLoginAttemptService()
+	case "postgres":
+		return postgres.NewLoginAttemptService(db)
+	default:
+		log.Fatal("auth.loginAttemptStore must be memory or postgres.")
+		return nil
+	}
+}
+
+// twoFactorOptions returns the options used for two-factor authentication from the config.
+func twoFactorOptions(config *triviaConfig) auth.TwoFactorOptions {
+	options := auth.DefaultTwoFactorOptions()
+	options.Issuer = requireStringValue(config.Auth.TOTPIssuer, options.Issuer, "")
+	if strings.Contains(options.Issuer, ":") {
+		log.Fatal("auth.totpIssuer must not contain a colon.")
+	}
+	return options
+}
+
+// matchmakingOptions returns the options used for the matchmaking queue from the config.
+func matchmakingOptions(config *triviaConfig) game.MatchmakingOptions {
+	options := game.DefaultMatchmakingOptions()
+
+	if s, ok := getStringValue(config.Matchmaking.RequireVerifiedEmail); ok {
+		require, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatal("matchmaking.requireVerifiedEmail must be true or false.")
+		}
+		options.RequireVerifiedEmail = require
+	}
+	return options
+}
+
+// oidcProviderNamePattern matches the names that OpenID Connect providers can have. The names are
+// used in URLs.
+var oidcProviderNamePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// oidcProviderTimeout is how long requests to OpenID Connect providers can take.
+const oidcProviderTimeout = 10 * time.Second
+
+// oidcProviders creates the OpenID Connect providers that users can log in with from the config.
+func oidcProviders(config *triviaConfig) []*oidc.Provider {
+	client := &http.Client{Timeout: oidcProviderTimeout}
+	providers := make([]*oidc.Provider, 0, len(config.OIDC.Providers))
+	names := make(map[string]bool)
+
+	for idx, p := range config.OIDC.Providers {
+		prefix := fmt.Sprintf("oidc.providers[%d]", idx)
+		name := requireStringValue(p.Name, "", prefix+".name cannot be empty.")
+		if !oidcProviderNamePattern.MatchString(name) {
+			log.Fatalf("%s.name can only contain the characters a-z, 0-9, and -.", prefix)
+		}
+		if names[name] {
+			log.Fatalf("%s.name %s is used by more than one provider.", prefix, name)
+		}
+		names[name] = true
+
+		issuer := requireStringValue(p.Issuer, "", prefix+".issuer cannot be empty.")
+		if u, err := url.Parse(issuer); err != nil || u.Scheme != "https" || u.RawQuery != "" || u.Fragment != "" {
+			log.Fatalf("%s.issuer must be an https URL without a query or fragment.", prefix)
+		}
+		redirectURL := requireStringValue(p.RedirectURL, "", prefix+".redirectURL cannot be empty.")
+		if u, err := url.Parse(redirectURL); err != nil || !u.IsAbs() {
+			log.Fatalf("%s.redirectURL must be a valid absolute URL.", prefix)
+		}
+
+		var scopes []string
+		if s, ok := getStringValue(p.Scopes); ok {
+			scopes = strings.Fields(s)
+		}
+
+		providers = append(providers, oidc.NewProvider(oidc.Config{
+			Name:         name,
+			Issuer:       issuer,
+			ClientID:     requireStringValue(p.ClientID, "", prefix+".clientID cannot be empty."),
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+		}, client))
+	}
+	return providers
+}
+
+// newMailer creates the mailer described by the config. Emails are sent through an SMTP server if
+// mail.smtpAddr is set and are otherwise written to mail.outboxDir, or the log if that isn't set either.
+func newMailer(config *triviaConfig) mail.Mailer {
+	from := requireStringValue(config.Mail.From, "", "mail.from cannot be empty.")
+
+	if addr, ok := getStringValue(config.Mail.SMTPAddr); ok {
+		username, _ := getStringValue(config.Mail.SMTPUsername)
+		return mail.NewSMTPMailer(addr, username, config.Mail.SMTPPassword, from)
+	}
+
+	outboxDir, _ := getStringValue(config.Mail.OutboxDir)
+	mailer, err := mail.NewOutboxMailer(outboxDir, from)
+	if err != nil {
+		log.Fatal("error creating mail outbox: ", err)
+	}
+	log.Println("mail.smtpAddr is not set, emails will not be sent.")
+	return mailer
+}
+
 func escapeDBValue(unescaped string) string {
 	escaped := unescaped
 	quoteString := false
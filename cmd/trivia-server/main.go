@@ -13,12 +13,19 @@ import (
 	"time"
 
 	"github.com/expixel/actual-trivia-server/eplog"
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/achievement"
+	"github.com/expixel/actual-trivia-server/trivia/api/admin"
 	"github.com/expixel/actual-trivia-server/trivia/api/auth"
+	"github.com/expixel/actual-trivia-server/trivia/api/daily"
+	"github.com/expixel/actual-trivia-server/trivia/api/leaderboard"
 	"github.com/expixel/actual-trivia-server/trivia/api/profile"
 	"github.com/expixel/actual-trivia-server/trivia/game"
 	"github.com/expixel/actual-trivia-server/trivia/postgres/migrations"
 
 	"github.com/expixel/actual-trivia-server/trivia/postgres"
+	"github.com/expixel/actual-trivia-server/trivia/rating"
+	"github.com/expixel/actual-trivia-server/trivia/xp"
 	_ "github.com/lib/pq"
 )
 
@@ -81,12 +88,8 @@ func main() {
 		return
 	}
 
-	authPepper256, ok := getStringValue(config.Auth.Pepper256)
-	if ok {
-		auth.SetAESKeyHex(authPepper256)
-	} else {
-		panic("Woah there! Need a unique AES Key.")
-	}
+	setPepperKeys(config)
+	setArgon2Params(config)
 
 	mgSuccess := migrations.RunMigrations(db)
 	if !mgSuccess {
@@ -100,17 +103,78 @@ func main() {
 	// ## services
 	userService := postgres.NewUserService(db)
 	tokenService := postgres.NewTokenService(db)
+	pruneInterval, pruneBatchSize := tokenPruneSettings(config)
+	tokenJanitor := auth.NewTokenJanitor(tokenService, pruneInterval, pruneBatchSize)
+
+	// commands given on the command line are run instead of starting the server.
+	if flag.NArg() > 0 {
+		code := runCommand(flag.Arg(0), flag.Args()[1:], &commandServices{tokenJanitor: tokenJanitor, users: userService})
+		eplog.Stop()
+		eplog.WaitForStop()
+		os.Exit(code)
+		return
+	}
+
 	questionService := postgres.NewQuestionService(db)
-	authService := auth.NewService(userService, tokenService)
+	dailyService := postgres.NewDailyService(db, questionService)
+	scheduledGameService := postgres.NewScheduledGameService(db)
+	ratingService := postgres.NewRatingService(db)
+	matchmaking := matchmakingOptions(config)
+	ratingUpdater := rating.NewUpdater(ratingService, matchmaking.RequireVerifiedEmail)
+	gameResultService := postgres.NewGameResultService(db)
+	leaderboardService := postgres.NewLeaderboardService(db)
+	leaderboardRefresher := leaderboard.NewRefresher(leaderboardService)
+	gamesSet := game.NewGameSet(tokenService, questionService)
+	twoFactorAuth := auth.NewTwoFactorAuth(userService, postgres.NewTwoFactorService(db), postgres.NewLoginChallengeService(db),
+		twoFactorOptions(config))
+	authService := auth.NewService(userService, tokenService, gameResultService, tokenLifetimes(config), gamesSet, twoFactorAuth)
+	mailer := newMailer(config)
+	passwordResetter := auth.NewPasswordResetter(userService, postgres.NewPasswordResetService(db), authService, mailer, passwordResetOptions(config))
+	emailVerifier := auth.NewEmailVerifier(userService, postgres.NewEmailVerificationService(db), mailer, emailVerificationOptions(config))
+	accountManager := auth.NewAccountManager(userService, authService, accountOptions(config))
+	oidcLogin := auth.NewOIDCLogin(oidcProviders(config), userService, postgres.NewExternalIdentityService(db), authService)
+	loginThrottle := auth.NewLoginThrottle(loginAttemptService(config, db), userService, mailer, loginThrottleOptions(config))
+	gamesSet.OnGameFinished(ratingUpdater.ProcessGameResult)
+	achievementService := postgres.NewAchievementService(db)
+	achievementEngine := achievement.NewEngine(achievement.Definitions, achievementService, gameResultService, gamesSet)
+	xpCurve, xpRewards := xpSettings(config)
+	xpAwarder := xp.NewAwarder(postgres.NewXPService(db), xpCurve, xpRewards, gamesSet)
+	gamesSet.OnGameFinished(func(result *trivia.GameResult) {
+		if err := gameResultService.StoreGameResult(result); err != nil {
+			eplog.Error("results", "error occurred while storing result of game %s: %s", result.GameID, err)
+			return
+		}
+
+		// achievements are evaluated against totals that include the stored result.
+		unlocked := achievementEngine.ProcessGameResult(result)
+
+		achievements := make(map[int64]int, len(unlocked))
+		for userID, definitions := range unlocked {
+			achievements[userID] = len(definitions)
+		}
+		xpAwarder.ProcessGameResult(result, achievements)
+	})
+	scheduler := game.NewScheduler(gamesSet, scheduledGameService, game.SystemClock)
+	matchmaker := game.NewMatchmaker(gamesSet, ratingUpdater.MatchmakingRating, game.SystemClock, matchmaking)
 
 	// ## handlers
-	authHandler := auth.NewHandler(authService)
-	profileHandler := profile.NewHandler(userService, tokenService)
-	gameHandler := game.NewHandler(tokenService, questionService)
+	authHandler := auth.NewHandler(authService, tokenService, passwordResetter, emailVerifier, accountManager, oidcLogin, loginThrottle,
+		twoFactorAuth)
+	profileHandler := profile.NewHandler(userService, tokenService, dailyService, gameResultService, achievementService, ratingUpdater, xpAwarder,
+		accountManager)
+	gameHandler := game.NewHandler(gamesSet, scheduledGameService, matchmaker, gameResultService, game.SystemClock)
+	dailyHandler := daily.NewHandler(dailyService, questionService, tokenService, gamesSet)
+	leaderboardHandler := leaderboard.NewHandler(leaderboardService, tokenService)
+	adminHandler := admin.NewHandler(userService, questionService, tokenService, gamesSet)
 	r := http.NewServeMux()
 	r.Handle("/v1/auth/", withLogging(authHandler))
 	r.Handle("/v1/profile/", withLogging(profileHandler))
 	r.Handle("/v1/game/", withLogging(gameHandler))
+	r.Handle("/v1/daily", withLogging(dailyHandler))
+	r.Handle("/v1/daily/", withLogging(dailyHandler))
+	r.Handle("/v1/leaderboard", withLogging(leaderboardHandler))
+	r.Handle("/v1/leaderboard/", withLogging(leaderboardHandler))
+	r.Handle("/v1/admin/", withLogging(adminHandler))
 
 	server := &http.Server{
 		Addr:         requireStringValue(config.Server.Addr, "0.0.0.0:8080", "server.addr cannot be empty"),
@@ -125,6 +189,11 @@ func main() {
 		log.Fatal("server.shutdownTimeout must be a valid number.")
 	}
 
+	scheduler.Start()
+	matchmaker.Start()
+	leaderboardRefresher.Start()
+	tokenJanitor.Start()
+
 	go func() {
 		log.Println("starting server...")
 		if err := server.ListenAndServe(); err != nil {
@@ -147,6 +216,14 @@ func main() {
 	log.Println("shutting down...")
 	log.Println("waiting for connections...")
 	server.Shutdown(ctx)
+	log.Println("stopping scheduler...")
+	scheduler.Stop()
+	log.Println("stopping matchmaker...")
+	matchmaker.Stop()
+	log.Println("stopping leaderboard refresher...")
+	leaderboardRefresher.Stop()
+	log.Println("stopping token janitor...")
+	tokenJanitor.Stop()
 	log.Println("shutting down eplog...")
 	eplog.Stop()
 	eplog.WaitForStop()
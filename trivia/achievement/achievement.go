@@ -0,0 +1,190 @@
+package achievement
+
+import (
+	"github.com/expixel/actual-trivia-server/eplog"
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/game/message"
+)
+
+var logger = eplog.NewPrefixLogger("achievement")
+
+// Definition declares a single achievement and the rule that has to be met to unlock it.
+type Definition struct {
+	// ID identifies the achievement in storage so it must never change once the achievement
+	// has been awarded to anyone.
+	ID          string
+	Name        string
+	Description string
+	Rule        Rule
+}
+
+// Context is everything known about a single participant's finished game while their
+// achievements are being evaluated. Totals are loaded lazily so that rules which only
+// look at the game itself don't cost any queries.
+type Context struct {
+	Result      *trivia.GameResult
+	Participant *trivia.GameParticipantResult
+
+	results trivia.GameResultService
+	stats   *trivia.UserStats
+
+	// recent holds the participant's most recent games and recentLimit is the limit that
+	// was used to load them.
+	recent      []trivia.MatchHistoryEntry
+	recentLimit int
+
+	// err is the first error that occurred while loading totals.
+	err error
+}
+
+// Stats returns the participant's totals including the finished game.
+func (ctx *Context) Stats() *trivia.UserStats {
+	if ctx.stats == nil {
+		stats, err := ctx.results.UserStats(ctx.Participant.UserID)
+		if err != nil {
+			ctx.fail(err)
+			stats = &trivia.UserStats{}
+		}
+		ctx.stats = stats
+	}
+	return ctx.stats
+}
+
+// RecentGames returns up to n of the participant's most recently finished games starting with
+// the finished game.
+func (ctx *Context) RecentGames(n int) []trivia.MatchHistoryEntry {
+	if n > ctx.recentLimit {
+		recent, err := ctx.results.MatchHistory(ctx.Participant.UserID, n, 0)
+		if err != nil {
+			ctx.fail(err)
+			return nil
+		}
+		ctx.recent = recent
+		ctx.recentLimit = n
+	}
+
+	if len(ctx.recent) > n {
+		return ctx.recent[:n]
+	}
+	return ctx.recent
+}
+
+func (ctx *Context) fail(err error) {
+	if ctx.err == nil {
+		ctx.err = err
+	}
+}
+
+// Lookup returns the definition of a built in achievement using its ID. This returns nil if there
+// is no such achievement.
+func Lookup(id string) *Definition {
+	for _, d := range Definitions {
+		if d.ID == id {
+			return d
+		}
+	}
+	return nil
+}
+
+// A Notifier sends messages to users that are connected to a game.
+type Notifier interface {
+	SendToUser(gameID string, userID int64, msg interface{}) error
+}
+
+// Engine evaluates achievements for the participants of finished games and awards the ones
+// that they unlock.
+type Engine struct {
+	definitions  []*Definition
+	achievements trivia.AchievementService
+	results      trivia.GameResultService
+	notifier     Notifier
+}
+
+// NewEngine creates a new engine that awards the given achievements. Unlocked achievements are
+// sent to the participants of a game using the notifier.
+func NewEngine(definitions []*Definition, achievements trivia.AchievementService, results trivia.GameResultService,
+	notifier Notifier) *Engine {
+	return &Engine{
+		definitions:  definitions,
+		achievements: achievements,
+		results:      results,
+		notifier:     notifier,
+	}
+}
+
+// ProcessGameResult awards achievements to the registered participants of a finished game and lets
+// them know what they unlocked. The result must already be stored so that the totals used by
+// rules include it. This returns the achievements each user unlocked. Errors are only logged.
+func (e *Engine) ProcessGameResult(result *trivia.GameResult) map[int64][]*Definition {
+	unlockedByUser := make(map[int64][]*Definition)
+	for idx := range result.Participants {
+		participant := &result.Participants[idx]
+		if participant.Guest {
+			continue
+		}
+
+		unlocked, err := e.Award(result, participant)
+		if err != nil {
+			logger.Error("error occurred while awarding achievements to user %d for game %s: %s",
+				participant.UserID, result.GameID, err)
+			continue
+		}
+
+		if len(unlocked) == 0 {
+			continue
+		}
+		unlockedByUser[participant.UserID] = unlocked
+
+		if e.notifier == nil {
+			continue
+		}
+
+		msg := &message.AchievementsUnlocked{Achievements: make([]message.Achievement, len(unlocked))}
+		for i, d := range unlocked {
+			msg.Achievements[i] = message.Achievement{ID: d.ID, Name: d.Name, Description: d.Description}
+		}
+
+		// the participant might have already left the game which is fine since they'll
+		// still see their new badges on their profile.
+		e.notifier.SendToUser(result.GameID, participant.UserID, msg)
+	}
+	return unlockedByUser
+}
+
+// Award evaluates every achievement for a participant of a finished game and awards the ones whose
+// rules are met. This returns the achievements that the participant did not already have.
+func (e *Engine) Award(result *trivia.GameResult, participant *trivia.GameParticipantResult) ([]*Definition, error) {
+	ctx := &Context{Result: result, Participant: participant, results: e.results}
+
+	met := make([]string, 0)
+	for _, d := range e.definitions {
+		if d.Rule(ctx) {
+			met = append(met, d.ID)
+		}
+	}
+
+	// nothing is awarded from partial totals since a rule may have failed because of them.
+	if ctx.err != nil {
+		return nil, ctx.err
+	}
+
+	if len(met) == 0 {
+		return nil, nil
+	}
+
+	awarded, err := e.achievements.AwardAchievements(participant.UserID, met, result.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	unlocked := make([]*Definition, 0, len(awarded))
+	for _, d := range e.definitions {
+		for _, id := range awarded {
+			if d.ID == id {
+				unlocked = append(unlocked, d)
+				break
+			}
+		}
+	}
+	return unlocked, nil
+}
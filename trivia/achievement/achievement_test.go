@@ -0,0 +1,199 @@
+package achievement
+
+import (
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/game/message"
+)
+
+type fakeResultService struct {
+	trivia.GameResultService
+	stats        map[int64]*trivia.UserStats
+	history      map[int64][]trivia.MatchHistoryEntry
+	statsQueries int
+}
+
+func (s *fakeResultService) UserStats(userID int64) (*trivia.UserStats, error) {
+	s.statsQueries++
+	if stats, ok := s.stats[userID]; ok {
+		return stats, nil
+	}
+	return &trivia.UserStats{}, nil
+}
+
+func (s *fakeResultService) MatchHistory(userID int64, limit int, offset int) ([]trivia.MatchHistoryEntry, error) {
+	history := s.history[userID]
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	return history, nil
+}
+
+type fakeAchievementService struct {
+	trivia.AchievementService
+	awarded map[int64]map[string]bool
+}
+
+func (s *fakeAchievementService) AwardAchievements(userID int64, achievementIDs []string, awardedAt time.Time) ([]string, error) {
+	if s.awarded[userID] == nil {
+		s.awarded[userID] = make(map[string]bool)
+	}
+
+	awarded := make([]string, 0)
+	for _, id := range achievementIDs {
+		if !s.awarded[userID][id] {
+			s.awarded[userID][id] = true
+			awarded = append(awarded, id)
+		}
+	}
+	return awarded, nil
+}
+
+type fakeNotifier struct {
+	sent map[int64][]interface{}
+}
+
+func (n *fakeNotifier) SendToUser(gameID string, userID int64, msg interface{}) error {
+	n.sent[userID] = append(n.sent[userID], msg)
+	return nil
+}
+
+func wins(n int) []trivia.MatchHistoryEntry {
+	history := make([]trivia.MatchHistoryEntry, n)
+	for idx := range history {
+		history[idx] = trivia.MatchHistoryEntry{Placement: 1, ParticipantCount: 2}
+	}
+	return history
+}
+
+func newTestContext(results *fakeResultService, participant int) *Context {
+	result := &trivia.GameResult{
+		GameID:        "game",
+		QuestionCount: 10,
+		FinishedAt:    time.Now(),
+		Participants: []trivia.GameParticipantResult{
+			{UserID: 1, Username: "winner", Placement: 1, CorrectAnswers: 10, FastestCorrectAnswer: 800 * time.Millisecond},
+			{UserID: 2, Username: "loser", Placement: 2, CorrectAnswers: 4, FastestCorrectAnswer: 3 * time.Second},
+		},
+	}
+	return &Context{Result: result, Participant: &result.Participants[participant], results: results}
+}
+
+func TestRules(t *testing.T) {
+	results := &fakeResultService{
+		stats: map[int64]*trivia.UserStats{
+			1: {GamesPlayed: 12, Wins: 5, Categories: []trivia.CategoryStats{{Category: "Science", Questions: 120}}},
+			2: {GamesPlayed: 1, Categories: []trivia.CategoryStats{{Category: "Music", Questions: 4}}},
+		},
+		history: map[int64][]trivia.MatchHistoryEntry{
+			1: append(wins(3), trivia.MatchHistoryEntry{Placement: 2, ParticipantCount: 2}),
+		},
+	}
+
+	tests := []struct {
+		name        string
+		rule        Rule
+		participant int
+		expected    bool
+	}{
+		{"games played", GamesPlayed(10), 0, true},
+		{"too few games played", GamesPlayed(10), 1, false},
+		{"first win", Wins(1), 0, true},
+		{"no wins", Wins(1), 1, false},
+		{"perfect game", PerfectGame(10), 0, true},
+		{"perfect game too short", PerfectGame(20), 0, false},
+		{"not a perfect game", PerfectGame(10), 1, false},
+		{"win streak", WinStreak(3), 0, true},
+		{"broken win streak", WinStreak(4), 0, false},
+		{"win streak after a loss", WinStreak(1), 1, false},
+		{"any category", CategoryQuestions("", 100), 0, true},
+		{"named category", CategoryQuestions("Science", 100), 0, true},
+		{"other category", CategoryQuestions("Music", 100), 0, false},
+		{"fast answer", CorrectAnswerWithin(time.Second), 0, true},
+		{"slow answer", CorrectAnswerWithin(time.Second), 1, false},
+		{"all", All(Wins(1), PerfectGame(10)), 0, true},
+		{"not all", All(Wins(1), PerfectGame(10)), 1, false},
+	}
+
+	for _, test := range tests {
+		ctx := newTestContext(results, test.participant)
+		if met := test.rule(ctx); met != test.expected {
+			t.Errorf("%s: expected %t but got %t", test.name, test.expected, met)
+		}
+		if ctx.err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, ctx.err)
+		}
+	}
+}
+
+func TestStatsAreOnlyLoadedOnce(t *testing.T) {
+	results := &fakeResultService{}
+	ctx := newTestContext(results, 1)
+	GamesPlayed(1)(ctx)
+	Wins(3)(ctx)
+	CategoryQuestions("", 10)(ctx)
+
+	if results.statsQueries != 1 {
+		t.Errorf("expected stats to be loaded once but they were loaded %d times", results.statsQueries)
+	}
+}
+
+func TestProcessGameResult(t *testing.T) {
+	results := &fakeResultService{
+		stats: map[int64]*trivia.UserStats{
+			1: {GamesPlayed: 1, Wins: 1},
+			2: {GamesPlayed: 1},
+		},
+	}
+	achievements := &fakeAchievementService{awarded: make(map[int64]map[string]bool)}
+	notifier := &fakeNotifier{sent: make(map[int64][]interface{})}
+	definitions := []*Definition{
+		{ID: "first-game", Rule: GamesPlayed(1)},
+		{ID: "first-win", Rule: Wins(1)},
+	}
+	engine := NewEngine(definitions, achievements, results, notifier)
+
+	result := newTestContext(results, 0).Result
+	result.Participants = append(result.Participants, trivia.GameParticipantResult{UserID: -3, Guest: true, Placement: 3})
+	unlocked := engine.ProcessGameResult(result)
+	if len(unlocked[1]) != 2 || len(unlocked[2]) != 1 {
+		t.Errorf("expected 2 achievements for the winner and 1 for the loser but got %v", unlocked)
+	}
+
+	if len(notifier.sent[1]) != 1 || len(notifier.sent[2]) != 1 {
+		t.Fatalf("expected one message for each registered participant: %v", notifier.sent)
+	}
+	if len(notifier.sent[-3]) != 0 || achievements.awarded[-3] != nil {
+		t.Errorf("expected guests to not be awarded achievements")
+	}
+
+	msg := notifier.sent[1][0].(*message.AchievementsUnlocked)
+	if len(msg.Achievements) != 2 || msg.Achievements[0].ID != "first-game" || msg.Achievements[1].ID != "first-win" {
+		t.Errorf("unexpected achievements unlocked by the winner: %+v", msg.Achievements)
+	}
+
+	// achievements that were already awarded aren't unlocked again.
+	engine.ProcessGameResult(result)
+	if len(notifier.sent[1]) != 1 || len(notifier.sent[2]) != 1 {
+		t.Errorf("expected no messages for achievements that were already unlocked: %v", notifier.sent)
+	}
+}
+
+func TestDefinitionIDsAreUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, d := range Definitions {
+		if d.ID == "" || d.Name == "" || d.Rule == nil {
+			t.Errorf("incomplete achievement definition: %+v", d)
+		}
+		if seen[d.ID] {
+			t.Errorf("duplicate achievement ID: %s", d.ID)
+		}
+		seen[d.ID] = true
+
+		if Lookup(d.ID) != d {
+			t.Errorf("expected to find achievement %s by its ID", d.ID)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package achievement
+
+import "time"
+
+// Definitions are all of the achievements that can be unlocked by players. Adding an achievement
+// only requires adding a definition here; IDs must be unique and can never be reused.
+var Definitions = []*Definition{
+	{
+		ID:          "first-game",
+		Name:        "First Steps",
+		Description: "Finish your first game.",
+		Rule:        GamesPlayed(1),
+	},
+	{
+		ID:          "games-100",
+		Name:        "Regular",
+		Description: "Finish 100 games.",
+		Rule:        GamesPlayed(100),
+	},
+	{
+		ID:          "first-win",
+		Name:        "First Victory",
+		Description: "Win a game against at least one other player.",
+		Rule:        Wins(1),
+	},
+	{
+		ID:          "wins-50",
+		Name:        "Champion",
+		Description: "Win 50 games.",
+		Rule:        Wins(50),
+	},
+	{
+		ID:          "perfect-game",
+		Name:        "Flawless",
+		Description: "Answer every question correctly in a game with at least 10 questions.",
+		Rule:        PerfectGame(10),
+	},
+	{
+		ID:          "win-streak-3",
+		Name:        "On a Roll",
+		Description: "Win 3 games in a row.",
+		Rule:        WinStreak(3),
+	},
+	{
+		ID:          "win-streak-10",
+		Name:        "Unstoppable",
+		Description: "Win 10 games in a row.",
+		Rule:        WinStreak(10),
+	},
+	{
+		ID:          "category-100",
+		Name:        "Specialist",
+		Description: "Answer 100 questions in a single category.",
+		Rule:        CategoryQuestions("", 100),
+	},
+	{
+		ID:          "quick-draw",
+		Name:        "Quick Draw",
+		Description: "Answer a question correctly within a second of it being asked.",
+		Rule:        CorrectAnswerWithin(time.Second),
+	},
+}
@@ -0,0 +1,86 @@
+package achievement
+
+import "time"
+
+// A Rule decides whether a participant has met the requirements of an achievement.
+type Rule func(ctx *Context) bool
+
+// GamesPlayed is met once a user has finished n games.
+func GamesPlayed(n int) Rule {
+	return func(ctx *Context) bool {
+		return ctx.Stats().GamesPlayed >= n
+	}
+}
+
+// Wins is met once a user has won n games against other players.
+func Wins(n int) Rule {
+	return func(ctx *Context) bool {
+		if n == 1 {
+			// the finished game is enough to tell without loading any totals.
+			if ctx.Result.Won(ctx.Participant) {
+				return true
+			}
+		}
+		return ctx.Stats().Wins >= n
+	}
+}
+
+// PerfectGame is met by answering every question of a game with at least minQuestions
+// questions correctly.
+func PerfectGame(minQuestions int) Rule {
+	return func(ctx *Context) bool {
+		return ctx.Result.QuestionCount >= minQuestions && ctx.Participant.CorrectAnswers == ctx.Result.QuestionCount
+	}
+}
+
+// WinStreak is met by winning n games in a row, ending with the finished game.
+func WinStreak(n int) Rule {
+	return func(ctx *Context) bool {
+		if !ctx.Result.Won(ctx.Participant) {
+			return false
+		}
+
+		streak := 0
+		for _, game := range ctx.RecentGames(n) {
+			// this is the same as GameResult.Won.
+			if game.Placement != 1 || game.ParticipantCount < 2 {
+				break
+			}
+			streak++
+		}
+		return streak >= n
+	}
+}
+
+// CategoryQuestions is met once a user has answered n questions in a category. An empty category
+// is met by answering n questions in any single category.
+func CategoryQuestions(category string, n int) Rule {
+	return func(ctx *Context) bool {
+		for _, c := range ctx.Stats().Categories {
+			if (category == "" || c.Category == category) && c.Questions >= n {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// CorrectAnswerWithin is met by answering a question correctly within d of it being asked.
+func CorrectAnswerWithin(d time.Duration) Rule {
+	return func(ctx *Context) bool {
+		fastest := ctx.Participant.FastestCorrectAnswer
+		return fastest > 0 && fastest <= d
+	}
+}
+
+// All is met when every one of the given rules is met.
+func All(rules ...Rule) Rule {
+	return func(ctx *Context) bool {
+		for _, rule := range rules {
+			if !rule(ctx) {
+				return false
+			}
+		}
+		return true
+	}
+}
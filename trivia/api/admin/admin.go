@@ -0,0 +1,157 @@
+// Package admin is the API that moderators and admins use to manage users, live games and questions.
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/expixel/actual-trivia-server/eplog"
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/game"
+)
+
+var logger = eplog.NewPrefixLogger("admin")
+
+// errInvalidRole is returned when giving a user a role that doesn't exist.
+var errInvalidRole = errors.New("admin: role does not exist")
+
+// errQuestionNotFound is returned when moderating a question that doesn't exist.
+var errQuestionNotFound = errors.New("admin: question does not exist")
+
+const (
+	// maxChoices is the most choices that a question can have.
+	maxChoices = 6
+
+	// maxPromptLength is the longest prompt or choice that a question can have.
+	maxPromptLength = 1000
+
+	// maxCategoryLength is the longest category or source that a question can have, which is the
+	// size of their columns.
+	maxCategoryLength = 128
+
+	// maxEndReasonLength is the longest reason that can be given for ending a game.
+	maxEndReasonLength = 256
+)
+
+// defaultEndReason is sent to players when a game is ended without a reason.
+const defaultEndReason = "This game was ended by a moderator."
+
+type service struct {
+	users     trivia.UserService
+	questions trivia.QuestionService
+	games     *game.TriviaGamesSet
+}
+
+// setRole changes a user's role for a staff member whose role allows it. Role changes are logged
+// so that there is a record of who gave out each role.
+func (s *service) setRole(staff *trivia.User, userID int64, role trivia.Role) error {
+	if !role.Valid() {
+		return errInvalidRole
+	}
+	if err := s.users.SetUserRole(userID, role); err != nil {
+		return err
+	}
+
+	logger.Info("%s (%d) changed the role of user %d to %s", staff.Username, staff.ID, userID, role)
+	return nil
+}
+
+// endGame ends a live game for a staff member, with a reason that is shown to its players.
+func (s *service) endGame(staff *trivia.User, gameID string, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		reason = defaultEndReason
+	}
+	if len(reason) > maxEndReasonLength {
+		reason = reason[:maxEndReasonLength]
+	}
+
+	if err := s.games.EndGame(gameID, reason); err != nil {
+		return err
+	}
+	logger.Info("%s (%d) ended game %s", staff.Username, staff.ID, gameID)
+	return nil
+}
+
+// updateQuestion saves an edited question for a staff member. The returned string is the reason
+// that the question is invalid, or empty if it was saved. The error is errQuestionNotFound if
+// there is no such question.
+func (s *service) updateQuestion(staff *trivia.User, q *trivia.Question) (string, error) {
+	normalizeQuestion(q)
+	if reason := validateQuestion(q); reason != "" {
+		return reason, nil
+	}
+
+	updated, err := s.questions.UpdateQuestion(q)
+	if err != nil {
+		return "", err
+	}
+	if !updated {
+		return "", errQuestionNotFound
+	}
+	logger.Info("%s (%d) edited question %d", staff.Username, staff.ID, q.ID)
+	return "", nil
+}
+
+// setQuestionDisabled takes a question out of new games or puts it back for a staff member.
+func (s *service) setQuestionDisabled(staff *trivia.User, id int64, disabled bool) error {
+	updated, err := s.questions.SetQuestionDisabled(id, disabled)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return errQuestionNotFound
+	}
+
+	if disabled {
+		logger.Info("%s (%d) disabled question %d", staff.Username, staff.ID, id)
+	} else {
+		logger.Info("%s (%d) enabled question %d", staff.Username, staff.ID, id)
+	}
+	return nil
+}
+
+// normalizeQuestion trims the whitespace around the text of a question.
+func normalizeQuestion(q *trivia.Question) {
+	q.Category = strings.TrimSpace(q.Category)
+	q.Prompt = strings.TrimSpace(q.Prompt)
+	q.Source = strings.TrimSpace(q.Source)
+	for i := range q.Choices {
+		q.Choices[i] = strings.TrimSpace(q.Choices[i])
+	}
+}
+
+// validateQuestion returns the reason that a question can't be saved, or an empty string if it can.
+func validateQuestion(q *trivia.Question) string {
+	if q.Prompt == "" || len(q.Prompt) > maxPromptLength {
+		return fmt.Sprintf("Prompt must be between 1 and %d characters long.", maxPromptLength)
+	}
+	if q.Category == "" || len(q.Category) > maxCategoryLength {
+		return fmt.Sprintf("Category must be between 1 and %d characters long.", maxCategoryLength)
+	}
+	if len(q.Source) > maxCategoryLength {
+		return fmt.Sprintf("Source must be at most %d characters long.", maxCategoryLength)
+	}
+	if q.Difficulty < 0 {
+		return "Difficulty must not be negative."
+	}
+
+	if len(q.Choices) < 2 || len(q.Choices) > maxChoices {
+		return fmt.Sprintf("Questions must have between 2 and %d choices.", maxChoices)
+	}
+	seen := make(map[string]bool, len(q.Choices))
+	for _, choice := range q.Choices {
+		if choice == "" || len(choice) > maxPromptLength {
+			return fmt.Sprintf("Choices must be between 1 and %d characters long.", maxPromptLength)
+		}
+		if seen[strings.ToLower(choice)] {
+			return "Choices must all be different."
+		}
+		seen[strings.ToLower(choice)] = true
+	}
+	if q.CorrectChoice < 0 || q.CorrectChoice >= len(q.Choices) {
+		return "Correct choice must be the index of one of the choices."
+	}
+	return ""
+}
@@ -0,0 +1,183 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/api"
+	"github.com/expixel/actual-trivia-server/trivia/game"
+	"github.com/expixel/actual-trivia-server/trivia/null"
+	"github.com/expixel/actual-trivia-server/trivia/triviatest"
+)
+
+func validQuestion() *trivia.Question {
+	return &trivia.Question{
+		ID:            1,
+		Category:      "Science",
+		Difficulty:    1,
+		Prompt:        "What is the chemical symbol for gold?",
+		Choices:       []string{"Au", "Ag", "Gd", "Go"},
+		CorrectChoice: 0,
+	}
+}
+
+func TestValidateQuestion(t *testing.T) {
+	q := validQuestion()
+	q.Prompt = "  " + q.Prompt + "\n"
+	q.Choices[1] = " Ag "
+	normalizeQuestion(q)
+	if reason := validateQuestion(q); reason != "" {
+		t.Fatalf("expected the question to be valid but got: %s", reason)
+	}
+	if q.Prompt != "What is the chemical symbol for gold?" || q.Choices[1] != "Ag" {
+		t.Errorf("expected the question to be trimmed but got %+v", q)
+	}
+
+	invalid := map[string]func(q *trivia.Question){
+		"empty prompt":         func(q *trivia.Question) { q.Prompt = "" },
+		"long prompt":          func(q *trivia.Question) { q.Prompt = strings.Repeat("a", maxPromptLength+1) },
+		"empty category":       func(q *trivia.Question) { q.Category = "" },
+		"long source":          func(q *trivia.Question) { q.Source = strings.Repeat("a", maxCategoryLength+1) },
+		"negative difficulty":  func(q *trivia.Question) { q.Difficulty = -1 },
+		"one choice":           func(q *trivia.Question) { q.Choices = q.Choices[:1] },
+		"too many choices":     func(q *trivia.Question) { q.Choices = append(q.Choices, "a", "b", "c") },
+		"empty choice":         func(q *trivia.Question) { q.Choices[2] = "" },
+		"duplicate choices":    func(q *trivia.Question) { q.Choices[3] = "au" },
+		"correct out of range": func(q *trivia.Question) { q.CorrectChoice = 4 },
+		"negative correct":     func(q *trivia.Question) { q.CorrectChoice = -1 },
+	}
+	for name, change := range invalid {
+		q := validQuestion()
+		change(q)
+		if reason := validateQuestion(q); reason == "" {
+			t.Errorf("expected a question with %s to be invalid", name)
+		}
+	}
+}
+
+func TestSetInvalidRole(t *testing.T) {
+	s := &service{}
+	staff := &trivia.User{ID: 1, Username: "admin", Role: trivia.RoleAdmin}
+	for _, role := range []trivia.Role{"", "owner", "Admin"} {
+		if err := s.setRole(staff, 2, role); err != errInvalidRole {
+			t.Errorf("expected role %q to be invalid but got %v", role, err)
+		}
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	player := &trivia.User{Role: trivia.RolePlayer}
+	moderator := &trivia.User{Role: trivia.RoleModerator}
+	admin := &trivia.User{Role: trivia.RoleAdmin}
+	guest := &trivia.User{Guest: true, Role: trivia.RoleAdmin}
+
+	for _, permission := range []trivia.Permission{trivia.PermissionViewUsers, trivia.PermissionManageRoles,
+		trivia.PermissionManageGames, trivia.PermissionModerateQuestions} {
+		if player.Can(permission) {
+			t.Errorf("expected players not to have %s", permission)
+		}
+		if guest.Can(permission) {
+			t.Errorf("expected guests not to have %s", permission)
+		}
+		if !admin.Can(permission) {
+			t.Errorf("expected admins to have %s", permission)
+		}
+	}
+
+	if !moderator.Can(trivia.PermissionModerateQuestions) || !moderator.Can(trivia.PermissionManageGames) {
+		t.Errorf("expected moderators to be able to moderate questions and games")
+	}
+	if moderator.Can(trivia.PermissionManageRoles) {
+		t.Errorf("expected moderators not to be able to change roles")
+	}
+}
+
+// newTestTokenService returns a token service where each user's token is their username.
+func newTestTokenService() *triviatest.TokenService {
+	ts := triviatest.NewTokenService()
+	for _, user := range []*trivia.User{
+		{ID: 1, Username: "player", Role: trivia.RolePlayer},
+		{ID: 2, Username: "moderator", Role: trivia.RoleModerator},
+		{ID: 3, Username: "admin", Role: trivia.RoleAdmin},
+	} {
+		ts.Users[user.ID] = user
+		ts.Auth[user.Username] = &trivia.AuthToken{Token: user.Username, UserID: null.NewInt64(user.ID), ExpiresAt: time.Now().Add(time.Hour)}
+	}
+	ts.Auth["guest"] = &trivia.AuthToken{Token: "guest", GuestID: null.NewInt64(7), ExpiresAt: time.Now().Add(time.Hour)}
+	ts.Auth["expired"] = &trivia.AuthToken{Token: "expired", UserID: null.NewInt64(3), ExpiresAt: time.Now().Add(-time.Hour)}
+	return ts
+}
+
+func TestWithPermission(t *testing.T) {
+	ts := newTestTokenService()
+	var called *trivia.User
+	handler := api.WithPermission(ts, trivia.PermissionManageGames, func(w http.ResponseWriter, r *http.Request, staff *trivia.User) {
+		called = staff
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		token    string
+		expected int
+	}{
+		{"", http.StatusUnauthorized},
+		{"expired", http.StatusUnauthorized},
+		{"player", http.StatusForbidden},
+		{"guest", http.StatusForbidden},
+		{"moderator", http.StatusOK},
+		{"admin", http.StatusOK},
+	}
+	for _, c := range cases {
+		called = nil
+		r := httptest.NewRequest("GET", "/v1/admin/games", nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		if w.Code != c.expected {
+			t.Errorf("expected %q to get status %d but got %d", c.token, c.expected, w.Code)
+		}
+		if c.expected == http.StatusOK && (called == nil || called.Username != c.token) {
+			t.Errorf("expected the handler to be called with %q but got %+v", c.token, called)
+		}
+		if c.expected != http.StatusOK && called != nil {
+			t.Errorf("expected the handler not to be called for %q", c.token)
+		}
+	}
+}
+
+func TestHandlerRequiresPermissions(t *testing.T) {
+	h := NewHandler(nil, nil, newTestTokenService(), game.NewGameSet(nil, nil))
+
+	cases := []struct {
+		method   string
+		path     string
+		token    string
+		expected int
+	}{
+		{"GET", "/v1/admin/games", "", http.StatusUnauthorized},
+		{"GET", "/v1/admin/games", "player", http.StatusForbidden},
+		{"GET", "/v1/admin/games", "guest", http.StatusForbidden},
+		{"GET", "/v1/admin/games", "moderator", http.StatusOK},
+		{"PUT", "/v1/admin/users/1/role", "moderator", http.StatusForbidden},
+		{"POST", "/v1/admin/games/missing/end", "admin", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != c.expected {
+			t.Errorf("expected %s %s as %q to get status %d but got %d", c.method, c.path, c.token, c.expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/api"
+	"github.com/expixel/actual-trivia-server/trivia/game"
+)
+
+// maxListLimit is the maximum number of users or questions returned in a single request.
+const maxListLimit = 100
+
+type handler struct {
+	service      *service
+	tokenService trivia.AuthTokenService
+}
+
+// listUsers is an endpoint that searches users by the start of their username or email.
+func (h *handler) listUsers(w http.ResponseWriter, r *http.Request, staff *trivia.User) {
+	limit, offset, ok := api.RequirePagination(w, r, 25, maxListLimit)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	role := trivia.Role(query.Get("role"))
+	if role != "" && !role.Valid() {
+		api.Error(w, "Role does not exist.", http.StatusBadRequest)
+		return
+	}
+
+	users, err := h.service.users.ListUsers(strings.TrimSpace(query.Get("search")), role, limit, offset)
+	if err != nil {
+		logger.Error("error occurred while listing users: %s", err)
+		api.Error(w, "Unknown error occurred while listing users.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := usersResponse{Users: make([]userResponse, len(users))}
+	for idx := range users {
+		resp.Users[idx] = newUserResponse(&users[idx])
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// setRole is an endpoint that changes the role of a user.
+func (h *handler) setRole(w http.ResponseWriter, r *http.Request, staff *trivia.User) {
+	userID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		api.Error(w, "No user with the given ID.", http.StatusNotFound)
+		return
+	}
+
+	body := setRoleRequest{}
+	if err = api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+
+	if err = h.service.setRole(staff, userID, trivia.Role(body.Role)); err != nil {
+		switch err {
+		case errInvalidRole:
+			api.Error(w, "Role does not exist.", http.StatusBadRequest)
+		case trivia.ErrUserNotFound:
+			api.Error(w, "No user with the given ID.", http.StatusNotFound)
+		case trivia.ErrLastAdmin:
+			api.Error(w, "The last admin cannot be given another role.", http.StatusConflict)
+		default:
+			logger.Error("error occurred while changing user role: %s", err)
+			api.Error(w, "Unknown error occurred while changing the user's role.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// listGames is an endpoint that returns the games and challenges that are currently running.
+func (h *handler) listGames(w http.ResponseWriter, r *http.Request, staff *trivia.User) {
+	games := h.service.games.LiveGames()
+	challenges := h.service.games.Challenges()
+
+	resp := gamesResponse{
+		Games:      make([]liveGameResponse, len(games)),
+		Challenges: make([]challengeResponse, len(challenges)),
+	}
+	for idx := range games {
+		resp.Games[idx] = newLiveGameResponse(&games[idx])
+	}
+	for idx, challenge := range challenges {
+		resp.Challenges[idx] = newChallengeResponse(challenge)
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// getGame is an endpoint that returns a game that is currently running.
+func (h *handler) getGame(w http.ResponseWriter, r *http.Request, staff *trivia.User) {
+	liveGame := h.service.games.LiveGameByID(mux.Vars(r)["id"])
+	if liveGame == nil {
+		api.Error(w, "No game with the given ID.", http.StatusNotFound)
+		return
+	}
+
+	resp := newLiveGameResponse(liveGame)
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// endGame is an endpoint that ends a game for all of its players. The body with the reason that
+// is shown to players is optional.
+func (h *handler) endGame(w http.ResponseWriter, r *http.Request, staff *trivia.User) {
+	body := endGameRequest{}
+	if r.ContentLength != 0 {
+		if err := api.RequireJSONBody(w, r, &body); err != nil {
+			return
+		}
+	}
+
+	if err := h.service.endGame(staff, mux.Vars(r)["id"], body.Reason); err != nil {
+		if err == game.ErrGameNotFound {
+			api.Error(w, "No game with the given ID.", http.StatusNotFound)
+		} else {
+			logger.Error("error occurred while ending game: %s", err)
+			api.Error(w, "Unknown error occurred while ending the game.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// listQuestions is an endpoint that searches questions by their prompt, including disabled ones.
+func (h *handler) listQuestions(w http.ResponseWriter, r *http.Request, staff *trivia.User) {
+	limit, offset, ok := api.RequirePagination(w, r, 25, maxListLimit)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	questions, err := h.service.questions.ListQuestions(strings.TrimSpace(query.Get("search")),
+		strings.TrimSpace(query.Get("category")), limit, offset)
+	if err != nil {
+		logger.Error("error occurred while listing questions: %s", err)
+		api.Error(w, "Unknown error occurred while listing questions.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := questionsResponse{Questions: make([]questionResponse, len(questions))}
+	for idx := range questions {
+		resp.Questions[idx] = newQuestionResponse(&questions[idx])
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// requireQuestionID reads the question ID from a request's path and writes an error to the
+// response if it is invalid.
+func requireQuestionID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		api.Error(w, "No question with the given ID.", http.StatusNotFound)
+		return 0, false
+	}
+	return id, true
+}
+
+// updateQuestion is an endpoint that edits a question.
+func (h *handler) updateQuestion(w http.ResponseWriter, r *http.Request, staff *trivia.User) {
+	id, ok := requireQuestionID(w, r)
+	if !ok {
+		return
+	}
+
+	body := updateQuestionRequest{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+
+	q := trivia.Question{
+		ID:            id,
+		Category:      body.Category,
+		Difficulty:    body.Difficulty,
+		Prompt:        body.Prompt,
+		Choices:       body.Choices,
+		CorrectChoice: body.CorrectChoice,
+		Source:        body.Source,
+	}
+	reason, err := h.service.updateQuestion(staff, &q)
+	if err != nil {
+		if err == errQuestionNotFound {
+			api.Error(w, "No question with the given ID.", http.StatusNotFound)
+		} else {
+			logger.Error("error occurred while updating question: %s", err)
+			api.Error(w, "Unknown error occurred while updating the question.", http.StatusInternalServerError)
+		}
+		return
+	}
+	if reason != "" {
+		api.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// setQuestionDisabled returns an endpoint that takes a question out of new games or puts it back.
+func (h *handler) setQuestionDisabled(disabled bool) func(w http.ResponseWriter, r *http.Request, staff *trivia.User) {
+	return func(w http.ResponseWriter, r *http.Request, staff *trivia.User) {
+		id, ok := requireQuestionID(w, r)
+		if !ok {
+			return
+		}
+
+		if err := h.service.setQuestionDisabled(staff, id, disabled); err != nil {
+			if err == errQuestionNotFound {
+				api.Error(w, "No question with the given ID.", http.StatusNotFound)
+			} else {
+				logger.Error("error occurred while disabling question: %s", err)
+				api.Error(w, "Unknown error occurred while updating the question.", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		resp := true
+		api.Response(w, &resp, http.StatusOK)
+	}
+}
+
+// NewHandler creates a new handler for the admin endpoints. Each endpoint requires a permission
+// that is given to users by their role.
+func NewHandler(us trivia.UserService, qs trivia.QuestionService, ts trivia.AuthTokenService, games *game.TriviaGamesSet) http.Handler {
+	h := handler{
+		service:      &service{users: us, questions: qs, games: games},
+		tokenService: ts,
+	}
+	can := func(permission trivia.Permission, fn func(w http.ResponseWriter, r *http.Request, staff *trivia.User)) http.HandlerFunc {
+		return api.WithPermission(ts, permission, fn)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/admin/users", can(trivia.PermissionViewUsers, h.listUsers)).Methods("GET")
+	r.HandleFunc("/v1/admin/users/{id}/role", can(trivia.PermissionManageRoles, h.setRole)).Methods("PUT")
+	r.HandleFunc("/v1/admin/games", can(trivia.PermissionManageGames, h.listGames)).Methods("GET")
+	r.HandleFunc("/v1/admin/games/{id}", can(trivia.PermissionManageGames, h.getGame)).Methods("GET")
+	r.HandleFunc("/v1/admin/games/{id}/end", can(trivia.PermissionManageGames, h.endGame)).Methods("POST")
+	r.HandleFunc("/v1/admin/questions", can(trivia.PermissionModerateQuestions, h.listQuestions)).Methods("GET")
+	r.HandleFunc("/v1/admin/questions/{id}", can(trivia.PermissionModerateQuestions, h.updateQuestion)).Methods("PUT")
+	r.HandleFunc("/v1/admin/questions/{id}/disable", can(trivia.PermissionModerateQuestions, h.setQuestionDisabled(true))).Methods("POST")
+	r.HandleFunc("/v1/admin/questions/{id}/enable", can(trivia.PermissionModerateQuestions, h.setQuestionDisabled(false))).Methods("POST")
+	return api.WrapAPIHandler(r)
+}
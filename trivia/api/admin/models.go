@@ -0,0 +1,133 @@
+package admin
+
+import (
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/game"
+)
+
+type userResponse struct {
+	ID            int64  `json:"id"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
+	Role          string `json:"role"`
+}
+
+func newUserResponse(u *trivia.UserListing) userResponse {
+	return userResponse{
+		ID:            u.ID,
+		Username:      u.Username,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Role:          string(u.Role),
+	}
+}
+
+type usersResponse struct {
+	Users []userResponse `json:"users"`
+}
+
+type setRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type liveGameResponse struct {
+	ID                  string   `json:"id"`
+	Participants        int      `json:"participants"`
+	MaxParticipants     int      `json:"maxParticipants"`
+	ParticipationClosed bool     `json:"participationClosed"`
+	QuestionCount       int      `json:"questionCount"`
+	Categories          []string `json:"categories"`
+	Unlisted            bool     `json:"unlisted"`
+
+	// StartAt is null for games that are not scheduled.
+	StartAt *int64 `json:"startAt"`
+}
+
+func newLiveGameResponse(g *game.LiveGame) liveGameResponse {
+	resp := liveGameResponse{
+		ID:                  g.ID,
+		Participants:        g.ParticipantsCount,
+		MaxParticipants:     g.MaxParticipants,
+		ParticipationClosed: g.ParticipationClosed,
+		QuestionCount:       g.QuestionCount,
+		Categories:          g.Categories,
+		Unlisted:            g.Unlisted,
+	}
+	if !g.StartAt.IsZero() {
+		startAt := g.StartAt.Unix()
+		resp.StartAt = &startAt
+	}
+	return resp
+}
+
+type challengeResponse struct {
+	ID            string `json:"id"`
+	Host          string `json:"host"`
+	CreatedAt     int64  `json:"createdAt"`
+	Deadline      int64  `json:"deadline"`
+	QuestionCount int    `json:"questionCount"`
+	Joined        int    `json:"joined"`
+	Finished      int    `json:"finished"`
+	Revealed      bool   `json:"revealed"`
+}
+
+func newChallengeResponse(c *game.ChallengeGame) challengeResponse {
+	joined, finished := c.PlayerCounts()
+	return challengeResponse{
+		ID:            c.ID,
+		Host:          c.Host.Username,
+		CreatedAt:     c.CreatedAt.Unix(),
+		Deadline:      c.Deadline.Unix(),
+		QuestionCount: c.QuestionCount(),
+		Joined:        joined,
+		Finished:      finished,
+		Revealed:      c.Revealed(),
+	}
+}
+
+type gamesResponse struct {
+	Games      []liveGameResponse  `json:"games"`
+	Challenges []challengeResponse `json:"challenges"`
+}
+
+type endGameRequest struct {
+	Reason string `json:"reason"`
+}
+
+type questionResponse struct {
+	ID            int64    `json:"id"`
+	Category      string   `json:"category"`
+	Difficulty    int      `json:"difficulty"`
+	Prompt        string   `json:"prompt"`
+	Choices       []string `json:"choices"`
+	CorrectChoice int      `json:"correctChoice"`
+	Source        string   `json:"source"`
+	Disabled      bool     `json:"disabled"`
+}
+
+func newQuestionResponse(q *trivia.Question) questionResponse {
+	return questionResponse{
+		ID:            q.ID,
+		Category:      q.Category,
+		Difficulty:    q.Difficulty,
+		Prompt:        q.Prompt,
+		Choices:       q.Choices,
+		CorrectChoice: q.CorrectChoice,
+		Source:        q.Source,
+		Disabled:      q.Disabled,
+	}
+}
+
+type questionsResponse struct {
+	Questions []questionResponse `json:"questions"`
+}
+
+type updateQuestionRequest struct {
+	Category      string   `json:"category"`
+	Difficulty    int      `json:"difficulty"`
+	Prompt        string   `json:"prompt"`
+	Choices       []string `json:"choices"`
+	CorrectChoice int      `json:"correctChoice"`
+	Source        string   `json:"source"`
+}
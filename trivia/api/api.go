@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +18,10 @@ import (
 var logger = eplog.NewPrefixLogger("api")
 var errTokenWithNoUserOrGuest = errors.New("token has no valid user_id or guest_id")
 
+// ErrPermissionDenied is returned when a user's role doesn't give them the permission that a
+// request needs.
+var ErrPermissionDenied = errors.New("user does not have the required permission")
+
 type apiResponse struct {
 	Code    int         `json:"code"`
 	Success bool        `json:"success"`
@@ -75,22 +80,57 @@ func RequireJSONBody(w http.ResponseWriter, r *http.Request, target interface{})
 	return nil
 }
 
+// RequirePagination reads the limit and offset query parameters from a request or sends the right
+// errors to the client if they are not valid. The returned bool is false if an error was sent.
+func RequirePagination(w http.ResponseWriter, r *http.Request, defaultLimit int, maxLimit int) (int, int, bool) {
+	query := r.URL.Query()
+
+	limit := defaultLimit
+	if limitParam := query.Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 1 || parsed > maxLimit {
+			Error(w, fmt.Sprintf("Limit must be a number from 1 to %d.", maxLimit), http.StatusBadRequest)
+			return 0, 0, false
+		}
+		limit = parsed
+	}
+
+	offset := 0
+	if offsetParam := query.Get("offset"); offsetParam != "" {
+		parsed, err := strconv.Atoi(offsetParam)
+		if err != nil || parsed < 0 {
+			Error(w, "Offset must be a positive number.", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		offset = parsed
+	}
+
+	return limit, offset, true
+}
+
 // GetUserForAuthToken returns a user for a token or returns nil and an error if the user was null
 // or the token was expired. In the case of an expired token the error, ErrTokenExpired will be returned.
 func GetUserForAuthToken(token string, ts trivia.AuthTokenService) (*trivia.User, error) {
+	_, user, err := getAuthTokenAndUser(token, ts)
+	return user, err
+}
+
+// getAuthTokenAndUser returns an auth token and its user or returns an error if the token was
+// expired or doesn't belong to anyone.
+func getAuthTokenAndUser(token string, ts trivia.AuthTokenService) (*trivia.AuthToken, *trivia.User, error) {
 	auth, user, err := ts.GetAuthTokenAndUser(token)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if auth == nil {
-		return nil, trivia.ErrTokenNotFound
+		return nil, nil, trivia.ErrTokenNotFound
 	}
 	if !auth.GuestID.Valid && user == nil {
-		return nil, errTokenWithNoUserOrGuest
+		return nil, nil, errTokenWithNoUserOrGuest
 	}
 
-	if time.Now().After(auth.ExpiresAt) {
-		return nil, trivia.ErrTokenExpired
+	if auth.Expired(time.Now()) {
+		return nil, nil, trivia.ErrTokenExpired
 	}
 
 	if user == nil {
@@ -102,36 +142,48 @@ func GetUserForAuthToken(token string, ts trivia.AuthTokenService) (*trivia.User
 		}
 	}
 
-	return user, nil
+	return auth, user, nil
 }
 
 // GetRequestUser extracts a user from a request.
 func GetRequestUser(r *http.Request, ts trivia.AuthTokenService) (*trivia.User, error) {
+	_, user, err := GetRequestToken(r, ts)
+	return user, err
+}
+
+// GetRequestToken extracts the auth token from a request and returns it along with its user.
+func GetRequestToken(r *http.Request, ts trivia.AuthTokenService) (*trivia.AuthToken, *trivia.User, error) {
 	authHeaders, ok := r.Header["Authorization"]
 	if !ok || len(authHeaders) < 1 {
-		return nil, trivia.ErrNoAuthInfo
+		return nil, nil, trivia.ErrNoAuthInfo
 	}
 	authHeader := authHeaders[len(authHeaders)-1]
 
 	fields := strings.Fields(authHeader)
 	if len(fields) != 2 {
-		return nil, trivia.ErrInvalidToken
+		return nil, nil, trivia.ErrInvalidToken
 	}
 
 	tokenType := fields[0]
 	if !strings.EqualFold(tokenType, "Bearer") {
-		return nil, trivia.ErrInvalidToken
+		return nil, nil, trivia.ErrInvalidToken
 	}
 
 	tokenString := fields[1]
-	user, err := GetUserForAuthToken(tokenString, ts)
-	return user, err
+	return getAuthTokenAndUser(tokenString, ts)
 }
 
 // RequireRequestUser authenticates a user and sends the proper error messages to the client
 // if a user cannot be authenticated.
 func RequireRequestUser(w http.ResponseWriter, r *http.Request, ts trivia.AuthTokenService) (*trivia.User, error) {
-	user, err := GetRequestUser(r, ts)
+	_, user, err := RequireRequestToken(w, r, ts)
+	return user, err
+}
+
+// RequireRequestToken authenticates a user like RequireRequestUser and also returns the auth
+// token that the request was authenticated with.
+func RequireRequestToken(w http.ResponseWriter, r *http.Request, ts trivia.AuthTokenService) (*trivia.AuthToken, *trivia.User, error) {
+	token, user, err := GetRequestToken(r, ts)
 	if err != nil {
 		switch err {
 		case trivia.ErrNoAuthInfo:
@@ -147,7 +199,34 @@ func RequireRequestUser(w http.ResponseWriter, r *http.Request, ts trivia.AuthTo
 			Error(w, "An unknown error occurred while authenticating your request.", http.StatusInternalServerError)
 		}
 	}
-	return user, err
+	return token, user, err
+}
+
+// RequirePermission authenticates a user like RequireRequestUser and makes sure that their role
+// gives them a permission. ErrPermissionDenied is returned and sent to the client if it doesn't.
+func RequirePermission(w http.ResponseWriter, r *http.Request, ts trivia.AuthTokenService, permission trivia.Permission) (*trivia.User, error) {
+	user, err := RequireRequestUser(w, r, ts)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Can(permission) {
+		Error(w, "You do not have permission to do this.", http.StatusForbidden)
+		return nil, ErrPermissionDenied
+	}
+	return user, nil
+}
+
+// WithPermission wraps a handler so that it is only called for users whose role gives them a
+// permission. Everyone else gets the errors sent by RequirePermission.
+func WithPermission(ts trivia.AuthTokenService, permission trivia.Permission,
+	handler func(w http.ResponseWriter, r *http.Request, user *trivia.User)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := RequirePermission(w, r, ts, permission)
+		if err != nil {
+			return
+		}
+		handler(w, r, user)
+	}
 }
 
 type corsHandler struct {
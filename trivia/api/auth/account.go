@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+// AccountOptions are the options used for changing account details.
+type AccountOptions struct {
+	// UsernameChangeInterval is how long a user has to wait after changing their username before
+	// they can change it again.
+	UsernameChangeInterval time.Duration
+
+	// UsernameReservation is how long an old username stays reserved for its previous owner after
+	// it was changed. Nobody else can take it until then.
+	UsernameReservation time.Duration
+
+	// RecentLoginWindow is how long after logging in users without a password can change their email
+	// address or delete their account, since they have no password to confirm it with.
+	RecentLoginWindow time.Duration
+}
+
+// DefaultAccountOptions returns the account options used when none are configured.
+func DefaultAccountOptions() AccountOptions {
+	return AccountOptions{
+		UsernameChangeInterval: 30 * (24 * time.Hour),
+		UsernameReservation:    30 * (24 * time.Hour),
+		RecentLoginWindow:      10 * time.Minute,
+	}
+}
+
+// AccountManager changes the passwords, email addresses, and usernames of existing accounts and
+// deletes accounts.
+type AccountManager struct {
+	users   trivia.UserService
+	auth    trivia.AuthService
+	options AccountOptions
+	now     func() time.Time
+}
+
+// NewAccountManager creates a new account manager. Other sessions of a user are logged out through
+// the auth service when they change their password.
+func NewAccountManager(users trivia.UserService, auth trivia.AuthService, options AccountOptions) *AccountManager {
+	return &AccountManager{users: users, auth: auth, options: options, now: time.Now}
+}
+
+// Options returns the options that the account manager was created with.
+func (m *AccountManager) Options() AccountOptions {
+	return m.options
+}
+
+// checkPassword makes sure that a password is the user's current password. This returns
+// ErrIncorrectPassword if it isn't.
+func (m *AccountManager) checkPassword(userID int64, password string) (*trivia.UserCred, error) {
+	cred, err := m.users.CredByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if cred == nil {
+		return nil, trivia.ErrUserNotFound
+	}
+	if err = ComparePassword(cred.Password, password); err != nil {
+		return nil, trivia.ErrIncorrectPassword
+	}
+	return cred, nil
+}
+
+// reauthenticate makes sure that the owner of an account is the one changing it. Users with a
+// password have to enter it, and users that log in with a provider have to have started the session
+// with the given token family within RecentLoginWindow. This returns ErrIncorrectPassword or
+// ErrRecentLoginRequired if they can't confirm it.
+func (m *AccountManager) reauthenticate(userID int64, family string, password string) (*trivia.UserCred, error) {
+	cred, err := m.users.CredByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if cred == nil {
+		return nil, trivia.ErrUserNotFound
+	}
+	if len(cred.Password) > 0 {
+		if err = ComparePassword(cred.Password, password); err != nil {
+			return nil, trivia.ErrIncorrectPassword
+		}
+		return cred, nil
+	}
+
+	// refreshing tokens keeps the same session, so only a new login starts one.
+	sessions, err := m.auth.UserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if session.Family == family && m.now().Sub(session.CreatedAt) < m.options.RecentLoginWindow {
+			return cred, nil
+		}
+	}
+	return nil, trivia.ErrRecentLoginRequired
+}
+
+// ChangePassword replaces a user's password if current is their current password. Every session
+// other than the one with the given token family is logged out. This returns ErrIncorrectPassword
+// if the current password is wrong.
+func (m *AccountManager) ChangePassword(userID int64, family string, current string, password string) error {
+	if _, err := m.checkPassword(userID, current); err != nil {
+		return err
+	}
+
+	prepared, err := PreparePassword(password)
+	if err != nil {
+		return err
+	}
+	if err = m.users.UpdatePassword(userID, prepared); err != nil {
+		return err
+	}
+
+	// whoever knew the old password shouldn't stay logged in, but the user making the change does.
+	sessions, err := m.auth.UserSessions(userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.Family == family {
+			continue
+		}
+		if err = m.auth.RevokeSession(userID, session.ID); err != nil && err != trivia.ErrSessionNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChangeEmail changes a user's email address if password is their current password, or if the
+// session with the given token family was logged into recently for users without a password. The
+// new address has to be verified again. This returns ErrIncorrectPassword if the password is wrong,
+// ErrRecentLoginRequired if the login isn't recent enough, or ErrEmailInUse if another user has the
+// address.
+func (m *AccountManager) ChangeEmail(userID int64, family string, password string, email string) error {
+	cred, err := m.reauthenticate(userID, family, password)
+	if err != nil {
+		return err
+	}
+	if cred.Email == email {
+		return nil
+	}
+
+	found, err := m.users.CredByEmail(email)
+	if err != nil {
+		return err
+	}
+	if found != nil && found.UserID != userID {
+		return trivia.ErrEmailInUse
+	}
+	return m.users.UpdateEmail(userID, email)
+}
+
+// ChangeUsername changes a user's username. Users can only change their username once every
+// UsernameChangeInterval and their old username is reserved for them for UsernameReservation.
+// This returns ErrRateLimited if the user changed their username too recently or ErrUsernameInUse
+// if another user has or recently had the username.
+func (m *AccountManager) ChangeUsername(userID int64, username string) (*trivia.User, error) {
+	user, err := m.users.UserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, trivia.ErrUserNotFound
+	}
+	if user.Username == username {
+		return user, nil
+	}
+
+	now := m.now()
+	if !user.UsernameChanged.IsZero() && now.Before(user.UsernameChanged.Add(m.options.UsernameChangeInterval)) {
+		return nil, trivia.ErrRateLimited
+	}
+
+	// changing the capitalization of a username doesn't make it available to anyone else.
+	if !strings.EqualFold(user.Username, username) {
+		found, err := m.users.UserByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+		if found != nil {
+			return nil, trivia.ErrUsernameInUse
+		}
+	}
+
+	reservation, err := m.users.UsernameReservation(username)
+	if err != nil {
+		return nil, err
+	}
+	if reservation != nil && (!reservation.UserID.Valid || reservation.UserID.Int64 != userID) {
+		return nil, trivia.ErrUsernameInUse
+	}
+
+	if err = m.users.ChangeUsername(userID, username, now.Add(m.options.UsernameReservation)); err != nil {
+		return nil, err
+	}
+	user.Username = username
+	user.UsernameChanged = now
+	return user, nil
+}
+
+// DeleteAccount deletes a user if password is their current password, or if the session with the
+// given token family was logged into recently for users without a password. The user is logged out
+// of every session first. Their game results are kept without their username, which stays reserved
+// for UsernameReservation. This returns ErrIncorrectPassword if the password is wrong or
+// ErrRecentLoginRequired if the login isn't recent enough.
+func (m *AccountManager) DeleteAccount(userID int64, family string, password string) error {
+	if _, err := m.reauthenticate(userID, family, password); err != nil {
+		return err
+	}
+
+	// tokens aren't deleted along with users so the sessions are revoked before the user is gone.
+	if err := m.auth.RevokeAllSessions(userID); err != nil {
+		return err
+	}
+
+	deleted, err := m.users.DeleteUser(userID, m.now().Add(m.options.UsernameReservation))
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return trivia.ErrUserNotFound
+	}
+	return nil
+}
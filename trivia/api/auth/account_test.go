@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/triviatest"
+)
+
+func TestChangePassword(t *testing.T) {
+	users := &fakeUserService{}
+	tokens := triviatest.NewTokenService()
+	s := &service{users: users, tokens: tokens, lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "original", "original@example.com", "old password")
+	accounts := NewAccountManager(users, s, DefaultAccountOptions())
+	current := newTestPair(t, s, tokens, 1)
+	other := newTestPair(t, s, tokens, 1)
+
+	if err := accounts.ChangePassword(1, current.Auth.Family, "wrong password", "new password"); err != trivia.ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword but got %v", err)
+	}
+
+	if err := accounts.ChangePassword(1, current.Auth.Family, "old password", "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ComparePassword(users.creds[0].Password, "new password"); err != nil {
+		t.Errorf("expected the new password to be stored")
+	}
+	if _, ok := tokens.Sessions[current.Auth.Family]; !ok {
+		t.Errorf("expected the session that changed the password to stay logged in")
+	}
+	if _, ok := tokens.Sessions[other.Auth.Family]; ok {
+		t.Errorf("expected other sessions to be logged out")
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "original", "original@example.com", "old password")
+	accounts := NewAccountManager(users, s, DefaultAccountOptions())
+	createTestUser(t, s, "someone", "someone@example.com", "password")
+	users.creds[0].EmailVerified = true
+
+	if err := accounts.ChangeEmail(1, "", "wrong password", "new@example.com"); err != trivia.ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword but got %v", err)
+	}
+	if err := accounts.ChangeEmail(1, "", "old password", "someone@example.com"); err != trivia.ErrEmailInUse {
+		t.Errorf("expected ErrEmailInUse but got %v", err)
+	}
+
+	if err := accounts.ChangeEmail(1, "", "old password", "new@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if users.creds[0].Email != "new@example.com" || users.creds[0].EmailVerified {
+		t.Errorf("expected the new email address to be stored without being verified")
+	}
+}
+
+func TestChangeUsername(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "original", "original@example.com", "old password")
+	accounts := NewAccountManager(users, s, DefaultAccountOptions())
+	createTestUser(t, s, "someone", "someone@example.com", "password")
+
+	if _, err := accounts.ChangeUsername(1, "someone"); err != trivia.ErrUsernameInUse {
+		t.Errorf("expected ErrUsernameInUse but got %v", err)
+	}
+
+	user, err := accounts.ChangeUsername(1, "renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "renamed" {
+		t.Errorf("expected the username to be changed but got %s", user.Username)
+	}
+
+	// the old username is reserved for the previous owner.
+	if _, _, err = s.CreateUser("Original", "impostor@example.com", "password"); err != trivia.ErrUsernameInUse {
+		t.Errorf("expected a new user to not be able to take the old username but got %v", err)
+	}
+	if _, err = accounts.ChangeUsername(2, "original"); err != trivia.ErrUsernameInUse {
+		t.Errorf("expected another user to not be able to take the old username but got %v", err)
+	}
+
+	if _, err = accounts.ChangeUsername(1, "again"); err != trivia.ErrRateLimited {
+		t.Errorf("expected ErrRateLimited but got %v", err)
+	}
+
+	accounts.now = func() time.Time { return time.Now().Add(accounts.options.UsernameChangeInterval + time.Minute) }
+	if _, err = accounts.ChangeUsername(1, "original"); err != nil {
+		t.Errorf("expected the previous owner to be able to take back their old username but got %v", err)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	users := &fakeUserService{}
+	tokens := triviatest.NewTokenService()
+	s := &service{users: users, tokens: tokens, lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "original", "original@example.com", "old password")
+	accounts := NewAccountManager(users, s, DefaultAccountOptions())
+	pair := newTestPair(t, s, tokens, 1)
+
+	if err := accounts.DeleteAccount(1, pair.Auth.Family, "wrong password"); err != trivia.ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword but got %v", err)
+	}
+
+	if err := accounts.DeleteAccount(1, pair.Auth.Family, "old password"); err != nil {
+		t.Fatal(err)
+	}
+	if len(users.users) != 0 {
+		t.Errorf("expected the user to be deleted")
+	}
+	if _, ok := tokens.Auth[pair.Auth.Token]; ok {
+		t.Errorf("expected the user's tokens to be revoked")
+	}
+
+	// nobody can sign up with the username of the deleted user right away.
+	if _, _, err := s.CreateUser("original", "impostor@example.com", "password"); err != trivia.ErrUsernameInUse {
+		t.Errorf("expected the deleted user's username to be reserved but got %v", err)
+	}
+}
+
+func TestReauthenticateWithoutPassword(t *testing.T) {
+	users := &fakeUserService{}
+	tokens := triviatest.NewTokenService()
+	s := &service{users: users, tokens: tokens, lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "original", "original@example.com", "old password")
+	accounts := NewAccountManager(users, s, DefaultAccountOptions())
+	user, _, err := s.createUser("provider", "provider@example.com", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := newTestPair(t, s, tokens, user.ID)
+	other := newTestPair(t, s, tokens, user.ID)
+	tokens.Sessions[other.Auth.Family].CreatedAt = time.Now().Add(-time.Hour)
+
+	// users without a password can't type one in, so a recent login is asked for instead.
+	if err = accounts.ChangeEmail(user.ID, other.Auth.Family, "", "changed@example.com"); err != trivia.ErrRecentLoginRequired {
+		t.Errorf("expected ErrRecentLoginRequired for an old login but got %v", err)
+	}
+	if err = accounts.ChangeEmail(user.ID, pair.Auth.Family, "", "changed@example.com"); err != nil {
+		t.Errorf("expected a recent login to be able to change the email address but got %v", err)
+	}
+
+	accounts.now = func() time.Time { return time.Now().Add(accounts.options.RecentLoginWindow) }
+	if err = accounts.DeleteAccount(user.ID, pair.Auth.Family, ""); err != trivia.ErrRecentLoginRequired {
+		t.Errorf("expected ErrRecentLoginRequired once the login is no longer recent but got %v", err)
+	}
+	accounts.now = time.Now
+	if err = accounts.DeleteAccount(user.ID, pair.Auth.Family, ""); err != nil {
+		t.Errorf("expected a recent login to be able to delete the account but got %v", err)
+	}
+}
@@ -20,12 +20,43 @@ const maxTokenGenerationRetries = 2
 
 var errTokenGenMaxReached = errors.New("auth: reached the maximum number of retries for token generation")
 
+// TokenLifetimes are how long newly issued tokens stay valid.
+type TokenLifetimes struct {
+	// Auth tokens are short lived since they are sent with every request. Clients are expected to
+	// get a new one using their refresh token before it expires.
+	Auth time.Duration
+
+	// Refresh tokens are good for a single use within their lifetime, so a client that refreshes
+	// its tokens at least this often stays logged in indefinitely.
+	Refresh time.Duration
+}
+
+// DefaultTokenLifetimes returns the token lifetimes used when none are configured.
+func DefaultTokenLifetimes() TokenLifetimes {
+	return TokenLifetimes{Auth: 15 * time.Minute, Refresh: 30 * (24 * time.Hour)}
+}
+
+// LiveGames updates the live game connections of users when their sessions or accounts change.
+// This is implemented by game.TriviaGamesSet.
+type LiveGames interface {
+	// DisconnectSessions closes the live connections that were authenticated with tokens from
+	// revoked sessions.
+	DisconnectSessions(families []string)
+
+	// UpgradeGuest moves a guest that upgraded to a full account to their new user and session.
+	UpgradeGuest(guestID int64, user *trivia.User, guestSession string, session string)
+}
+
 type service struct {
-	users  trivia.UserService
-	tokens trivia.AuthTokenService
+	users     trivia.UserService
+	tokens    trivia.AuthTokenService
+	results   trivia.GameResultService
+	lifetimes TokenLifetimes
+	games     LiveGames
+	twoFactor *TwoFactorAuth
 }
 
-func (s *service) LoginWithEmailOrUsername(emailOrUsername string, password string) (*trivia.TokenPair, error) {
+func (s *service) LoginWithEmailOrUsername(emailOrUsername string, password string, client trivia.ClientInfo) (*trivia.LoginResult, error) {
 	var creds *trivia.UserCred
 	var err error
 
@@ -44,17 +75,68 @@ func (s *service) LoginWithEmailOrUsername(emailOrUsername string, password stri
 
 	err = ComparePassword(creds.Password, password)
 	if err != nil {
+		if err == ErrUnknownPepperKey {
+			logger.Error("password of user %d is encrypted with an unknown pepper key", creds.UserID)
+		}
 		return nil, trivia.ErrIncorrectPassword
 	}
 
-	authTokenString, refreshTokenString, err := s.generateTokenStrings(creds.UserID, false)
+	// passwords are moved to the current hash and pepper key as their owners log in.
+	if _, err = upgradeStoredPassword(s.users, creds, password); err != nil {
+		logger.Error("error occurred while upgrading the password of user %d: %s", creds.UserID, err)
+	}
+	return s.StartLogin(creds.UserID, client)
+}
+
+func (s *service) StartLogin(userID int64, client trivia.ClientInfo) (*trivia.LoginResult, error) {
+	if s.twoFactor != nil {
+		challenge, err := s.twoFactor.challenge(userID)
+		if err != nil {
+			return nil, err
+		}
+		if challenge != nil {
+			return &trivia.LoginResult{Challenge: challenge}, nil
+		}
+	}
+
+	pair, err := s.LoginUser(userID, client)
 	if err != nil {
 		return nil, err
 	}
-	return s.storeTokenStrings(null.NewInt64(creds.UserID), null.Int64{}, authTokenString, refreshTokenString)
+	return &trivia.LoginResult{Pair: pair}, nil
+}
+
+func (s *service) FinishLogin(challenge string, code string, client trivia.ClientInfo) (*trivia.TokenPair, error) {
+	if s.twoFactor == nil {
+		return nil, trivia.ErrTokenNotFound
+	}
+
+	userID, err := s.twoFactor.finishChallenge(challenge, code)
+	if err != nil {
+		return nil, err
+	}
+	return s.LoginUser(userID, client)
+}
+
+func (s *service) LoginUser(userID int64, client trivia.ClientInfo) (*trivia.TokenPair, error) {
+	authTokenString, refreshTokenString, err := s.generateTokenStrings(userID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	family, err := generateTokenFamily()
+	if err != nil {
+		return nil, err
+	}
+
+	pair := s.newTokenPair(null.NewInt64(userID), null.Int64{}, family, authTokenString, refreshTokenString)
+	if err = s.tokens.CreateTokenPair(pair.Auth, pair.Refresh, client); err != nil {
+		return nil, err
+	}
+	return pair, nil
 }
 
-func (s *service) LoginAsGuest() (*trivia.TokenPair, error) {
+func (s *service) LoginAsGuest(client trivia.ClientInfo) (*trivia.TokenPair, error) {
 	guestID, err := s.users.NextGuestID()
 	if err != nil {
 		return nil, err
@@ -65,7 +147,111 @@ func (s *service) LoginAsGuest() (*trivia.TokenPair, error) {
 		return nil, err
 	}
 
-	return s.storeTokenStrings(null.Int64{}, null.NewInt64(guestID), authTokenString, refreshTokenString)
+	family, err := generateTokenFamily()
+	if err != nil {
+		return nil, err
+	}
+
+	pair := s.newTokenPair(null.Int64{}, null.NewInt64(guestID), family, authTokenString, refreshTokenString)
+	if err = s.tokens.CreateTokenPair(pair.Auth, pair.Refresh, client); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+func (s *service) RefreshTokenPair(refreshToken string, client trivia.ClientInfo) (*trivia.TokenPair, error) {
+	used, err := s.tokens.RefreshTokenByString(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if used == nil {
+		return nil, trivia.ErrTokenNotFound
+	}
+
+	// a rotated token being used again means that either the client or someone who stole the
+	// token is holding on to an old token, and there's no telling which one, so every token
+	// from the same login is revoked.
+	if used.Used {
+		return nil, s.revokeReusedFamily(used)
+	}
+
+	if time.Now().After(used.ExpiresAt) {
+		return nil, trivia.ErrTokenExpired
+	}
+
+	var authTokenString, refreshTokenString string
+	if used.GuestID.Valid {
+		authTokenString, refreshTokenString, err = s.generateTokenStrings(used.GuestID.Int64, true)
+	} else {
+		authTokenString, refreshTokenString, err = s.generateTokenStrings(used.UserID.Int64, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pair := s.newTokenPair(used.UserID, used.GuestID, used.Family, authTokenString, refreshTokenString)
+	rotated, err := s.tokens.RotateTokenPair(used, pair.Auth, pair.Refresh, client)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// another request used the same token first.
+		return nil, s.revokeReusedFamily(used)
+	}
+	return pair, nil
+}
+
+// revokeReusedFamily revokes the family of a refresh token that was used more than once and
+// returns ErrTokenReused if the family was revoked.
+func (s *service) revokeReusedFamily(token *trivia.RefreshToken) error {
+	logger.Warn("refresh token from family %s was reused, revoking the family", token.Family)
+	if err := s.revokeFamilies([]string{token.Family}); err != nil {
+		return err
+	}
+	return trivia.ErrTokenReused
+}
+
+// revokeFamilies revokes token families and disconnects anything that is still connected using
+// one of their tokens.
+func (s *service) revokeFamilies(families []string) error {
+	for _, family := range families {
+		if err := s.tokens.RevokeTokenFamily(family); err != nil {
+			return err
+		}
+	}
+	s.disconnect(families)
+	return nil
+}
+
+// disconnect closes the live connections of revoked token families.
+func (s *service) disconnect(families []string) {
+	if s.games != nil && len(families) > 0 {
+		s.games.DisconnectSessions(families)
+	}
+}
+
+func (s *service) UserSessions(userID int64) ([]trivia.Session, error) {
+	return s.tokens.UserSessions(userID)
+}
+
+func (s *service) RevokeSession(userID int64, sessionID int64) error {
+	session, err := s.tokens.SessionByID(sessionID)
+	if err != nil {
+		return err
+	}
+	if session == nil || !session.UserID.Valid || session.UserID.Int64 != userID {
+		return trivia.ErrSessionNotFound
+	}
+	return s.revokeFamilies([]string{session.Family})
+}
+
+func (s *service) RevokeAllSessions(userID int64) error {
+	families, err := s.tokens.RevokeUserSessions(userID)
+	if err != nil {
+		return err
+	}
+	s.disconnect(families)
+	return nil
 }
 
 func (s *service) CreateUser(username string, email string, password string) (*trivia.User, *trivia.UserCred, error) {
@@ -73,7 +259,17 @@ func (s *service) CreateUser(username string, email string, password string) (*t
 	if err != nil {
 		return nil, nil, err
 	}
+	return s.createUser(username, email, preparedPassword, nil)
+}
+
+func (s *service) CreateUserWithIdentity(username string, email string, identity *trivia.ExternalIdentity) (*trivia.User, *trivia.UserCred, error) {
+	return s.createUser(username, email, nil, identity)
+}
 
+// createUser creates a user with an already prepared password, which is nil for users that log in
+// with the given identity instead.
+func (s *service) createUser(username string, email string, preparedPassword []byte,
+	identity *trivia.ExternalIdentity) (*trivia.User, *trivia.UserCred, error) {
 	// #CLEANUP I should merge the email and username search into a single query to reduce how much I'm hitting the database for signups.
 	// Maybe something like:
 	// 		SELECT u.username, c.email FROM users u
@@ -84,6 +280,12 @@ func (s *service) CreateUser(username string, email string, password string) (*t
 		return nil, nil, trivia.ErrUsernameInUse
 	}
 
+	// usernames that were recently changed stay with their previous owner for a while.
+	reservation, err := s.users.UsernameReservation(username)
+	if err != nil || reservation != nil {
+		return nil, nil, trivia.ErrUsernameInUse
+	}
+
 	emailFound, err := s.users.CredByEmail(email)
 	if err != nil || emailFound != nil {
 		return nil, nil, trivia.ErrEmailInUse
@@ -91,47 +293,90 @@ func (s *service) CreateUser(username string, email string, password string) (*t
 
 	user := &trivia.User{Username: username}
 	creds := &trivia.UserCred{Email: email, Password: preparedPassword}
-	if err = s.users.CreateUser(user, creds); err != nil {
+	if identity != nil {
+		err = s.users.CreateUserWithIdentity(user, creds, identity)
+	} else {
+		err = s.users.CreateUser(user, creds)
+	}
+	if err != nil {
 		return nil, nil, err
 	}
 
 	return user, creds, nil
 }
 
-func (s *service) LogoutUserWithToken(token string) error {
-	deleted, err := s.tokens.DeleteToken(token, true)
+func (s *service) UpgradeGuest(guest *trivia.AuthToken, username string, email string, password string,
+	client trivia.ClientInfo) (*trivia.User, *trivia.TokenPair, error) {
+	if !guest.GuestID.Valid {
+		return nil, nil, trivia.ErrNotGuest
+	}
+	guestID := guest.GuestID.Int64
+
+	user, _, err := s.CreateUser(username, email, password)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	if deleted {
-		return nil
+
+	// the account exists at this point so failing to move the guest's games shouldn't stop the
+	// user from logging in with it.
+	transferred, err := s.results.TransferGuestResults(guestID, user.ID, user.Username)
+	if err != nil {
+		logger.Error("error occurred while transferring the games of guest %d to user %d: %s", guestID, user.ID, err)
+	} else {
+		logger.Debug("transferred %d games from guest %d to user %d", transferred, guestID, user.ID)
 	}
-	return trivia.ErrTokenNotFound
-}
 
-// storeTokenStrings stores the generated auth and refresh tokens into the database and
-// returns the stored token pair.
-func (s *service) storeTokenStrings(userID null.Int64, guestID null.Int64, authTokenString string, refreshTokenString string) (*trivia.TokenPair, error) {
-	// #FIXME this is annoying as hell for manual testing right now so I'm temporarily extended the
-	// token expiration delay. Will definitely have to change this back to something reasonable
-	// once I have a good API consumer set up (probably in a React application)
+	authTokenString, refreshTokenString, err := s.generateTokenStrings(user.ID, false)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// const authTokenExpiresIn time.Duration = 5 * time.Minute
-	// const refreshTokenExpiresIn time.Duration = (24 * time.Hour) * 7
+	family, err := generateTokenFamily()
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// #FIXME REMOVE THESE
-	const authTokenExpiresIn time.Duration = 14 * (24 * time.Hour)
-	const refreshTokenExpiresIn time.Duration = 30 * (24 * time.Hour)
+	pair := s.newTokenPair(null.NewInt64(user.ID), null.Int64{}, family, authTokenString, refreshTokenString)
+	if err = s.tokens.CreateTokenPair(pair.Auth, pair.Refresh, client); err != nil {
+		return nil, nil, err
+	}
 
+	// live connections are moved to the new session before the guest's tokens are revoked so
+	// that they aren't disconnected.
+	if s.games != nil {
+		s.games.UpgradeGuest(guestID, user, guest.Family, family)
+	}
+	if err = s.tokens.RevokeTokenFamily(guest.Family); err != nil {
+		logger.Error("error occurred while revoking the tokens of upgraded guest %d: %s", guestID, err)
+	}
+	return user, pair, nil
+}
+
+func (s *service) LogoutUserWithToken(token string) error {
+	authToken, err := s.tokens.AuthTokenByString(token)
+	if err != nil {
+		return err
+	}
+	if authToken == nil {
+		return trivia.ErrTokenNotFound
+	}
+
+	// the refresh tokens are revoked along with the auth token so that the session can't be
+	// brought back using one of them.
+	return s.revokeFamilies([]string{authToken.Family})
+}
+
+// newTokenPair creates a new token pair in the given family that expires after the service's
+// token lifetimes.
+func (s *service) newTokenPair(userID null.Int64, guestID null.Int64, family string, authTokenString string, refreshTokenString string) *trivia.TokenPair {
 	now := time.Now()
-	authTokenExpiresAt := now.Add(authTokenExpiresIn)
-	refreshTokenExpiresAt := now.Add(refreshTokenExpiresIn)
 
 	authToken := &trivia.AuthToken{
 		Token:     authTokenString,
 		UserID:    userID,
 		GuestID:   guestID,
-		ExpiresAt: authTokenExpiresAt,
+		ExpiresAt: now.Add(s.lifetimes.Auth),
+		Family:    family,
 	}
 
 	refreshToken := &trivia.RefreshToken{
@@ -139,16 +384,20 @@ func (s *service) storeTokenStrings(userID null.Int64, guestID null.Int64, authT
 		AuthToken: authTokenString,
 		UserID:    userID,
 		GuestID:   guestID,
-		ExpiresAt: refreshTokenExpiresAt,
+		ExpiresAt: now.Add(s.lifetimes.Refresh),
+		Family:    family,
 	}
 
-	err := s.tokens.CreateTokenPair(authToken, refreshToken)
-	if err != nil {
-		return nil, err
-	}
+	return &trivia.TokenPair{Auth: authToken, Refresh: refreshToken}
+}
 
-	pair := &trivia.TokenPair{Auth: authToken, Refresh: refreshToken}
-	return pair, nil
+// generateTokenFamily generates the family for the tokens of a new login.
+func generateTokenFamily() (string, error) {
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
 }
 
 func (s *service) generateTokenStrings(userID int64, isGuest bool) (string, string, error) {
@@ -208,7 +457,10 @@ func (s *service) generateTokenStrings(userID int64, isGuest bool) (string, stri
 	return authTokenString, refreshTokenString, nil
 }
 
-// NewService creates a new authentication service.
-func NewService(users trivia.UserService, tokens trivia.AuthTokenService) trivia.AuthService {
-	return &service{users: users, tokens: tokens}
+// NewService creates a new authentication service that issues tokens with the given lifetimes.
+// The live connections of users are updated through games if it is not nil, and users with
+// two-factor authentication enabled are given a login challenge through twoFactor if it is not nil.
+func NewService(users trivia.UserService, tokens trivia.AuthTokenService, results trivia.GameResultService,
+	lifetimes TokenLifetimes, games LiveGames, twoFactor *TwoFactorAuth) trivia.AuthService {
+	return &service{users: users, tokens: tokens, results: results, lifetimes: lifetimes, games: games, twoFactor: twoFactor}
 }
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/null"
+	"github.com/expixel/actual-trivia-server/trivia/triviatest"
+)
+
+func TestRefreshTokenPairRotates(t *testing.T) {
+	tokens := triviatest.NewTokenService()
+	s := &service{tokens: tokens, lifetimes: DefaultTokenLifetimes()}
+	original := newTestPair(t, s, tokens, 7)
+
+	pair, err := s.RefreshTokenPair(original.Refresh.Token, trivia.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pair.Refresh.Token == original.Refresh.Token || pair.Auth.Token == original.Auth.Token {
+		t.Errorf("expected new tokens to be issued")
+	}
+	if pair.Refresh.Family != original.Refresh.Family || pair.Auth.Family != original.Refresh.Family {
+		t.Errorf("expected the new tokens to stay in the same family")
+	}
+	if pair.Auth.UserID != original.Auth.UserID {
+		t.Errorf("expected the new tokens to belong to the same user")
+	}
+	if _, ok := tokens.Auth[original.Auth.Token]; ok {
+		t.Errorf("expected the old auth token to be deleted")
+	}
+
+	// the new refresh token can be used in turn.
+	if _, err = s.RefreshTokenPair(pair.Refresh.Token, trivia.ClientInfo{}); err != nil {
+		t.Errorf("expected the rotated refresh token to be usable but got: %s", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	tokens := triviatest.NewTokenService()
+	s := &service{tokens: tokens, lifetimes: DefaultTokenLifetimes()}
+	original := newTestPair(t, s, tokens, 7)
+	other := newTestPair(t, s, tokens, 7)
+
+	pair, err := s.RefreshTokenPair(original.Refresh.Token, trivia.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.RefreshTokenPair(original.Refresh.Token, trivia.ClientInfo{}); err != trivia.ErrTokenReused {
+		t.Fatalf("expected ErrTokenReused but got %v", err)
+	}
+
+	if _, ok := tokens.Auth[pair.Auth.Token]; ok {
+		t.Errorf("expected the auth token issued from the family to be revoked")
+	}
+	if _, err = s.RefreshTokenPair(pair.Refresh.Token, trivia.ClientInfo{}); err != trivia.ErrTokenNotFound {
+		t.Errorf("expected the refresh token issued from the family to be revoked but got %v", err)
+	}
+
+	// other logins are left alone.
+	if _, ok := tokens.Auth[other.Auth.Token]; !ok {
+		t.Errorf("expected tokens from another family to be kept")
+	}
+}
+
+func TestRefreshTokenPairErrors(t *testing.T) {
+	tokens := triviatest.NewTokenService()
+	s := &service{tokens: tokens, lifetimes: TokenLifetimes{Auth: time.Minute, Refresh: -time.Minute}}
+	expired := newTestPair(t, s, tokens, 7)
+
+	if _, err := s.RefreshTokenPair(expired.Refresh.Token, trivia.ClientInfo{}); err != trivia.ErrTokenExpired {
+		t.Errorf("expected ErrTokenExpired but got %v", err)
+	}
+	if _, err := s.RefreshTokenPair("missing.7", trivia.ClientInfo{}); err != trivia.ErrTokenNotFound {
+		t.Errorf("expected ErrTokenNotFound but got %v", err)
+	}
+}
+
+func TestLogoutRevokesFamily(t *testing.T) {
+	tokens := triviatest.NewTokenService()
+	s := &service{tokens: tokens, lifetimes: DefaultTokenLifetimes()}
+	pair := newTestPair(t, s, tokens, 7)
+
+	if err := s.LogoutUserWithToken(pair.Auth.Token); err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens.Auth) != 0 || len(tokens.Refresh) != 0 {
+		t.Errorf("expected every token to be revoked on logout")
+	}
+	if err := s.LogoutUserWithToken(pair.Auth.Token); err != trivia.ErrTokenNotFound {
+		t.Errorf("expected ErrTokenNotFound but got %v", err)
+	}
+}
+
+func TestTokenJanitorPrunesInBatches(t *testing.T) {
+	tokens := triviatest.NewTokenService()
+	expired := &service{tokens: tokens, lifetimes: TokenLifetimes{Auth: -time.Minute, Refresh: -time.Minute}}
+	for idx := 0; idx < 7; idx++ {
+		newTestPair(t, expired, tokens, 7)
+	}
+	active := &service{tokens: tokens, lifetimes: DefaultTokenLifetimes()}
+	kept := newTestPair(t, active, tokens, 7)
+
+	janitor := NewTokenJanitor(tokens, time.Hour, 3)
+	authDeleted, refreshDeleted, err := janitor.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if authDeleted != 7 || refreshDeleted != 7 {
+		t.Errorf("expected 7 auth and 7 refresh tokens to be pruned but got %d and %d", authDeleted, refreshDeleted)
+	}
+	if len(tokens.Auth) != 1 || tokens.Auth[kept.Auth.Token] == nil || len(tokens.Refresh) != 1 {
+		t.Errorf("expected only the tokens that haven't expired to be kept")
+	}
+}
+
+func TestRefreshTokenPairUpdatesSession(t *testing.T) {
+	tokens := triviatest.NewTokenService()
+	s := &service{tokens: tokens, lifetimes: DefaultTokenLifetimes()}
+	original := newTestPair(t, s, tokens, 7)
+
+	client := trivia.ClientInfo{UserAgent: "other", IP: "10.0.0.1"}
+	if _, err := s.RefreshTokenPair(original.Refresh.Token, client); err != nil {
+		t.Fatal(err)
+	}
+
+	session := tokens.Sessions[original.Refresh.Family]
+	if session == nil || session.UserAgent != client.UserAgent || session.IP != client.IP {
+		t.Errorf("expected the session to be updated with the refreshing client but got %+v", session)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	tokens := triviatest.NewTokenService()
+	games := &fakeLiveGames{}
+	s := &service{tokens: tokens, lifetimes: DefaultTokenLifetimes(), games: games}
+	revoked := newTestPair(t, s, tokens, 7)
+	kept := newTestPair(t, s, tokens, 7)
+	session := tokens.Sessions[revoked.Refresh.Family]
+
+	if err := s.RevokeSession(8, session.ID); err != trivia.ErrSessionNotFound {
+		t.Errorf("expected another user's session to not be found but got %v", err)
+	}
+	if err := s.RevokeSession(7, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := tokens.Auth[revoked.Auth.Token]; ok {
+		t.Errorf("expected the session's tokens to be revoked")
+	}
+	if _, ok := tokens.Auth[kept.Auth.Token]; !ok {
+		t.Errorf("expected the user's other sessions to be kept")
+	}
+	if len(games.families) != 1 || games.families[0] != revoked.Refresh.Family {
+		t.Errorf("expected the session's connections to be closed but got %v", games.families)
+	}
+	if err := s.RevokeSession(7, session.ID); err != trivia.ErrSessionNotFound {
+		t.Errorf("expected ErrSessionNotFound but got %v", err)
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	tokens := triviatest.NewTokenService()
+	games := &fakeLiveGames{}
+	s := &service{tokens: tokens, lifetimes: DefaultTokenLifetimes(), games: games}
+	newTestPair(t, s, tokens, 7)
+	newTestPair(t, s, tokens, 7)
+	other := newTestPair(t, s, tokens, 8)
+
+	if err := s.RevokeAllSessions(7); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens.Auth) != 1 || tokens.Auth[other.Auth.Token] == nil || len(tokens.Sessions) != 1 {
+		t.Errorf("expected only the other user's session to be kept")
+	}
+	if len(games.families) != 2 {
+		t.Errorf("expected the connections of 2 sessions to be closed but got %v", games.families)
+	}
+}
+
+func TestUpgradeGuest(t *testing.T) {
+	tokens := triviatest.NewTokenService()
+	results := &fakeResultService{transferred: make(map[int64]int64)}
+	games := &fakeLiveGames{}
+	s := &service{users: &fakeUserService{}, tokens: tokens, results: results, lifetimes: DefaultTokenLifetimes(), games: games}
+
+	guestAuth, guestRefresh, err := s.generateTokenStrings(3, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest := s.newTokenPair(null.Int64{}, null.NewInt64(3), "guest-family", guestAuth, guestRefresh)
+	tokens.CreateTokenPair(guest.Auth, guest.Refresh, trivia.ClientInfo{})
+
+	user, pair, err := s.UpgradeGuest(guest.Auth, "upgraded", "upgraded@example.com", "password", trivia.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results.transferred[3] != user.ID {
+		t.Errorf("expected the guest's games to be transferred to the new user")
+	}
+	if pair.Auth.UserID != null.NewInt64(user.ID) || pair.Auth.GuestID.Valid {
+		t.Errorf("expected the new tokens to belong to the new user")
+	}
+	if games.upgraded[3] != user || games.sessions["guest-family"] != pair.Refresh.Family {
+		t.Errorf("expected live games to move the guest to the new user and session")
+	}
+	if _, ok := tokens.Auth[guest.Auth.Token]; ok {
+		t.Errorf("expected the guest's tokens to be revoked")
+	}
+	if len(games.families) != 0 {
+		t.Errorf("expected the guest's connections to be kept but %v were disconnected", games.families)
+	}
+
+	if _, _, err = s.UpgradeGuest(pair.Auth, "again", "again@example.com", "password", trivia.ClientInfo{}); err != trivia.ErrNotGuest {
+		t.Errorf("expected ErrNotGuest but got %v", err)
+	}
+}
@@ -0,0 +1,433 @@
+package auth
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/mail"
+	"github.com/expixel/actual-trivia-server/trivia/null"
+	"github.com/expixel/actual-trivia-server/trivia/triviatest"
+)
+
+type fakeLiveGames struct {
+	families []string
+	upgraded map[int64]*trivia.User
+	sessions map[string]string
+}
+
+func (g *fakeLiveGames) DisconnectSessions(families []string) {
+	g.families = append(g.families, families...)
+}
+
+func (g *fakeLiveGames) UpgradeGuest(guestID int64, user *trivia.User, guestSession string, session string) {
+	g.upgraded = map[int64]*trivia.User{guestID: user}
+	g.sessions = map[string]string{guestSession: session}
+}
+
+type fakeUserService struct {
+	trivia.UserService
+	users        []*trivia.User
+	creds        []*trivia.UserCred
+	reservations []*trivia.UsernameReservation
+
+	// identities is where identities are linked when users are created with one.
+	identities *fakeIdentityService
+}
+
+func (s *fakeUserService) UserByID(id int64) (*trivia.User, error) {
+	for _, u := range s.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeUserService) UserByUsername(username string) (*trivia.User, error) {
+	for _, u := range s.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeUserService) CredByEmail(email string) (*trivia.UserCred, error) {
+	for _, c := range s.creds {
+		if c.Email == email {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeUserService) ReplacePassword(userID int64, old []byte, password []byte) (bool, error) {
+	for _, c := range s.creds {
+		if c.UserID == userID && bytes.Equal(c.Password, old) {
+			c.Password = password
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *fakeUserService) CredsWithPasswords(afterUserID int64, limit int) ([]*trivia.UserCred, error) {
+	var creds []*trivia.UserCred
+	for _, c := range s.creds {
+		if c.UserID > afterUserID && len(c.Password) > 0 && len(creds) < limit {
+			creds = append(creds, c)
+		}
+	}
+	return creds, nil
+}
+
+func (s *fakeUserService) CredByUsername(username string) (*trivia.UserCred, error) {
+	user, _ := s.UserByUsername(username)
+	if user == nil {
+		return nil, nil
+	}
+	return s.CredByUserID(user.ID)
+}
+
+func (s *fakeUserService) CreateUser(user *trivia.User, cred *trivia.UserCred) error {
+	user.ID = int64(len(s.users) + 1)
+	cred.UserID = user.ID
+	s.users = append(s.users, user)
+	s.creds = append(s.creds, cred)
+	return nil
+}
+
+func (s *fakeUserService) CreateUserWithIdentity(user *trivia.User, cred *trivia.UserCred, identity *trivia.ExternalIdentity) error {
+	if found, _ := s.identities.IdentityBySubject(identity.Provider, identity.Subject); found != nil {
+		return trivia.ErrIdentityInUse
+	}
+	s.CreateUser(user, cred)
+	identity.UserID = user.ID
+	return s.identities.LinkIdentity(identity)
+}
+
+func (s *fakeUserService) CredByUserID(userID int64) (*trivia.UserCred, error) {
+	for _, c := range s.creds {
+		if c.UserID == userID {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeUserService) MarkEmailVerified(userID int64, email string) (bool, error) {
+	for _, c := range s.creds {
+		if c.UserID == userID && c.Email == email {
+			c.EmailVerified = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *fakeUserService) UpdatePassword(userID int64, password []byte) error {
+	for _, c := range s.creds {
+		if c.UserID == userID {
+			c.Password = password
+		}
+	}
+	return nil
+}
+
+func (s *fakeUserService) UpdateEmail(userID int64, email string) error {
+	for _, c := range s.creds {
+		if c.UserID == userID {
+			c.Email = email
+			c.EmailVerified = false
+		}
+	}
+	return nil
+}
+
+func (s *fakeUserService) ChangeUsername(userID int64, username string, reservedUntil time.Time) error {
+	for _, u := range s.users {
+		if u.ID == userID {
+			s.reservations = append(s.reservations, &trivia.UsernameReservation{
+				Username:      u.Username,
+				UserID:        null.NewInt64(userID),
+				ReservedUntil: reservedUntil,
+			})
+			u.Username = username
+			u.UsernameChanged = time.Now()
+		}
+	}
+	return nil
+}
+
+func (s *fakeUserService) DeleteUser(id int64, reservedUntil time.Time) (bool, error) {
+	for idx, u := range s.users {
+		if u.ID == id {
+			s.reservations = append(s.reservations, &trivia.UsernameReservation{
+				Username:      u.Username,
+				ReservedUntil: reservedUntil,
+			})
+			s.users = append(s.users[:idx], s.users[idx+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *fakeUserService) UsernameReservation(username string) (*trivia.UsernameReservation, error) {
+	for _, r := range s.reservations {
+		if strings.EqualFold(r.Username, username) && time.Now().Before(r.ReservedUntil) {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+type fakeResultService struct {
+	trivia.GameResultService
+	transferred map[int64]int64
+}
+
+func (s *fakeResultService) TransferGuestResults(guestID int64, userID int64, username string) (int64, error) {
+	s.transferred[guestID] = userID
+	return 1, nil
+}
+
+type fakeIdentityService struct {
+	identities []*trivia.ExternalIdentity
+}
+
+func (s *fakeIdentityService) IdentityBySubject(provider string, subject string) (*trivia.ExternalIdentity, error) {
+	for _, identity := range s.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeIdentityService) LinkIdentity(identity *trivia.ExternalIdentity) error {
+	if found, _ := s.IdentityBySubject(identity.Provider, identity.Subject); found != nil {
+		return trivia.ErrIdentityInUse
+	}
+	identity.Created = time.Now()
+	s.identities = append(s.identities, identity)
+	return nil
+}
+
+func (s *fakeIdentityService) UserIdentities(userID int64) ([]trivia.ExternalIdentity, error) {
+	identities := make([]trivia.ExternalIdentity, 0)
+	for _, identity := range s.identities {
+		if identity.UserID == userID {
+			identities = append(identities, *identity)
+		}
+	}
+	return identities, nil
+}
+
+type fakeResetService struct {
+	tokens map[string]*trivia.PasswordResetToken
+}
+
+func (s *fakeResetService) CreatePasswordResetToken(token *trivia.PasswordResetToken) error {
+	for t, existing := range s.tokens {
+		if existing.UserID == token.UserID {
+			delete(s.tokens, t)
+		}
+	}
+	s.tokens[token.Token] = token
+	return nil
+}
+
+func (s *fakeResetService) UsePasswordResetToken(token string) (*trivia.PasswordResetToken, error) {
+	reset := s.tokens[token]
+	delete(s.tokens, token)
+	return reset, nil
+}
+
+type fakeMailer struct {
+	sent []*mail.Message
+}
+
+func (m *fakeMailer) Send(msg *mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var emailLinkPattern = regexp.MustCompile(`https://trivia\.example\.com/\S*`)
+
+// sentEmailToken returns the token in the link of the last email that was sent.
+func sentEmailToken(t *testing.T, mailer *fakeMailer) string {
+	t.Helper()
+	if len(mailer.sent) == 0 {
+		t.Fatal("expected an email to be sent")
+	}
+	link := emailLinkPattern.FindString(mailer.sent[len(mailer.sent)-1].Body)
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("token")
+}
+
+type fakeVerificationService struct {
+	tokens map[string]*trivia.EmailVerificationToken
+}
+
+func (s *fakeVerificationService) CreateEmailVerificationToken(token *trivia.EmailVerificationToken) error {
+	for t, existing := range s.tokens {
+		if existing.UserID == token.UserID {
+			delete(s.tokens, t)
+		}
+	}
+	s.tokens[token.Token] = token
+	return nil
+}
+
+func (s *fakeVerificationService) UseEmailVerificationToken(token string) (*trivia.EmailVerificationToken, error) {
+	verification := s.tokens[token]
+	delete(s.tokens, token)
+	return verification, nil
+}
+
+type fakeTwoFactorService struct {
+	secrets map[int64]*trivia.TwoFactor
+	codes   map[int64]map[string]bool
+}
+
+func newFakeTwoFactorService() *fakeTwoFactorService {
+	return &fakeTwoFactorService{secrets: make(map[int64]*trivia.TwoFactor), codes: make(map[int64]map[string]bool)}
+}
+
+func (s *fakeTwoFactorService) TwoFactor(userID int64) (*trivia.TwoFactor, error) {
+	if tf, ok := s.secrets[userID]; ok {
+		copied := *tf
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (s *fakeTwoFactorService) StartTwoFactor(userID int64, secret []byte) (bool, error) {
+	if tf, ok := s.secrets[userID]; ok && tf.Enabled {
+		return false, nil
+	}
+	s.secrets[userID] = &trivia.TwoFactor{UserID: userID, Secret: secret}
+	return true, nil
+}
+
+func (s *fakeTwoFactorService) EnableTwoFactor(userID int64, counter int64, recoveryCodes []string) (bool, error) {
+	tf, ok := s.secrets[userID]
+	if !ok || tf.Enabled {
+		return false, nil
+	}
+	tf.Enabled = true
+	tf.LastCounter = counter
+	return true, s.ReplaceRecoveryCodes(userID, recoveryCodes)
+}
+
+func (s *fakeTwoFactorService) UseTwoFactorCounter(userID int64, counter int64) (bool, error) {
+	tf, ok := s.secrets[userID]
+	if !ok || !tf.Enabled || tf.LastCounter >= counter {
+		return false, nil
+	}
+	tf.LastCounter = counter
+	return true, nil
+}
+
+func (s *fakeTwoFactorService) ReplaceTwoFactorSecret(userID int64, old []byte, secret []byte) (bool, error) {
+	tf, ok := s.secrets[userID]
+	if !ok || string(tf.Secret) != string(old) {
+		return false, nil
+	}
+	tf.Secret = secret
+	return true, nil
+}
+
+func (s *fakeTwoFactorService) DisableTwoFactor(userID int64) error {
+	delete(s.secrets, userID)
+	delete(s.codes, userID)
+	return nil
+}
+
+func (s *fakeTwoFactorService) ReplaceRecoveryCodes(userID int64, codes []string) error {
+	s.codes[userID] = make(map[string]bool)
+	for _, code := range codes {
+		s.codes[userID][code] = true
+	}
+	return nil
+}
+
+func (s *fakeTwoFactorService) UseRecoveryCode(userID int64, code string) (bool, error) {
+	if !s.codes[userID][code] {
+		return false, nil
+	}
+	delete(s.codes[userID], code)
+	return true, nil
+}
+
+func (s *fakeTwoFactorService) RecoveryCodesLeft(userID int64) (int, error) {
+	return len(s.codes[userID]), nil
+}
+
+type fakeLoginChallengeService struct {
+	challenges map[string]*trivia.LoginChallenge
+}
+
+func (s *fakeLoginChallengeService) CreateLoginChallenge(challenge *trivia.LoginChallenge) error {
+	copied := *challenge
+	s.challenges[challenge.Token] = &copied
+	return nil
+}
+
+func (s *fakeLoginChallengeService) LoginChallenge(token string) (*trivia.LoginChallenge, error) {
+	if challenge, ok := s.challenges[token]; ok {
+		copied := *challenge
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (s *fakeLoginChallengeService) CountLoginChallengeAttempt(token string) (int, error) {
+	challenge, ok := s.challenges[token]
+	if !ok {
+		return 0, nil
+	}
+	challenge.Attempts++
+	return challenge.Attempts, nil
+}
+
+func (s *fakeLoginChallengeService) DeleteLoginChallenge(token string) (bool, error) {
+	_, ok := s.challenges[token]
+	delete(s.challenges, token)
+	return ok, nil
+}
+
+// createTestUser creates a user with a password through an auth service.
+func createTestUser(t *testing.T, s *service, username string, email string, password string) *trivia.User {
+	t.Helper()
+	user, _, err := s.CreateUser(username, email, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func newTestPair(t *testing.T, s *service, tokens *triviatest.TokenService, userID int64) *trivia.TokenPair {
+	t.Helper()
+	authString, refreshString, err := s.generateTokenStrings(userID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	family, err := generateTokenFamily()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair := s.newTokenPair(null.NewInt64(userID), null.Int64{}, family, authString, refreshString)
+	tokens.CreateTokenPair(pair.Auth, pair.Refresh, trivia.ClientInfo{UserAgent: "test", IP: "127.0.0.1"})
+	return pair
+}
@@ -1,66 +1,139 @@
 package auth
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/expixel/actual-trivia-server/trivia"
 	"github.com/expixel/actual-trivia-server/trivia/api"
+	"github.com/expixel/actual-trivia-server/trivia/oidc"
 	"github.com/expixel/actual-trivia-server/trivia/validate"
 )
 
+// maxUserAgentLength is the longest user agent that is stored with a session. Anything past
+// this is cut off.
+const maxUserAgentLength = 512
+
 type handler struct {
-	authService trivia.AuthService
+	authService  trivia.AuthService
+	tokenService trivia.AuthTokenService
+	resets       *PasswordResetter
+	verifier     *EmailVerifier
+	accounts     *AccountManager
+	oidcLogin    *OIDCLogin
+	throttle     *LoginThrottle
+	twoFactor    *TwoFactorAuth
 }
 
-func (h *handler) signup(w http.ResponseWriter, r *http.Request) {
-	type signupBody struct {
-		Username string `json:"username"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+// clientInfo returns information about the client that sent a request.
+func clientInfo(r *http.Request) trivia.ClientInfo {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
 	}
 
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return trivia.ClientInfo{UserAgent: userAgent, IP: ip}
+}
+
+type signupBody struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// requireSignupBody reads and validates the body of a request that creates an account or sends
+// the right errors to the client if it is not valid. The returned bool is false if an error was sent.
+func requireSignupBody(w http.ResponseWriter, r *http.Request) (*signupBody, bool) {
 	body := signupBody{}
 	if err := api.RequireJSONBody(w, r, &body); err != nil {
-		return
+		return nil, false
 	}
 
 	body.Username = strings.TrimSpace(body.Username)
-	if len(body.Username) < 3 || len(body.Username) > 64 {
+	if !requireUsername(w, body.Username) {
+		return nil, false
+	}
+
+	if !requirePassword(w, body.Password) {
+		return nil, false
+	}
+
+	body.Email = strings.TrimSpace(body.Email)
+	if !requireEmail(w, body.Email) {
+		return nil, false
+	}
+	return &body, true
+}
+
+// requireUsername makes sure that a new username is valid or sends an error to the client if it isn't.
+func requireUsername(w http.ResponseWriter, username string) bool {
+	if len(username) < 3 || len(username) > 64 {
 		api.Error(w, "Username must be from 3 to 64 characters long.", http.StatusBadRequest)
-		return
+		return false
 	}
-	if !validate.IsValidUsername(body.Username) {
+	if !validate.IsValidUsername(username) {
 		api.Error(w, "Username can only contain the characters a-z, A-Z, 0-9, <, >, -, _, and .", http.StatusBadRequest)
-		return
+		return false
 	}
+	return true
+}
 
-	if len(body.Password) < 6 || len(body.Password) > 256 {
+// requireEmail makes sure that an email address is valid or sends an error to the client if it isn't.
+func requireEmail(w http.ResponseWriter, email string) bool {
+	if !validate.IsEmail(email) {
+		api.Error(w, "A valid email address must be provided.", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// requirePassword makes sure that a new password is an allowed length or sends an error to the
+// client if it isn't.
+func requirePassword(w http.ResponseWriter, password string) bool {
+	if len(password) < 6 || len(password) > 256 {
 		api.Error(w, "Password must be from 3 to 256 characters long.", http.StatusBadRequest)
-		return
+		return false
 	}
+	return true
+}
 
-	body.Email = strings.TrimSpace(body.Email)
-	if !validate.IsEmail(body.Email) {
-		api.Error(w, "A valid email address must be provided.", http.StatusBadRequest)
+// createUserError sends the error for a user that could not be created.
+func createUserError(w http.ResponseWriter, err error) {
+	switch err {
+	case trivia.ErrEmailInUse:
+		api.Error(w, "Email address is already in use.", http.StatusConflict)
+	case trivia.ErrUsernameInUse:
+		api.Error(w, "Username is already in use.", http.StatusConflict)
+	default:
+		logger.Error("error ocurred while creating user: %s", err)
+		api.Error(w, "Unknown error occurred while creating user.", http.StatusInternalServerError)
+	}
+}
+
+func (h *handler) signup(w http.ResponseWriter, r *http.Request) {
+	body, ok := requireSignupBody(w, r)
+	if !ok {
 		return
 	}
 
 	user, _, err := h.authService.CreateUser(body.Username, body.Email, body.Password)
 	if err != nil {
-		switch err {
-		case trivia.ErrEmailInUse:
-			api.Error(w, "Email address is already in use.", http.StatusConflict)
-		case trivia.ErrUsernameInUse:
-			api.Error(w, "Username is already in use.", http.StatusConflict)
-		default:
-			logger.Error("error ocurred while creating user: %s", err)
-			api.Error(w, "Unknown error occurred while creating user.", http.StatusInternalServerError)
-		}
+		createUserError(w, err)
 		return
 	}
+	h.sendVerification(user)
 
 	resp := signupResponse{
 		UserID:   user.ID,
@@ -84,20 +157,129 @@ func (h *handler) login(w http.ResponseWriter, r *http.Request) {
 	// and password in here and make sure that they don't go over our limits.
 	// for now this should be fine though.
 
-	pair, err := h.authService.LoginWithEmailOrUsername(body.Username, body.Password)
+	client := clientInfo(r)
+	attempt, wait, err := h.throttle.Attempt(client.IP, body.Username)
+	if !h.requireLoginAttempt(w, wait, err) {
+		return
+	}
+
+	result, err := h.authService.LoginWithEmailOrUsername(body.Username, body.Password, client)
 	if err != nil {
 		switch err {
-		case trivia.ErrUserNotFound:
-			api.Error(w, "No user with the given email/username and password.", http.StatusNotFound)
-		case trivia.ErrIncorrectPassword:
+		case trivia.ErrUserNotFound, trivia.ErrIncorrectPassword:
+			h.throttle.Failed(attempt)
 			api.Error(w, "No user with the given email/username and password.", http.StatusNotFound)
 		default:
+			h.cancelLoginAttempt(attempt)
 			logger.Error("error ocurred while logging in with email and password: %s", err)
 			api.Error(w, "Unknown error occurred while logging in.", http.StatusInternalServerError)
 		}
 		return
 	}
 
+	// failed logins are only forgotten once the second factor is also right.
+	if result.Challenge != nil {
+		h.cancelLoginAttempt(attempt)
+		api.Response(w, newChallengeResponse(result.Challenge), http.StatusOK)
+		return
+	}
+	if err := h.throttle.Succeeded(attempt); err != nil {
+		logger.Error("error occurred while clearing failed logins: %s", err)
+	}
+	api.Response(w, newLoginResponse(result.Pair), http.StatusOK)
+}
+
+// requireLoginAttempt sends an error to the client if a login couldn't be attempted because of too
+// many failed logins. The returned bool is false if an error was sent.
+func (h *handler) requireLoginAttempt(w http.ResponseWriter, wait time.Duration, err error) bool {
+	if err != nil {
+		logger.Error("error occurred while checking failed logins: %s", err)
+		api.Error(w, "Unknown error occurred while logging in.", http.StatusInternalServerError)
+		return false
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		api.Error(w, "Too many failed logins. Try again later.", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// cancelLoginAttempt takes back a login attempt that didn't fail.
+func (h *handler) cancelLoginAttempt(attempt *LoginAttempt) {
+	if err := h.throttle.Cancel(attempt); err != nil {
+		logger.Error("error occurred while taking back a login attempt: %s", err)
+	}
+}
+
+func newChallengeResponse(challenge *trivia.LoginChallenge) *challengeResponse {
+	return &challengeResponse{
+		TwoFactorRequired:  true,
+		Challenge:          challenge.Token,
+		ChallengeExpiresAt: challenge.ExpiresAt.Unix(),
+	}
+}
+
+// loginTwoFactor is an endpoint that finishes a login that was given a challenge with a code from
+// the user's authenticator app or one of their recovery codes.
+func (h *handler) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type loginTwoFactorBody struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+
+	body := loginTwoFactorBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+
+	userID, err := h.twoFactor.ChallengeUser(body.Challenge)
+	if err != nil {
+		if err == trivia.ErrTokenNotFound {
+			api.Error(w, "Login is invalid or expired. Please log in again.", http.StatusBadRequest)
+		} else {
+			logger.Error("error occurred while finding login challenge: %s", err)
+			api.Error(w, "Unknown error occurred while logging in.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	client := clientInfo(r)
+	attempt, wait, err := h.throttle.AttemptUser(client.IP, userID)
+	if !h.requireLoginAttempt(w, wait, err) {
+		return
+	}
+
+	pair, err := h.authService.FinishLogin(body.Challenge, body.Code, client)
+	if err != nil {
+		switch err {
+		case trivia.ErrIncorrectTwoFactorCode:
+			h.throttle.Failed(attempt)
+			api.Error(w, "Two-factor authentication code is incorrect.", http.StatusUnauthorized)
+		case trivia.ErrTokenNotFound:
+			h.cancelLoginAttempt(attempt)
+			api.Error(w, "Login is invalid or expired. Please log in again.", http.StatusBadRequest)
+		default:
+			h.cancelLoginAttempt(attempt)
+			logger.Error("error occurred while finishing login with two-factor authentication: %s", err)
+			api.Error(w, "Unknown error occurred while logging in.", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := h.throttle.Succeeded(attempt); err != nil {
+		logger.Error("error occurred while clearing failed logins: %s", err)
+	}
+	api.Response(w, newLoginResponse(pair), http.StatusOK)
+}
+
+// guest is an endpoint used to option a guest identity to endter games
+// without making an actual account.
+func (h *handler) guest(w http.ResponseWriter, r *http.Request) {
+	pair, err := h.authService.LoginAsGuest(clientInfo(r))
+	if err != nil {
+		logger.Error("error ocurred while generating guest tokens: %s", err)
+		api.Error(w, "Unknown error occurred while logging in.", http.StatusInternalServerError)
+	}
 	resp := loginResponse{
 		AuthToken:             pair.Auth.Token,
 		AuthTokenExpiresAt:    pair.Auth.ExpiresAt.Unix(),
@@ -107,14 +289,74 @@ func (h *handler) login(w http.ResponseWriter, r *http.Request) {
 	api.Response(w, &resp, http.StatusOK)
 }
 
-// guest is an endpoint used to option a guest identity to endter games
-// without making an actual account.
-func (h *handler) guest(w http.ResponseWriter, r *http.Request) {
-	pair, err := h.authService.LoginAsGuest()
+// upgradeGuest is an endpoint used to turn the guest making the request into a full account. The
+// games the guest has played are kept and any game they are currently in continues with the new account.
+func (h *handler) upgradeGuest(w http.ResponseWriter, r *http.Request) {
+	token, user, err := api.RequireRequestToken(w, r, h.tokenService)
 	if err != nil {
-		logger.Error("error ocurred while generating guest tokens: %s", err)
-		api.Error(w, "Unknown error occurred while logging in.", http.StatusInternalServerError)
+		return
+	}
+	if !user.Guest {
+		api.Error(w, "Only guests can be upgraded to an account.", http.StatusForbidden)
+		return
+	}
+
+	body, ok := requireSignupBody(w, r)
+	if !ok {
+		return
+	}
+
+	user, pair, err := h.authService.UpgradeGuest(token, body.Username, body.Email, body.Password, clientInfo(r))
+	if err != nil {
+		if err == trivia.ErrNotGuest {
+			api.Error(w, "Only guests can be upgraded to an account.", http.StatusForbidden)
+		} else {
+			createUserError(w, err)
+		}
+		return
+	}
+	h.sendVerification(user)
+
+	resp := upgradeResponse{
+		UserID:   user.ID,
+		Username: user.Username,
+		loginResponse: loginResponse{
+			AuthToken:             pair.Auth.Token,
+			AuthTokenExpiresAt:    pair.Auth.ExpiresAt.Unix(),
+			RefreshToken:          pair.Refresh.Token,
+			RefreshTokenExpiresAt: pair.Refresh.ExpiresAt.Unix(),
+		},
 	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// refresh is an endpoint used to exchange a refresh token for a new pair of tokens.
+func (h *handler) refresh(w http.ResponseWriter, r *http.Request) {
+	type refreshBody struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	body := refreshBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+
+	pair, err := h.authService.RefreshTokenPair(body.RefreshToken, clientInfo(r))
+	if err != nil {
+		switch err {
+		case trivia.ErrTokenNotFound:
+			api.Error(w, "Invalid refresh token provided.", http.StatusUnauthorized)
+		case trivia.ErrTokenExpired:
+			api.Error(w, "Refresh token is expired.", http.StatusUnauthorized)
+		case trivia.ErrTokenReused:
+			api.Error(w, "Refresh token was already used. Please log in again.", http.StatusUnauthorized)
+		default:
+			logger.Error("error ocurred while refreshing tokens: %s", err)
+			api.Error(w, "Unknown error occurred while refreshing tokens.", http.StatusInternalServerError)
+		}
+		return
+	}
+
 	resp := loginResponse{
 		AuthToken:             pair.Auth.Token,
 		AuthTokenExpiresAt:    pair.Auth.ExpiresAt.Unix(),
@@ -148,13 +390,719 @@ func (h *handler) logout(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// forgotPassword is an endpoint that emails a password reset link to a user. The response is the
+// same whether or not an account uses the email address.
+func (h *handler) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	type forgotBody struct {
+		Email string `json:"email"`
+	}
+
+	body := forgotBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+
+	body.Email = strings.TrimSpace(body.Email)
+	if !requireEmail(w, body.Email) {
+		return
+	}
+
+	if wait := h.resets.AllowRequest(clientInfo(r).IP, body.Email); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		api.Error(w, "Too many password reset requests. Try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	// the email is sent in the background so that how long the request takes doesn't give away
+	// whether the address has an account.
+	go func() {
+		if err := h.resets.RequestReset(body.Email); err != nil {
+			logger.Error("error occurred while requesting a password reset: %s", err)
+		}
+	}()
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// resetPassword is an endpoint that sets a new password using a reset token.
+func (h *handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	type resetBody struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	body := resetBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+	if !requirePassword(w, body.Password) {
+		return
+	}
+
+	if err := h.resets.Reset(body.Token, body.Password); err != nil {
+		switch err {
+		case trivia.ErrTokenNotFound, trivia.ErrTokenExpired:
+			api.Error(w, "Password reset token is invalid or expired.", http.StatusBadRequest)
+		default:
+			logger.Error("error occurred while resetting password: %s", err)
+			api.Error(w, "Unknown error occurred while resetting password.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// sendVerification emails a verification link to a new user in the background.
+func (h *handler) sendVerification(user *trivia.User) {
+	go func() {
+		if err := h.verifier.SendVerification(user.ID); err != nil {
+			logger.Error("error occurred while sending verification email to user %d: %s", user.ID, err)
+		}
+	}()
+}
+
+// verifyEmail is an endpoint that verifies an email address using a token that was emailed to it.
+func (h *handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	type verifyBody struct {
+		Token string `json:"token"`
+	}
+
+	body := verifyBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+
+	if err := h.verifier.Verify(body.Token); err != nil {
+		switch err {
+		case trivia.ErrTokenNotFound, trivia.ErrTokenExpired:
+			api.Error(w, "Verification token is invalid or expired.", http.StatusBadRequest)
+		default:
+			logger.Error("error occurred while verifying email: %s", err)
+			api.Error(w, "Unknown error occurred while verifying email.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// resendVerification is an endpoint that emails a new verification link to the user.
+func (h *handler) resendVerification(w http.ResponseWriter, r *http.Request) {
+	user, err := api.RequireRequestUser(w, r, h.tokenService)
+	if err != nil {
+		return
+	}
+	if user.Guest {
+		api.Error(w, "Guests do not have email addresses.", http.StatusForbidden)
+		return
+	}
+
+	if err = h.verifier.Resend(user.ID); err != nil {
+		switch err {
+		case trivia.ErrEmailAlreadyVerified:
+			api.Error(w, "Email address is already verified.", http.StatusConflict)
+		case trivia.ErrRateLimited:
+			api.Error(w, "Too many verification emails have been sent. Try again later.", http.StatusTooManyRequests)
+		default:
+			logger.Error("error occurred while resending verification email: %s", err)
+			api.Error(w, "Unknown error occurred while sending verification email.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// requireAccountUser authenticates a registered user for the endpoints that change account
+// details. Guests don't have any details to change.
+func (h *handler) requireAccountUser(w http.ResponseWriter, r *http.Request) (*trivia.AuthToken, *trivia.User, bool) {
+	token, user, err := api.RequireRequestToken(w, r, h.tokenService)
+	if err != nil {
+		return nil, nil, false
+	}
+	if user.Guest {
+		api.Error(w, "Guests cannot change account details.", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return token, user, true
+}
+
+// changePassword is an endpoint that replaces the user's password. Every other session of the
+// user is logged out.
+func (h *handler) changePassword(w http.ResponseWriter, r *http.Request) {
+	token, user, ok := h.requireAccountUser(w, r)
+	if !ok {
+		return
+	}
+
+	type changePasswordBody struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+
+	body := changePasswordBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+	if !requirePassword(w, body.NewPassword) {
+		return
+	}
+
+	if err := h.accounts.ChangePassword(user.ID, token.Family, body.CurrentPassword, body.NewPassword); err != nil {
+		if err == trivia.ErrIncorrectPassword {
+			api.Error(w, "Current password is incorrect.", http.StatusForbidden)
+		} else {
+			logger.Error("error occurred while changing password: %s", err)
+			api.Error(w, "Unknown error occurred while changing password.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// changeEmail is an endpoint that changes the user's email address and emails a verification link
+// to the new address.
+func (h *handler) changeEmail(w http.ResponseWriter, r *http.Request) {
+	token, user, ok := h.requireAccountUser(w, r)
+	if !ok {
+		return
+	}
+
+	type changeEmailBody struct {
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+
+	body := changeEmailBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+	body.Email = strings.TrimSpace(body.Email)
+	if !requireEmail(w, body.Email) {
+		return
+	}
+
+	if err := h.accounts.ChangeEmail(user.ID, token.Family, body.Password, body.Email); err != nil {
+		switch err {
+		case trivia.ErrIncorrectPassword:
+			api.Error(w, "Password is incorrect.", http.StatusForbidden)
+		case trivia.ErrRecentLoginRequired:
+			api.Error(w, "Log in again to change your email address.", http.StatusForbidden)
+		case trivia.ErrEmailInUse:
+			api.Error(w, "Email address is already in use.", http.StatusConflict)
+		default:
+			logger.Error("error occurred while changing email: %s", err)
+			api.Error(w, "Unknown error occurred while changing email.", http.StatusInternalServerError)
+		}
+		return
+	}
+	h.sendVerification(user)
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// changeUsername is an endpoint that changes the user's username.
+func (h *handler) changeUsername(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.requireAccountUser(w, r)
+	if !ok {
+		return
+	}
+
+	type changeUsernameBody struct {
+		Username string `json:"username"`
+	}
+
+	body := changeUsernameBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+	body.Username = strings.TrimSpace(body.Username)
+	if !requireUsername(w, body.Username) {
+		return
+	}
+
+	interval := h.accounts.Options().UsernameChangeInterval
+	user, err := h.accounts.ChangeUsername(user.ID, body.Username)
+	if err != nil {
+		switch err {
+		case trivia.ErrUsernameInUse:
+			api.Error(w, "Username is already in use.", http.StatusConflict)
+		case trivia.ErrRateLimited:
+			api.Error(w, fmt.Sprintf("Username can only be changed once every %d days.", int(interval.Hours()/24)),
+				http.StatusTooManyRequests)
+		default:
+			logger.Error("error occurred while changing username: %s", err)
+			api.Error(w, "Unknown error occurred while changing username.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := usernameResponse{
+		UserID:       user.ID,
+		Username:     user.Username,
+		NextChangeAt: user.UsernameChanged.Add(interval).Unix(),
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// newLoginResponse converts a token pair to its response.
+func newLoginResponse(pair *trivia.TokenPair) *loginResponse {
+	return &loginResponse{
+		AuthToken:             pair.Auth.Token,
+		AuthTokenExpiresAt:    pair.Auth.ExpiresAt.Unix(),
+		RefreshToken:          pair.Refresh.Token,
+		RefreshTokenExpiresAt: pair.Refresh.ExpiresAt.Unix(),
+	}
+}
+
+// oidcProviders is an endpoint that lists the OpenID Connect providers that users can log in with.
+func (h *handler) oidcProviders(w http.ResponseWriter, r *http.Request) {
+	resp := oidcProvidersResponse{Providers: h.oidcLogin.Providers()}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// startOIDC starts a login with a provider for the given user, or 0 for logins that aren't linking
+// an account, and sends the provider's page to the client.
+func (h *handler) startOIDC(w http.ResponseWriter, r *http.Request, linkUserID int64) {
+	u, err := h.oidcLogin.Start(mux.Vars(r)["provider"], linkUserID)
+	if err != nil {
+		if err == ErrProviderNotFound {
+			api.Error(w, "No login provider with the given name.", http.StatusNotFound)
+		} else {
+			logger.Error("error occurred while starting OpenID Connect login: %s", err)
+			api.Error(w, "Login provider could not be reached.", http.StatusBadGateway)
+		}
+		return
+	}
+
+	resp := oidcAuthorizeResponse{URL: u}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// oidcError sends the error for a login with a provider that could not be finished.
+func oidcError(w http.ResponseWriter, err error) {
+	switch {
+	case err == ErrProviderNotFound:
+		api.Error(w, "No login provider with the given name.", http.StatusNotFound)
+	case err == ErrInvalidOIDCState:
+		api.Error(w, "Login is invalid or expired. Please try again.", http.StatusBadRequest)
+	case err == oidc.ErrInvalidGrant:
+		api.Error(w, "Login provider rejected the authorization code. Please try again.", http.StatusBadRequest)
+	case errors.Is(err, oidc.ErrInvalidIDToken):
+		logger.Warn("rejected ID token: %s", err)
+		api.Error(w, "Login provider sent an invalid identity.", http.StatusBadGateway)
+	default:
+		logger.Error("error occurred while finishing OpenID Connect login: %s", err)
+		api.Error(w, "Unknown error occurred while logging in with provider.", http.StatusBadGateway)
+	}
+}
+
+type oidcCallbackBody struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// oidcAuthorize is an endpoint that starts a login with a provider.
+func (h *handler) oidcAuthorize(w http.ResponseWriter, r *http.Request) {
+	h.startOIDC(w, r, 0)
+}
+
+// oidcCallback is an endpoint that finishes a login with a provider using the code and state that
+// the provider sent the user back with. Users whose account at the provider isn't linked yet have
+// to pick a username with the returned signup token.
+func (h *handler) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	body := oidcCallbackBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+
+	result, err := h.oidcLogin.Finish(mux.Vars(r)["provider"], body.Code, body.State, clientInfo(r))
+	if err != nil {
+		oidcError(w, err)
+		return
+	}
+
+	resp := oidcCallbackResponse{}
+	if result.Pair != nil {
+		resp.loginResponse = newLoginResponse(result.Pair)
+	} else if result.Challenge != nil {
+		resp.challengeResponse = newChallengeResponse(result.Challenge)
+	} else {
+		resp.SignupRequired = true
+		resp.SignupToken = result.SignupToken
+		resp.SuggestedUsername = result.SuggestedUsername
+		resp.Email = result.Email
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// oidcSignup is an endpoint that creates an account for a user that logged in with a provider for
+// the first time.
+func (h *handler) oidcSignup(w http.ResponseWriter, r *http.Request) {
+	type oidcSignupBody struct {
+		SignupToken string `json:"signupToken"`
+		Username    string `json:"username"`
+	}
+
+	body := oidcSignupBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+	body.Username = strings.TrimSpace(body.Username)
+	if !requireUsername(w, body.Username) {
+		return
+	}
+
+	user, pair, err := h.oidcLogin.Signup(body.SignupToken, body.Username, clientInfo(r))
+	if err != nil {
+		switch err {
+		case trivia.ErrTokenNotFound:
+			api.Error(w, "Signup token is invalid or expired. Please log in again.", http.StatusBadRequest)
+		case ErrNoProviderEmail:
+			api.Error(w, "Login provider did not share an email address.", http.StatusBadRequest)
+		case trivia.ErrEmailInUse:
+			api.Error(w, "An account already uses this email address. Log in and link the provider from that account instead.",
+				http.StatusConflict)
+		case trivia.ErrIdentityInUse:
+			api.Error(w, "This login is already linked to an account.", http.StatusConflict)
+		default:
+			createUserError(w, err)
+		}
+		return
+	}
+	if !user.EmailVerified {
+		h.sendVerification(user)
+	}
+
+	resp := upgradeResponse{
+		UserID:        user.ID,
+		Username:      user.Username,
+		loginResponse: *newLoginResponse(pair),
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// oidcLinkAuthorize is an endpoint that starts a login with a provider for linking the account at
+// the provider to the user making the request.
+func (h *handler) oidcLinkAuthorize(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.requireAccountUser(w, r)
+	if !ok {
+		return
+	}
+	h.startOIDC(w, r, user.ID)
+}
+
+// oidcLink is an endpoint that finishes a login that was started for linking an account at a provider.
+func (h *handler) oidcLink(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.requireAccountUser(w, r)
+	if !ok {
+		return
+	}
+
+	body := oidcCallbackBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+
+	if err := h.oidcLogin.Link(user.ID, mux.Vars(r)["provider"], body.Code, body.State); err != nil {
+		if err == trivia.ErrIdentityInUse {
+			api.Error(w, "This login is already linked to an account.", http.StatusConflict)
+		} else {
+			oidcError(w, err)
+		}
+		return
+	}
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+type twoFactorCodeBody struct {
+	Code string `json:"code"`
+}
+
+// requireTwoFactorCode reads the code from a request that changes a user's two-factor
+// authentication or sends an error to the client if they have failed too many times. Wrong codes
+// count towards the same limit as failed logins, so the returned attempt must be finished with
+// finishTwoFactorAttempt. The returned bool is false if an error was sent.
+func (h *handler) requireTwoFactorCode(w http.ResponseWriter, r *http.Request, userID int64) (string, *LoginAttempt, bool) {
+	body := twoFactorCodeBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return "", nil, false
+	}
+
+	attempt, wait, err := h.throttle.AttemptUser(clientInfo(r).IP, userID)
+	if err != nil {
+		logger.Error("error occurred while checking failed logins: %s", err)
+		api.Error(w, "Unknown error occurred while checking code.", http.StatusInternalServerError)
+		return "", nil, false
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		api.Error(w, "Too many incorrect codes. Try again later.", http.StatusTooManyRequests)
+		return "", nil, false
+	}
+	return body.Code, attempt, true
+}
+
+// finishTwoFactorAttempt finishes the attempt for a change to a user's two-factor authentication.
+// Only wrong codes count as failures.
+func (h *handler) finishTwoFactorAttempt(attempt *LoginAttempt, err error) {
+	if err == trivia.ErrIncorrectTwoFactorCode {
+		h.throttle.Failed(attempt)
+	} else {
+		h.cancelLoginAttempt(attempt)
+	}
+}
+
+// twoFactorError sends the error for a change to a user's two-factor authentication that failed.
+func (h *handler) twoFactorError(w http.ResponseWriter, err error) {
+	switch err {
+	case trivia.ErrIncorrectTwoFactorCode:
+		api.Error(w, "Two-factor authentication code is incorrect.", http.StatusUnauthorized)
+	case trivia.ErrTwoFactorEnabled:
+		api.Error(w, "Two-factor authentication is already enabled.", http.StatusConflict)
+	case trivia.ErrTwoFactorNotEnabled:
+		api.Error(w, "Two-factor authentication is not enabled.", http.StatusConflict)
+	default:
+		logger.Error("error occurred while changing two-factor authentication: %s", err)
+		api.Error(w, "Unknown error occurred while changing two-factor authentication.", http.StatusInternalServerError)
+	}
+}
+
+// twoFactorStatus is an endpoint that shows whether the user has two-factor authentication enabled.
+func (h *handler) twoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.requireAccountUser(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := h.twoFactor.Status(user.ID)
+	if err != nil {
+		logger.Error("error occurred while getting two-factor authentication status: %s", err)
+		api.Error(w, "Unknown error occurred while getting two-factor authentication status.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := twoFactorStatusResponse{Enabled: status.Enabled, RecoveryCodesLeft: status.RecoveryCodesLeft}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// enrollTwoFactor is an endpoint that generates a secret for the user to add to their
+// authenticator app. Two-factor authentication is enabled once a code is sent to confirmTwoFactor.
+func (h *handler) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.requireAccountUser(w, r)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.twoFactor.Enroll(user.ID)
+	if err != nil {
+		h.twoFactorError(w, err)
+		return
+	}
+
+	resp := twoFactorEnrollResponse{Secret: enrollment.Secret, URI: enrollment.URI}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// confirmTwoFactor is an endpoint that enables two-factor authentication with a code from the
+// user's authenticator app. The response has the user's recovery codes, which aren't shown again.
+func (h *handler) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.requireAccountUser(w, r)
+	if !ok {
+		return
+	}
+	code, attempt, ok := h.requireTwoFactorCode(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	codes, err := h.twoFactor.Confirm(user.ID, code)
+	h.finishTwoFactorAttempt(attempt, err)
+	if err != nil {
+		h.twoFactorError(w, err)
+		return
+	}
+
+	resp := recoveryCodesResponse{RecoveryCodes: codes}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// disableTwoFactor is an endpoint that turns off two-factor authentication with a code from the
+// user's authenticator app or a recovery code.
+func (h *handler) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.requireAccountUser(w, r)
+	if !ok {
+		return
+	}
+	code, attempt, ok := h.requireTwoFactorCode(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	err := h.twoFactor.Disable(user.ID, code)
+	h.finishTwoFactorAttempt(attempt, err)
+	if err != nil {
+		h.twoFactorError(w, err)
+		return
+	}
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// regenerateRecoveryCodes is an endpoint that replaces the user's recovery codes with new ones
+// after checking a code from their authenticator app or one of their old recovery codes.
+func (h *handler) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.requireAccountUser(w, r)
+	if !ok {
+		return
+	}
+	code, attempt, ok := h.requireTwoFactorCode(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(user.ID, code)
+	h.finishTwoFactorAttempt(attempt, err)
+	if err != nil {
+		h.twoFactorError(w, err)
+		return
+	}
+
+	resp := recoveryCodesResponse{RecoveryCodes: codes}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// requireSessionUser authenticates a registered user for the session endpoints. Guests only ever
+// have the session they are using so they can't manage sessions.
+func (h *handler) requireSessionUser(w http.ResponseWriter, r *http.Request) (*trivia.AuthToken, *trivia.User, bool) {
+	token, user, err := api.RequireRequestToken(w, r, h.tokenService)
+	if err != nil {
+		return nil, nil, false
+	}
+	if user.Guest {
+		api.Error(w, "Guests cannot manage sessions.", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return token, user, true
+}
+
+// sessions is an endpoint that lists the sessions that the user is logged in with.
+func (h *handler) sessions(w http.ResponseWriter, r *http.Request) {
+	token, user, ok := h.requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.authService.UserSessions(user.ID)
+	if err != nil {
+		logger.Error("error occurred while getting sessions: %s", err)
+		api.Error(w, "Unknown error occurred while getting sessions.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := sessionsResponse{Sessions: make([]sessionResponse, len(sessions))}
+	for idx, s := range sessions {
+		resp.Sessions[idx] = sessionResponse{
+			ID:         s.ID,
+			CreatedAt:  s.CreatedAt.Unix(),
+			LastUsedAt: s.LastUsedAt.Unix(),
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			Current:    s.Family == token.Family,
+		}
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// revokeSession is an endpoint that logs the user out of one of their sessions.
+func (h *handler) revokeSession(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		api.Error(w, "No session with the given ID.", http.StatusNotFound)
+		return
+	}
+
+	if err = h.authService.RevokeSession(user.ID, sessionID); err != nil {
+		if err == trivia.ErrSessionNotFound {
+			api.Error(w, "No session with the given ID.", http.StatusNotFound)
+		} else {
+			logger.Error("error occurred while revoking session: %s", err)
+			api.Error(w, "Unknown error occurred while revoking session.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// revokeAllSessions is an endpoint that logs the user out everywhere, including the session
+// that was used to make the request.
+func (h *handler) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.authService.RevokeAllSessions(user.ID); err != nil {
+		logger.Error("error occurred while revoking all sessions: %s", err)
+		api.Error(w, "Unknown error occurred while logging out.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := true
+	api.Response(w, &resp, http.StatusOK)
+}
+
 // NewHandler creates a new handler for requests to the authentication api.
-func NewHandler(as trivia.AuthService) http.Handler {
-	h := handler{authService: as}
+func NewHandler(as trivia.AuthService, ts trivia.AuthTokenService, resets *PasswordResetter, verifier *EmailVerifier,
+	accounts *AccountManager, oidcLogin *OIDCLogin, throttle *LoginThrottle, twoFactor *TwoFactorAuth) http.Handler {
+	h := handler{authService: as, tokenService: ts, resets: resets, verifier: verifier, accounts: accounts, oidcLogin: oidcLogin,
+		throttle: throttle, twoFactor: twoFactor}
 	r := mux.NewRouter()
 	r.HandleFunc("/v1/auth/signup", h.signup).Methods("POST")
 	r.HandleFunc("/v1/auth/login", h.login).Methods("POST")
+	r.HandleFunc("/v1/auth/login/2fa", h.loginTwoFactor).Methods("POST")
 	r.HandleFunc("/v1/auth/logout", h.logout).Methods("POST")
 	r.HandleFunc("/v1/auth/guest", h.guest).Methods("POST")
+	r.HandleFunc("/v1/auth/guest/upgrade", h.upgradeGuest).Methods("POST")
+	r.HandleFunc("/v1/auth/refresh", h.refresh).Methods("POST")
+	r.HandleFunc("/v1/auth/password/forgot", h.forgotPassword).Methods("POST")
+	r.HandleFunc("/v1/auth/password/reset", h.resetPassword).Methods("POST")
+	r.HandleFunc("/v1/auth/password/change", h.changePassword).Methods("POST")
+	r.HandleFunc("/v1/auth/email/verify", h.verifyEmail).Methods("POST")
+	r.HandleFunc("/v1/auth/email/resend", h.resendVerification).Methods("POST")
+	r.HandleFunc("/v1/auth/email/change", h.changeEmail).Methods("POST")
+	r.HandleFunc("/v1/auth/username/change", h.changeUsername).Methods("POST")
+	r.HandleFunc("/v1/auth/oidc", h.oidcProviders).Methods("GET")
+	r.HandleFunc("/v1/auth/oidc/signup", h.oidcSignup).Methods("POST")
+	r.HandleFunc("/v1/auth/oidc/{provider}/authorize", h.oidcAuthorize).Methods("POST")
+	r.HandleFunc("/v1/auth/oidc/{provider}/callback", h.oidcCallback).Methods("POST")
+	r.HandleFunc("/v1/auth/oidc/{provider}/link/authorize", h.oidcLinkAuthorize).Methods("POST")
+	r.HandleFunc("/v1/auth/oidc/{provider}/link", h.oidcLink).Methods("POST")
+	r.HandleFunc("/v1/auth/2fa", h.twoFactorStatus).Methods("GET")
+	r.HandleFunc("/v1/auth/2fa/enroll", h.enrollTwoFactor).Methods("POST")
+	r.HandleFunc("/v1/auth/2fa/confirm", h.confirmTwoFactor).Methods("POST")
+	r.HandleFunc("/v1/auth/2fa/disable", h.disableTwoFactor).Methods("POST")
+	r.HandleFunc("/v1/auth/2fa/recovery-codes", h.regenerateRecoveryCodes).Methods("POST")
+	r.HandleFunc("/v1/auth/sessions", h.sessions).Methods("GET")
+	r.HandleFunc("/v1/auth/sessions", h.revokeAllSessions).Methods("DELETE")
+	r.HandleFunc("/v1/auth/sessions/{id}", h.revokeSession).Methods("DELETE")
 	return api.WrapAPIHandler(r)
 }
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/periodic"
+)
+
+// DefaultPruneInterval is how often expired tokens are pruned when no interval is configured.
+const DefaultPruneInterval = time.Hour
+
+// DefaultPruneBatchSize is the number of tokens deleted from each table in a single batch when
+// no batch size is configured.
+const DefaultPruneBatchSize = 1000
+
+// TokenJanitor periodically deletes expired auth and refresh tokens on its own goroutine.
+type TokenJanitor struct {
+	tokens    trivia.AuthTokenService
+	batchSize int
+	runner    *periodic.Runner
+}
+
+// NewTokenJanitor creates a new janitor that prunes expired tokens every interval, deleting up to
+// batchSize tokens from each table at a time.
+func NewTokenJanitor(tokens trivia.AuthTokenService, interval time.Duration, batchSize int) *TokenJanitor {
+	j := &TokenJanitor{
+		tokens:    tokens,
+		batchSize: batchSize,
+	}
+	j.runner = periodic.NewRunner(interval, true, j.prune)
+	return j
+}
+
+// Start starts pruning tokens on its own goroutine.
+func (j *TokenJanitor) Start() {
+	j.runner.Start()
+}
+
+// Stop stops the janitor and waits for it to finish the batch it is deleting.
+func (j *TokenJanitor) Stop() {
+	j.runner.Stop()
+}
+
+func (j *TokenJanitor) prune() {
+	authDeleted, refreshDeleted, err := j.Prune()
+	if err != nil {
+		logger.Error("error occurred while pruning expired tokens: %s", err)
+	}
+	if authDeleted > 0 || refreshDeleted > 0 {
+		logger.Info("pruned %d expired auth tokens and %d expired refresh tokens", authDeleted, refreshDeleted)
+	}
+}
+
+// Prune deletes every token that has expired in batches and returns the number of auth and refresh
+// tokens that were deleted. Pruning stops early if the janitor is stopped.
+func (j *TokenJanitor) Prune() (authDeleted int64, refreshDeleted int64, err error) {
+	now := time.Now()
+	for {
+		authBatch, refreshBatch, err := j.tokens.DeleteExpiredTokens(now, j.batchSize)
+		authDeleted += authBatch
+		refreshDeleted += refreshBatch
+		if err != nil {
+			return authDeleted, refreshDeleted, err
+		}
+
+		if authBatch < int64(j.batchSize) && refreshBatch < int64(j.batchSize) {
+			return authDeleted, refreshDeleted, nil
+		}
+
+		select {
+		case <-j.runner.Stopping():
+			return authDeleted, refreshDeleted, nil
+		default:
+		}
+	}
+}
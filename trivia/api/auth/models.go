@@ -11,3 +11,76 @@ type signupResponse struct {
 	UserID   int64  `json:"userID"`
 	Username string `json:"username"`
 }
+
+type upgradeResponse struct {
+	UserID   int64  `json:"userID"`
+	Username string `json:"username"`
+	loginResponse
+}
+
+type usernameResponse struct {
+	UserID   int64  `json:"userID"`
+	Username string `json:"username"`
+
+	// NextChangeAt is the earliest time that the username can be changed again.
+	NextChangeAt int64 `json:"nextChangeAt"`
+}
+
+type sessionResponse struct {
+	ID         int64  `json:"id"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastUsedAt int64  `json:"lastUsedAt"`
+	UserAgent  string `json:"userAgent"`
+	IP         string `json:"ip"`
+
+	// Current is true for the session that the request was made with.
+	Current bool `json:"current"`
+}
+
+type sessionsResponse struct {
+	Sessions []sessionResponse `json:"sessions"`
+}
+
+type oidcProvidersResponse struct {
+	Providers []string `json:"providers"`
+}
+
+type oidcAuthorizeResponse struct {
+	// URL is the provider's page that the user should be sent to.
+	URL string `json:"url"`
+}
+
+// oidcCallbackResponse is the result of logging in with a provider. If the account at the provider
+// isn't linked to a user yet then SignupRequired is true and the tokens are empty. If the user has
+// two-factor authentication enabled then the login has to be finished with the challenge instead.
+type oidcCallbackResponse struct {
+	SignupRequired    bool   `json:"signupRequired"`
+	SignupToken       string `json:"signupToken,omitempty"`
+	SuggestedUsername string `json:"suggestedUsername,omitempty"`
+	Email             string `json:"email,omitempty"`
+	*loginResponse
+	*challengeResponse
+}
+
+// challengeResponse is sent instead of tokens when a login has to be finished with a two-factor
+// authentication code.
+type challengeResponse struct {
+	TwoFactorRequired  bool   `json:"twoFactorRequired"`
+	Challenge          string `json:"challenge"`
+	ChallengeExpiresAt int64  `json:"challengeExpiresAt"`
+}
+
+type twoFactorStatusResponse struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+type twoFactorEnrollResponse struct {
+	// Secret is the secret for typing into an authenticator app, and URI is for showing as a QR code.
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
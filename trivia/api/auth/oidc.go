@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/oidc"
+	"github.com/expixel/actual-trivia-server/trivia/validate"
+)
+
+// oidcStateLifetime is how long a user has to log in at a provider after starting a login.
+const oidcStateLifetime = 10 * time.Minute
+
+// oidcSignupLifetime is how long a user has to pick a username after logging in at a provider for
+// the first time.
+const oidcSignupLifetime = 15 * time.Minute
+
+// ErrProviderNotFound is returned when there is no OpenID Connect provider with a given name.
+var ErrProviderNotFound = errors.New("auth: no OpenID Connect provider with the given name")
+
+// ErrInvalidOIDCState is returned when a login at a provider is finished with a state that wasn't
+// issued, has expired, or was issued for something else.
+var ErrInvalidOIDCState = errors.New("auth: invalid or expired OpenID Connect state")
+
+// ErrNoProviderEmail is returned when a provider doesn't share an email address for an account
+// that is used to sign up.
+var ErrNoProviderEmail = errors.New("auth: provider did not share an email address")
+
+// oidcState is a login that was started with a provider and hasn't been finished yet.
+type oidcState struct {
+	provider  string
+	verifier  string
+	nonce     string
+	expiresAt time.Time
+
+	// linkUserID is the user that the account at the provider is being linked to, or 0 for logins.
+	linkUserID int64
+}
+
+// oidcSignup is a login of an account at a provider that isn't linked to a user yet.
+type oidcSignup struct {
+	provider  string
+	claims    *oidc.Claims
+	expiresAt time.Time
+}
+
+// OIDCLoginResult is the result of logging in with a provider. If the account at the provider is
+// linked to a user then Pair is set, or Challenge if the user has two-factor authentication
+// enabled, and otherwise the user has to pick a username with SignupToken.
+type OIDCLoginResult struct {
+	Pair      *trivia.TokenPair
+	Challenge *trivia.LoginChallenge
+
+	SignupToken       string
+	SuggestedUsername string
+	Email             string
+}
+
+// OIDCLogin logs users in with OpenID Connect providers. The logins that are in progress are only
+// kept in memory, so a login has to be finished on the same server that it was started on.
+type OIDCLogin struct {
+	providers  map[string]*oidc.Provider
+	users      trivia.UserService
+	identities trivia.ExternalIdentityService
+	auth       trivia.AuthService
+	now        func() time.Time
+
+	// lock guards states, signups, and lastSweep.
+	lock      *sync.Mutex
+	states    map[string]*oidcState
+	signups   map[string]*oidcSignup
+	lastSweep time.Time
+}
+
+// NewOIDCLogin creates logins for the given providers.
+func NewOIDCLogin(providers []*oidc.Provider, users trivia.UserService, identities trivia.ExternalIdentityService,
+	auth trivia.AuthService) *OIDCLogin {
+	l := &OIDCLogin{
+		providers:  make(map[string]*oidc.Provider, len(providers)),
+		users:      users,
+		identities: identities,
+		auth:       auth,
+		now:        time.Now,
+		lock:       &sync.Mutex{},
+		states:     make(map[string]*oidcState),
+		signups:    make(map[string]*oidcSignup),
+	}
+	for _, p := range providers {
+		l.providers[p.Name()] = p
+	}
+	return l
+}
+
+// Providers returns the names of the providers that users can log in with.
+func (l *OIDCLogin) Providers() []string {
+	names := make([]string, 0, len(l.providers))
+	for name := range l.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Start starts a login with a provider and returns the provider's page that the user should be
+// sent to. If linkUserID isn't 0 then the account that the user logs in with is linked to that
+// user with Link instead of being used to log in.
+func (l *OIDCLogin) Start(provider string, linkUserID int64) (string, error) {
+	p, ok := l.providers[provider]
+	if !ok {
+		return "", ErrProviderNotFound
+	}
+
+	req, err := p.NewAuthRequest()
+	if err != nil {
+		return "", err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sweep()
+	l.states[req.State] = &oidcState{
+		provider:   provider,
+		verifier:   req.Verifier,
+		nonce:      req.Nonce,
+		expiresAt:  l.now().Add(oidcStateLifetime),
+		linkUserID: linkUserID,
+	}
+	return req.URL, nil
+}
+
+// exchange finishes a login at a provider by exchanging the authorization code that the provider
+// sent the user back with. The state can only be used once.
+func (l *OIDCLogin) exchange(provider string, code string, state string, linkUserID int64) (*oidc.Claims, error) {
+	l.lock.Lock()
+	s, ok := l.states[state]
+	delete(l.states, state)
+	l.lock.Unlock()
+
+	if !ok || s.provider != provider || s.linkUserID != linkUserID || l.now().After(s.expiresAt) {
+		return nil, ErrInvalidOIDCState
+	}
+	p, ok := l.providers[provider]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+	return p.Exchange(code, s.verifier, s.nonce)
+}
+
+// Finish finishes a login that was started with Start. The user is logged in if their account at
+// the provider is linked to a user, and otherwise they are given a token for signing up with
+// Signup. This returns ErrInvalidOIDCState if the state can't be used, or oidc.ErrInvalidGrant and
+// oidc.ErrInvalidIDToken errors if the provider's response can't be used.
+func (l *OIDCLogin) Finish(provider string, code string, state string, client trivia.ClientInfo) (*OIDCLoginResult, error) {
+	claims, err := l.exchange(provider, code, state, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := l.identities.IdentityBySubject(provider, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		result, err := l.auth.StartLogin(identity.UserID, client)
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCLoginResult{Pair: result.Pair, Challenge: result.Challenge}, nil
+	}
+
+	token, err := generateEmailToken()
+	if err != nil {
+		return nil, err
+	}
+
+	l.lock.Lock()
+	l.signups[token] = &oidcSignup{provider: provider, claims: claims, expiresAt: l.now().Add(oidcSignupLifetime)}
+	l.lock.Unlock()
+
+	return &OIDCLoginResult{
+		SignupToken:       token,
+		SuggestedUsername: suggestUsername(claims),
+		Email:             claims.Email,
+	}, nil
+}
+
+// Signup creates a user with the given username for an account at a provider that a signup token
+// was issued for, links the account to the user, and logs the user in. The user's email address is
+// the one that the provider shared and it is already verified if the provider says so. This returns
+// ErrTokenNotFound if the signup token can't be used, ErrNoProviderEmail if the provider didn't
+// share an email address, and otherwise the same errors as AuthService.CreateUserWithIdentity. The
+// signup token can be used again after ErrUsernameInUse so that the user can pick another username.
+func (l *OIDCLogin) Signup(signupToken string, username string, client trivia.ClientInfo) (*trivia.User, *trivia.TokenPair, error) {
+	// the signup is taken out while it is used so that the same token can't create two users.
+	l.lock.Lock()
+	signup, ok := l.signups[signupToken]
+	delete(l.signups, signupToken)
+	l.lock.Unlock()
+
+	if !ok || l.now().After(signup.expiresAt) {
+		return nil, nil, trivia.ErrTokenNotFound
+	}
+	claims := signup.claims
+	if claims.Email == "" || !validate.IsEmail(claims.Email) {
+		return nil, nil, ErrNoProviderEmail
+	}
+
+	user, _, err := l.auth.CreateUserWithIdentity(username, claims.Email, &trivia.ExternalIdentity{
+		Provider: signup.provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		if err == trivia.ErrUsernameInUse {
+			l.lock.Lock()
+			l.signups[signupToken] = signup
+			l.lock.Unlock()
+		}
+		return nil, nil, err
+	}
+
+	if claims.EmailVerified {
+		if _, err = l.users.MarkEmailVerified(user.ID, claims.Email); err != nil {
+			logger.Error("error occurred while marking the email of user %d verified: %s", user.ID, err)
+		} else {
+			user.EmailVerified = true
+		}
+	}
+
+	pair, err := l.auth.LoginUser(user.ID, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, pair, nil
+}
+
+// Link finishes a login that was started with Start for the given user and links the account at
+// the provider to the user. This returns ErrIdentityInUse if the account is already linked to a
+// user and otherwise the same errors as Finish.
+func (l *OIDCLogin) Link(userID int64, provider string, code string, state string) error {
+	claims, err := l.exchange(provider, code, state, userID)
+	if err != nil {
+		return err
+	}
+
+	return l.identities.LinkIdentity(&trivia.ExternalIdentity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		UserID:   userID,
+		Email:    claims.Email,
+	})
+}
+
+// sweep removes logins and signups that have expired. The lock must be held while this is called.
+func (l *OIDCLogin) sweep() {
+	now := l.now()
+	if now.Sub(l.lastSweep) < oidcStateLifetime {
+		return
+	}
+	l.lastSweep = now
+
+	for state, s := range l.states {
+		if now.After(s.expiresAt) {
+			delete(l.states, state)
+		}
+	}
+	for token, s := range l.signups {
+		if now.After(s.expiresAt) {
+			delete(l.signups, token)
+		}
+	}
+}
+
+// suggestUsername suggests a username for a new user based on what a provider knows about them.
+// This returns an empty string if nothing usable was shared.
+func suggestUsername(claims *oidc.Claims) string {
+	candidates := []string{claims.PreferredUsername, claims.Name}
+	if at := strings.Index(claims.Email, "@"); at > 0 {
+		candidates = append(candidates, claims.Email[:at])
+	}
+
+	for _, candidate := range candidates {
+		username := strings.Map(func(r rune) rune {
+			if r == ' ' {
+				return '_'
+			}
+			if validate.IsValidUsername(string(r)) {
+				return r
+			}
+			return -1
+		}, candidate)
+		if len(username) > 64 {
+			username = username[:64]
+		}
+		if len(username) >= 3 {
+			return username
+		}
+	}
+	return ""
+}
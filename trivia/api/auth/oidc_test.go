@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/oidc"
+	"github.com/expixel/actual-trivia-server/trivia/oidc/oidctest"
+	"github.com/expixel/actual-trivia-server/trivia/triviatest"
+)
+
+var mockIdentity = oidctest.Identity{
+	Subject:           "mock-user-1",
+	Email:             "player@example.com",
+	EmailVerified:     true,
+	PreferredUsername: "mock player",
+}
+
+// newMockProvider starts a mock OIDC provider and returns it with a provider that logs in at it.
+func newMockProvider(t *testing.T) (*oidc.Provider, *oidctest.Provider) {
+	t.Helper()
+	mock := oidctest.NewProvider("trivia", "secret")
+	t.Cleanup(mock.Close)
+	provider := oidc.NewProvider(oidc.Config{
+		Name:         "mock",
+		Issuer:       mock.Issuer(),
+		ClientID:     "trivia",
+		ClientSecret: "secret",
+		RedirectURL:  "https://trivia.example.com/login/mock",
+	}, &http.Client{Timeout: 5 * time.Second})
+	return provider, mock
+}
+
+// loginAtProvider starts a login and logs in at the mock provider, returning the code and state
+// that the provider sends the user back with.
+func loginAtProvider(t *testing.T, l *OIDCLogin, mock *oidctest.Provider, linkUserID int64) (string, string) {
+	t.Helper()
+	u, err := l.Start("mock", linkUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := mock.Authorize(u, mockIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, state
+}
+
+func TestOIDCSignupAndLogin(t *testing.T) {
+	provider, mock := newMockProvider(t)
+	identities := &fakeIdentityService{}
+	users := &fakeUserService{identities: identities}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	l := NewOIDCLogin([]*oidc.Provider{provider}, users, identities, s)
+	createTestUser(t, s, "taken", "taken@example.com", "password")
+
+	code, state := loginAtProvider(t, l, mock, 0)
+	result, err := l.Finish("mock", code, state, trivia.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Pair != nil || result.SignupToken == "" {
+		t.Fatalf("expected a first time login to require a signup")
+	}
+	if result.SuggestedUsername != "mock_player" || result.Email != mockIdentity.Email {
+		t.Errorf("unexpected suggestions: %+v", result)
+	}
+
+	// the signup token can be used again after picking a username that is taken.
+	if _, _, err = l.Signup(result.SignupToken, "taken", trivia.ClientInfo{}); err != trivia.ErrUsernameInUse {
+		t.Errorf("expected ErrUsernameInUse but got %v", err)
+	}
+	user, pair, err := l.Signup(result.SignupToken, "mock_player", trivia.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair.Auth.UserID.Int64 != user.ID {
+		t.Errorf("expected the new user to be logged in")
+	}
+	if cred, _ := users.CredByUserID(user.ID); cred.Password != nil || !cred.EmailVerified {
+		t.Errorf("expected a user without a password and with the provider's verified email address")
+	}
+	if _, _, err = l.Signup(result.SignupToken, "another", trivia.ClientInfo{}); err != trivia.ErrTokenNotFound {
+		t.Errorf("expected a signup token to only create one user but got %v", err)
+	}
+
+	code, state = loginAtProvider(t, l, mock, 0)
+	result, err = l.Finish("mock", code, state, trivia.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Pair == nil || result.Pair.Auth.UserID.Int64 != user.ID {
+		t.Errorf("expected a linked account to log the user in")
+	}
+}
+
+func TestOIDCSignupIdentityInUse(t *testing.T) {
+	provider, mock := newMockProvider(t)
+	identities := &fakeIdentityService{}
+	users := &fakeUserService{identities: identities}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	l := NewOIDCLogin([]*oidc.Provider{provider}, users, identities, s)
+	linker, _, err := s.CreateUser("linker", "linker@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, state := loginAtProvider(t, l, mock, 0)
+	result, err := l.Finish("mock", code, state, trivia.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another user links the same account before the signup is finished.
+	code, state = loginAtProvider(t, l, mock, linker.ID)
+	if err = l.Link(linker.ID, "mock", code, state); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = l.Signup(result.SignupToken, "mock_player", trivia.ClientInfo{}); err != trivia.ErrIdentityInUse {
+		t.Errorf("expected ErrIdentityInUse but got %v", err)
+	}
+	if len(users.users) != 1 {
+		t.Errorf("expected no user to be created without a linked identity but there are %d users", len(users.users))
+	}
+}
+
+func TestOIDCStates(t *testing.T) {
+	provider, mock := newMockProvider(t)
+	identities := &fakeIdentityService{}
+	users := &fakeUserService{identities: identities}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	l := NewOIDCLogin([]*oidc.Provider{provider}, users, identities, s)
+
+	if _, err := l.Start("unknown", 0); err != ErrProviderNotFound {
+		t.Errorf("expected ErrProviderNotFound but got %v", err)
+	}
+
+	code, state := loginAtProvider(t, l, mock, 0)
+	if _, err := l.Finish("mock", code, "made up state", trivia.ClientInfo{}); err != ErrInvalidOIDCState {
+		t.Errorf("expected ErrInvalidOIDCState for an unknown state but got %v", err)
+	}
+	if _, err := l.Finish("mock", code, state, trivia.ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Finish("mock", code, state, trivia.ClientInfo{}); err != ErrInvalidOIDCState {
+		t.Errorf("expected a state to only work once but got %v", err)
+	}
+
+	// a login started for linking can't be used to log in.
+	code, state = loginAtProvider(t, l, mock, 7)
+	if _, err := l.Finish("mock", code, state, trivia.ClientInfo{}); err != ErrInvalidOIDCState {
+		t.Errorf("expected ErrInvalidOIDCState for a linking state but got %v", err)
+	}
+
+	code, state = loginAtProvider(t, l, mock, 0)
+	l.now = func() time.Time { return time.Now().Add(oidcStateLifetime + time.Minute) }
+	if _, err := l.Finish("mock", code, state, trivia.ClientInfo{}); err != ErrInvalidOIDCState {
+		t.Errorf("expected ErrInvalidOIDCState for an expired state but got %v", err)
+	}
+}
+
+func TestOIDCLink(t *testing.T) {
+	provider, mock := newMockProvider(t)
+	identities := &fakeIdentityService{}
+	users := &fakeUserService{identities: identities}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	l := NewOIDCLogin([]*oidc.Provider{provider}, users, identities, s)
+	user, _, err := s.CreateUser("linker", "linker@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, state := loginAtProvider(t, l, mock, user.ID)
+	if err = l.Link(user.ID+1, "mock", code, state); err != ErrInvalidOIDCState {
+		t.Errorf("expected a login started by another user to not be usable but got %v", err)
+	}
+
+	code, state = loginAtProvider(t, l, mock, user.ID)
+	if err = l.Link(user.ID, "mock", code, state); err != nil {
+		t.Fatal(err)
+	}
+
+	code, state = loginAtProvider(t, l, mock, 0)
+	result, err := l.Finish("mock", code, state, trivia.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Pair == nil || result.Pair.Auth.UserID.Int64 != user.ID {
+		t.Errorf("expected the linked account to log in as the user")
+	}
+
+	code, state = loginAtProvider(t, l, mock, user.ID)
+	if err = l.Link(user.ID, "mock", code, state); err != trivia.ErrIdentityInUse {
+		t.Errorf("expected ErrIdentityInUse but got %v", err)
+	}
+}
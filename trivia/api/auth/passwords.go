@@ -1,134 +1,375 @@
 package auth
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // Default pepper that is used if SetAESKeyHex is not called from somewhere else. It's never used.
 const passwordAESPepper = "7c001eb77d617bc94ee1c357c23932dbe6713833022535afc779dfb04ffb06fd"
-const bcryptCost int = 10
 
-var passwordAESKey = make([]byte, 32)
+// Stored passwords are a password hash that is encrypted with a pepper key. There have been three
+// formats for storing them:
+//
+//   - Passwords stored before pepper keys had versions are the IV and a sha256 -> bcrypt hash that is
+//     encrypted with AES-CBC and padded with 0x04 bytes, which is always cbcPasswordLength bytes
+//     long. They are encrypted with the key of version 0.
+//   - passwordFormatVersioned passwords are the same but start with the format and the version of
+//     the pepper key.
+//   - passwordFormatGCM passwords start with the format and the version of the pepper key, followed by
+//     the nonce and a hash that is encrypted with AES-GCM. The hash is in the modular crypt format, so
+//     it can be either an argon2id hash or a bcrypt hash that was re-encrypted without the password.
+//
+// New passwords are always hashed with argon2id and stored as passwordFormatGCM.
+const (
+	passwordFormatVersioned byte = 1
+	passwordFormatGCM       byte = 2
+)
+
+// passwordHeaderLength is the length of the format and key version at the start of stored passwords.
+const passwordHeaderLength = 2
+
+// cbcPasswordLength is the length of an AES-CBC encrypted password without a header: the IV and a 60
+// byte bcrypt hash padded to 64 bytes. passwordFormatGCM passwords are always longer than this with
+// a header since SetArgon2Params doesn't allow argon2id hashes that are shorter than a bcrypt hash.
+const cbcPasswordLength = aes.BlockSize + 64
+
+// argon2idPrefix starts every argon2id hash in the modular crypt format.
+const argon2idPrefix = "$argon2id$"
+
+// ErrUnknownPepperKey is returned when a stored password is encrypted with a pepper key that isn't
+// in the keyring.
+var ErrUnknownPepperKey = errors.New("auth: password is encrypted with an unknown pepper key")
+
+// ErrPasswordMismatch is returned when a password doesn't match a stored password.
+var ErrPasswordMismatch = errors.New("auth: password does not match the stored password")
+
+// pepperKeys are the pepper keys by version, and currentPepperVersion is the version of the key
+// that passwords are encrypted with when they are stored.
+var pepperKeys map[byte][]byte
+var currentPepperVersion byte
+
+// Argon2Params are the parameters that new passwords are hashed with. Stored hashes include the
+// parameters that they were made with, so these can be changed at any time.
+type Argon2Params struct {
+	// Memory is the amount of memory used in KiB.
+	Memory uint32
+
+	// Time is the number of passes over the memory.
+	Time uint32
+
+	// Threads is the number of threads used.
+	Threads uint8
+
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// DefaultArgon2Params returns the parameters used when none are configured, which follow the
+// recommendations of RFC 9106 for when 64 MiB of memory can be used for each hash.
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 4, SaltLength: 16, KeyLength: 32}
+}
+
+var argon2Params = DefaultArgon2Params()
 
 func init() {
 	SetAESKeyHex(passwordAESPepper)
 }
 
-// SetAESKeyHex sets the global pepper used to encrypt HASHED passwords before they are stored
-// in a database.
-func SetAESKeyHex(pepperHex string) {
+// SetArgon2Params sets the global parameters that new passwords are hashed with. Passwords that
+// were hashed with other parameters are hashed again when their owners log in.
+func SetArgon2Params(params Argon2Params) error {
+	if params.Memory < 8*uint32(params.Threads) || params.Time < 1 || params.Threads < 1 {
+		return fmt.Errorf("auth: argon2 needs at least 1 pass, 1 thread, and 8 KiB of memory for each thread")
+	}
+	if params.SaltLength < 8 || params.KeyLength < 16 {
+		return fmt.Errorf("auth: argon2 salts must be at least 8 bytes and keys at least 16 bytes")
+	}
+	argon2Params = params
+	return nil
+}
+
+// decodePepperKey decodes a hex encoded 32 byte pepper key.
+func decodePepperKey(pepperHex string) ([]byte, error) {
 	decodedKey, err := hex.DecodeString(pepperHex)
 	if err != nil {
-		panic(fmt.Errorf("auth: error decoding aesKey: %v", err))
+		return nil, fmt.Errorf("auth: error decoding aesKey: %v", err)
 	}
 
 	if len(decodedKey) != 32 {
-		panic(fmt.Sprintf("auth: init expects the passwordAESKey to be 32 bytes (key is %d bytes)", len(decodedKey)))
+		return nil, fmt.Errorf("auth: init expects the passwordAESKey to be 32 bytes (key is %d bytes)", len(decodedKey))
 	}
+	return decodedKey, nil
+}
 
-	passwordAESKey = decodedKey
+// SetAESKeyHex sets the global pepper used to encrypt HASHED passwords before they are stored
+// in a database. This replaces the keyring with just this key as version 0.
+func SetAESKeyHex(pepperHex string) {
+	if err := SetPepperKeyring(map[byte]string{0: pepperHex}, 0); err != nil {
+		panic(err)
+	}
 }
 
-// padToBlocksize pads a slice of bytes so that its length is a multiple of the given blocksize.
-// It then returns the new padded slice.
-func padToBlocksize(unpadded []byte, blocksize int, paddingByte byte) []byte {
-	required := blocksize - (len(unpadded) % blocksize)
-	newSlice := unpadded
-	if required > 0 {
-		newSlice = make([]byte, len(unpadded)+required)
-		copy(newSlice, unpadded)
-		for i := len(unpadded); i < len(unpadded)+required; i++ {
-			newSlice[i] = paddingByte
+// SetPepperKeyring replaces the global pepper keys with hex encoded keys by version. Passwords are
+// encrypted with the key of the current version when they are stored and can be compared with any
+// key in the keyring, so keys can be rotated by adding a new version, making it current, and
+// removing the old version once every password has been re-encrypted with RekeyPassword.
+func SetPepperKeyring(keysHex map[byte]string, current byte) error {
+	keys := make(map[byte][]byte, len(keysHex))
+	for version, keyHex := range keysHex {
+		key, err := decodePepperKey(keyHex)
+		if err != nil {
+			return fmt.Errorf("auth: pepper key %d: %v", version, err)
 		}
+		keys[version] = key
 	}
-	return newSlice
+	if _, ok := keys[current]; !ok {
+		return fmt.Errorf("auth: there is no pepper key with the current version %d", current)
+	}
+
+	pepperKeys = keys
+	currentPepperVersion = current
+	return nil
 }
 
-func encrypt(unencrypted []byte) (encrypted []byte, err error) {
-	unencrypted = padToBlocksize(unencrypted, aes.BlockSize, 0x04)
-	block, err := aes.NewCipher(passwordAESKey)
+// decryptCBC decrypts a password hash that was stored with AES-CBC and removes its padding. bcrypt
+// hashes never contain the padding byte.
+func decryptCBC(key []byte, encrypted []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 
-	encrypted = make([]byte, aes.BlockSize+len(unencrypted))
-	iv := encrypted[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return nil, err // #CLEANUP I might actually need to panic here but it's not important right now.
+	if len(encrypted) < 2*aes.BlockSize || len(encrypted)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("auth: CBC encrypted password has an invalid length")
 	}
 
-	mode := cipher.NewCBCEncrypter(block, iv)
-	mode.CryptBlocks(encrypted[aes.BlockSize:], unencrypted)
+	iv := encrypted[:aes.BlockSize]
+	encrypted = encrypted[aes.BlockSize:]
 
-	return
+	// decrypted into a new slice so that the stored password isn't changed.
+	unencrypted := make([]byte, len(encrypted))
+	mode := cipher.NewCBCDecrypter(block, iv)
+	mode.CryptBlocks(unencrypted, encrypted)
+
+	return bytes.TrimRight(unencrypted, "\x04"), nil
 }
 
-func decrypt(encrypted []byte) (unencrypted []byte, err error) {
-	block, err := aes.NewCipher(passwordAESKey)
+// newGCM creates the AES-GCM cipher for a pepper key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
-	if len(encrypted) < aes.BlockSize {
-		return nil, fmt.Errorf("ciphertext (encrypted) is smaller than AES block size")
+// seal encrypts a password hash with the current pepper key. The format and key version are
+// authenticated along with the hash so that they can't be changed.
+func seal(hash []byte) ([]byte, error) {
+	gcm, err := newGCM(pepperKeys[currentPepperVersion])
+	if err != nil {
+		return nil, err
 	}
 
-	iv := encrypted[:aes.BlockSize]
-	encrypted = encrypted[aes.BlockSize:]
+	header := []byte{passwordFormatGCM, currentPepperVersion}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
 
-	mode := cipher.NewCBCDecrypter(block, iv)
-	mode.CryptBlocks(encrypted, encrypted)
-	unencrypted = encrypted
+	sealed := append(header, nonce...)
+	return gcm.Seal(sealed, nonce, hash, header), nil
+}
 
-	return
+// openGCM decrypts a passwordFormatGCM password. This returns false if the password isn't one, and
+// an error if it is one but can't be decrypted.
+func openGCM(stored []byte) ([]byte, bool, error) {
+	if len(stored) < passwordHeaderLength || stored[0] != passwordFormatGCM {
+		return nil, false, nil
+	}
+	key, ok := pepperKeys[stored[1]]
+	if !ok {
+		return nil, true, ErrUnknownPepperKey
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, true, err
+	}
+
+	header, rest := stored[:passwordHeaderLength], stored[passwordHeaderLength:]
+	if len(rest) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, true, fmt.Errorf("auth: GCM encrypted password is too short")
+	}
+	hash, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], header)
+	if err != nil {
+		return nil, true, fmt.Errorf("auth: GCM encrypted password could not be authenticated")
+	}
+	return hash, true, nil
 }
 
-// PreparePassword prepares a password for storage by passing it through sha256, bcrypt, and then aes256.
-func PreparePassword(password string) ([]byte, error) {
-	passwordBytes := []byte(password)
+// unseal decrypts a stored password hash with the pepper key that it was encrypted with and returns
+// the format and key version that it was stored with.
+func unseal(stored []byte) (hash []byte, format byte, version byte, err error) {
+	// passwords stored without a format can start with any byte, but they are never as long as a GCM
+	// password.
+	if len(stored) > passwordHeaderLength+cbcPasswordLength {
+		hash, ok, err := openGCM(stored)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if ok {
+			return hash, passwordFormatGCM, stored[1], nil
+		}
+	}
 
-	hasher := sha256.New()
-	_, err := hasher.Write(passwordBytes)
+	switch {
+	case len(stored) == passwordHeaderLength+cbcPasswordLength && stored[0] == passwordFormatVersioned:
+		format, version, stored = passwordFormatVersioned, stored[1], stored[passwordHeaderLength:]
+	case len(stored) == cbcPasswordLength:
+		format, version = 0, 0
+	default:
+		return nil, 0, 0, fmt.Errorf("auth: stored password is not in a known format")
+	}
+
+	key, ok := pepperKeys[version]
+	if !ok {
+		return nil, 0, 0, ErrUnknownPepperKey
+	}
+	hash, err = decryptCBC(key, stored)
+	return hash, format, version, err
+}
+
+// PasswordNeedsRekey returns true if a stored password isn't encrypted with AES-GCM and the current
+// pepper key. This is false for users without a password and for passwords that can't be decrypted.
+func PasswordNeedsRekey(stored []byte) bool {
+	if len(stored) == 0 {
+		return false
+	}
+	_, format, version, err := unseal(stored)
+	return err == nil && (format != passwordFormatGCM || version != currentPepperVersion)
+}
+
+// RekeyPassword re-encrypts a stored password with AES-GCM and the current pepper key. The password
+// itself isn't needed since only the encryption of its hash changes, so bcrypt hashes stay bcrypt
+// hashes until UpgradePassword is used when their owners log in.
+func RekeyPassword(stored []byte) ([]byte, error) {
+	hash, _, _, err := unseal(stored)
 	if err != nil {
 		return nil, err
 	}
-	hashed := hasher.Sum(nil)
+	return seal(hash)
+}
 
-	bcryptedPassword, err := bcrypt.GenerateFromPassword(hashed, bcryptCost)
+// UpgradePassword returns a stored password that is hashed and encrypted the way that new passwords
+// are, or nil if it already is. password must be the password that was already compared with
+// ComparePassword since it is hashed again if the stored hash is outdated.
+func UpgradePassword(stored []byte, password string) ([]byte, error) {
+	hash, format, version, err := unseal(stored)
 	if err != nil {
 		return nil, err
 	}
 
-	encrypted, err := encrypt(bcryptedPassword)
+	if params, ok := parseArgon2idHash(string(hash)); !ok || params.Memory != argon2Params.Memory ||
+		params.Time != argon2Params.Time || params.Threads != argon2Params.Threads ||
+		params.SaltLength != argon2Params.SaltLength || params.KeyLength != argon2Params.KeyLength {
+		return PreparePassword(password)
+	}
+	if format != passwordFormatGCM || version != currentPepperVersion {
+		return seal(hash)
+	}
+	return nil, nil
+}
+
+// argon2idHash hashes a password with argon2id and returns the hash in the modular crypt format.
+func argon2idHash(password string, salt []byte, params Argon2Params) string {
+	key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, params.Memory, params.Time,
+		params.Threads, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// argon2idParts are the parts of an argon2id hash in the modular crypt format.
+type argon2idParts struct {
+	Argon2Params
+	salt []byte
+	key  []byte
+}
+
+// parseArgon2idHash parses an argon2id hash in the modular crypt format. This returns false if the
+// hash isn't one.
+func parseArgon2idHash(hash string) (*argon2idParts, bool) {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return nil, false
+	}
+	fields := strings.Split(hash[len(argon2idPrefix):], "$")
+	if len(fields) != 4 {
+		return nil, false
+	}
+
+	var version int
+	var parts argon2idParts
+	if _, err := fmt.Sscanf(fields[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, false
+	}
+	_, err := fmt.Sscanf(fields[1], "m=%d,t=%d,p=%d", &parts.Memory, &parts.Time, &parts.Threads)
 	if err != nil {
-		return nil, err
+		return nil, false
 	}
+	if parts.salt, err = base64.RawStdEncoding.DecodeString(fields[2]); err != nil {
+		return nil, false
+	}
+	if parts.key, err = base64.RawStdEncoding.DecodeString(fields[3]); err != nil {
+		return nil, false
+	}
+	parts.SaltLength, parts.KeyLength = uint32(len(parts.salt)), uint32(len(parts.key))
+	return &parts, true
+}
 
-	return encrypted, nil
+// PreparePassword prepares a password for storage by hashing it with argon2id and encrypting the
+// hash with AES-GCM and the current pepper key.
+func PreparePassword(password string) ([]byte, error) {
+	salt := make([]byte, argon2Params.SaltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return seal([]byte(argon2idHash(password, salt, argon2Params)))
 }
 
-// ComparePassword compares a prepared password that has been stored somewhere to a plaintext password taken from a user.
+// ComparePassword compares a prepared password that has been stored somewhere to a plaintext password
+// taken from a user. This returns ErrPasswordMismatch if the password doesn't match.
 func ComparePassword(stored []byte, password string) error {
-	passwordBytes := []byte(password)
-	hasher := sha256.New()
-	_, err := hasher.Write(passwordBytes)
+	hash, _, _, err := unseal(stored)
 	if err != nil {
 		return err
 	}
-	hashed := hasher.Sum(nil)
 
-	storedUnencrypted, err := decrypt(stored)
-	if err != nil {
-		return err
+	if parts, ok := parseArgon2idHash(string(hash)); ok {
+		key := argon2.IDKey([]byte(password), parts.salt, parts.Time, parts.Memory, parts.Threads, parts.KeyLength)
+		if subtle.ConstantTimeCompare(key, parts.key) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
 	}
 
-	return bcrypt.CompareHashAndPassword(storedUnencrypted, hashed)
+	// bcrypt hashes are of the sha256 of the password so that long passwords aren't truncated.
+	hashed := sha256.Sum256([]byte(password))
+	err = bcrypt.CompareHashAndPassword(hash, hashed[:])
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrPasswordMismatch
+	}
+	return err
 }
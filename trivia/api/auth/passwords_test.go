@@ -2,23 +2,26 @@ package auth
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
 	"testing"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
+// argon2id with the default parameters -> 97 bytes ($argon2id$v=19$m=65536,t=3,p=4$ + salt + $ + key)
+// GCM tag -> +16 bytes (113 bytes)
+// prepend nonce -> +12 bytes (125 bytes)
+// prepend format and key version -> +2 bytes (127 bytes)
+const expectedAESLength = 127
+
 // bcrypt -> 60 bytes
 // 16byte block size -> +4 bytes (64 bytes)
 // prepend IV -> +16 bytes (80 bytes)
-const expectedAESLength = 80
-
-func TestBlockPadding(t *testing.T) {
-	// ^ it's not a complicated function but I plan to change it (maybe) and I don't trust myself.
-	unpadded := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-	padded := padToBlocksize(unpadded, 8, 6)
-	expected := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 6, 6, 6, 6, 6, 6}
-	if !bytes.Equal(padded, expected) {
-		t.Fatal("auth: slices are being padded incorrectly")
-	}
-}
+const expectedLegacyLength = 80
 
 func TestPasswordComparisons(t *testing.T) {
 	rawShortPassword := "short"
@@ -55,3 +58,225 @@ func TestPasswordComparisons(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+const (
+	testPepperV0 = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testPepperV1 = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+// legacyPassword prepares a password the way it was stored before pepper keys had versions: a
+// sha256 -> bcrypt hash encrypted with AES-CBC and key 0.
+func legacyPassword(t *testing.T, password string) []byte {
+	t.Helper()
+	return cbcPassword(t, pepperKeys[0], password)
+}
+
+// cbcPassword prepares a password the way it was stored before AES-GCM was used.
+func cbcPassword(t *testing.T, key []byte, password string) []byte {
+	t.Helper()
+	hashed := sha256.Sum256([]byte(password))
+	hash, err := bcrypt.GenerateFromPassword(hashed[:], bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bcrypt hashes are 60 bytes, so 4 bytes of padding fill the last block.
+	unencrypted := append(hash, 0x04, 0x04, 0x04, 0x04)
+	encrypted := make([]byte, aes.BlockSize+len(unencrypted))
+	if _, err := io.ReadFull(rand.Reader, encrypted[:aes.BlockSize]); err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCBCEncrypter(block, encrypted[:aes.BlockSize]).CryptBlocks(encrypted[aes.BlockSize:], unencrypted)
+	return encrypted
+}
+
+func TestPepperKeyRotation(t *testing.T) {
+	t.Cleanup(func() { SetAESKeyHex(passwordAESPepper) })
+	SetAESKeyHex(testPepperV0)
+
+	legacy := legacyPassword(t, "password")
+	if len(legacy) != expectedLegacyLength {
+		t.Fatalf("expected a legacy password to be %d bytes but it was %d bytes", expectedLegacyLength, len(legacy))
+	}
+	if !PasswordNeedsRekey(legacy) {
+		t.Errorf("expected a legacy password to need re-encrypting")
+	}
+
+	if err := SetPepperKeyring(map[byte]string{0: testPepperV0, 1: testPepperV1}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ComparePassword(legacy, "password"); err != nil {
+		t.Fatalf("expected a legacy password to be compared with key 0 but got %v", err)
+	}
+
+	rekeyed, err := RekeyPassword(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the bcrypt hash is kept since the password isn't known.
+	if len(rekeyed) != 2+12+60+16 {
+		t.Errorf("expected a re-encrypted bcrypt password to be %d bytes but it was %d bytes", 2+12+60+16, len(rekeyed))
+	}
+	if rekeyed[0] != passwordFormatGCM || rekeyed[1] != 1 || PasswordNeedsRekey(rekeyed) {
+		t.Errorf("expected the password to be encrypted with key 1 but it starts with %v", rekeyed[:2])
+	}
+
+	// once key 0 is removed only the re-encrypted password still works.
+	if err := SetPepperKeyring(map[byte]string{1: testPepperV1}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ComparePassword(rekeyed, "password"); err != nil {
+		t.Errorf("expected the re-encrypted password to be compared with key 1 but got %v", err)
+	}
+	if err := ComparePassword(rekeyed, "wrong"); err != ErrPasswordMismatch {
+		t.Errorf("expected a wrong password to be rejected")
+	}
+	if err := ComparePassword(legacy, "password"); err != ErrUnknownPepperKey {
+		t.Errorf("expected ErrUnknownPepperKey but got %v", err)
+	}
+}
+
+func TestSetPepperKeyringErrors(t *testing.T) {
+	t.Cleanup(func() { SetAESKeyHex(passwordAESPepper) })
+
+	if err := SetPepperKeyring(map[byte]string{1: testPepperV1}, 2); err == nil {
+		t.Errorf("expected an error when the current version has no key")
+	}
+	if err := SetPepperKeyring(map[byte]string{1: "0102"}, 1); err == nil {
+		t.Errorf("expected an error for a key that is too short")
+	}
+}
+
+func TestVersionedCBCPasswords(t *testing.T) {
+	t.Cleanup(func() { SetAESKeyHex(passwordAESPepper) })
+	if err := SetPepperKeyring(map[byte]string{0: testPepperV1, 3: testPepperV0}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// passwords stored with a key version but before AES-GCM was used.
+	versioned := append([]byte{passwordFormatVersioned, 3}, cbcPassword(t, pepperKeys[3], "password")...)
+
+	if err := ComparePassword(versioned, "password"); err != nil {
+		t.Fatalf("expected a versioned CBC password to be compared with key 3 but got %v", err)
+	}
+	if !PasswordNeedsRekey(versioned) {
+		t.Errorf("expected a versioned CBC password to need re-encrypting")
+	}
+}
+
+func TestUpgradePassword(t *testing.T) {
+	t.Cleanup(func() {
+		SetAESKeyHex(passwordAESPepper)
+		SetArgon2Params(DefaultArgon2Params())
+	})
+	SetAESKeyHex(testPepperV0)
+
+	upgraded, err := UpgradePassword(legacyPassword(t, "password"), "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgraded == nil || upgraded[0] != passwordFormatGCM || len(upgraded) != expectedAESLength {
+		t.Fatalf("expected a legacy password to be hashed again with argon2id")
+	}
+	if err := ComparePassword(upgraded, "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if again, err := UpgradePassword(upgraded, "password"); err != nil || again != nil {
+		t.Errorf("expected a current password to be left alone but got %v", err)
+	}
+
+	// passwords are hashed again when the parameters change.
+	params := DefaultArgon2Params()
+	params.Time = 1
+	if err := SetArgon2Params(params); err != nil {
+		t.Fatal(err)
+	}
+	rehashed, err := UpgradePassword(upgraded, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _, _, err := unseal(rehashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts, ok := parseArgon2idHash(string(hash)); !ok || parts.Time != 1 {
+		t.Errorf("expected the password to be hashed with the new parameters but got %s", hash)
+	}
+
+	// a new pepper key only needs the hash to be encrypted again.
+	if err := SetPepperKeyring(map[byte]string{0: testPepperV0, 1: testPepperV1}, 1); err != nil {
+		t.Fatal(err)
+	}
+	rekeyed, err := UpgradePassword(rehashed, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rekeyedHash, _, version, err := unseal(rekeyed)
+	if err != nil || version != 1 || !bytes.Equal(rekeyedHash, hash) {
+		t.Errorf("expected the same hash to be encrypted with key 1")
+	}
+}
+
+func TestGCMPasswordsAreAuthenticated(t *testing.T) {
+	t.Cleanup(func() { SetAESKeyHex(passwordAESPepper) })
+	if err := SetPepperKeyring(map[byte]string{0: testPepperV0, 1: testPepperV1}, 1); err != nil {
+		t.Fatal(err)
+	}
+	prepared, err := PreparePassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, prepared...)
+	tampered[len(tampered)-1] ^= 1
+	if err := ComparePassword(tampered, "password"); err == nil {
+		t.Errorf("expected a modified password to be rejected")
+	}
+
+	// the key version is authenticated too.
+	relabeled := append([]byte{}, prepared...)
+	relabeled[1] = 0
+	if err := ComparePassword(relabeled, "password"); err == nil {
+		t.Errorf("expected a password with a modified key version to be rejected")
+	}
+}
+
+func TestGCMPasswordsWithUnknownKeys(t *testing.T) {
+	t.Cleanup(func() { SetAESKeyHex(passwordAESPepper) })
+	if err := SetPepperKeyring(map[byte]string{0: testPepperV0, 1: testPepperV1}, 1); err != nil {
+		t.Fatal(err)
+	}
+	prepared, err := PreparePassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a multiple of the AES block size long, like passwords stored without a format.
+	stored := make([]byte, 6*aes.BlockSize)
+	copy(stored, prepared)
+	stored[1] = 9
+	if _, _, _, err := unseal(stored); err != ErrUnknownPepperKey {
+		t.Errorf("expected ErrUnknownPepperKey but got %v", err)
+	}
+}
+
+func TestSetArgon2ParamsErrors(t *testing.T) {
+	t.Cleanup(func() { SetArgon2Params(DefaultArgon2Params()) })
+
+	params := DefaultArgon2Params()
+	params.Time = 0
+	if err := SetArgon2Params(params); err == nil {
+		t.Errorf("expected an error for 0 passes")
+	}
+
+	params = DefaultArgon2Params()
+	params.Memory = 16
+	if err := SetArgon2Params(params); err == nil {
+		t.Errorf("expected an error for too little memory")
+	}
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+// DefaultRekeyBatchSize is the number of passwords that are loaded at a time by RekeyPasswords
+// when no batch size is given.
+const DefaultRekeyBatchSize = 500
+
+// rekeyStoredPassword re-encrypts a user's stored password with the current pepper key if it isn't
+// already. This returns false if the password didn't need it or was changed in the meantime.
+func rekeyStoredPassword(users trivia.UserService, cred *trivia.UserCred) (bool, error) {
+	if len(cred.Password) == 0 {
+		return false, nil
+	}
+	// unseal is used instead of PasswordNeedsRekey so that passwords under unknown keys are reported.
+	hash, format, version, err := unseal(cred.Password)
+	if err != nil {
+		return false, err
+	}
+	if format == passwordFormatGCM && version == currentPepperVersion {
+		return false, nil
+	}
+	rekeyed, err := seal(hash)
+	if err != nil {
+		return false, err
+	}
+
+	// a password that was changed while it was being re-encrypted is already under the current key.
+	return users.ReplacePassword(cred.UserID, cred.Password, rekeyed)
+}
+
+// upgradeStoredPassword hashes and encrypts a user's stored password the way that new passwords are
+// if it isn't already. password must already have been compared with the stored password. This
+// returns false if the password didn't need it or was changed in the meantime.
+func upgradeStoredPassword(users trivia.UserService, cred *trivia.UserCred, password string) (bool, error) {
+	upgraded, err := UpgradePassword(cred.Password, password)
+	if err != nil || upgraded == nil {
+		return false, err
+	}
+	return users.ReplacePassword(cred.UserID, cred.Password, upgraded)
+}
+
+// RekeyPasswords re-encrypts every stored password that isn't encrypted with the current pepper key,
+// loading batchSize passwords at a time, and returns how many were re-encrypted. Passwords that are
+// encrypted with a key that isn't in the keyring are skipped and counted in failed, since those
+// users can't log in until they reset their password anyway.
+func RekeyPasswords(users trivia.UserService, batchSize int) (rekeyed int, failed int, err error) {
+	var after int64
+	for {
+		creds, err := users.CredsWithPasswords(after, batchSize)
+		if err != nil {
+			return rekeyed, failed, err
+		}
+
+		for _, cred := range creds {
+			ok, err := rekeyStoredPassword(users, cred)
+			if err == ErrUnknownPepperKey {
+				logger.Warn("password of user %d is encrypted with an unknown pepper key", cred.UserID)
+				failed++
+				continue
+			}
+			if err != nil {
+				return rekeyed, failed, err
+			}
+			if ok {
+				rekeyed++
+			}
+		}
+
+		if len(creds) < batchSize {
+			return rekeyed, failed, nil
+		}
+		after = creds[len(creds)-1].UserID
+	}
+}
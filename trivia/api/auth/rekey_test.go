@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/triviatest"
+)
+
+func TestRekeyPasswords(t *testing.T) {
+	t.Cleanup(func() { SetAESKeyHex(passwordAESPepper) })
+	SetAESKeyHex(testPepperV0)
+
+	users := &fakeUserService{}
+	for i := int64(1); i <= 5; i++ {
+		users.creds = append(users.creds, &trivia.UserCred{UserID: i, Password: legacyPassword(t, "password")})
+	}
+	// users that log in with a provider don't have a password.
+	users.creds = append(users.creds, &trivia.UserCred{UserID: 6})
+
+	if err := SetPepperKeyring(map[byte]string{0: testPepperV0, 1: testPepperV1}, 1); err != nil {
+		t.Fatal(err)
+	}
+	current, err := PreparePassword("current")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.creds[2].Password = current
+
+	rekeyed, failed, err := RekeyPasswords(users, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rekeyed != 4 || failed != 0 {
+		t.Errorf("expected 4 passwords to be re-encrypted but %d were and %d failed", rekeyed, failed)
+	}
+	if !bytes.Equal(users.creds[2].Password, current) {
+		t.Errorf("expected a password under the current key to be left alone")
+	}
+	for _, c := range users.creds {
+		if PasswordNeedsRekey(c.Password) {
+			t.Errorf("expected the password of user %d to be re-encrypted", c.UserID)
+		}
+	}
+}
+
+func TestLoginUpgradesPassword(t *testing.T) {
+	t.Cleanup(func() { SetAESKeyHex(passwordAESPepper) })
+	SetAESKeyHex(testPepperV0)
+
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "player", "player@example.com", "password")
+	users.creds[0].Password = legacyPassword(t, "password")
+
+	if err := SetPepperKeyring(map[byte]string{0: testPepperV0, 1: testPepperV1}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoginWithEmailOrUsername("player", "wrong", trivia.ClientInfo{}); err != trivia.ErrIncorrectPassword {
+		t.Fatalf("expected ErrIncorrectPassword but got %v", err)
+	}
+	if !PasswordNeedsRekey(users.creds[0].Password) {
+		t.Errorf("expected a failed login to leave the password alone")
+	}
+
+	if _, err := s.LoginWithEmailOrUsername("player", "password", trivia.ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	hash, _, version, err := unseal(users.creds[0].Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := parseArgon2idHash(string(hash)); !ok || version != 1 {
+		t.Errorf("expected the password to be hashed with argon2id and encrypted with key 1 after logging in")
+	}
+	if _, err := s.LoginWithEmailOrUsername("player@example.com", "password", trivia.ClientInfo{}); err != nil {
+		t.Errorf("expected the re-encrypted password to work but got %v", err)
+	}
+}
+
+func TestRekeyPasswordsUnknownKey(t *testing.T) {
+	t.Cleanup(func() { SetAESKeyHex(passwordAESPepper) })
+	if err := SetPepperKeyring(map[byte]string{0: testPepperV0, 1: testPepperV1}, 1); err != nil {
+		t.Fatal(err)
+	}
+	retired, err := PreparePassword("retired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := &fakeUserService{creds: []*trivia.UserCred{
+		{UserID: 1, Password: retired},
+		{UserID: 2, Password: legacyPassword(t, "password")},
+	}}
+
+	// key 1 is retired, so the first password can't be decrypted anymore.
+	if err := SetPepperKeyring(map[byte]string{0: testPepperV0, 2: testPepperV1}, 2); err != nil {
+		t.Fatal(err)
+	}
+	rekeyed, failed, err := RekeyPasswords(users, DefaultRekeyBatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rekeyed != 1 || failed != 1 {
+		t.Errorf("expected 1 password to be re-encrypted and 1 to fail but %d were and %d failed", rekeyed, failed)
+	}
+	if !bytes.Equal(users.creds[0].Password, retired) {
+		t.Errorf("expected a password under an unknown key to be left alone")
+	}
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/mail"
+)
+
+// DefaultPasswordResetLifetime is how long a password reset token can be used for when no
+// lifetime is configured.
+const DefaultPasswordResetLifetime = time.Hour
+
+// PasswordResetOptions are the options used for password resets.
+type PasswordResetOptions struct {
+	// Lifetime is how long a reset token can be used for after it was emailed.
+	Lifetime time.Duration
+
+	// URL is the page that users are sent to for choosing a new password. The reset token is
+	// added to it as the token query parameter.
+	URL string
+
+	// MaxRequestsPerEmail is the number of resets that can be requested for an email address within
+	// RequestWindow, and MaxRequestsPerIP is the number that can be requested from an IP address.
+	MaxRequestsPerEmail int
+	MaxRequestsPerIP    int
+	RequestWindow       time.Duration
+}
+
+// DefaultPasswordResetOptions returns the password reset options used when none are configured.
+// The URL has to be configured.
+func DefaultPasswordResetOptions() PasswordResetOptions {
+	return PasswordResetOptions{
+		Lifetime:            DefaultPasswordResetLifetime,
+		MaxRequestsPerEmail: 3,
+		MaxRequestsPerIP:    20,
+		RequestWindow:       time.Hour,
+	}
+}
+
+// PasswordResetter lets users that forgot their passwords choose new ones by emailing them
+// single use reset tokens.
+type PasswordResetter struct {
+	users   trivia.UserService
+	resets  trivia.PasswordResetService
+	auth    trivia.AuthService
+	mailer  mail.Mailer
+	options PasswordResetOptions
+	now     func() time.Time
+
+	// lock guards requested and lastSweep.
+	lock *sync.Mutex
+
+	// requested are the times at which resets were requested for each email address and from each
+	// IP address within the request window.
+	requested map[string][]time.Time
+
+	// lastSweep is the last time that keys with no recent requests were removed from requested.
+	lastSweep time.Time
+}
+
+// NewPasswordResetter creates a new password resetter. Users are logged out of every session through
+// the auth service once their password is reset.
+func NewPasswordResetter(users trivia.UserService, resets trivia.PasswordResetService, auth trivia.AuthService,
+	mailer mail.Mailer, options PasswordResetOptions) *PasswordResetter {
+	return &PasswordResetter{
+		users:     users,
+		resets:    resets,
+		auth:      auth,
+		mailer:    mailer,
+		options:   options,
+		now:       time.Now,
+		lock:      &sync.Mutex{},
+		requested: make(map[string][]time.Time),
+	}
+}
+
+// AllowRequest records a reset request for an email address from an IP address, or returns how
+// long to wait before trying again if either has requested too many resets. Requests are limited
+// by the address that was entered whether or not it has an account, so that the limit doesn't give
+// away which addresses do.
+func (r *PasswordResetter) AllowRequest(ip string, email string) time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	r.sweep(now)
+
+	emailKey := "email:" + strings.ToLower(email)
+	ipKey := "ip:" + ip
+	wait := r.requestWait(emailKey, r.options.MaxRequestsPerEmail, now)
+	if ipWait := r.requestWait(ipKey, r.options.MaxRequestsPerIP, now); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	r.requested[emailKey] = append(r.requested[emailKey], now)
+	r.requested[ipKey] = append(r.requested[ipKey], now)
+	return 0
+}
+
+// requestWait returns how long a key has to wait before it can request another reset. lock must
+// be held.
+func (r *PasswordResetter) requestWait(key string, max int, now time.Time) time.Duration {
+	requested := r.recentRequests(key, now)
+	if len(requested) < max {
+		return 0
+	}
+	return requested[len(requested)-max].Add(r.options.RequestWindow).Sub(now)
+}
+
+// recentRequests returns the times that resets were requested for a key within the request window,
+// dropping any older ones. lock must be held.
+func (r *PasswordResetter) recentRequests(key string, now time.Time) []time.Time {
+	requested := r.requested[key]
+	idx := 0
+	for idx < len(requested) && now.Sub(requested[idx]) >= r.options.RequestWindow {
+		idx++
+	}
+	requested = requested[idx:]
+	if len(requested) == 0 {
+		delete(r.requested, key)
+	} else {
+		r.requested[key] = requested
+	}
+	return requested
+}
+
+// sweep forgets keys that haven't requested a reset within the request window every so often so
+// that the map doesn't keep growing. lock must be held.
+func (r *PasswordResetter) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < r.options.RequestWindow {
+		return
+	}
+	for key := range r.requested {
+		r.recentRequests(key, now)
+	}
+	r.lastSweep = now
+}
+
+// RequestReset emails a reset token to the user with the given email address. Nothing is sent if
+// there is no such user, and no error is returned either so that callers can't tell which email
+// addresses have accounts.
+func (r *PasswordResetter) RequestReset(email string) error {
+	cred, err := r.users.CredByEmail(email)
+	if err != nil {
+		return err
+	}
+	if cred == nil {
+		return nil
+	}
+
+	user, err := r.users.UserByID(cred.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	token, err := generateEmailToken()
+	if err != nil {
+		return err
+	}
+
+	reset := &trivia.PasswordResetToken{
+		Token:     token,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(r.options.Lifetime),
+	}
+	if err = r.resets.CreatePasswordResetToken(reset); err != nil {
+		return err
+	}
+
+	return r.mailer.Send(&mail.Message{
+		To:      cred.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password of your account. If it was you, you can choose a new password here:\n\n"+
+			"%s\n\n"+
+			"This link expires in %s. If you didn't ask to reset your password you can ignore this email.\n",
+			user.Username, tokenURL(r.options.URL, token), r.options.Lifetime),
+	})
+}
+
+// Reset sets a new password for the user that a reset token was emailed to and logs them out
+// everywhere. The token can't be used again afterwards. This returns ErrTokenNotFound or
+// ErrTokenExpired if the token can't be used.
+func (r *PasswordResetter) Reset(token string, password string) error {
+	reset, err := r.resets.UsePasswordResetToken(token)
+	if err != nil {
+		return err
+	}
+	if reset == nil {
+		return trivia.ErrTokenNotFound
+	}
+	if time.Now().After(reset.ExpiresAt) {
+		return trivia.ErrTokenExpired
+	}
+
+	prepared, err := PreparePassword(password)
+	if err != nil {
+		return err
+	}
+	if err = r.users.UpdatePassword(reset.UserID, prepared); err != nil {
+		return err
+	}
+
+	// whoever knew the old password shouldn't stay logged in.
+	return r.auth.RevokeAllSessions(reset.UserID)
+}
+
+// tokenURL returns a link with a token that is emailed to users.
+func tokenURL(link string, token string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		// the URL is checked when the config is loaded so this shouldn't happen.
+		return link + "?token=" + url.QueryEscape(token)
+	}
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// generateEmailToken generates a new token for emailing to a user.
+func generateEmailToken() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
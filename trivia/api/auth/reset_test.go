@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/triviatest"
+)
+
+func TestPasswordReset(t *testing.T) {
+	users := &fakeUserService{}
+	tokens := triviatest.NewTokenService()
+	s := &service{users: users, tokens: tokens, lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "forgetful", "forgetful@example.com", "old password")
+
+	options := DefaultPasswordResetOptions()
+	options.URL = "https://trivia.example.com/reset?from=email"
+	mailer := &fakeMailer{}
+	resetter := NewPasswordResetter(users, &fakeResetService{tokens: make(map[string]*trivia.PasswordResetToken)}, s, mailer, options)
+	loggedIn := newTestPair(t, &service{tokens: tokens, lifetimes: DefaultTokenLifetimes()}, tokens, 1)
+
+	if err := resetter.RequestReset("forgetful@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if mailer.sent[0].To != "forgetful@example.com" {
+		t.Errorf("expected the reset email to be sent to the user but it was sent to %s", mailer.sent[0].To)
+	}
+	token := sentEmailToken(t, mailer)
+
+	if err := resetter.Reset(token, "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if ComparePassword(users.creds[0].Password, "new password") != nil {
+		t.Errorf("expected the password to be changed")
+	}
+	if _, ok := tokens.Auth[loggedIn.Auth.Token]; ok {
+		t.Errorf("expected the user's existing tokens to be revoked")
+	}
+
+	if err := resetter.Reset(token, "another password"); err != trivia.ErrTokenNotFound {
+		t.Errorf("expected the reset token to only work once but got %v", err)
+	}
+}
+
+func TestPasswordResetOnlyLatestTokenWorks(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "forgetful", "forgetful@example.com", "old password")
+
+	options := DefaultPasswordResetOptions()
+	options.URL = "https://trivia.example.com/reset?from=email"
+	mailer := &fakeMailer{}
+	resetter := NewPasswordResetter(users, &fakeResetService{tokens: make(map[string]*trivia.PasswordResetToken)}, s, mailer, options)
+
+	resetter.RequestReset("forgetful@example.com")
+	first := sentEmailToken(t, mailer)
+	resetter.RequestReset("forgetful@example.com")
+	second := sentEmailToken(t, mailer)
+
+	if err := resetter.Reset(first, "new password"); err != trivia.ErrTokenNotFound {
+		t.Errorf("expected the first reset token to be replaced but got %v", err)
+	}
+	if err := resetter.Reset(second, "new password"); err != nil {
+		t.Errorf("expected the latest reset token to work but got %v", err)
+	}
+}
+
+func TestPasswordResetErrors(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "forgetful", "forgetful@example.com", "old password")
+
+	options := DefaultPasswordResetOptions()
+	options.Lifetime = -time.Minute
+	options.URL = "https://trivia.example.com/reset?from=email"
+	mailer := &fakeMailer{}
+	resetter := NewPasswordResetter(users, &fakeResetService{tokens: make(map[string]*trivia.PasswordResetToken)}, s, mailer, options)
+
+	if err := resetter.RequestReset("nobody@example.com"); err != nil || len(mailer.sent) != 0 {
+		t.Errorf("expected nothing to be sent to an unknown email address without an error but got %v", err)
+	}
+
+	resetter.RequestReset("forgetful@example.com")
+	if err := resetter.Reset(sentEmailToken(t, mailer), "new password"); err != trivia.ErrTokenExpired {
+		t.Errorf("expected ErrTokenExpired but got %v", err)
+	}
+}
+
+func TestPasswordResetRequestLimits(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "forgetful", "forgetful@example.com", "old password")
+
+	options := DefaultPasswordResetOptions()
+	options.URL = "https://trivia.example.com/reset?from=email"
+	resetter := NewPasswordResetter(users, &fakeResetService{tokens: make(map[string]*trivia.PasswordResetToken)}, s, &fakeMailer{}, options)
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	resetter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if wait := resetter.AllowRequest("10.0.0.1", "forgetful@example.com"); wait != 0 {
+			t.Fatalf("expected request %d to be allowed but it has to wait %s", i+1, wait)
+		}
+		now = now.Add(time.Minute)
+	}
+
+	// the address is limited whether or not it is typed the same way, and from every IP address.
+	if wait := resetter.AllowRequest("10.0.0.2", "Forgetful@example.com"); wait != 57*time.Minute {
+		t.Errorf("expected the email address to wait 57m0s but it has to wait %s", wait)
+	}
+	now = now.Add(57 * time.Minute)
+	if wait := resetter.AllowRequest("10.0.0.2", "forgetful@example.com"); wait != 0 {
+		t.Errorf("expected the email address to be allowed once its oldest request is out of the window but it has to wait %s", wait)
+	}
+
+	// an IP address can't get around the limit by asking for many addresses.
+	for i := 0; i < 20; i++ {
+		if wait := resetter.AllowRequest("10.0.0.3", fmt.Sprintf("player%d@example.com", i)); wait != 0 {
+			t.Fatalf("expected request %d from the IP address to be allowed but it has to wait %s", i+1, wait)
+		}
+	}
+	if wait := resetter.AllowRequest("10.0.0.3", "someone@example.com"); wait != time.Hour {
+		t.Errorf("expected the IP address to wait 1h0m0s but it has to wait %s", wait)
+	}
+
+	// refused requests aren't counted against the email address.
+	if wait := resetter.AllowRequest("10.0.0.4", "someone@example.com"); wait != 0 {
+		t.Errorf("expected another IP address to be allowed but it has to wait %s", wait)
+	}
+}
+
+func TestForgotPasswordIsLimited(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "forgetful", "forgetful@example.com", "old password")
+
+	options := DefaultPasswordResetOptions()
+	options.URL = "https://trivia.example.com/reset?from=email"
+	resetter := NewPasswordResetter(users, &fakeResetService{tokens: make(map[string]*trivia.PasswordResetToken)}, s, &fakeMailer{}, options)
+	h := &handler{resets: resetter}
+
+	codes := make([]int, 4)
+	for i := range codes {
+		r := httptest.NewRequest("POST", "/v1/auth/password/forgot", strings.NewReader(`{"email": "nobody@example.com"}`))
+		w := httptest.NewRecorder()
+		h.forgotPassword(w, r)
+		codes[i] = w.Code
+	}
+
+	if codes[0] != http.StatusOK || codes[2] != http.StatusOK || codes[3] != http.StatusTooManyRequests {
+		t.Errorf("expected the fourth request for an address to be refused but got %v", codes)
+	}
+}
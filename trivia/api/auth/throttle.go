@@ -0,0 +1,424 @@
+package auth
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/mail"
+	"github.com/expixel/actual-trivia-server/trivia/validate"
+)
+
+// maxLoginKeyLength is the longest email address or username that failed logins are counted by.
+// Anything past this is cut off.
+const maxLoginKeyLength = 128
+
+// ThrottleLimits are how failed logins are limited for one kind of key.
+type ThrottleLimits struct {
+	// FreeFailures is the number of failed logins that are allowed without waiting. Every failure
+	// after that doubles how long the next login has to wait, starting at BaseDelay and going up
+	// to MaxDelay.
+	FreeFailures int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+
+	// LockoutFailures is the number of failed logins after which logins are locked for LockoutDuration.
+	LockoutFailures int
+	LockoutDuration time.Duration
+}
+
+// wait returns how long to wait after the last failed login before trying again.
+func (l ThrottleLimits) wait(failures int) time.Duration {
+	if failures >= l.LockoutFailures {
+		return l.LockoutDuration
+	}
+	if failures < l.FreeFailures {
+		return 0
+	}
+
+	delay := l.BaseDelay
+	for i := l.FreeFailures; i < failures && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.MaxDelay {
+		delay = l.MaxDelay
+	}
+	return delay
+}
+
+// LoginThrottleOptions are the options used for limiting failed logins.
+type LoginThrottleOptions struct {
+	// Account limits the failed logins of a single account from every IP address.
+	Account ThrottleLimits
+
+	// IP limits the failed logins from a single IP address to every account. This is more lenient
+	// since many players can share an address.
+	IP ThrottleLimits
+
+	// ResetAfter is how long after the last failed login the failures are forgotten. This should
+	// be longer than the lockout durations.
+	ResetAfter time.Duration
+}
+
+// DefaultLoginThrottleOptions returns the login throttle options used when none are configured.
+func DefaultLoginThrottleOptions() LoginThrottleOptions {
+	return LoginThrottleOptions{
+		Account: ThrottleLimits{
+			FreeFailures:    5,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			LockoutFailures: 10,
+			LockoutDuration: 15 * time.Minute,
+		},
+		IP: ThrottleLimits{
+			FreeFailures:    20,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			LockoutFailures: 100,
+			LockoutDuration: 15 * time.Minute,
+		},
+		ResetAfter: time.Hour,
+	}
+}
+
+// LoginThrottle slows down and then temporarily locks out logins after too many of them fail, both
+// for each IP address and for each account. Account owners are emailed when their account is locked.
+type LoginThrottle struct {
+	attempts   trivia.LoginAttemptService
+	users      trivia.UserService
+	mailer     mail.Mailer
+	options    LoginThrottleOptions
+	now        func() time.Time
+	background func(func())
+
+	// lock guards lastSweep.
+	lock      *sync.Mutex
+	lastSweep time.Time
+}
+
+// NewLoginThrottle creates a new login throttle that counts failed logins with attempts.
+func NewLoginThrottle(attempts trivia.LoginAttemptService, users trivia.UserService, mailer mail.Mailer,
+	options LoginThrottleOptions) *LoginThrottle {
+	return &LoginThrottle{
+		attempts:   attempts,
+		users:      users,
+		mailer:     mailer,
+		options:    options,
+		now:        time.Now,
+		background: func(f func()) { go f() },
+		lock:       &sync.Mutex{},
+	}
+}
+
+// ipKey returns the key that failed logins from an IP address are counted with.
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// accountKey returns the key that failed logins to an account are counted with and the ID of the
+// account's user. Accounts are counted by user so that switching between the email address and
+// username doesn't get around the limit. Logins to accounts that don't exist are counted by what
+// was entered, and the returned user ID is 0.
+func (t *LoginThrottle) accountKey(emailOrUsername string) (string, int64, error) {
+	var cred *trivia.UserCred
+	var err error
+	if validate.IsEmail(emailOrUsername) {
+		cred, err = t.users.CredByEmail(emailOrUsername)
+	} else {
+		cred, err = t.users.CredByUsername(emailOrUsername)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if cred == nil || cred.UserID == 0 {
+		login := strings.ToLower(emailOrUsername)
+		if len(login) > maxLoginKeyLength {
+			login = login[:maxLoginKeyLength]
+		}
+		return "login:" + login, 0, nil
+	}
+	return userKey(cred.UserID), cred.UserID, nil
+}
+
+// userKey returns the key that failed logins to a user's account are counted with.
+func userKey(userID int64) string {
+	return "user:" + strconv.FormatInt(userID, 10)
+}
+
+// waitFor returns how much longer a key with the given failures has to wait before it can be used
+// to log in again.
+func (t *LoginThrottle) waitFor(attempts *trivia.LoginAttempts, limits ThrottleLimits, now time.Time) time.Duration {
+	if attempts == nil || now.Sub(attempts.LastFailure) > t.options.ResetAfter {
+		return 0
+	}
+
+	remaining := attempts.LastFailure.Add(limits.wait(attempts.Failures)).Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// remaining returns how much longer a key has to wait before it can be used to log in again.
+func (t *LoginThrottle) remaining(key string, limits ThrottleLimits, now time.Time) (time.Duration, error) {
+	attempts, err := t.attempts.LoginAttempts(key)
+	if err != nil {
+		return 0, err
+	}
+	return t.waitFor(attempts, limits, now), nil
+}
+
+// Check returns how long a client has to wait before it can try to log in to an account without
+// counting an attempt. The client can try now if this returns 0.
+func (t *LoginThrottle) Check(ip string, emailOrUsername string) (time.Duration, error) {
+	key, _, err := t.accountKey(emailOrUsername)
+	if err != nil {
+		return 0, err
+	}
+
+	now := t.now()
+	ipWait, err := t.remaining(ipKey(ip), t.options.IP, now)
+	if err != nil {
+		return 0, err
+	}
+	accountWait, err := t.remaining(key, t.options.Account, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if accountWait > ipWait {
+		return accountWait, nil
+	}
+	return ipWait, nil
+}
+
+// A LoginAttempt is a login that was counted as failed before its password or code was checked, so
+// that parallel logins can't all get past the limit before any of them has failed. Every attempt
+// must be finished with Failed, Succeeded or Cancel.
+type LoginAttempt struct {
+	ip     string
+	key    string
+	userID int64
+	at     time.Time
+
+	// ipFailures and accountFailures are the failures including this attempt.
+	ipFailures      int
+	accountFailures int
+}
+
+// Attempt counts a login from a client to an account before the password is checked. If the client
+// has to wait before trying again nothing is counted and the returned duration is how long.
+func (t *LoginThrottle) Attempt(ip string, emailOrUsername string) (*LoginAttempt, time.Duration, error) {
+	key, userID, err := t.accountKey(emailOrUsername)
+	if err != nil {
+		return nil, 0, err
+	}
+	return t.attempt(ip, key, userID)
+}
+
+// AttemptUser is like Attempt for a user that is already known, such as when they are entering a
+// two-factor authentication code. Wrong codes count towards the same limit as wrong passwords.
+func (t *LoginThrottle) AttemptUser(ip string, userID int64) (*LoginAttempt, time.Duration, error) {
+	return t.attempt(ip, userKey(userID), userID)
+}
+
+func (t *LoginThrottle) attempt(ip string, key string, userID int64) (*LoginAttempt, time.Duration, error) {
+	now := t.now()
+	t.sweep(now)
+
+	ipAttempts, wait, err := t.record(ipKey(ip), t.options.IP, now)
+	if err != nil || wait > 0 {
+		return nil, wait, err
+	}
+
+	accountAttempts, wait, err := t.record(key, t.options.Account, now)
+	if err != nil || wait > 0 {
+		if undoErr := t.attempts.UndoLoginFailure(ipKey(ip)); undoErr != nil {
+			logger.Error("error occurred while taking back a login attempt from %s: %s", ip, undoErr)
+		}
+		return nil, wait, err
+	}
+
+	return &LoginAttempt{
+		ip:              ip,
+		key:             key,
+		userID:          userID,
+		at:              now,
+		ipFailures:      ipAttempts.Failures,
+		accountFailures: accountAttempts.Failures,
+	}, 0, nil
+}
+
+// record counts an attempt for a key unless it has to wait, in which case how long is returned.
+func (t *LoginThrottle) record(key string, limits ThrottleLimits, now time.Time) (*trivia.LoginAttempts, time.Duration, error) {
+	var wait time.Duration
+	attempts, allowed, err := t.attempts.RecordLoginAttempt(key, now, t.options.ResetAfter, func(current trivia.LoginAttempts) bool {
+		wait = t.waitFor(&current, limits, now)
+		return wait <= 0
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if !allowed {
+		return nil, wait, nil
+	}
+	return attempts, 0, nil
+}
+
+// Failed finishes an attempt whose password or code was wrong. Lockouts are logged, and the owner of
+// an account is emailed when it is locked. Since locked accounts refuse attempts, only the attempt
+// that starts a lockout can get here with enough failures, so the owner is emailed once per lockout.
+func (t *LoginThrottle) Failed(attempt *LoginAttempt) {
+	if attempt.ipFailures >= t.options.IP.LockoutFailures {
+		logger.Warn("locked out logins from %s for %s after %d failed logins", attempt.ip, t.options.IP.LockoutDuration,
+			attempt.ipFailures)
+	}
+
+	if attempt.accountFailures >= t.options.Account.LockoutFailures {
+		logger.Warn("locked out logins to %s for %s after %d failed logins", attempt.key, t.options.Account.LockoutDuration,
+			attempt.accountFailures)
+		if attempt.userID != 0 {
+			userID, failures, until := attempt.userID, attempt.accountFailures, attempt.at.Add(t.options.Account.LockoutDuration)
+			t.background(func() {
+				if err := t.notifyLockout(userID, failures, until); err != nil {
+					logger.Error("error occurred while notifying user %d of a lockout: %s", userID, err)
+				}
+			})
+		}
+	}
+}
+
+// Succeeded finishes an attempt that logged in, which forgets the failed logins to the account.
+// Failures from the IP address are kept so that logging in to one account doesn't reset the limit
+// for guessing others, but the attempt itself doesn't count against the address.
+func (t *LoginThrottle) Succeeded(attempt *LoginAttempt) error {
+	if err := t.attempts.UndoLoginFailure(ipKey(attempt.ip)); err != nil {
+		return err
+	}
+	return t.attempts.ClearLoginFailures(attempt.key)
+}
+
+// Cancel finishes an attempt that neither failed nor logged in, such as a right password that
+// still needs a two-factor authentication code, so that it doesn't count against either limit.
+func (t *LoginThrottle) Cancel(attempt *LoginAttempt) error {
+	if err := t.attempts.UndoLoginFailure(ipKey(attempt.ip)); err != nil {
+		return err
+	}
+	return t.attempts.UndoLoginFailure(attempt.key)
+}
+
+// notifyLockout emails the owner of an account that was locked.
+func (t *LoginThrottle) notifyLockout(userID int64, failures int, until time.Time) error {
+	user, err := t.users.UserByID(userID)
+	if err != nil || user == nil {
+		return err
+	}
+	cred, err := t.users.CredByUserID(userID)
+	if err != nil || cred == nil {
+		return err
+	}
+
+	return t.mailer.Send(&mail.Message{
+		To:      cred.Email,
+		Subject: "Your account was locked",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"There were %d failed attempts to log in to your account, so logging in is locked until %s.\n\n"+
+			"If this wasn't you, someone may be trying to guess your password. If you are worried that they "+
+			"might succeed, reset your password to a new one that you don't use anywhere else.\n",
+			user.Username, failures, until.UTC().Format("2006-01-02 15:04 MST")),
+	})
+}
+
+// sweep forgets the failed logins of keys that haven't failed for a while. This only does anything
+// once every ResetAfter.
+func (t *LoginThrottle) sweep(now time.Time) {
+	t.lock.Lock()
+	if now.Sub(t.lastSweep) < t.options.ResetAfter {
+		t.lock.Unlock()
+		return
+	}
+	t.lastSweep = now
+	t.lock.Unlock()
+
+	if deleted, err := t.attempts.DeleteStaleLoginAttempts(now.Add(-t.options.ResetAfter)); err != nil {
+		logger.Error("error occurred while deleting stale login attempts: %s", err)
+	} else if deleted > 0 {
+		logger.Debug("deleted %d stale login attempts", deleted)
+	}
+}
+
+type memoryLoginAttempts struct {
+	lock     *sync.Mutex
+	attempts map[string]trivia.LoginAttempts
+}
+
+// NewMemoryLoginAttemptService creates a service that counts failed logins in memory. The counts
+// aren't shared with other servers, so this should only be used when there is a single server.
+func NewMemoryLoginAttemptService() trivia.LoginAttemptService {
+	return &memoryLoginAttempts{lock: &sync.Mutex{}, attempts: make(map[string]trivia.LoginAttempts)}
+}
+
+func (s *memoryLoginAttempts) LoginAttempts(key string) (*trivia.LoginAttempts, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if attempts, ok := s.attempts[key]; ok {
+		return &attempts, nil
+	}
+	return nil, nil
+}
+
+func (s *memoryLoginAttempts) RecordLoginAttempt(key string, at time.Time, resetAfter time.Duration,
+	allow func(current trivia.LoginAttempts) bool) (*trivia.LoginAttempts, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok || attempts.LastFailure.Before(at.Add(-resetAfter)) {
+		attempts = trivia.LoginAttempts{Key: key}
+	}
+	if !allow(attempts) {
+		return &attempts, false, nil
+	}
+
+	attempts.Failures++
+	if at.After(attempts.LastFailure) {
+		attempts.LastFailure = at
+	}
+	s.attempts[key] = attempts
+	return &attempts, true, nil
+}
+
+func (s *memoryLoginAttempts) UndoLoginFailure(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if attempts, ok := s.attempts[key]; ok && attempts.Failures > 0 {
+		attempts.Failures--
+		s.attempts[key] = attempts
+	}
+	return nil
+}
+
+func (s *memoryLoginAttempts) ClearLoginFailures(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *memoryLoginAttempts) DeleteStaleLoginAttempts(before time.Time) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var deleted int64
+	for key, attempts := range s.attempts {
+		if attempts.LastFailure.Before(before) {
+			delete(s.attempts, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
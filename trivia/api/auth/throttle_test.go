@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia/triviatest"
+)
+
+func checkWait(t *testing.T, throttle *LoginThrottle, ip string, login string, expected time.Duration) {
+	t.Helper()
+	wait, err := throttle.Check(ip, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != expected {
+		t.Errorf("expected a login to %s from %s to wait %s but it has to wait %s", login, ip, expected, wait)
+	}
+}
+
+// attempt counts a login that is allowed right away.
+func attempt(t *testing.T, throttle *LoginThrottle, ip string, login string) *LoginAttempt {
+	t.Helper()
+	attempt, wait, err := throttle.Attempt(ip, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait > 0 {
+		t.Fatalf("expected a login to %s from %s to be allowed but it has to wait %s", login, ip, wait)
+	}
+	return attempt
+}
+
+// fail waits as long as the throttle asks before each failed login.
+func fail(t *testing.T, throttle *LoginThrottle, now *time.Time, ip string, login string, times int) {
+	t.Helper()
+	for i := 0; i < times; i++ {
+		wait, err := throttle.Check(ip, login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*now = now.Add(wait)
+		throttle.Failed(attempt(t, throttle, ip, login))
+	}
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "player", "player@example.com", "password")
+
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptService(), users, &fakeMailer{}, DefaultLoginThrottleOptions())
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	throttle.now = func() time.Time { return now }
+	throttle.background = func(f func()) { f() }
+
+	fail(t, throttle, &now, "10.0.0.1", "player", 4)
+	checkWait(t, throttle, "10.0.0.1", "player", 0)
+
+	fail(t, throttle, &now, "10.0.0.1", "player", 1)
+	checkWait(t, throttle, "10.0.0.1", "player", time.Second)
+	fail(t, throttle, &now, "10.0.0.1", "player", 1)
+	checkWait(t, throttle, "10.0.0.1", "player", 2*time.Second)
+	fail(t, throttle, &now, "10.0.0.1", "player", 1)
+	checkWait(t, throttle, "10.0.0.1", "player", 4*time.Second)
+
+	// the account is limited from every address, but other accounts aren't.
+	checkWait(t, throttle, "10.0.0.2", "player", 4*time.Second)
+	checkWait(t, throttle, "10.0.0.1", "someone else", 0)
+}
+
+func TestThrottleBackoffIsCapped(t *testing.T) {
+	limits := DefaultLoginThrottleOptions().Account
+	limits.LockoutFailures = 1000
+	if wait := limits.wait(100); wait != limits.MaxDelay {
+		t.Errorf("expected the wait to be capped at %s but got %s", limits.MaxDelay, wait)
+	}
+}
+
+func TestThrottleLockout(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "player", "player@example.com", "password")
+
+	mailer := &fakeMailer{}
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptService(), users, mailer, DefaultLoginThrottleOptions())
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	throttle.now = func() time.Time { return now }
+	throttle.background = func(f func()) { f() }
+
+	// logging in with the email address and the username counts against the same account.
+	fail(t, throttle, &now, "10.0.0.1", "player", 5)
+	fail(t, throttle, &now, "10.0.0.2", "player@example.com", 5)
+	checkWait(t, throttle, "10.0.0.3", "player", 15*time.Minute)
+
+	if len(mailer.sent) != 1 || mailer.sent[0].To != "player@example.com" {
+		t.Fatalf("expected the owner to be emailed once but got %d emails", len(mailer.sent))
+	}
+	if !strings.Contains(mailer.sent[0].Body, "10 failed attempts") {
+		t.Errorf("expected the email to say how many logins failed: %s", mailer.sent[0].Body)
+	}
+
+	now = now.Add(10 * time.Minute)
+	checkWait(t, throttle, "10.0.0.3", "player", 5*time.Minute)
+	now = now.Add(5 * time.Minute)
+	checkWait(t, throttle, "10.0.0.3", "player", 0)
+}
+
+func TestThrottleLockoutEmailsOnce(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "player", "player@example.com", "password")
+
+	mailer := &fakeMailer{}
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptService(), users, mailer, DefaultLoginThrottleOptions())
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	throttle.now = func() time.Time { return now }
+	throttle.background = func(f func()) { f() }
+
+	fail(t, throttle, &now, "10.0.0.1", "player", 10)
+	for i := 0; i < 20; i++ {
+		_, wait, err := throttle.Attempt("10.0.0.1", "player")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait <= 0 {
+			t.Fatalf("expected logins to a locked account to be refused")
+		}
+		now = now.Add(time.Second)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("expected the owner to be emailed once while the account is locked but got %d emails", len(mailer.sent))
+	}
+
+	// the next failure after the lockout starts a new one.
+	fail(t, throttle, &now, "10.0.0.1", "player", 1)
+	if len(mailer.sent) != 2 {
+		t.Errorf("expected the owner to be emailed again for a new lockout but got %d emails", len(mailer.sent))
+	}
+}
+
+func TestThrottleParallelAttempts(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "player", "player@example.com", "password")
+
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptService(), users, &fakeMailer{}, DefaultLoginThrottleOptions())
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	throttle.now = func() time.Time { return now }
+	throttle.background = func(f func()) { f() }
+
+	allowed := make(chan *LoginAttempt, 50)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt, wait, err := throttle.Attempt("10.0.0.1", "player")
+			if err != nil {
+				t.Error(err)
+			} else if wait <= 0 {
+				allowed <- attempt
+			}
+		}()
+	}
+	wg.Wait()
+	close(allowed)
+
+	if len(allowed) != 5 {
+		t.Errorf("expected only the 5 free attempts to be allowed at once but %d were", len(allowed))
+	}
+	for attempt := range allowed {
+		throttle.Failed(attempt)
+	}
+	checkWait(t, throttle, "10.0.0.1", "player", time.Second)
+}
+
+func TestThrottleCancel(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "player", "player@example.com", "password")
+
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptService(), users, &fakeMailer{}, DefaultLoginThrottleOptions())
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	throttle.now = func() time.Time { return now }
+	throttle.background = func(f func()) { f() }
+
+	for i := 0; i < 30; i++ {
+		if err := throttle.Cancel(attempt(t, throttle, "10.0.0.1", "player")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkWait(t, throttle, "10.0.0.1", "player", 0)
+}
+
+func TestThrottleUnknownAccounts(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "player", "player@example.com", "password")
+
+	mailer := &fakeMailer{}
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptService(), users, mailer, DefaultLoginThrottleOptions())
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	throttle.now = func() time.Time { return now }
+	throttle.background = func(f func()) { f() }
+
+	fail(t, throttle, &now, "10.0.0.1", "Nobody", 10)
+	checkWait(t, throttle, "10.0.0.2", "nobody", 15*time.Minute)
+	if len(mailer.sent) != 0 {
+		t.Errorf("expected no emails for an account that doesn't exist")
+	}
+}
+
+func TestThrottleIP(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "player", "player@example.com", "password")
+
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptService(), users, &fakeMailer{}, DefaultLoginThrottleOptions())
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	throttle.now = func() time.Time { return now }
+	throttle.background = func(f func()) { f() }
+
+	for i := 0; i < 100; i++ {
+		fail(t, throttle, &now, "10.0.0.1", "guess"+strings.Repeat("s", i), 1)
+	}
+	checkWait(t, throttle, "10.0.0.1", "player", 15*time.Minute)
+	checkWait(t, throttle, "10.0.0.2", "player", 0)
+}
+
+func TestThrottleSuccess(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "player", "player@example.com", "password")
+
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptService(), users, &fakeMailer{}, DefaultLoginThrottleOptions())
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	throttle.now = func() time.Time { return now }
+	throttle.background = func(f func()) { f() }
+
+	fail(t, throttle, &now, "10.0.0.1", "player", 6)
+	fail(t, throttle, &now, "10.0.0.1", "nobody", 20)
+	wait, err := throttle.Check("10.0.0.1", "player@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(wait)
+	if err := throttle.Succeeded(attempt(t, throttle, "10.0.0.1", "player@example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	// failures to other accounts from the same address still count.
+	checkWait(t, throttle, "10.0.0.2", "player", 0)
+	checkWait(t, throttle, "10.0.0.1", "player", time.Minute)
+}
+
+func TestThrottleResetAfter(t *testing.T) {
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "player", "player@example.com", "password")
+
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptService(), users, &fakeMailer{}, DefaultLoginThrottleOptions())
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	throttle.now = func() time.Time { return now }
+	throttle.background = func(f func()) { f() }
+
+	checkWait(t, throttle, "10.0.0.1", "player", 0)
+	fail(t, throttle, &now, "10.0.0.1", "player", 9)
+	fail(t, throttle, &now, "10.0.0.1", "nobody", 1)
+	now = now.Add(time.Hour + time.Second)
+	fail(t, throttle, &now, "10.0.0.1", "player", 1)
+	checkWait(t, throttle, "10.0.0.1", "player", 0)
+
+	// failures that are too old to count are swept from the store.
+	attempts, err := throttle.attempts.LoginAttempts("login:nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != nil {
+		t.Errorf("expected stale failures to be deleted but got %+v", attempts)
+	}
+}
@@ -0,0 +1,360 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/totp"
+)
+
+// recoveryCodeLength is the number of characters in a recovery code, not counting the dash that
+// they are shown with.
+const recoveryCodeLength = 10
+
+// TwoFactorOptions are the options used for two-factor authentication.
+type TwoFactorOptions struct {
+	// Issuer is the name that authenticator apps show for accounts.
+	Issuer string
+
+	TOTP totp.Options
+
+	// ChallengeLifetime is how long a user has to enter a code after entering their password.
+	ChallengeLifetime time.Duration
+
+	// MaxChallengeAttempts is the number of codes that can be tried for each challenge.
+	MaxChallengeAttempts int
+
+	// RecoveryCodes is the number of recovery codes that users are given.
+	RecoveryCodes int
+}
+
+// DefaultTwoFactorOptions returns the two-factor authentication options used when none are configured.
+func DefaultTwoFactorOptions() TwoFactorOptions {
+	return TwoFactorOptions{
+		Issuer:               "Actual Trivia",
+		TOTP:                 totp.DefaultOptions(),
+		ChallengeLifetime:    5 * time.Minute,
+		MaxChallengeAttempts: 5,
+		RecoveryCodes:        10,
+	}
+}
+
+// TwoFactorEnrollment is a secret that was generated for a user who is enabling two-factor
+// authentication.
+type TwoFactorEnrollment struct {
+	// Secret is the secret encoded for typing into an authenticator app.
+	Secret string
+
+	// URI is the provisioning URI that authenticator apps read from a QR code.
+	URI string
+}
+
+// TwoFactorStatus describes a user's two-factor authentication.
+type TwoFactorStatus struct {
+	Enabled           bool
+	RecoveryCodesLeft int
+}
+
+// TwoFactorAuth lets users protect their accounts with codes from an authenticator app in addition
+// to their password. Users are also given single use recovery codes for when they lose their app.
+type TwoFactorAuth struct {
+	users      trivia.UserService
+	store      trivia.TwoFactorService
+	challenges trivia.LoginChallengeService
+	options    TwoFactorOptions
+	now        func() time.Time
+}
+
+// NewTwoFactorAuth creates two-factor authentication that stores secrets and recovery codes in
+// store and logins that are waiting for a code in challenges.
+func NewTwoFactorAuth(users trivia.UserService, store trivia.TwoFactorService, challenges trivia.LoginChallengeService,
+	options TwoFactorOptions) *TwoFactorAuth {
+	return &TwoFactorAuth{users: users, store: store, challenges: challenges, options: options, now: time.Now}
+}
+
+// Status returns whether a user has two-factor authentication enabled and how many recovery codes
+// they have left.
+func (a *TwoFactorAuth) Status(userID int64) (*TwoFactorStatus, error) {
+	tf, err := a.store.TwoFactor(userID)
+	if err != nil {
+		return nil, err
+	}
+	if tf == nil || !tf.Enabled {
+		return &TwoFactorStatus{}, nil
+	}
+
+	left, err := a.store.RecoveryCodesLeft(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &TwoFactorStatus{Enabled: true, RecoveryCodesLeft: left}, nil
+}
+
+// Enroll generates a new secret for a user. Two-factor authentication isn't enabled until the user
+// confirms that their app works with Confirm. This returns ErrTwoFactorEnabled if the user already
+// has it enabled.
+func (a *TwoFactorAuth) Enroll(userID int64) (*TwoFactorEnrollment, error) {
+	user, err := a.users.UserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, trivia.ErrUserNotFound
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	started, err := a.store.StartTwoFactor(userID, sealed)
+	if err != nil {
+		return nil, err
+	}
+	if !started {
+		return nil, trivia.ErrTwoFactorEnabled
+	}
+
+	return &TwoFactorEnrollment{
+		Secret: totp.EncodeSecret(secret),
+		URI:    a.options.TOTP.ProvisioningURI(a.options.Issuer, user.Username, secret),
+	}, nil
+}
+
+// Confirm enables two-factor authentication for a user once they enter a code from their app and
+// returns their recovery codes. This returns ErrTwoFactorNotEnabled if the user never enrolled,
+// ErrTwoFactorEnabled if it is already enabled, and ErrIncorrectTwoFactorCode if the code is wrong.
+func (a *TwoFactorAuth) Confirm(userID int64, code string) ([]string, error) {
+	tf, err := a.store.TwoFactor(userID)
+	if err != nil {
+		return nil, err
+	}
+	if tf == nil {
+		return nil, trivia.ErrTwoFactorNotEnabled
+	}
+	if tf.Enabled {
+		return nil, trivia.ErrTwoFactorEnabled
+	}
+
+	counter, ok, err := a.checkTOTP(tf, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, trivia.ErrIncorrectTwoFactorCode
+	}
+
+	codes, err := generateRecoveryCodes(a.options.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := a.store.EnableTwoFactor(userID, int64(counter), normalizeRecoveryCodes(codes))
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, trivia.ErrTwoFactorEnabled
+	}
+	return codes, nil
+}
+
+// Disable turns off two-factor authentication for a user after checking a code from their app or
+// a recovery code. This returns ErrTwoFactorNotEnabled if it isn't enabled and
+// ErrIncorrectTwoFactorCode if the code is wrong.
+func (a *TwoFactorAuth) Disable(userID int64, code string) error {
+	if err := a.verify(userID, code); err != nil {
+		return err
+	}
+	return a.store.DisableTwoFactor(userID)
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes after checking a code from their app or
+// one of their old recovery codes. This returns the same errors as Disable.
+func (a *TwoFactorAuth) RegenerateRecoveryCodes(userID int64, code string) ([]string, error) {
+	if err := a.verify(userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := generateRecoveryCodes(a.options.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	if err = a.store.ReplaceRecoveryCodes(userID, normalizeRecoveryCodes(codes)); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// challenge creates a login challenge for a user if they have two-factor authentication enabled,
+// and otherwise returns nil.
+func (a *TwoFactorAuth) challenge(userID int64) (*trivia.LoginChallenge, error) {
+	tf, err := a.store.TwoFactor(userID)
+	if err != nil || tf == nil || !tf.Enabled {
+		return nil, err
+	}
+
+	token, err := generateEmailToken()
+	if err != nil {
+		return nil, err
+	}
+	challenge := &trivia.LoginChallenge{
+		Token:     token,
+		UserID:    userID,
+		ExpiresAt: a.now().Add(a.options.ChallengeLifetime),
+	}
+	if err = a.challenges.CreateLoginChallenge(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// ChallengeUser returns the user that a login challenge is for so that failed attempts can be
+// counted against their account. This returns ErrTokenNotFound if the challenge has expired.
+func (a *TwoFactorAuth) ChallengeUser(token string) (int64, error) {
+	challenge, err := a.challenges.LoginChallenge(token)
+	if err != nil {
+		return 0, err
+	}
+	if challenge == nil {
+		return 0, trivia.ErrTokenNotFound
+	}
+	return challenge.UserID, nil
+}
+
+// finishChallenge checks a code for a login challenge and returns the user that the login is for.
+// Each challenge can only be finished once and only tried MaxChallengeAttempts times.
+func (a *TwoFactorAuth) finishChallenge(token string, code string) (int64, error) {
+	challenge, err := a.challenges.LoginChallenge(token)
+	if err != nil {
+		return 0, err
+	}
+	if challenge == nil {
+		return 0, trivia.ErrTokenNotFound
+	}
+
+	attempts, err := a.challenges.CountLoginChallengeAttempt(token)
+	if err != nil {
+		return 0, err
+	}
+	if attempts == 0 || attempts > a.options.MaxChallengeAttempts {
+		if _, err = a.challenges.DeleteLoginChallenge(token); err != nil {
+			return 0, err
+		}
+		return 0, trivia.ErrTokenNotFound
+	}
+
+	if err = a.verify(challenge.UserID, code); err != nil {
+		if err == trivia.ErrTwoFactorNotEnabled {
+			err = trivia.ErrTokenNotFound
+		}
+		return 0, err
+	}
+
+	deleted, err := a.challenges.DeleteLoginChallenge(token)
+	if err != nil {
+		return 0, err
+	}
+	if !deleted {
+		return 0, trivia.ErrTokenNotFound
+	}
+	return challenge.UserID, nil
+}
+
+// verify checks a code from a user's app or one of their recovery codes, which is used up.
+func (a *TwoFactorAuth) verify(userID int64, code string) error {
+	tf, err := a.store.TwoFactor(userID)
+	if err != nil {
+		return err
+	}
+	if tf == nil || !tf.Enabled {
+		return trivia.ErrTwoFactorNotEnabled
+	}
+
+	counter, ok, err := a.checkTOTP(tf, code)
+	if err != nil {
+		return err
+	}
+	if ok {
+		// codes can't be used twice, so a code that was seen by someone else is useless to them.
+		used, err := a.store.UseTwoFactorCounter(userID, int64(counter))
+		if err != nil {
+			return err
+		}
+		if !used {
+			return trivia.ErrIncorrectTwoFactorCode
+		}
+		return nil
+	}
+
+	used, err := a.store.UseRecoveryCode(userID, normalizeRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return trivia.ErrIncorrectTwoFactorCode
+	}
+	return nil
+}
+
+// checkTOTP checks a code from a user's app and returns its counter if it is valid and wasn't
+// already used. Secrets that are encrypted with an old pepper key are encrypted again.
+func (a *TwoFactorAuth) checkTOTP(tf *trivia.TwoFactor, code string) (uint64, bool, error) {
+	secret, ok, err := openGCM(tf.Secret)
+	if err != nil {
+		return 0, false, err
+	}
+	if !ok {
+		return 0, false, errors.New("auth: two-factor secret could not be decrypted")
+	}
+
+	counter, ok := a.options.TOTP.Validate(secret, code, a.now())
+	if !ok || int64(counter) <= tf.LastCounter {
+		return 0, false, nil
+	}
+
+	if tf.Secret[1] != currentPepperVersion {
+		if sealed, err := seal(secret); err != nil {
+			logger.Error("error occurred while encrypting the two-factor secret of user %d: %s", tf.UserID, err)
+		} else if _, err = a.store.ReplaceTwoFactorSecret(tf.UserID, tf.Secret, sealed); err != nil {
+			logger.Error("error occurred while replacing the two-factor secret of user %d: %s", tf.UserID, err)
+		}
+	}
+	return counter, true, nil
+}
+
+// generateRecoveryCodes generates recovery codes formatted for showing to users, like ABCDE-FGHIJ.
+func generateRecoveryCodes(count int) ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, count)
+	for i := range codes {
+		buffer := make([]byte, 7)
+		if _, err := rand.Read(buffer); err != nil {
+			return nil, err
+		}
+		code := encoding.EncodeToString(buffer)[:recoveryCodeLength]
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode removes the formatting from a recovery code that a user entered.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func normalizeRecoveryCodes(codes []string) []string {
+	normalized := make([]string, len(codes))
+	for i, code := range codes {
+		normalized[i] = normalizeRecoveryCode(code)
+	}
+	return normalized
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/triviatest"
+)
+
+// enrollTwoFactor turns on two-factor authentication for a user and returns their secret and
+// recovery codes.
+func enrollTwoFactor(t *testing.T, twoFactor *TwoFactorAuth, userID int64, now time.Time) ([]byte, []string) {
+	t.Helper()
+	enrollment, err := twoFactor.Enroll(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = twoFactor.Confirm(userID, wrongCode(twoFactor.options.TOTP.Code(secret, now))); err != trivia.ErrIncorrectTwoFactorCode {
+		t.Fatalf("expected a wrong code to be rejected but got %v", err)
+	}
+	codes, err := twoFactor.Confirm(userID, twoFactor.options.TOTP.Code(secret, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret, codes
+}
+
+// wrongCode returns a code that is different from a correct one.
+func wrongCode(code string) string {
+	last := code[len(code)-1]
+	return code[:len(code)-1] + string('0'+(last-'0'+1)%10)
+}
+
+// startTwoFactorLogin logs in with a password and returns the challenge that was given.
+func startTwoFactorLogin(t *testing.T, s *service) string {
+	result, err := s.LoginWithEmailOrUsername("player", "password", trivia.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Pair != nil || result.Challenge == nil {
+		t.Fatalf("expected a challenge instead of tokens")
+	}
+	return result.Challenge.Token
+}
+
+func TestTwoFactorEnrollment(t *testing.T) {
+	users := &fakeUserService{}
+	challenges := &fakeLoginChallengeService{challenges: make(map[string]*trivia.LoginChallenge)}
+	twoFactor := NewTwoFactorAuth(users, newFakeTwoFactorService(), challenges, DefaultTwoFactorOptions())
+	now := time.Unix(1500000000, 0)
+	twoFactor.now = func() time.Time { return now }
+
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes(), twoFactor: twoFactor}
+	user := createTestUser(t, s, "player", "player@example.com", "password")
+	_, codes := enrollTwoFactor(t, twoFactor, user.ID, now)
+
+	if len(codes) != twoFactor.options.RecoveryCodes {
+		t.Errorf("expected %d recovery codes but got %d", twoFactor.options.RecoveryCodes, len(codes))
+	}
+	status, err := twoFactor.Status(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.RecoveryCodesLeft != len(codes) {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if _, err = twoFactor.Enroll(1); err != trivia.ErrTwoFactorEnabled {
+		t.Errorf("expected enrolling again to fail with ErrTwoFactorEnabled but got %v", err)
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	users := &fakeUserService{}
+	challenges := &fakeLoginChallengeService{challenges: make(map[string]*trivia.LoginChallenge)}
+	twoFactor := NewTwoFactorAuth(users, newFakeTwoFactorService(), challenges, DefaultTwoFactorOptions())
+	now := time.Unix(1500000000, 0)
+	twoFactor.now = func() time.Time { return now }
+
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes(), twoFactor: twoFactor}
+	user := createTestUser(t, s, "player", "player@example.com", "password")
+	secret, _ := enrollTwoFactor(t, twoFactor, user.ID, now)
+
+	challenge := startTwoFactorLogin(t, s)
+	now = now.Add(30 * time.Second)
+	code := twoFactor.options.TOTP.Code(secret, now)
+
+	if _, err := s.FinishLogin(challenge, wrongCode(code), trivia.ClientInfo{}); err != trivia.ErrIncorrectTwoFactorCode {
+		t.Errorf("expected a wrong code to be rejected but got %v", err)
+	}
+	if _, err := s.FinishLogin(challenge, code, trivia.ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishLogin(challenge, code, trivia.ClientInfo{}); err != trivia.ErrTokenNotFound {
+		t.Errorf("expected a finished challenge to be gone but got %v", err)
+	}
+
+	// a code that was already used can't finish another login.
+	challenge = startTwoFactorLogin(t, s)
+	if _, err := s.FinishLogin(challenge, code, trivia.ClientInfo{}); err != trivia.ErrIncorrectTwoFactorCode {
+		t.Errorf("expected a used code to be rejected but got %v", err)
+	}
+}
+
+func TestTwoFactorRecoveryCodes(t *testing.T) {
+	users := &fakeUserService{}
+	challenges := &fakeLoginChallengeService{challenges: make(map[string]*trivia.LoginChallenge)}
+	twoFactor := NewTwoFactorAuth(users, newFakeTwoFactorService(), challenges, DefaultTwoFactorOptions())
+	now := time.Unix(1500000000, 0)
+	twoFactor.now = func() time.Time { return now }
+
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes(), twoFactor: twoFactor}
+	user := createTestUser(t, s, "player", "player@example.com", "password")
+	_, codes := enrollTwoFactor(t, twoFactor, user.ID, now)
+
+	challenge := startTwoFactorLogin(t, s)
+	if _, err := s.FinishLogin(challenge, " "+codes[0]+" ", trivia.ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+
+	challenge = startTwoFactorLogin(t, s)
+	if _, err := s.FinishLogin(challenge, codes[0], trivia.ClientInfo{}); err != trivia.ErrIncorrectTwoFactorCode {
+		t.Errorf("expected a used recovery code to be rejected but got %v", err)
+	}
+
+	status, err := twoFactor.Status(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.RecoveryCodesLeft != len(codes)-1 {
+		t.Errorf("expected %d recovery codes left but got %d", len(codes)-1, status.RecoveryCodesLeft)
+	}
+
+	replaced, err := twoFactor.RegenerateRecoveryCodes(1, codes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = twoFactor.RegenerateRecoveryCodes(1, codes[2]); err != trivia.ErrIncorrectTwoFactorCode {
+		t.Errorf("expected an old recovery code to be rejected but got %v", err)
+	}
+
+	if err = twoFactor.Disable(1, replaced[0]); err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.LoginWithEmailOrUsername("player", "password", trivia.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Pair == nil {
+		t.Errorf("expected tokens after two-factor authentication was disabled")
+	}
+}
+
+func TestTwoFactorChallengeAttempts(t *testing.T) {
+	users := &fakeUserService{}
+	challenges := &fakeLoginChallengeService{challenges: make(map[string]*trivia.LoginChallenge)}
+	twoFactor := NewTwoFactorAuth(users, newFakeTwoFactorService(), challenges, DefaultTwoFactorOptions())
+	now := time.Unix(1500000000, 0)
+	twoFactor.now = func() time.Time { return now }
+
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes(), twoFactor: twoFactor}
+	user := createTestUser(t, s, "player", "player@example.com", "password")
+	secret, _ := enrollTwoFactor(t, twoFactor, user.ID, now)
+
+	challenge := startTwoFactorLogin(t, s)
+	now = now.Add(30 * time.Second)
+	code := twoFactor.options.TOTP.Code(secret, now)
+	for i := 0; i < twoFactor.options.MaxChallengeAttempts; i++ {
+		if _, err := s.FinishLogin(challenge, wrongCode(code), trivia.ClientInfo{}); err != trivia.ErrIncorrectTwoFactorCode {
+			t.Fatalf("attempt %d: expected a wrong code to be rejected but got %v", i, err)
+		}
+	}
+
+	if _, err := s.FinishLogin(challenge, code, trivia.ClientInfo{}); err != trivia.ErrTokenNotFound {
+		t.Errorf("expected the challenge to be gone after too many attempts but got %v", err)
+	}
+	if _, err := twoFactor.ChallengeUser(challenge); err != trivia.ErrTokenNotFound {
+		t.Errorf("expected the challenge to be deleted but got %v", err)
+	}
+}
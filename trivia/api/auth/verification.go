@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/mail"
+)
+
+// EmailVerificationOptions are the options used for verifying email addresses.
+type EmailVerificationOptions struct {
+	// Lifetime is how long a verification token can be used for after it was emailed.
+	Lifetime time.Duration
+
+	// URL is the page that users are sent to for verifying their email address. The verification
+	// token is added to it as the token query parameter.
+	URL string
+
+	// ResendCooldown is how long a user has to wait after a verification email was sent before
+	// they can have another one sent.
+	ResendCooldown time.Duration
+
+	// MaxResends is the number of verification emails that a user can have sent within ResendWindow.
+	MaxResends   int
+	ResendWindow time.Duration
+}
+
+// DefaultEmailVerificationOptions returns the email verification options used when none are
+// configured. The URL has to be configured.
+func DefaultEmailVerificationOptions() EmailVerificationOptions {
+	return EmailVerificationOptions{
+		Lifetime:       48 * time.Hour,
+		ResendCooldown: time.Minute,
+		MaxResends:     5,
+		ResendWindow:   24 * time.Hour,
+	}
+}
+
+// EmailVerifier confirms that users own their email addresses by emailing them single use
+// verification tokens.
+type EmailVerifier struct {
+	users         trivia.UserService
+	verifications trivia.EmailVerificationService
+	mailer        mail.Mailer
+	options       EmailVerificationOptions
+	now           func() time.Time
+
+	// lock guards sent and lastSweep.
+	lock *sync.Mutex
+
+	// sent are the times at which verification emails were sent to each user within the resend window.
+	sent map[int64][]time.Time
+
+	// lastSweep is the last time that users with no recent emails were removed from sent.
+	lastSweep time.Time
+}
+
+// NewEmailVerifier creates a new email verifier.
+func NewEmailVerifier(users trivia.UserService, verifications trivia.EmailVerificationService, mailer mail.Mailer,
+	options EmailVerificationOptions) *EmailVerifier {
+	return &EmailVerifier{
+		users:         users,
+		verifications: verifications,
+		mailer:        mailer,
+		options:       options,
+		now:           time.Now,
+		lock:          &sync.Mutex{},
+		sent:          make(map[int64][]time.Time),
+	}
+}
+
+// SendVerification emails a verification token to a user. This returns ErrEmailAlreadyVerified if
+// the user's email address has already been verified.
+func (v *EmailVerifier) SendVerification(userID int64) error {
+	v.lock.Lock()
+	v.recordSend(userID, v.now())
+	v.lock.Unlock()
+	return v.sendVerification(userID)
+}
+
+// Resend emails a new verification token to a user unless they have had too many sent recently,
+// in which case ErrRateLimited is returned.
+func (v *EmailVerifier) Resend(userID int64) error {
+	if !v.allowSend(userID) {
+		return trivia.ErrRateLimited
+	}
+	return v.sendVerification(userID)
+}
+
+// sendVerification emails a verification token to a user without checking or recording how many
+// they have had sent recently.
+func (v *EmailVerifier) sendVerification(userID int64) error {
+	cred, err := v.users.CredByUserID(userID)
+	if err != nil {
+		return err
+	}
+	if cred == nil {
+		return trivia.ErrUserNotFound
+	}
+	if cred.EmailVerified {
+		return trivia.ErrEmailAlreadyVerified
+	}
+
+	user, err := v.users.UserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return trivia.ErrUserNotFound
+	}
+
+	token, err := generateEmailToken()
+	if err != nil {
+		return err
+	}
+
+	verification := &trivia.EmailVerificationToken{
+		Token:     token,
+		UserID:    userID,
+		Email:     cred.Email,
+		ExpiresAt: v.now().Add(v.options.Lifetime),
+	}
+	if err = v.verifications.CreateEmailVerificationToken(verification); err != nil {
+		return err
+	}
+
+	return v.mailer.Send(&mail.Message{
+		To:      cred.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Thanks for signing up! Please confirm that this is your email address by following this link:\n\n"+
+			"%s\n\n"+
+			"This link expires in %s. If you didn't create an account you can ignore this email.\n",
+			user.Username, tokenURL(v.options.URL, token), v.options.Lifetime),
+	})
+}
+
+// Verify marks the email address that a verification token was sent to as verified. This returns
+// ErrTokenNotFound or ErrTokenExpired if the token can't be used. Tokens sent to an address that
+// the user has since changed are not found.
+func (v *EmailVerifier) Verify(token string) error {
+	verification, err := v.verifications.UseEmailVerificationToken(token)
+	if err != nil {
+		return err
+	}
+	if verification == nil {
+		return trivia.ErrTokenNotFound
+	}
+	if v.now().After(verification.ExpiresAt) {
+		return trivia.ErrTokenExpired
+	}
+
+	verified, err := v.users.MarkEmailVerified(verification.UserID, verification.Email)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return trivia.ErrTokenNotFound
+	}
+	return nil
+}
+
+// allowSend records a verification email for a user, or returns false if they have had too many
+// sent recently. Checking and recording happen together so that concurrent resends can't all
+// get past the limits. Emails count even if they fail to send, like password reset requests.
+func (v *EmailVerifier) allowSend(userID int64) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	now := v.now()
+	sent := v.recentSends(userID, now)
+	if len(sent) >= v.options.MaxResends {
+		return false
+	}
+	if len(sent) > 0 && now.Sub(sent[len(sent)-1]) < v.options.ResendCooldown {
+		return false
+	}
+	v.recordSend(userID, now)
+	return true
+}
+
+// recordSend records that a verification email was sent to a user. lock must be held.
+func (v *EmailVerifier) recordSend(userID int64, now time.Time) {
+	v.sent[userID] = append(v.recentSends(userID, now), now)
+
+	// users that haven't had an email sent within the window are forgotten every so often so that
+	// the map doesn't keep growing.
+	if now.Sub(v.lastSweep) >= v.options.ResendWindow {
+		for id := range v.sent {
+			if len(v.recentSends(id, now)) == 0 {
+				delete(v.sent, id)
+			}
+		}
+		v.lastSweep = now
+	}
+}
+
+// recentSends returns the times that verification emails were sent to a user within the resend
+// window, dropping any older ones. lock must be held.
+func (v *EmailVerifier) recentSends(userID int64, now time.Time) []time.Time {
+	sent := v.sent[userID]
+	idx := 0
+	for idx < len(sent) && now.Sub(sent[idx]) >= v.options.ResendWindow {
+		idx++
+	}
+	sent = sent[idx:]
+	v.sent[userID] = sent
+	return sent
+}
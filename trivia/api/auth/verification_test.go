@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/triviatest"
+)
+
+func TestEmailVerification(t *testing.T) {
+	options := DefaultEmailVerificationOptions()
+	options.URL = "https://trivia.example.com/verify"
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "newbie", "newbie@example.com", "password")
+	mailer := &fakeMailer{}
+	verifier := NewEmailVerifier(users, &fakeVerificationService{tokens: make(map[string]*trivia.EmailVerificationToken)}, mailer, options)
+
+	if err := verifier.SendVerification(1); err != nil {
+		t.Fatal(err)
+	}
+	token := sentEmailToken(t, mailer)
+
+	if err := verifier.Verify(token); err != nil {
+		t.Fatal(err)
+	}
+	if !users.creds[0].EmailVerified {
+		t.Errorf("expected the email address to be verified")
+	}
+	if err := verifier.Verify(token); err != trivia.ErrTokenNotFound {
+		t.Errorf("expected the verification token to only work once but got %v", err)
+	}
+	if err := verifier.SendVerification(1); err != trivia.ErrEmailAlreadyVerified {
+		t.Errorf("expected ErrEmailAlreadyVerified but got %v", err)
+	}
+}
+
+func TestEmailVerificationChangedEmail(t *testing.T) {
+	options := DefaultEmailVerificationOptions()
+	options.URL = "https://trivia.example.com/verify"
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "newbie", "newbie@example.com", "password")
+	mailer := &fakeMailer{}
+	verifier := NewEmailVerifier(users, &fakeVerificationService{tokens: make(map[string]*trivia.EmailVerificationToken)}, mailer, options)
+
+	verifier.SendVerification(1)
+	users.creds[0].Email = "changed@example.com"
+
+	if err := verifier.Verify(sentEmailToken(t, mailer)); err != trivia.ErrTokenNotFound {
+		t.Errorf("expected a token sent to an old address to not verify the new one but got %v", err)
+	}
+	if users.creds[0].EmailVerified {
+		t.Errorf("expected the new email address to not be verified")
+	}
+}
+
+func TestEmailVerificationExpired(t *testing.T) {
+	options := DefaultEmailVerificationOptions()
+	options.Lifetime = -time.Minute
+	options.URL = "https://trivia.example.com/verify"
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "newbie", "newbie@example.com", "password")
+	mailer := &fakeMailer{}
+	verifier := NewEmailVerifier(users, &fakeVerificationService{tokens: make(map[string]*trivia.EmailVerificationToken)}, mailer, options)
+
+	verifier.SendVerification(1)
+	if err := verifier.Verify(sentEmailToken(t, mailer)); err != trivia.ErrTokenExpired {
+		t.Errorf("expected ErrTokenExpired but got %v", err)
+	}
+}
+
+func TestEmailVerificationResendLimits(t *testing.T) {
+	options := DefaultEmailVerificationOptions()
+	options.MaxResends = 3
+	options.URL = "https://trivia.example.com/verify"
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "newbie", "newbie@example.com", "password")
+	mailer := &fakeMailer{}
+	verifier := NewEmailVerifier(users, &fakeVerificationService{tokens: make(map[string]*trivia.EmailVerificationToken)}, mailer, options)
+
+	now := time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC)
+	verifier.now = func() time.Time { return now }
+
+	// the email sent on signup counts towards the limits.
+	if err := verifier.SendVerification(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Resend(1); err != trivia.ErrRateLimited {
+		t.Errorf("expected a resend within the cooldown to be rate limited but got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		now = now.Add(options.ResendCooldown)
+		if err := verifier.Resend(1); err != nil {
+			t.Fatalf("expected resend %d to be sent but got %v", i+1, err)
+		}
+	}
+
+	now = now.Add(options.ResendCooldown)
+	if err := verifier.Resend(1); err != trivia.ErrRateLimited {
+		t.Errorf("expected a resend over the limit to be rate limited but got %v", err)
+	}
+
+	now = now.Add(options.ResendWindow)
+	if err := verifier.Resend(1); err != nil {
+		t.Errorf("expected resends to be allowed again after the window but got %v", err)
+	}
+	if len(mailer.sent) != 4 {
+		t.Errorf("expected 4 verification emails to be sent but %d were", len(mailer.sent))
+	}
+}
+
+func TestEmailVerificationAllowSendRecords(t *testing.T) {
+	options := DefaultEmailVerificationOptions()
+	options.URL = "https://trivia.example.com/verify"
+	users := &fakeUserService{}
+	s := &service{users: users, tokens: triviatest.NewTokenService(), lifetimes: DefaultTokenLifetimes()}
+	createTestUser(t, s, "newbie", "newbie@example.com", "password")
+	verifier := NewEmailVerifier(users, &fakeVerificationService{tokens: make(map[string]*trivia.EmailVerificationToken)}, &fakeMailer{}, options)
+
+	now := time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC)
+	verifier.now = func() time.Time { return now }
+
+	// a resend that is allowed is recorded right away, before its email is sent.
+	if !verifier.allowSend(1) {
+		t.Fatal("expected the first resend to be allowed")
+	}
+	if verifier.allowSend(1) {
+		t.Errorf("expected a second resend within the cooldown to be rate limited")
+	}
+}
@@ -0,0 +1,153 @@
+package daily
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/eplog"
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/game"
+)
+
+var logger = eplog.NewPrefixLogger("daily")
+
+// questionCount is the number of questions in each daily challenge.
+const questionCount = 10
+
+// answerDuration is the amount of time players get to answer each daily challenge question.
+const answerDuration = 15 * time.Second
+
+type service struct {
+	daily     trivia.DailyService
+	questions trivia.QuestionService
+	games     *game.TriviaGamesSet
+
+	// createLock is held while creating the daily challenge for a day so that
+	// the questions are only selected once per server.
+	createLock *sync.Mutex
+}
+
+// dayOf returns midnight (UTC) of the day that the given time is in.
+func dayOf(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// seedForDay returns the seed used to select the questions for a day.
+func seedForDay(day time.Time) int64 {
+	return dayOf(day).Unix() / int64((24 * time.Hour).Seconds())
+}
+
+// gameIDForDay returns the ID of the challenge game used for a day.
+func gameIDForDay(day time.Time) string {
+	return "daily-" + day.Format("2006-01-02")
+}
+
+// selectQuestionIDs deterministically selects count IDs from a list of question IDs using
+// the given seed. The same seed and IDs will always produce the same selection.
+func selectQuestionIDs(ids []int64, seed int64, count int) []int64 {
+	if count > len(ids) {
+		count = len(ids)
+	}
+
+	shuffled := make([]int64, len(ids))
+	copy(shuffled, ids)
+
+	// a partial Fisher-Yates shuffle is enough since we only need the first count IDs.
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < count; i++ {
+		j := i + rng.Intn(len(shuffled)-i)
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	return shuffled[:count]
+}
+
+// challengeForDay returns the daily challenge for a day, selecting and storing its
+// questions if that hasn't been done yet.
+func (s *service) challengeForDay(day time.Time) (*trivia.DailyChallenge, error) {
+	challenge, err := s.daily.DailyChallengeForDay(day)
+	if err != nil || challenge != nil {
+		return challenge, err
+	}
+
+	s.createLock.Lock()
+	defer s.createLock.Unlock()
+
+	ids, err := s.questions.QuestionIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	// another server might have beaten us to creating today's challenge, in which
+	// case theirs is kept and we load it back.
+	if err = s.daily.CreateDailyChallenge(day, selectQuestionIDs(ids, seedForDay(day), questionCount)); err != nil {
+		return nil, err
+	}
+	return s.daily.DailyChallengeForDay(day)
+}
+
+// gameForDay returns the challenge game that is used to play the daily challenge for a day.
+func (s *service) gameForDay(day time.Time) (*game.ChallengeGame, error) {
+	gameID := gameIDForDay(day)
+	if g := s.games.ChallengeByID(gameID); g != nil {
+		return g, nil
+	}
+
+	challenge, err := s.challengeForDay(day)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &game.TriviaGameOptions{
+		MinParticipants:        1,
+		MaxParticipants:        int(^uint32(0) >> 1),
+		QuestionCount:          len(challenge.Questions),
+		QuestionAnswerDuration: answerDuration,
+	}
+
+	hooks := game.ChallengeHooks{
+		CanJoin: func(user *trivia.User) string {
+			return s.startAttempt(day, user)
+		},
+		PlayerDone: func(user *trivia.User, score int, correctAnswers int, finished bool) {
+			s.finishAttempt(day, user, score, correctAnswers, finished)
+		},
+	}
+
+	g, err := s.games.CreateChallengeWithQuestions(gameID, nil, options, challenge.Questions, day.Add(24*time.Hour), hooks)
+	if err == game.ErrGameIDInUse {
+		return s.games.ChallengeByID(gameID), nil
+	}
+	return g, err
+}
+
+// startAttempt records a user's attempt at a daily challenge. This returns the reason
+// the user can't play if they are not allowed to.
+func (s *service) startAttempt(day time.Time, user *trivia.User) string {
+	if user.Guest {
+		return "Only registered users can play the daily challenge."
+	}
+
+	started, err := s.daily.StartDailyAttempt(day, user.ID)
+	if err != nil {
+		logger.Error("error occurred while starting daily attempt: %s", err)
+		return "An unknown error occurred while starting the daily challenge."
+	}
+	if !started {
+		return "You have already played today's daily challenge."
+	}
+	return ""
+}
+
+func (s *service) finishAttempt(day time.Time, user *trivia.User, score int, correctAnswers int, finished bool) {
+	err := s.daily.FinishDailyAttempt(&trivia.DailyAttempt{
+		Day:            day,
+		UserID:         user.ID,
+		Score:          score,
+		CorrectAnswers: correctAnswers,
+		Finished:       finished,
+	})
+	if err != nil {
+		logger.Error("error occurred while finishing daily attempt for %s: %s", user.Username, err)
+	}
+}
@@ -0,0 +1,64 @@
+package daily
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectQuestionIDsIsDeterministic(t *testing.T) {
+	ids := make([]int64, 100)
+	for i := range ids {
+		ids[i] = int64(i + 1)
+	}
+
+	first := selectQuestionIDs(ids, 17700, 10)
+	second := selectQuestionIDs(ids, 17700, 10)
+	if len(first) != 10 {
+		t.Fatalf("expected 10 question IDs but got %d", len(first))
+	}
+
+	seen := make(map[int64]bool)
+	for idx := range first {
+		if first[idx] != second[idx] {
+			t.Fatalf("selections with the same seed differ: %v != %v", first, second)
+		}
+		if seen[first[idx]] {
+			t.Fatalf("question ID %d was selected twice: %v", first[idx], first)
+		}
+		seen[first[idx]] = true
+	}
+
+	different := selectQuestionIDs(ids, 17701, 10)
+	same := true
+	for idx := range first {
+		if first[idx] != different[idx] {
+			same = false
+		}
+	}
+	if same {
+		t.Errorf("selections with different seeds should differ: %v", first)
+	}
+
+	if ids[0] != 1 || ids[99] != 100 {
+		t.Errorf("selecting questions should not modify the original IDs")
+	}
+}
+
+func TestSelectQuestionIDsWithFewQuestions(t *testing.T) {
+	selected := selectQuestionIDs([]int64{4, 8}, 1, 10)
+	if len(selected) != 2 {
+		t.Errorf("expected every question to be selected when there are too few: %v", selected)
+	}
+}
+
+func TestDayOf(t *testing.T) {
+	est := time.FixedZone("EST", -5*60*60)
+	day := dayOf(time.Date(2018, 3, 26, 22, 30, 0, 0, est))
+	if !day.Equal(time.Date(2018, 3, 27, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the UTC day to be 2018-03-27 but got %s", day)
+	}
+
+	if seedForDay(day) != seedForDay(day.Add(23*time.Hour)) {
+		t.Errorf("every time in a day should have the same seed")
+	}
+}
@@ -0,0 +1,148 @@
+package daily
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/api"
+	"github.com/expixel/actual-trivia-server/trivia/game"
+)
+
+// maxLeaderboardLimit is the maximum number of leaderboard entries returned in a single request.
+const maxLeaderboardLimit = 100
+
+type handler struct {
+	service      *service
+	tokenService trivia.AuthTokenService
+}
+
+// status is an endpoint that returns the state of today's daily challenge for the current user.
+func (h *handler) status(w http.ResponseWriter, r *http.Request) {
+	currentUser, err := api.RequireRequestUser(w, r, h.tokenService)
+	if err != nil {
+		return
+	}
+
+	day := dayOf(time.Now())
+	challenge, err := h.service.challengeForDay(day)
+	if err != nil {
+		logger.Error("error occurred while getting daily challenge: %s", err)
+		api.Error(w, "Unknown error occurred while getting the daily challenge.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := dailyStatusResponse{
+		Day:           day.Format("2006-01-02"),
+		QuestionCount: len(challenge.Questions),
+		AnswerMillis:  int64(answerDuration / time.Millisecond),
+		NextDay:       day.Add(24 * time.Hour).Unix(),
+	}
+
+	if !currentUser.Guest {
+		attempt, err := h.service.daily.DailyAttempt(day, currentUser.ID)
+		if err != nil {
+			logger.Error("error occurred while getting daily attempt: %s", err)
+			api.Error(w, "Unknown error occurred while getting the daily challenge.", http.StatusInternalServerError)
+			return
+		}
+		if attempt != nil {
+			resp.Attempted = true
+			resp.Finished = attempt.Finished
+			resp.Score = attempt.Score
+			resp.CorrectAnswers = attempt.CorrectAnswers
+		}
+
+		streak, err := h.service.daily.DailyStreak(currentUser.ID)
+		if err != nil {
+			logger.Error("error occurred while getting daily streak: %s", err)
+			api.Error(w, "Unknown error occurred while getting the daily challenge.", http.StatusInternalServerError)
+			return
+		}
+		resp.CurrentStreak = streak.CurrentAsOf(day)
+		resp.LongestStreak = streak.Longest
+	}
+
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// leaderboard is an endpoint that returns the ranked scores for a day's daily challenge.
+func (h *handler) leaderboard(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	day := dayOf(time.Now())
+	if dayParam := query.Get("day"); dayParam != "" {
+		parsed, err := time.Parse("2006-01-02", dayParam)
+		if err != nil {
+			api.Error(w, "Day must be formatted as YYYY-MM-DD.", http.StatusBadRequest)
+			return
+		}
+		day = parsed
+	}
+
+	limit, offset, ok := api.RequirePagination(w, r, 25, maxLeaderboardLimit)
+	if !ok {
+		return
+	}
+
+	entries, err := h.service.daily.DailyLeaderboard(day, limit, offset)
+	if err != nil {
+		logger.Error("error occurred while getting daily leaderboard: %s", err)
+		api.Error(w, "Unknown error occurred while getting the leaderboard.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := leaderboardResponse{
+		Day:     day.Format("2006-01-02"),
+		Entries: make([]leaderboardEntry, len(entries)),
+	}
+	for idx, e := range entries {
+		resp.Entries[idx] = leaderboardEntry{
+			Rank:           e.Rank,
+			Username:       e.Username,
+			Score:          e.Score,
+			CorrectAnswers: e.CorrectAnswers,
+		}
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// play is the websocket endpoint used to play today's daily challenge.
+func (h *handler) play(w http.ResponseWriter, r *http.Request) {
+	g, err := h.service.gameForDay(dayOf(time.Now()))
+	if err != nil {
+		logger.Error("error occurred while getting daily challenge game: %s", err)
+		api.Error(w, "Unknown error occurred while getting the daily challenge.", http.StatusInternalServerError)
+		return
+	}
+
+	rawConn, err := game.UpgradeConn(w, r)
+	if err != nil {
+		logger.Error("error occurred while upgrading to ws conn: %s", err)
+		return
+	}
+
+	h.service.games.AddRawConnToChallenge(rawConn, g.ID)
+}
+
+// NewHandler creates a new handler for the daily challenge endpoints.
+func NewHandler(ds trivia.DailyService, qs trivia.QuestionService, ts trivia.AuthTokenService, games *game.TriviaGamesSet) http.Handler {
+	h := handler{
+		service: &service{
+			daily:      ds,
+			questions:  qs,
+			games:      games,
+			createLock: &sync.Mutex{},
+		},
+		tokenService: ts,
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/daily", h.status).Methods("GET")
+	r.HandleFunc("/v1/daily/leaderboard", h.leaderboard).Methods("GET")
+	r.HandleFunc("/v1/daily/ws", h.play).Methods("GET")
+	return api.WrapAPIHandler(r)
+}
@@ -0,0 +1,30 @@
+package daily
+
+type dailyStatusResponse struct {
+	Day           string `json:"day"`
+	QuestionCount int    `json:"questionCount"`
+	AnswerMillis  int64  `json:"answerMillis"`
+
+	// NextDay is the unix timestamp at which the next daily challenge becomes available.
+	NextDay int64 `json:"nextDay"`
+
+	Attempted      bool `json:"attempted"`
+	Finished       bool `json:"finished"`
+	Score          int  `json:"score"`
+	CorrectAnswers int  `json:"correctAnswers"`
+
+	CurrentStreak int `json:"currentStreak"`
+	LongestStreak int `json:"longestStreak"`
+}
+
+type leaderboardEntry struct {
+	Rank           int    `json:"rank"`
+	Username       string `json:"username"`
+	Score          int    `json:"score"`
+	CorrectAnswers int    `json:"correctAnswers"`
+}
+
+type leaderboardResponse struct {
+	Day     string             `json:"day"`
+	Entries []leaderboardEntry `json:"entries"`
+}
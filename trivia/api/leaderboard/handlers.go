@@ -0,0 +1,106 @@
+package leaderboard
+
+import (
+	"net/http"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/api"
+	"github.com/gorilla/mux"
+)
+
+// maxLeaderboardLimit is the maximum number of leaderboard entries returned in a single request.
+const maxLeaderboardLimit = 100
+
+type handler struct {
+	leaderboards trivia.LeaderboardService
+	tokenService trivia.AuthTokenService
+}
+
+// requireQuery reads the leaderboard query from a request and writes an error to the
+// response if it is invalid.
+func requireQuery(w http.ResponseWriter, r *http.Request) (trivia.LeaderboardQuery, bool) {
+	params := r.URL.Query()
+	query, reason := parseQuery(params.Get("period"), params.Get("category"), params.Get("rankBy"))
+	if reason != "" {
+		api.Error(w, reason, http.StatusBadRequest)
+		return query, false
+	}
+	return query, true
+}
+
+// leaderboard is an endpoint that returns a page of a leaderboard.
+func (h *handler) leaderboard(w http.ResponseWriter, r *http.Request) {
+	query, ok := requireQuery(w, r)
+	if !ok {
+		return
+	}
+
+	limit, offset, ok := api.RequirePagination(w, r, 25, maxLeaderboardLimit)
+	if !ok {
+		return
+	}
+
+	entries, err := h.leaderboards.Leaderboard(query, limit, offset)
+	if err != nil {
+		logger.Error("error occurred while getting leaderboard: %s", err)
+		api.Error(w, "Unknown error occurred while getting the leaderboard.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := leaderboardResponse{
+		Period:   string(query.Period),
+		Category: query.Category,
+		RankBy:   string(query.RankBy),
+		Entries:  make([]leaderboardEntry, len(entries)),
+	}
+	for idx := range entries {
+		resp.Entries[idx] = newLeaderboardEntry(&entries[idx])
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// rank is an endpoint that returns the current user's place on a leaderboard.
+func (h *handler) rank(w http.ResponseWriter, r *http.Request) {
+	currentUser, err := api.RequireRequestUser(w, r, h.tokenService)
+	if err != nil {
+		return
+	}
+
+	if currentUser.Guest {
+		api.Error(w, "Guests are not ranked on leaderboards.", http.StatusForbidden)
+		return
+	}
+
+	query, ok := requireQuery(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := h.leaderboards.LeaderboardEntry(query, currentUser.ID)
+	if err != nil {
+		logger.Error("error occurred while getting leaderboard rank: %s", err)
+		api.Error(w, "Unknown error occurred while getting the leaderboard.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := rankResponse{
+		Period:   string(query.Period),
+		Category: query.Category,
+		RankBy:   string(query.RankBy),
+	}
+	if entry != nil {
+		e := newLeaderboardEntry(entry)
+		resp.Entry = &e
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// NewHandler creates a new handler for the leaderboard endpoints.
+func NewHandler(ls trivia.LeaderboardService, ts trivia.AuthTokenService) http.Handler {
+	h := handler{leaderboards: ls, tokenService: ts}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/leaderboard", h.leaderboard).Methods("GET")
+	r.HandleFunc("/v1/leaderboard/me", h.rank).Methods("GET")
+	return api.WrapAPIHandler(r)
+}
@@ -0,0 +1,86 @@
+package leaderboard
+
+import (
+	"time"
+
+	"github.com/expixel/actual-trivia-server/eplog"
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/periodic"
+)
+
+var logger = eplog.NewPrefixLogger("leaderboard")
+
+// refreshInterval is how often the leaderboards that are not updated as soon as
+// games finish (the weekly ones) are recalculated.
+const refreshInterval = 5 * time.Minute
+
+// Refresher periodically refreshes leaderboards on its own goroutine.
+type Refresher struct {
+	service trivia.LeaderboardService
+	runner  *periodic.Runner
+}
+
+// NewRefresher creates a new refresher for the leaderboards in the given service.
+func NewRefresher(service trivia.LeaderboardService) *Refresher {
+	r := &Refresher{service: service}
+	r.runner = periodic.NewRunner(refreshInterval, true, r.refresh)
+	return r
+}
+
+// Start starts refreshing leaderboards on its own goroutine.
+func (r *Refresher) Start() {
+	r.runner.Start()
+}
+
+// Stop stops the refresher and waits for it to finish whatever it is doing.
+func (r *Refresher) Stop() {
+	r.runner.Stop()
+}
+
+func (r *Refresher) refresh() {
+	if err := r.service.RefreshLeaderboards(); err != nil {
+		logger.Error("error occurred while refreshing leaderboards: %s", err)
+	}
+}
+
+// parseQuery builds a leaderboard query from the period, category, and rankBy parameters. An empty string
+// is returned along with the query if it is valid, or the reason that it isn't.
+func parseQuery(period string, category string, rankBy string) (trivia.LeaderboardQuery, string) {
+	query := trivia.LeaderboardQuery{
+		Period:   trivia.LeaderboardAllTime,
+		Category: category,
+		RankBy:   trivia.RankByScore,
+	}
+
+	if period != "" {
+		query.Period = trivia.LeaderboardPeriod(period)
+	}
+	if rankBy != "" {
+		query.RankBy = trivia.LeaderboardRanking(rankBy)
+	}
+
+	if query.Period != trivia.LeaderboardAllTime && query.Period != trivia.LeaderboardWeekly {
+		return query, "Period must be all or weekly."
+	}
+
+	if len(query.Category) > 128 {
+		return query, "Category must be at most 128 characters long."
+	}
+
+	switch query.RankBy {
+	case trivia.RankByRating:
+		if query.Period != trivia.LeaderboardAllTime || query.Category != "" {
+			return query, "Ratings can only be ranked on the all-time leaderboard without a category."
+		}
+	case trivia.RankByScore:
+	case trivia.RankByWins:
+		// wins belong to whole games so they aren't split up by category.
+		if query.Category != "" {
+			return query, "Category leaderboards can only be ranked by score."
+		}
+	default:
+		return query, "Rank by must be rating, score, or wins."
+	}
+
+	return query, ""
+}
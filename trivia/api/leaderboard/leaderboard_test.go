@@ -0,0 +1,38 @@
+package leaderboard
+
+import (
+	"testing"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+func TestParseQuery(t *testing.T) {
+	query, reason := parseQuery("", "", "")
+	if reason != "" || query.Period != trivia.LeaderboardAllTime || query.RankBy != trivia.RankByScore {
+		t.Errorf("expected the all-time score leaderboard by default but got %+v (%s)", query, reason)
+	}
+
+	valid := [][3]string{
+		{"all", "", "rating"},
+		{"weekly", "", "wins"},
+		{"weekly", "Science", "score"},
+	}
+	for _, params := range valid {
+		if _, reason := parseQuery(params[0], params[1], params[2]); reason != "" {
+			t.Errorf("expected %v to be a valid leaderboard but got: %s", params, reason)
+		}
+	}
+
+	invalid := [][3]string{
+		{"monthly", "", "score"},
+		{"weekly", "", "rating"},
+		{"all", "Science", "rating"},
+		{"all", "Science", "wins"},
+		{"all", "", "correct"},
+	}
+	for _, params := range invalid {
+		if _, reason := parseQuery(params[0], params[1], params[2]); reason == "" {
+			t.Errorf("expected %v to be an invalid leaderboard", params)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package leaderboard
+
+import (
+	"math"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+type leaderboardEntry struct {
+	Rank     int    `json:"rank"`
+	Username string `json:"username"`
+	Rating   int64  `json:"rating"`
+	Score    int64  `json:"score"`
+	Wins     int    `json:"wins"`
+	Games    int    `json:"games"`
+}
+
+type leaderboardResponse struct {
+	Period   string             `json:"period"`
+	Category string             `json:"category"`
+	RankBy   string             `json:"rankBy"`
+	Entries  []leaderboardEntry `json:"entries"`
+}
+
+type rankResponse struct {
+	Period   string `json:"period"`
+	Category string `json:"category"`
+	RankBy   string `json:"rankBy"`
+
+	// Entry is null if the user is not on the leaderboard yet.
+	Entry *leaderboardEntry `json:"entry"`
+}
+
+func newLeaderboardEntry(e *trivia.LeaderboardEntry) leaderboardEntry {
+	return leaderboardEntry{
+		Rank:     e.Rank,
+		Username: e.Username,
+		Rating:   int64(math.Round(e.Rating)),
+		Score:    e.Score,
+		Wins:     e.Wins,
+		Games:    e.Games,
+	}
+}
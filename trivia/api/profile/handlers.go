@@ -1,16 +1,36 @@
 package profile
 
 import (
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/expixel/actual-trivia-server/trivia"
 	"github.com/expixel/actual-trivia-server/trivia/api"
+	"github.com/expixel/actual-trivia-server/trivia/null"
+	"github.com/expixel/actual-trivia-server/trivia/rating"
+	"github.com/expixel/actual-trivia-server/trivia/xp"
 	"github.com/gorilla/mux"
 )
 
+// maxMatchHistoryLimit is the maximum number of games returned in a single page of a match history.
+const maxMatchHistoryLimit = 50
+
+// AccountDeleter deletes the accounts of users. This is implemented by auth.AccountManager.
+type AccountDeleter interface {
+	// DeleteAccount deletes a user if password is their current password, or if the session with
+	// the given token family was logged into recently for users without a password. This returns
+	// ErrIncorrectPassword or ErrRecentLoginRequired if they can't confirm that it's them.
+	DeleteAccount(userID int64, family string, password string) error
+}
+
 type handler struct {
-	userService  trivia.UserService
+	service      *service
 	tokenService trivia.AuthTokenService
+	dailyService trivia.DailyService
+	accounts     AccountDeleter
 }
 
 func (h *handler) me(w http.ResponseWriter, r *http.Request) {
@@ -25,13 +45,230 @@ func (h *handler) me(w http.ResponseWriter, r *http.Request) {
 		Guest:    currentUser.Guest,
 		GuestID:  currentUser.GuestID,
 	}
+
+	if !currentUser.Guest {
+		streak, err := h.dailyService.DailyStreak(currentUser.ID)
+		if err != nil {
+			logger.Error("error occurred while getting daily streak: %s", err)
+			api.Error(w, "Unknown error occurred while getting profile.", http.StatusInternalServerError)
+			return
+		}
+		resp.DailyStreak = streak.CurrentAsOf(time.Now())
+		resp.LongestDailyStreak = streak.Longest
+
+		r, err := h.service.ratings.PlayerRating(currentUser.ID)
+		if err != nil {
+			logger.Error("error occurred while getting rating: %s", err)
+			api.Error(w, "Unknown error occurred while getting profile.", http.StatusInternalServerError)
+			return
+		}
+		resp.Rating = null.NewInt64(int64(math.Round(r.Rating)))
+		resp.RatedGames = r.GamesPlayed
+		resp.ProvisionalRating = r.GamesPlayed < rating.ProvisionalGames
+
+		resp.Level, err = h.service.level(currentUser)
+		if err != nil {
+			logger.Error("error occurred while getting level: %s", err)
+			api.Error(w, "Unknown error occurred while getting profile.", http.StatusInternalServerError)
+			return
+		}
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// requireProfileUser finds the registered user with the username in the request's path. If there is
+// no such user an error is written to the response and nil is returned.
+func (h *handler) requireProfileUser(w http.ResponseWriter, r *http.Request) *trivia.User {
+	user, err := h.service.users.UserByUsername(mux.Vars(r)["username"])
+	if err != nil {
+		logger.Error("error occurred while getting user by username: %s", err)
+		api.Error(w, "Unknown error occurred while getting profile.", http.StatusInternalServerError)
+		return nil
+	}
+
+	if user == nil {
+		api.Error(w, "No user with the given username.", http.StatusNotFound)
+	}
+	return user
+}
+
+// profile is an endpoint that returns the public profile of a user.
+func (h *handler) profile(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	// guests aren't stored anywhere so all there is to show is their name.
+	if isGuestUsername(username) {
+		resp := publicProfileResponse{Username: username, Guest: true, Limited: true}
+		api.Response(w, &resp, http.StatusOK)
+		return
+	}
+
+	user := h.requireProfileUser(w, r)
+	if user == nil {
+		return
+	}
+
+	stats, err := h.service.publicStats(user)
+	if err != nil {
+		logger.Error("error occurred while getting profile stats: %s", err)
+		api.Error(w, "Unknown error occurred while getting profile.", http.StatusInternalServerError)
+		return
+	}
+
+	badges, err := h.service.badges(user)
+	if err != nil {
+		logger.Error("error occurred while getting badges: %s", err)
+		api.Error(w, "Unknown error occurred while getting profile.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := publicProfileResponse{
+		Username:     user.Username,
+		Joined:       null.NewInt64(user.Created.Unix()),
+		Stats:        stats,
+		Badges:       badges,
+		MatchHistory: null.NewString("/v1/profile/" + url.PathEscape(user.Username) + "/games"),
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// matchHistory is an endpoint that returns a page of the games that a user has finished.
+func (h *handler) matchHistory(w http.ResponseWriter, r *http.Request) {
+	if isGuestUsername(mux.Vars(r)["username"]) {
+		api.Error(w, "Guests do not have a match history.", http.StatusNotFound)
+		return
+	}
+
+	limit, offset, ok := api.RequirePagination(w, r, 20, maxMatchHistoryLimit)
+	if !ok {
+		return
+	}
+
+	user := h.requireProfileUser(w, r)
+	if user == nil {
+		return
+	}
+
+	games, err := h.service.results.MatchHistory(user.ID, limit, offset)
+	if err != nil {
+		logger.Error("error occurred while getting match history: %s", err)
+		api.Error(w, "Unknown error occurred while getting match history.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := matchHistoryResponse{Username: user.Username, Games: make([]matchHistoryEntry, len(games))}
+	for idx, g := range games {
+		resp.Games[idx] = newMatchHistoryEntry(g)
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// newMatchHistoryEntry converts a game from a user's match history to its response.
+func newMatchHistoryEntry(g trivia.MatchHistoryEntry) matchHistoryEntry {
+	return matchHistoryEntry{
+		ResultID:         g.ResultID,
+		GameID:           g.GameID,
+		FinishedAt:       g.FinishedAt.Unix(),
+		QuestionCount:    g.QuestionCount,
+		ParticipantCount: g.ParticipantCount,
+		Placement:        g.Placement,
+		Score:            g.Score,
+		CorrectAnswers:   g.CorrectAnswers,
+		Results:          "/v1/game/results/" + strconv.FormatInt(g.ResultID, 10),
+	}
+}
+
+// requireRegisteredUser authenticates the user making a request and makes sure that they aren't a
+// guest, since guests don't have accounts.
+func (h *handler) requireRegisteredUser(w http.ResponseWriter, r *http.Request) *trivia.User {
+	_, user := h.requireRegisteredToken(w, r)
+	return user
+}
+
+// requireRegisteredToken is requireRegisteredUser for endpoints that also need the auth token that
+// the request was made with.
+func (h *handler) requireRegisteredToken(w http.ResponseWriter, r *http.Request) (*trivia.AuthToken, *trivia.User) {
+	token, user, err := api.RequireRequestToken(w, r, h.tokenService)
+	if err != nil {
+		return nil, nil
+	}
+	if user.Guest {
+		api.Error(w, "Guests do not have accounts.", http.StatusForbidden)
+		return nil, nil
+	}
+	return token, user
+}
+
+// deleteMe is an endpoint that deletes the account of the user making the request. The games they
+// played are kept for the other players without their username.
+func (h *handler) deleteMe(w http.ResponseWriter, r *http.Request) {
+	token, user := h.requireRegisteredToken(w, r)
+	if user == nil {
+		return
+	}
+
+	type deleteBody struct {
+		Password string `json:"password"`
+	}
+
+	body := deleteBody{}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+
+	if err := h.accounts.DeleteAccount(user.ID, token.Family, body.Password); err != nil {
+		switch err {
+		case trivia.ErrIncorrectPassword:
+			api.Error(w, "Password is incorrect.", http.StatusForbidden)
+		case trivia.ErrRecentLoginRequired:
+			api.Error(w, "Log in again to delete your account.", http.StatusForbidden)
+		case trivia.ErrUserNotFound:
+			api.Error(w, "Account was already deleted.", http.StatusNotFound)
+		default:
+			logger.Error("error occurred while deleting account: %s", err)
+			api.Error(w, "Unknown error occurred while deleting account.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := true
 	api.Response(w, &resp, http.StatusOK)
 }
 
+// export is an endpoint that returns everything that is stored about the user making the request.
+func (h *handler) export(w http.ResponseWriter, r *http.Request) {
+	user := h.requireRegisteredUser(w, r)
+	if user == nil {
+		return
+	}
+
+	resp, err := h.service.export(user, h.tokenService, h.dailyService)
+	if err != nil {
+		logger.Error("error occurred while exporting account: %s", err)
+		api.Error(w, "Unknown error occurred while exporting account.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="trivia-export.json"`)
+	api.Response(w, resp, http.StatusOK)
+}
+
 // NewHandler creates a new handler for the profile service.
-func NewHandler(us trivia.UserService, ts trivia.AuthTokenService) http.Handler {
-	h := handler{userService: us, tokenService: ts}
+func NewHandler(us trivia.UserService, ts trivia.AuthTokenService, ds trivia.DailyService,
+	rs trivia.GameResultService, as trivia.AchievementService, ratings *rating.Updater, levels *xp.Awarder,
+	accounts AccountDeleter) http.Handler {
+	h := handler{
+		service:      &service{users: us, results: rs, achievements: as, ratings: ratings, levels: levels},
+		tokenService: ts,
+		dailyService: ds,
+		accounts:     accounts,
+	}
+
 	r := mux.NewRouter()
 	r.HandleFunc("/v1/profile/me", h.me).Methods("GET")
+	r.HandleFunc("/v1/profile/me", h.deleteMe).Methods("DELETE")
+	r.HandleFunc("/v1/profile/me/export", h.export).Methods("GET")
+	r.HandleFunc("/v1/profile/{username}", h.profile).Methods("GET")
+	r.HandleFunc("/v1/profile/{username}/games", h.matchHistory).Methods("GET")
 	return api.WrapAPIHandler(r)
 }
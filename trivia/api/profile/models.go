@@ -9,4 +9,126 @@ type userProfileResponse struct {
 	Username string     `json:"username"`
 	Guest    bool       `json:"guest"`
 	GuestID  null.Int64 `json:"guestId"`
+
+	DailyStreak        int `json:"dailyStreak"`
+	LongestDailyStreak int `json:"longestDailyStreak"`
+
+	// Rating is null for guests since they are never rated.
+	Rating            null.Int64 `json:"rating"`
+	RatedGames        int        `json:"ratedGames"`
+	ProvisionalRating bool       `json:"provisionalRating"`
+
+	// Level is null for guests since they never earn XP.
+	Level *levelResponse `json:"level"`
+}
+
+type levelResponse struct {
+	Level       int     `json:"level"`
+	XP          int64   `json:"xp"`
+	LevelXP     int64   `json:"levelXP"`
+	NextLevelXP int64   `json:"nextLevelXP"`
+	Progress    float64 `json:"progress"`
+}
+
+type publicProfileResponse struct {
+	Username string `json:"username"`
+	Guest    bool   `json:"guest"`
+
+	// Limited is true for guests since nothing about them is kept besides their username.
+	Limited bool `json:"limited"`
+
+	// Joined is the unix timestamp at which the user signed up.
+	Joined null.Int64    `json:"joined"`
+	Stats  *profileStats `json:"stats"`
+
+	Badges []badgeResponse `json:"badges"`
+
+	// MatchHistory is the path of the user's match history.
+	MatchHistory null.String `json:"matchHistory"`
+}
+
+type profileStats struct {
+	GamesPlayed    int     `json:"gamesPlayed"`
+	Wins           int     `json:"wins"`
+	Score          int64   `json:"score"`
+	CorrectAnswers int     `json:"correctAnswers"`
+	Questions      int     `json:"questions"`
+	Accuracy       float64 `json:"accuracy"`
+
+	Rating            int64 `json:"rating"`
+	RatedGames        int   `json:"ratedGames"`
+	ProvisionalRating bool  `json:"provisionalRating"`
+
+	Level *levelResponse `json:"level"`
+
+	FavoriteCategory null.String     `json:"favoriteCategory"`
+	Categories       []categoryStats `json:"categories"`
+}
+
+type badgeResponse struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	AwardedAt   int64  `json:"awardedAt"`
+}
+
+type categoryStats struct {
+	Category       string  `json:"category"`
+	Games          int     `json:"games"`
+	Score          int64   `json:"score"`
+	CorrectAnswers int     `json:"correctAnswers"`
+	Questions      int     `json:"questions"`
+	Accuracy       float64 `json:"accuracy"`
+}
+
+type matchHistoryEntry struct {
+	ResultID         int64  `json:"resultId"`
+	GameID           string `json:"gameId"`
+	FinishedAt       int64  `json:"finishedAt"`
+	QuestionCount    int    `json:"questionCount"`
+	ParticipantCount int    `json:"participantCount"`
+	Placement        int    `json:"placement"`
+	Score            int    `json:"score"`
+	CorrectAnswers   int    `json:"correctAnswers"`
+
+	// Results is the path of the full results of the game.
+	Results string `json:"results"`
+}
+
+type matchHistoryResponse struct {
+	Username string              `json:"username"`
+	Games    []matchHistoryEntry `json:"games"`
+}
+
+type exportResponse struct {
+	// ExportedAt is the unix timestamp at which the export was made.
+	ExportedAt int64 `json:"exportedAt"`
+
+	Profile  exportProfile       `json:"profile"`
+	Stats    *profileStats       `json:"stats"`
+	Badges   []badgeResponse     `json:"badges"`
+	Sessions []exportSession     `json:"sessions"`
+	Games    []matchHistoryEntry `json:"games"`
+}
+
+type exportProfile struct {
+	ID            int64  `json:"id"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
+	Joined        int64  `json:"joined"`
+
+	// UsernameChanged is null if the user never changed their username.
+	UsernameChanged null.Int64 `json:"usernameChanged"`
+
+	DailyStreak        int `json:"dailyStreak"`
+	LongestDailyStreak int `json:"longestDailyStreak"`
+}
+
+type exportSession struct {
+	ID         int64  `json:"id"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastUsedAt int64  `json:"lastUsedAt"`
+	UserAgent  string `json:"userAgent"`
+	IP         string `json:"ip"`
 }
@@ -1,10 +1,214 @@
 package profile
 
 import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/expixel/actual-trivia-server/eplog"
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/achievement"
+	"github.com/expixel/actual-trivia-server/trivia/null"
+	"github.com/expixel/actual-trivia-server/trivia/rating"
+	"github.com/expixel/actual-trivia-server/trivia/xp"
 )
 
 var logger = eplog.NewPrefixLogger("profile")
 
+// guestUsernamePrefix is the prefix of the usernames given to guests.
+const guestUsernamePrefix = "#Guest"
+
+// exportPageSize is how many games are loaded at a time when a user's games are exported.
+const exportPageSize = 100
+
 type service struct {
+	users        trivia.UserService
+	results      trivia.GameResultService
+	achievements trivia.AchievementService
+	ratings      *rating.Updater
+	levels       *xp.Awarder
+}
+
+// publicStats collects the stats of a registered user that anyone is allowed to see.
+func (s *service) publicStats(user *trivia.User) (*profileStats, error) {
+	stats, err := s.results.UserStats(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.ratings.PlayerRating(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	level, err := s.level(user)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &profileStats{
+		GamesPlayed:       stats.GamesPlayed,
+		Wins:              stats.Wins,
+		Score:             stats.Score,
+		CorrectAnswers:    stats.CorrectAnswers,
+		Questions:         stats.Questions,
+		Accuracy:          accuracy(stats.CorrectAnswers, stats.Questions),
+		Rating:            int64(math.Round(r.Rating)),
+		RatedGames:        r.GamesPlayed,
+		ProvisionalRating: r.GamesPlayed < rating.ProvisionalGames,
+		Level:             level,
+		Categories:        make([]categoryStats, len(stats.Categories)),
+	}
+
+	// categories are already ordered by how many questions have been answered in them.
+	if len(stats.Categories) > 0 {
+		resp.FavoriteCategory = null.NewString(stats.Categories[0].Category)
+	}
+
+	for idx, c := range stats.Categories {
+		resp.Categories[idx] = categoryStats{
+			Category:       c.Category,
+			Games:          c.Games,
+			Score:          c.Score,
+			CorrectAnswers: c.CorrectAnswers,
+			Questions:      c.Questions,
+			Accuracy:       accuracy(c.CorrectAnswers, c.Questions),
+		}
+	}
+	return resp, nil
+}
+
+// level returns a user's level and their progress towards the next one.
+func (s *service) level(user *trivia.User) (*levelResponse, error) {
+	progress, err := s.levels.Progress(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &levelResponse{
+		Level:       progress.Level,
+		XP:          progress.XP,
+		LevelXP:     progress.LevelXP,
+		NextLevelXP: progress.NextLevelXP,
+		Progress:    progress.Fraction(),
+	}, nil
+}
+
+// badges returns the achievements that have been awarded to a user. Badges for achievements
+// that are no longer defined are left out.
+func (s *service) badges(user *trivia.User) ([]badgeResponse, error) {
+	badges, err := s.achievements.UserBadges(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]badgeResponse, 0, len(badges))
+	for _, b := range badges {
+		if d := achievement.Lookup(b.AchievementID); d != nil {
+			resp = append(resp, badgeResponse{
+				ID:          d.ID,
+				Name:        d.Name,
+				Description: d.Description,
+				AwardedAt:   b.AwardedAt.Unix(),
+			})
+		}
+	}
+	return resp, nil
+}
+
+// export collects everything that is stored about a registered user.
+func (s *service) export(user *trivia.User, tokens trivia.AuthTokenService, daily trivia.DailyService) (*exportResponse, error) {
+	// the user is loaded again since users from auth tokens don't have every field set.
+	stored, err := s.users.UserByID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, trivia.ErrUserNotFound
+	}
+
+	cred, err := s.users.CredByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if cred == nil {
+		return nil, trivia.ErrUserNotFound
+	}
+
+	streak, err := daily.DailyStreak(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	resp := &exportResponse{
+		ExportedAt: now.Unix(),
+		Profile: exportProfile{
+			ID:                 stored.ID,
+			Username:           stored.Username,
+			Email:              cred.Email,
+			EmailVerified:      cred.EmailVerified,
+			Joined:             stored.Created.Unix(),
+			DailyStreak:        streak.CurrentAsOf(now),
+			LongestDailyStreak: streak.Longest,
+		},
+		Games: make([]matchHistoryEntry, 0),
+	}
+	if !stored.UsernameChanged.IsZero() {
+		resp.Profile.UsernameChanged = null.NewInt64(stored.UsernameChanged.Unix())
+	}
+
+	if resp.Stats, err = s.publicStats(stored); err != nil {
+		return nil, err
+	}
+	if resp.Badges, err = s.badges(stored); err != nil {
+		return nil, err
+	}
+
+	sessions, err := tokens.UserSessions(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	resp.Sessions = make([]exportSession, len(sessions))
+	for idx, session := range sessions {
+		resp.Sessions[idx] = exportSession{
+			ID:         session.ID,
+			CreatedAt:  session.CreatedAt.Unix(),
+			LastUsedAt: session.LastUsedAt.Unix(),
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+		}
+	}
+
+	for offset := 0; ; offset += exportPageSize {
+		games, err := s.results.MatchHistory(user.ID, exportPageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range games {
+			resp.Games = append(resp.Games, newMatchHistoryEntry(g))
+		}
+		if len(games) < exportPageSize {
+			break
+		}
+	}
+	return resp, nil
+}
+
+// accuracy returns the fraction of questions that were answered correctly.
+func accuracy(correctAnswers int, questions int) float64 {
+	if questions < 1 {
+		return 0
+	}
+	return float64(correctAnswers) / float64(questions)
+}
+
+// isGuestUsername returns true if a username is one given to guests.
+func isGuestUsername(username string) bool {
+	if !strings.HasPrefix(username, guestUsernamePrefix) {
+		return false
+	}
+	guestID, err := strconv.ParseInt(username[len(guestUsernamePrefix):], 10, 64)
+	return err == nil && guestID > 0
 }
@@ -0,0 +1,28 @@
+package profile
+
+import "testing"
+
+func TestIsGuestUsername(t *testing.T) {
+	guests := []string{"#Guest1", "#Guest4821"}
+	for _, username := range guests {
+		if !isGuestUsername(username) {
+			t.Errorf("expected %s to be a guest username", username)
+		}
+	}
+
+	users := []string{"Guest1", "#Guest", "#Guest0", "#Guest-3", "#Guestbook", "expixel"}
+	for _, username := range users {
+		if isGuestUsername(username) {
+			t.Errorf("expected %s to not be a guest username", username)
+		}
+	}
+}
+
+func TestAccuracy(t *testing.T) {
+	if a := accuracy(0, 0); a != 0 {
+		t.Errorf("expected an accuracy of 0 without any questions but got %f", a)
+	}
+	if a := accuracy(3, 4); a != 0.75 {
+		t.Errorf("expected an accuracy of 0.75 but got %f", a)
+	}
+}
@@ -0,0 +1,507 @@
+package game
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/game/message"
+)
+
+// authTimeout is the amount of time a connection that is handled on its own goroutine
+// (challenges and matchmaking) has to send its auth token before it is closed.
+const authTimeout = 30 * time.Second
+
+// challengeResultsRetention is the amount of time a challenge is kept around after its
+// results have been revealed so that players can still look them up.
+const challengeResultsRetention = 24 * time.Hour
+
+// ChallengeGame is an asynchronous game where every player answers the same fixed set of
+// questions at their own pace before a deadline. There is no shared game loop like the one
+// in TriviaGame; each player's connection is handled on its own goroutine with its own timers.
+type ChallengeGame struct {
+	ID string
+
+	// OwningSet is the trivia game set that owns this challenge.
+	OwningSet *TriviaGamesSet
+
+	// Host is the user that created the challenge.
+	Host *trivia.User
+
+	CreatedAt time.Time
+
+	// Deadline is the time at which the results of the challenge are revealed if
+	// every player has not already finished.
+	Deadline time.Time
+
+	options      *TriviaGameOptions
+	questions    []trivia.Question
+	tokenService trivia.AuthTokenService
+	sessions     *sessionConns
+
+	// lock should be held while accessing players, finishedCount, or results.
+	lock *sync.Mutex
+
+	// players are all of the players that have joined the challenge by user ID.
+	players map[int64]*challengePlayer
+
+	// finishedCount is the number of players that have answered every question.
+	finishedCount int
+
+	// results are the final standings of the challenge. This is nil until the results are revealed.
+	results []message.ChallengeResult
+
+	// revealedChan is closed once the results of the challenge have been revealed.
+	revealedChan chan struct{}
+
+	deadlineTimer *time.Timer
+
+	hooks ChallengeHooks
+}
+
+// ChallengeHooks are optional callbacks that are used to customize a challenge. The hooks are
+// called from the goroutines of the players' connections and never with the challenge's lock held.
+type ChallengeHooks struct {
+	// CanJoin is called before a user joins the challenge for the first time. If it returns a
+	// non-empty string the user is not allowed to join and the string is sent to them as the reason.
+	CanJoin func(user *trivia.User) string
+
+	// PlayerDone is called once for every player when they have answered every question, or when the
+	// results are revealed for players that have not finished.
+	PlayerDone func(user *trivia.User, score int, correctAnswers int, finished bool)
+}
+
+// challengePlayer is a single player's progress through a challenge.
+type challengePlayer struct {
+	User *trivia.User
+
+	// conn is the connection currently being used to play for this player.
+	// This is nil if the player is not connected.
+	conn *Conn
+
+	// CurrentQuestion is the index of the next question that the player will answer.
+	CurrentQuestion int
+
+	// questionEnds is the time at which the player's time to answer the current question
+	// runs out. This is zero if the current question has not been posed to the player yet.
+	// This keeps running while a player is disconnected so that they can't buy more time.
+	questionEnds time.Time
+
+	Score          int
+	CorrectAnswers int
+
+	// Finished is true once the player has answered every question.
+	Finished   bool
+	FinishedAt time.Time
+}
+
+// challengeWaitResult is the reason a player's goroutine stopped waiting.
+type challengeWaitResult int
+
+const (
+	challengeWaitDone = challengeWaitResult(iota)
+	challengeWaitClosed
+	challengeWaitRevealed
+)
+
+// QuestionCount returns the number of questions in this challenge.
+func (c *ChallengeGame) QuestionCount() int {
+	return len(c.questions)
+}
+
+// startDeadline starts the timer that reveals the results of the challenge at its deadline.
+func (c *ChallengeGame) startDeadline() {
+	c.lock.Lock()
+	c.deadlineTimer = time.AfterFunc(time.Until(c.Deadline), c.reveal)
+	c.lock.Unlock()
+}
+
+// Play runs a single player's connection to the challenge. This blocks until the
+// player is done with the connection so it should be run on its own goroutine.
+func (c *ChallengeGame) Play(conn *Conn) {
+	defer conn.Close()
+
+	conn.WriteBytes(message.MustEncodeBytes(&message.ClientInfoRequest{GameID: c.ID}))
+	user := waitForAuth(conn, c.tokenService, c.sessions)
+	if user == nil {
+		return
+	}
+
+	player, reason := c.joinPlayer(user, conn)
+	if player == nil {
+		if reason != "" {
+			c.writeMessage(conn, &message.ChallengeClosed{Reason: reason})
+		} else {
+			c.writeMessage(conn, &message.ChallengeResults{Results: c.Results()})
+		}
+		return
+	}
+	defer c.leavePlayer(player, conn)
+
+	logger.Debug("challenge(%s): %s connected", c.ID, user.Username)
+	switch c.playQuestions(conn, player) {
+	case challengeWaitClosed:
+		return
+	case challengeWaitRevealed:
+		c.writeMessage(conn, &message.ChallengeResults{Results: c.Results()})
+		return
+	}
+
+	// the player has finished so they just wait around for the results.
+	c.writeMessage(conn, c.finishedMessage(player))
+	if c.waitFor(conn, nil) == challengeWaitRevealed {
+		c.writeMessage(conn, &message.ChallengeResults{Results: c.Results()})
+	}
+}
+
+// waitForAuth waits for a connection to send a ClientAuth message and returns the authenticated
+// user, or nil if the user could not be authenticated. Authenticated connections are tracked in
+// sessions so they are closed if the session they were authenticated with is revoked.
+func waitForAuth(conn *Conn, tokenService trivia.AuthTokenService, sessions *sessionConns) *trivia.User {
+	timeout := time.NewTimer(authTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case msg := <-conn.recvChan:
+			switch msg := msg.(type) {
+			case *message.ClientAuth:
+				token, user, err := tokenService.GetAuthTokenAndUser(msg.AuthToken)
+				if err != nil {
+					logger.Error("error getting user auth: %s", err)
+					return nil
+				}
+				if user == nil || token.Expired(time.Now()) {
+					conn.WriteBytes(bmUserNotFound)
+					return nil
+				}
+				sessions.track(token.Family, conn)
+				return user
+			case *message.SocketClosed:
+				return nil
+			}
+		case <-timeout.C:
+			return nil
+		}
+	}
+}
+
+// joinPlayer adds a user to the challenge or reassociates them with their player if they have
+// already joined. If the player cannot join, nil is returned along with the reason. If the
+// player is nil and the reason is empty the results of the challenge have already been revealed.
+func (c *ChallengeGame) joinPlayer(user *trivia.User, conn *Conn) (*challengePlayer, string) {
+	if player, reason, found := c.rejoinPlayer(user, conn); found {
+		return player, reason
+	}
+
+	if c.hooks.CanJoin != nil {
+		if reason := c.hooks.CanJoin(user); reason != "" {
+			return nil, reason
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.results != nil {
+		return nil, ""
+	}
+
+	if player, ok := c.players[user.ID]; ok {
+		// the same user joined from somewhere else while we were checking if they could join.
+		if player.conn != nil {
+			player.conn.Close()
+		}
+		player.conn = conn
+		return player, ""
+	}
+
+	if len(c.players) >= c.options.MaxParticipants {
+		return nil, "This challenge already has the maximum number of players."
+	}
+
+	player := &challengePlayer{User: user, conn: conn}
+	c.players[user.ID] = player
+	return player, ""
+}
+
+// rejoinPlayer reassociates a user with their player if they have already joined the challenge.
+// The last return value is false if the user is new to the challenge.
+func (c *ChallengeGame) rejoinPlayer(user *trivia.User, conn *Conn) (*challengePlayer, string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.results != nil {
+		return nil, "", true
+	}
+
+	player, ok := c.players[user.ID]
+	if !ok {
+		return nil, "", false
+	}
+
+	// we just jump over to the new connection. The goroutine for the old connection
+	// will stop once it sees that the socket has been closed.
+	if player.conn != nil {
+		player.conn.Close()
+	}
+	player.conn = conn
+	return player, "", true
+}
+
+// leavePlayer disassociates a connection from a player if the player is still using it.
+func (c *ChallengeGame) leavePlayer(player *challengePlayer, conn *Conn) {
+	c.lock.Lock()
+	if player.conn == conn {
+		player.conn = nil
+	}
+	c.lock.Unlock()
+}
+
+// playQuestions poses each of the remaining questions to a player one at a time. This returns
+// challengeWaitDone once the player has answered every question.
+func (c *ChallengeGame) playQuestions(conn *Conn, player *challengePlayer) challengeWaitResult {
+	for {
+		c.lock.Lock()
+		if player.conn != conn {
+			c.lock.Unlock()
+			return challengeWaitClosed
+		}
+
+		if player.Finished {
+			c.lock.Unlock()
+			return challengeWaitDone
+		}
+
+		if player.CurrentQuestion >= len(c.questions) {
+			allFinished := c.finishPlayer(player)
+			score, correctAnswers := player.Score, player.CorrectAnswers
+			c.lock.Unlock()
+
+			if c.hooks.PlayerDone != nil {
+				c.hooks.PlayerDone(player.User, score, correctAnswers, true)
+			}
+			if allFinished {
+				c.reveal()
+			}
+			return challengeWaitDone
+		}
+
+		now := time.Now()
+		index := player.CurrentQuestion
+		q := &c.questions[index]
+		if player.questionEnds.IsZero() {
+			player.questionEnds = now.Add(questionReadTime(q) + c.options.QuestionAnswerDuration)
+		}
+		questionEnds := player.questionEnds
+		c.lock.Unlock()
+
+		answer := -1
+		if now.Before(questionEnds) {
+			var result challengeWaitResult
+			answer, result = c.askQuestion(conn, index, q, questionEnds)
+			if result != challengeWaitDone {
+				return result
+			}
+		}
+
+		c.lock.Lock()
+		if player.conn == conn && player.CurrentQuestion == index {
+			if answer == q.CorrectChoice {
+				player.Score += correctAnswerPoints
+				player.CorrectAnswers++
+			}
+			player.CurrentQuestion++
+			player.questionEnds = time.Time{}
+		}
+		c.lock.Unlock()
+
+		c.writeMessage(conn, &message.RevealAnswer{QuestionIndex: index, AnswerIndex: q.CorrectChoice})
+		if result := c.waitFor(conn, time.After(answerAnimationTime)); result != challengeWaitDone {
+			return result
+		}
+	}
+}
+
+// askQuestion sends a question to a player and waits for them to select an answer or for their
+// time to run out. It returns the selected answer or -1 if no answer was selected.
+func (c *ChallengeGame) askQuestion(conn *Conn, index int, q *trivia.Question, questionEnds time.Time) (int, challengeWaitResult) {
+	c.writeMessage(conn, &message.SetPrompt{
+		Prompt:     q.Prompt,
+		Choices:    q.Choices,
+		Category:   q.Category,
+		Difficulty: "Unknown",
+		Index:      index,
+	})
+
+	countdownStart := questionEnds.Add(-c.options.QuestionAnswerDuration)
+	countdownTimer := time.NewTimer(time.Until(countdownStart))
+	defer countdownTimer.Stop()
+	endTimer := time.NewTimer(time.Until(questionEnds))
+	defer endTimer.Stop()
+
+	for {
+		select {
+		case msg := <-conn.recvChan:
+			switch msg := msg.(type) {
+			case *message.SocketClosed:
+				if message.IsSocketClosed(msg, conn.wsConn) {
+					return -1, challengeWaitClosed
+				}
+			case *message.SelectAnswer:
+				if msg.QuestionIndex == index && msg.Index >= 0 {
+					return msg.Index, challengeWaitDone
+				}
+			case nil:
+				// invalid messages are already logged by the read loop.
+			default:
+				logger.Error("unhandled challenge message of type '%T'", msg)
+			}
+		case <-countdownTimer.C:
+			untilEnd := time.Until(questionEnds)
+			c.writeMessage(conn, &message.QuestionCountdownTick{
+				Begin:           true,
+				MillisRemaining: int(untilEnd.Nanoseconds() / int64(time.Millisecond)),
+			})
+		case <-endTimer.C:
+			return -1, challengeWaitDone
+		case <-c.revealedChan:
+			return -1, challengeWaitRevealed
+		}
+	}
+}
+
+// waitFor waits for a channel to receive while discarding messages from the player. A nil
+// channel waits until the connection is closed or the results are revealed.
+func (c *ChallengeGame) waitFor(conn *Conn, ch <-chan time.Time) challengeWaitResult {
+	for {
+		select {
+		case msg := <-conn.recvChan:
+			if closed, ok := msg.(*message.SocketClosed); ok && message.IsSocketClosed(closed, conn.wsConn) {
+				return challengeWaitClosed
+			}
+		case <-ch:
+			return challengeWaitDone
+		case <-c.revealedChan:
+			return challengeWaitRevealed
+		}
+	}
+}
+
+// finishPlayer marks a player as finished. This returns true if every player that has joined the
+// challenge has finished, even if it isn't full. This should be called with the lock held.
+func (c *ChallengeGame) finishPlayer(player *challengePlayer) bool {
+	player.Finished = true
+	player.FinishedAt = time.Now()
+	c.finishedCount++
+	logger.Debug("challenge(%s): %s finished with %d points", c.ID, player.User.Username, player.Score)
+	return c.finishedCount >= len(c.players)
+}
+
+func (c *ChallengeGame) finishedMessage(player *challengePlayer) *message.ChallengeFinished {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return &message.ChallengeFinished{
+		Score:           player.Score,
+		CorrectAnswers:  player.CorrectAnswers,
+		PlayersFinished: c.finishedCount,
+		MaxPlayers:      c.options.MaxParticipants,
+		Deadline:        c.Deadline.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// reveal ranks the players and reveals the results of the challenge to everyone.
+func (c *ChallengeGame) reveal() {
+	c.lock.Lock()
+	if c.results != nil {
+		c.lock.Unlock()
+		return
+	}
+
+	players := make([]*challengePlayer, 0, len(c.players))
+	unfinished := make([]challengePlayer, 0)
+	for _, p := range c.players {
+		players = append(players, p)
+		if !p.Finished {
+			unfinished = append(unfinished, *p)
+		}
+	}
+	c.results = rankChallengePlayers(players)
+	close(c.revealedChan)
+	c.deadlineTimer.Stop()
+	c.lock.Unlock()
+
+	if c.hooks.PlayerDone != nil {
+		for _, p := range unfinished {
+			c.hooks.PlayerDone(p.User, p.Score, p.CorrectAnswers, false)
+		}
+	}
+
+	logger.Debug("challenge(%s): results revealed", c.ID)
+	time.AfterFunc(challengeResultsRetention, func() {
+		c.OwningSet.removeChallenge(c.ID)
+	})
+}
+
+// Revealed returns true if the results of the challenge have been revealed.
+func (c *ChallengeGame) Revealed() bool {
+	select {
+	case <-c.revealedChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Results returns the final standings of the challenge or nil if they have not been revealed yet.
+func (c *ChallengeGame) Results() []message.ChallengeResult {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.results
+}
+
+// PlayerCounts returns the number of players that have joined the challenge and the number that have finished.
+func (c *ChallengeGame) PlayerCounts() (joined int, finished int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.players), c.finishedCount
+}
+
+// writeMessage encodes and writes a single message to a connection.
+func (c *ChallengeGame) writeMessage(conn *Conn, msg interface{}) {
+	conn.WriteBytes(message.MustEncodeBytes(msg))
+}
+
+// rankChallengePlayers orders players by score and then by how early they finished
+// and assigns them placements. Players with the same score share a placement.
+func rankChallengePlayers(players []*challengePlayer) []message.ChallengeResult {
+	sort.SliceStable(players, func(i, j int) bool {
+		a, b := players[i], players[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Finished != b.Finished {
+			return a.Finished
+		}
+		return a.FinishedAt.Before(b.FinishedAt)
+	})
+
+	results := make([]message.ChallengeResult, len(players))
+	for idx, p := range players {
+		placement := idx + 1
+		if idx > 0 && players[idx-1].Score == p.Score {
+			placement = results[idx-1].Placement
+		}
+
+		results[idx] = message.ChallengeResult{
+			Placement:      placement,
+			Username:       p.User.Username,
+			Score:          p.Score,
+			CorrectAnswers: p.CorrectAnswers,
+			Finished:       p.Finished,
+		}
+	}
+	return results
+}
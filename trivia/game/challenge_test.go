@@ -0,0 +1,57 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+func TestRankChallengePlayers(t *testing.T) {
+	now := time.Now()
+	players := []*challengePlayer{
+		{User: &trivia.User{Username: "slow"}, Score: 300, Finished: true, FinishedAt: now.Add(time.Minute)},
+		{User: &trivia.User{Username: "unfinished"}, Score: 300},
+		{User: &trivia.User{Username: "best"}, Score: 500, Finished: true, FinishedAt: now.Add(time.Hour)},
+		{User: &trivia.User{Username: "fast"}, Score: 300, Finished: true, FinishedAt: now},
+		{User: &trivia.User{Username: "last"}, Score: 0, Finished: true, FinishedAt: now},
+	}
+
+	results := rankChallengePlayers(players)
+	expected := []struct {
+		username  string
+		placement int
+	}{
+		{"best", 1},
+		{"fast", 2},
+		{"slow", 2},
+		{"unfinished", 2},
+		{"last", 5},
+	}
+
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results but got %d", len(expected), len(results))
+	}
+	for idx, e := range expected {
+		if results[idx].Username != e.username || results[idx].Placement != e.placement {
+			t.Errorf("expected %s to be placed %d at index %d but got %s placed %d",
+				e.username, e.placement, idx, results[idx].Username, results[idx].Placement)
+		}
+	}
+}
+
+func TestFinishPlayerBeforeChallengeIsFull(t *testing.T) {
+	first := &challengePlayer{User: &trivia.User{ID: 1, Username: "first"}}
+	second := &challengePlayer{User: &trivia.User{ID: 2, Username: "second"}}
+	c := &ChallengeGame{
+		options: &TriviaGameOptions{MaxParticipants: 5},
+		players: map[int64]*challengePlayer{1: first, 2: second},
+	}
+
+	if c.finishPlayer(first) {
+		t.Errorf("expected the challenge to wait for the other player that joined")
+	}
+	if !c.finishPlayer(second) {
+		t.Errorf("expected every player that joined to have finished even though the challenge isn't full")
+	}
+}
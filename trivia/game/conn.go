@@ -37,6 +37,15 @@ type Conn struct {
 	// recvCond is a conditional variable that when non nil should be broadcasted
 	// to when there is a message available in this websocket.
 	recvCond *sync.Cond
+
+	// doneLock guards done and onDone.
+	doneLock sync.Mutex
+
+	// done is true once the connection has been stopped or its read loop has ended.
+	done bool
+
+	// onDone are called once when the connection is done.
+	onDone []func()
 }
 
 // NewWSConn creates a new wrapped web socket connection.
@@ -118,6 +127,7 @@ func (c *Conn) StartReadLoop() {
 	if c.recvCond != nil {
 		c.recvCond.Signal()
 	}
+	c.finish()
 }
 
 // WriteBytes writes some bytes to the websocket as a text message.
@@ -171,4 +181,35 @@ func (c *Conn) IsStopped() bool {
 // stop stops the websocket's read loop.
 func (c *Conn) stop() {
 	atomic.StoreInt32(&c.stopped, 1)
+	c.finish()
+}
+
+// whenDone registers a function that is called once the connection has been stopped or its read
+// loop has ended. If the connection is already done the function is called immediately.
+func (c *Conn) whenDone(fn func()) {
+	c.doneLock.Lock()
+	if !c.done {
+		c.onDone = append(c.onDone, fn)
+		c.doneLock.Unlock()
+		return
+	}
+	c.doneLock.Unlock()
+	fn()
+}
+
+// finish marks the connection as done and calls the functions registered with whenDone.
+func (c *Conn) finish() {
+	c.doneLock.Lock()
+	if c.done {
+		c.doneLock.Unlock()
+		return
+	}
+	c.done = true
+	onDone := c.onDone
+	c.onDone = nil
+	c.doneLock.Unlock()
+
+	for _, fn := range onDone {
+		fn()
+	}
 }
@@ -46,6 +46,13 @@ const noClientsWaitTime = time.Second * 30
 // some users.
 const pingDelay = time.Second * 1
 
+// correctAnswerPoints is the number of points awarded for answering a question correctly.
+const correctAnswerPoints = 100
+
+// resultsDisplayTime is the amount of time that the results of a game are shown for
+// before the game is reset or removed.
+const resultsDisplayTime = time.Second * 15
+
 var logger = eplog.NewPrefixLogger("game")
 
 var bmUserNotFound = message.MustEncodeBytes(&message.UserNotFound{})
@@ -66,6 +73,7 @@ const (
 	gameStateProcessAnswers
 	gameStateWaitingForClients
 	gameStateReporting
+	gameStateFinished
 )
 
 // TriviaGame represents and coordinates a currently running game.
@@ -93,6 +101,17 @@ type TriviaGame struct {
 	// stopGameChan is a channel used for stopping the current game.
 	stopGameChan chan bool
 
+	// userMessageChan is a channel of messages queued from outside of the game's loop
+	// that should be sent to a single user.
+	userMessageChan chan userMessage
+
+	// guestUpgradeChan is a channel of guests that have upgraded to full accounts whose clients
+	// should be moved to their new users.
+	guestUpgradeChan chan guestUpgrade
+
+	// endGameChan receives the reason that a moderator ended the game.
+	endGameChan chan string
+
 	// MsgPendingCond is a condition that will be signaled every time there is a message
 	// waiting for this game to process.
 	MsgPendingCond *sync.Cond
@@ -137,6 +156,9 @@ type TriviaGame struct {
 	currentQuestion int
 	questions       []trivia.Question
 
+	// questionAskedAt is the time at which the current question was sent to clients.
+	questionAskedAt time.Time
+
 	// participantsList is a list of participants list that also doubles as
 	// the outgoing message that is sent to update the participants list for clients.
 	participantsList message.ParticipantsList
@@ -165,6 +187,43 @@ type TriviaGameOptions struct {
 
 	// QuestionAnswerDuration is the amount of time that players get to answer each question.
 	QuestionAnswerDuration time.Duration
+
+	// FiftyFiftyLifelines is the number of 50/50 lifelines each player gets. A 50/50 removes
+	// two wrong choices from a question for the player that used it.
+	FiftyFiftyLifelines int
+
+	// DoublePointsLifelines is the number of double points lifelines each player gets. Double points
+	// must be declared before the player answers a question.
+	DoublePointsLifelines int
+
+	// SkipLifelines is the number of skip lifelines each player gets. A skip lets the player sit out
+	// a question without it counting against them.
+	SkipLifelines int
+
+	// StartAt is the time at which a scheduled game should start. If this is set the game start
+	// countdown will not begin until GameStartDelay before this time. Games without a StartAt
+	// start as soon as there are enough participants.
+	StartAt time.Time
+
+	// ReservedSeats are the IDs of users that have seats reserved in this game. Other users
+	// can't take those seats as participants until the game starts.
+	ReservedSeats []int64
+
+	// Categories limits the questions in the game to the given categories. Questions can
+	// come from any category if this is empty.
+	Categories []string
+
+	// Unlisted games are never picked for players using quickjoin and can only be joined
+	// using their ID.
+	Unlisted bool
+
+	// RemoveOnFinish removes the game from its set once it has finished instead of
+	// resetting it so that it can be played again.
+	RemoveOnFinish bool
+
+	// Clock is used to get the current time for the game's countdowns. SystemClock is used if
+	// this is nil.
+	Clock Clock
 }
 
 // url('/sample-path
@@ -191,13 +250,50 @@ type TriviaGameClient struct {
 	// This is -1 if the client has not selected an answer.
 	SelectedAnswer int
 
+	// AnswerTime is how long after the current question was asked the client selected an answer.
+	AnswerTime time.Duration
+
+	// FastestCorrectAnswer is the shortest AnswerTime of all of this client's correct answers.
+	// This is 0 if the client has not answered any questions correctly.
+	FastestCorrectAnswer time.Duration
+
 	// Score is this client's user's current score.
 	Score int
 
+	// CorrectAnswers is the number of questions this client has answered correctly.
+	CorrectAnswers int
+
+	// CategoryScores are the totals of the questions this client answered in each category.
+	CategoryScores map[string]*trivia.CategoryScore
+
+	// Lifelines are the lifelines that this client has remaining.
+	Lifelines message.Lifelines
+
+	// RemovedChoices are the choices of the current question that have been removed for this
+	// client by a 50/50 lifeline. This is nil if no choices have been removed.
+	RemovedChoices []int
+
+	// DoublePoints is true if the client has declared double points for the current question.
+	DoublePoints bool
+
+	// Skipped is true if the client is sitting out the current question.
+	Skipped bool
+
 	// Closed is true if the websocket for this client is currently Closed.
 	Closed bool
 }
 
+// userMessage is a message queued to be sent to a single user of a game.
+type userMessage struct {
+	userID int64
+	msg    interface{}
+}
+
+type guestUpgrade struct {
+	guestID int64
+	user    *trivia.User
+}
+
 // Start starts the trivia game.
 func (g *TriviaGame) Start() {
 	go g.startLoop()
@@ -209,6 +305,94 @@ func (g *TriviaGame) Stop() {
 	g.MsgPendingCond.Signal()
 }
 
+// SendToUser queues a message to be sent to a user connected to the game. This is safe to call from
+// any goroutine. The message is dropped if the user isn't connected to the game when it is sent.
+func (g *TriviaGame) SendToUser(userID int64, msg interface{}) {
+	select {
+	case g.userMessageChan <- userMessage{userID: userID, msg: msg}:
+		g.MsgPendingCond.Signal()
+	default:
+		logger.Error("game(%s) dropped message for user %d because too many messages are queued", g.ID, userID)
+	}
+}
+
+// UpgradeGuest queues moving a guest's client to the user that the guest upgraded to so that they
+// keep playing and the rest of the game is recorded for the user. This is safe to call from any goroutine.
+func (g *TriviaGame) UpgradeGuest(guestID int64, user *trivia.User) {
+	select {
+	case g.guestUpgradeChan <- guestUpgrade{guestID: guestID, user: user}:
+		g.MsgPendingCond.Signal()
+	default:
+		logger.Error("game(%s) dropped upgrade of guest %d because too many upgrades are queued", g.ID, guestID)
+	}
+}
+
+// End queues ending the game without recording its results. Everyone in the game is sent the reason
+// and disconnected. Games that are removed when they finish are removed from their set, and other
+// games start over empty. This is safe to call from any goroutine.
+func (g *TriviaGame) End(reason string) {
+	select {
+	case g.endGameChan <- reason:
+		g.MsgPendingCond.Signal()
+	default:
+		// the game is already being ended.
+	}
+}
+
+// end ends the game from its loop. This returns true if the loop should stop.
+func (g *TriviaGame) end(reason string) bool {
+	logger.Info("game(%s) was ended: %s", g.ID, reason)
+	g.broadcastMessage(&message.GameEnded{Reason: reason})
+	for _, conn := range g.pendingClients {
+		conn.WriteBytes(message.MustEncodeBytes(&message.GameEnded{Reason: reason}))
+		conn.Close()
+	}
+	g.pendingClients = make([]*Conn, 0)
+
+	if g.options.RemoveOnFinish {
+		g.OwningSet.removeGame(g.ID)
+		g.reset(true)
+		g.gameTickTimer.Stop()
+		return true
+	}
+	g.reset(true)
+	return false
+}
+
+// upgradeGuestClient moves a guest's client, connected or not, to the user that they upgraded to.
+func (g *TriviaGame) upgradeGuestClient(guestID int64, user *trivia.User) {
+	// guests are keyed by their negative guest IDs like User.ID.
+	guestKey := -guestID
+	clients := g.clients
+	client, ok := clients[guestKey]
+	if !ok {
+		clients = g.disconnectedClients
+		if client, ok = clients[guestKey]; !ok {
+			return
+		}
+	}
+
+	// the user can't be in the game twice so the guest is left alone if the user somehow
+	// already joined.
+	if _, ok := g.clients[user.ID]; ok {
+		return
+	}
+	if _, ok := g.disconnectedClients[user.ID]; ok {
+		return
+	}
+
+	participant := g.findParticipantInList(client)
+	delete(clients, guestKey)
+	client.User = user
+	clients[user.ID] = client
+	logger.Debug("game(%s) moved guest %d to user %s", g.ID, guestID, user.Username)
+
+	if participant != nil {
+		participant.Username = user.Username
+		g.broadcastMessage(&g.participantsList)
+	}
+}
+
 // AddConn adds a new connection to the game.
 func (g *TriviaGame) AddConn(conn *Conn) {
 	g.clientConnectedChan <- conn
@@ -231,6 +415,16 @@ connectionLoop:
 				g.pendingClients = append(g.pendingClients, conn)
 				logger.Debug("client %s added to pending clients", conn.wsConn.RemoteAddr()) // #TODO remove debug code
 				conn.WriteBytes(clientInfoRequestMessage)
+			case um := <-g.userMessageChan:
+				if client, ok := g.clients[um.userID]; ok {
+					g.sendMessage(client, um.msg)
+				}
+			case upgrade := <-g.guestUpgradeChan:
+				g.upgradeGuestClient(upgrade.guestID, upgrade.user)
+			case reason := <-g.endGameChan:
+				if g.end(reason) {
+					break connectionLoop
+				}
 			case val, ok := <-g.stopGameChan:
 				stopGameChanClosed = !ok
 				if val || !ok {
@@ -275,10 +469,15 @@ func (g *TriviaGame) addGameClient(conn *Conn, user *trivia.User) {
 		Conn:            conn,
 		CurrentQuestion: -1,
 		SelectedAnswer:  -1,
+		Lifelines: message.Lifelines{
+			FiftyFifty:   g.options.FiftyFiftyLifelines,
+			DoublePoints: g.options.DoublePointsLifelines,
+			Skip:         g.options.SkipLifelines,
+		},
 	}
 
 	// #TODO figure out whatever the fuck else goes into making someone a game participant or not.
-	if !g.isParticipationClosed() {
+	if g.canParticipate(user) {
 		client.Participant = true
 		g.participantsCount++
 		g.updateSetParticipation()
@@ -298,13 +497,47 @@ func (g *TriviaGame) isParticipationClosed() bool {
 		return true
 	}
 
-	if g.participantsCount >= g.options.MaxParticipants {
+	if g.participantsCount+g.unclaimedReservations() >= g.options.MaxParticipants {
+		return true
+	}
+
+	return false
+}
+
+// canParticipate returns true if a user can join the game as a participant. Users with a
+// reserved seat can always participate until the game starts.
+func (g *TriviaGame) canParticipate(user *trivia.User) bool {
+	if g.currentState >= gameStateQuestion {
+		return false
+	}
+
+	if g.hasReservedSeat(user) {
 		return true
 	}
 
+	return !g.isParticipationClosed()
+}
+
+func (g *TriviaGame) hasReservedSeat(user *trivia.User) bool {
+	for _, userID := range g.options.ReservedSeats {
+		if userID == user.ID {
+			return true
+		}
+	}
 	return false
 }
 
+// unclaimedReservations returns the number of reserved seats whose users have not joined the game yet.
+func (g *TriviaGame) unclaimedReservations() int {
+	unclaimed := 0
+	for _, userID := range g.options.ReservedSeats {
+		if client, ok := g.clients[userID]; !ok || !client.Participant {
+			unclaimed++
+		}
+	}
+	return unclaimed
+}
+
 func (g *TriviaGame) updateSetParticipation() {
 	g.OwningSet.WithSetGame(g.ID, func(set *TriviaGameSetGame) {
 		set.ParticipationClosed = g.isParticipationClosed()
@@ -319,13 +552,26 @@ func (g *TriviaGame) gameTick() {
 	case gameStateWaitForStart:
 		logger.Debug("checking participants count: %d >= %d", g.participantsCount, g.options.MinParticipants)
 		if g.participantsCount >= g.options.MinParticipants {
-			g.gameCountdownEnd = time.Now().Add(g.options.GameStartDelay)
+			// scheduled games wait until their countdown would end at their start time.
+			if untilCountdown := g.untilScheduledCountdown(); untilCountdown > 0 {
+				g.tickWait(untilCountdown)
+				break
+			}
+
+			g.gameCountdownEnd = g.now().Add(g.options.GameStartDelay)
 			g.currentState = gameStateFetchQuestions
 			g.tickImm()
 		}
 	case gameStateFetchQuestions:
 		var err error
-		g.questions, err = g.questionService.GetRandomQuestions(g.options.QuestionCount)
+		if len(g.options.Categories) > 0 {
+			g.questions, err = g.questionService.GetRandomQuestionsInCategories(g.options.QuestionCount, g.options.Categories)
+		}
+
+		// games fall back to questions from any category if their categories don't have enough.
+		if err != nil || len(g.questions) < g.options.QuestionCount {
+			g.questions, err = g.questionService.GetRandomQuestions(g.options.QuestionCount)
+		}
 		if err != nil {
 			logger.Error("error occurred while fetching questions for game(%s): %s", g.ID, err)
 			// #TODO I should end the game here.
@@ -338,11 +584,12 @@ func (g *TriviaGame) gameTick() {
 		g.currentState = gameStateCountdownToStart
 		g.tickImm()
 	case gameStateCountdownToStart:
-		now := time.Now()
+		now := g.now()
 		if now.After(g.gameCountdownEnd) {
 			g.currentState = gameStateQuestion
 			g.updateSetParticipation()
 			g.broadcastMessage(&message.GameStart{QuestionCount: g.options.QuestionCount})
+			g.sendLifelines()
 			g.tickWait(500 * time.Millisecond)
 		} else {
 			var waitDur time.Duration
@@ -368,6 +615,7 @@ func (g *TriviaGame) gameTick() {
 
 		q := g.questions[g.currentQuestion]
 		g.prepareClientsForQuestion()
+		g.questionAskedAt = g.now()
 		g.broadcastMessage(&message.SetPrompt{
 			Prompt:     q.Prompt,
 			Choices:    q.Choices,
@@ -377,20 +625,11 @@ func (g *TriviaGame) gameTick() {
 		})
 		g.currentState = gameStateStartQuestionCountdown
 
-		// extra time is time for reading after the animations
-		wordsInPrompt := countWords(q.Prompt)
-		for _, choice := range q.Choices {
-			wordsInPrompt += countWords(choice)
-		}
-
-		extraTime := (time.Duration(wordsInPrompt) * time.Second / time.Duration(wordsPerSecond))
-		if extraTime > maxQuestionReadTime {
-			extraTime = maxQuestionReadTime
-		}
-		logger.Debug("ask question (%d -- %s): %s", wordsInPrompt, extraTime.String(), q.Prompt)
-		g.tickWait(questionAnimationTime + extraTime) // time allowance for question animation/extra reading time
+		readTime := questionReadTime(&q)
+		logger.Debug("ask question (%s): %s", readTime.String(), q.Prompt)
+		g.tickWait(readTime) // time allowance for question animation/extra reading time
 	case gameStateStartQuestionCountdown:
-		g.gameCountdownEnd = time.Now().Add(g.options.QuestionAnswerDuration)
+		g.gameCountdownEnd = g.now().Add(g.options.QuestionAnswerDuration)
 		g.broadcastMessage(&message.QuestionCountdownTick{
 			Begin:           true,
 			MillisRemaining: int(g.options.QuestionAnswerDuration.Nanoseconds() / int64(time.Millisecond)),
@@ -398,7 +637,7 @@ func (g *TriviaGame) gameTick() {
 		g.currentState = gameStateQuestionCountdown
 		g.tickImm()
 	case gameStateQuestionCountdown:
-		now := time.Now()
+		now := g.now()
 		if now.After(g.gameCountdownEnd) {
 			g.currentState = gameStateProcessAnswers
 			g.tickWait(pingDelay)
@@ -428,6 +667,20 @@ func (g *TriviaGame) gameTick() {
 		}
 		g.currentState = gameStateQuestion
 		g.tickWait(answerAnimationTime) // I forget why I have a wait here, probably not important :|
+	case gameStateReporting:
+		result := g.finalResult()
+		g.broadcastMessage(newGameResultsMessage(result))
+		g.OwningSet.reportGameResult(result)
+		g.currentState = gameStateFinished
+		g.tickWait(resultsDisplayTime)
+	case gameStateFinished:
+		if g.options.RemoveOnFinish {
+			g.OwningSet.removeGame(g.ID)
+			g.reset(true)
+			g.Stop()
+		} else {
+			g.reset(true)
+		}
 	case gameStateWaitingForClients:
 		// #TODO if we reach this point, the game should end
 		g.reset(true) // for now I just reset though.
@@ -441,8 +694,21 @@ func (g *TriviaGame) gameTick() {
 func (g *TriviaGame) processAnswers() {
 	q := g.questions[g.currentQuestion]
 	for _, client := range g.clients {
-		if client.CurrentQuestion == g.currentQuestion && client.SelectedAnswer == q.CorrectChoice {
-			client.Score += 100
+		if client.CurrentQuestion == g.currentQuestion && !client.Skipped {
+			points := 0
+			correct := client.SelectedAnswer == q.CorrectChoice
+			if correct {
+				points = correctAnswerPoints
+				if client.DoublePoints {
+					points *= 2
+				}
+				client.Score += points
+				client.CorrectAnswers++
+				if client.FastestCorrectAnswer == 0 || client.AnswerTime < client.FastestCorrectAnswer {
+					client.FastestCorrectAnswer = client.AnswerTime
+				}
+			}
+			client.recordCategoryAnswer(q.Category, points, correct)
 		}
 
 		if client.Participant {
@@ -454,6 +720,25 @@ func (g *TriviaGame) processAnswers() {
 	g.broadcastMessage(&g.participantsList)
 }
 
+// recordCategoryAnswer adds an answered question to the client's totals for its category.
+// Skipped questions are never recorded.
+func (client *TriviaGameClient) recordCategoryAnswer(category string, points int, correct bool) {
+	if client.CategoryScores == nil {
+		client.CategoryScores = make(map[string]*trivia.CategoryScore)
+	}
+
+	categoryScore, ok := client.CategoryScores[category]
+	if !ok {
+		categoryScore = &trivia.CategoryScore{Category: category}
+		client.CategoryScores[category] = categoryScore
+	}
+	categoryScore.Score += points
+	categoryScore.Questions++
+	if correct {
+		categoryScore.CorrectAnswers++
+	}
+}
+
 func (g *TriviaGame) isGameInProgress() bool {
 	return g.currentState >= gameStateQuestion
 }
@@ -496,10 +781,17 @@ func (g *TriviaGame) readClientMessages() {
 				}
 			case *message.SelectAnswer:
 				if msg.QuestionIndex == client.CurrentQuestion && msg.QuestionIndex == g.currentQuestion {
-					if msg.Index >= 0 && client.SelectedAnswer < 0 {
+					if msg.Index >= 0 && client.SelectedAnswer < 0 && !client.Skipped && !isChoiceRemoved(client, msg.Index) {
 						client.SelectedAnswer = msg.Index
+						client.AnswerTime = g.now().Sub(g.questionAskedAt)
 					}
 				}
+			case *message.UseFiftyFifty:
+				g.useFiftyFifty(client, msg.QuestionIndex)
+			case *message.UseDoublePoints:
+				g.useDoublePoints(client, msg.QuestionIndex)
+			case *message.UseSkip:
+				g.useSkip(client, msg.QuestionIndex)
 			default:
 				logger.Error("unhandled client message of type '%T'", msg)
 			}
@@ -553,6 +845,10 @@ func (g *TriviaGame) prepareClientsForQuestion() {
 			client.CurrentQuestion = g.currentQuestion // so disconnected clients aren't penalized.
 		}
 		client.SelectedAnswer = -1 // reset the selected answer
+		client.AnswerTime = 0
+		client.RemovedChoices = nil
+		client.DoublePoints = false
+		client.Skipped = false
 	}
 }
 
@@ -611,6 +907,24 @@ func (g *TriviaGame) tickImm() {
 	g.skipLoopPause = true
 }
 
+// now returns the current time from the game's clock.
+func (g *TriviaGame) now() time.Time {
+	if g.options.Clock == nil {
+		return SystemClock.Now()
+	}
+	return g.options.Clock.Now()
+}
+
+// untilScheduledCountdown returns how long a scheduled game has to wait before starting its
+// countdown so that the countdown ends at the game's start time. This is never positive for
+// games that aren't scheduled.
+func (g *TriviaGame) untilScheduledCountdown() time.Duration {
+	if g.options.StartAt.IsZero() {
+		return 0
+	}
+	return g.options.StartAt.Add(-g.options.GameStartDelay).Sub(g.now())
+}
+
 // tickWait sets the delay until the next game tick.
 func (g *TriviaGame) tickWait(dur time.Duration) {
 	if dur <= 0 {
@@ -665,10 +979,11 @@ func (g *TriviaGame) restoreReconnectedClient(client *TriviaGameClient) {
 
 	if g.currentState > gameStateCountdownToStart {
 		multi.Append(&message.GameStart{QuestionCount: g.options.QuestionCount})
+		multi.Append(&client.Lifelines)
 	}
 
 	if g.currentState == gameStateCountdownToStart {
-		untilEnd := g.gameCountdownEnd.Sub(time.Now())
+		untilEnd := g.gameCountdownEnd.Sub(g.now())
 		multi.Append(&message.QuestionCountdownTick{
 			Begin:           false,
 			MillisRemaining: int(untilEnd.Nanoseconds() / int64(time.Millisecond)),
@@ -687,12 +1002,16 @@ func (g *TriviaGame) restoreReconnectedClient(client *TriviaGameClient) {
 			})
 
 			if g.currentState == gameStateStartQuestionCountdown || g.currentState == gameStateQuestionCountdown {
-				untilEnd := g.gameCountdownEnd.Sub(time.Now())
+				untilEnd := g.gameCountdownEnd.Sub(g.now())
 				multi.Append(&message.QuestionCountdownTick{
 					Begin:           false,
 					MillisRemaining: int(untilEnd.Nanoseconds() / int64(time.Millisecond)),
 				})
 			}
+
+			if client.CurrentQuestion == g.currentQuestion {
+				g.appendQuestionLifelines(&multi, client)
+			}
 		}
 	}
 
@@ -767,12 +1086,13 @@ func (g *TriviaGame) handlePendingClients() {
 			switch msg := msg.(type) {
 			case *message.ClientAuth:
 				authTokenString := msg.AuthToken
-				_, user, err := g.tokenService.GetAuthTokenAndUser(authTokenString)
+				token, user, err := g.tokenService.GetAuthTokenAndUser(authTokenString)
 				if err != nil {
 					logger.Error("error getting user auth: %s", err)
-				} else if user == nil {
+				} else if user == nil || token.Expired(time.Now()) {
 					c.WriteBytes(bmUserNotFound)
 				} else {
+					g.OwningSet.sessions.track(token.Family, c)
 					if !g.tryReconnectConn(c, user) {
 						g.addGameClient(c, user)
 					}
@@ -819,6 +1139,22 @@ func (g *TriviaGame) removeParticipantFromList(client *TriviaGameClient) {
 	}
 }
 
+// questionReadTime returns the amount of time that players are given to read a question
+// before the answer countdown starts. This includes the time for animating the question.
+func questionReadTime(q *trivia.Question) time.Duration {
+	// extra time is time for reading after the animations
+	wordsInPrompt := countWords(q.Prompt)
+	for _, choice := range q.Choices {
+		wordsInPrompt += countWords(choice)
+	}
+
+	extraTime := (time.Duration(wordsInPrompt) * time.Second / time.Duration(wordsPerSecond))
+	if extraTime > maxQuestionReadTime {
+		extraTime = maxQuestionReadTime
+	}
+	return questionAnimationTime + extraTime
+}
+
 // countWords counds the number of words in a string.
 func countWords(s string) int {
 	words := 0
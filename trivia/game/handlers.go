@@ -2,9 +2,13 @@ package game
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/api"
+	"github.com/expixel/actual-trivia-server/trivia/null"
 
 	"github.com/expixel/actual-trivia-server/trivia/game/message"
 
@@ -22,8 +26,20 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// UpgradeConn upgrades an HTTP request to a websocket connection that can be added to a game.
+func UpgradeConn(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	return upgrader.Upgrade(w, r, nil)
+}
+
+// maxScheduleAhead is how far in advance a game can be scheduled.
+const maxScheduleAhead = 30 * 24 * time.Hour
+
 type handler struct {
-	games *TriviaGamesSet
+	games          *TriviaGamesSet
+	scheduledGames trivia.ScheduledGameService
+	matchmaker     *Matchmaker
+	results        trivia.GameResultService
+	clock          Clock
 }
 
 func (h *handler) enterGame(w http.ResponseWriter, r *http.Request) {
@@ -43,10 +59,377 @@ func (h *handler) enterGame(w http.ResponseWriter, r *http.Request) {
 	h.games.AddRawConnToGame(rawConn, gameID)
 }
 
+func (h *handler) enterMatchmaking(w http.ResponseWriter, r *http.Request) {
+	rawConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("error occurred while upgrading to ws conn: %s", err)
+		return
+	}
+
+	conn := NewWSConn(rawConn, nil)
+	go conn.StartReadLoop()
+	go h.matchmaker.Serve(conn)
+}
+
+func (h *handler) createChallenge(w http.ResponseWriter, r *http.Request) {
+	host, err := api.RequireRequestUser(w, r, h.games.tokenService)
+	if err != nil {
+		return
+	}
+
+	type createChallengeBody struct {
+		QuestionCount int `json:"questionCount"`
+		AnswerSeconds int `json:"answerSeconds"`
+		MaxPlayers    int `json:"maxPlayers"`
+		DeadlineHours int `json:"deadlineHours"`
+	}
+
+	body := createChallengeBody{
+		QuestionCount: 10,
+		AnswerSeconds: 10,
+		MaxPlayers:    2,
+		DeadlineHours: 24,
+	}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+
+	if body.QuestionCount < 1 || body.QuestionCount > 50 {
+		api.Error(w, "Question count must be from 1 to 50.", http.StatusBadRequest)
+		return
+	}
+	if body.AnswerSeconds < 5 || body.AnswerSeconds > 60 {
+		api.Error(w, "Answer time must be from 5 to 60 seconds.", http.StatusBadRequest)
+		return
+	}
+	if body.MaxPlayers < 2 || body.MaxPlayers > 100 {
+		api.Error(w, "Max players must be from 2 to 100.", http.StatusBadRequest)
+		return
+	}
+	if body.DeadlineHours < 1 || body.DeadlineHours > 7*24 {
+		api.Error(w, "Deadline must be from 1 to 168 hours.", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := h.games.CreateChallenge(host, &TriviaGameOptions{
+		MinParticipants:        1,
+		MaxParticipants:        body.MaxPlayers,
+		QuestionCount:          body.QuestionCount,
+		QuestionAnswerDuration: time.Duration(body.AnswerSeconds) * time.Second,
+	}, h.clock.Now().Add(time.Duration(body.DeadlineHours)*time.Hour))
+	if err != nil {
+		logger.Error("error occurred while creating challenge: %s", err)
+		api.Error(w, "Unknown error occurred while creating challenge.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := newChallengeResponse(challenge)
+	api.Response(w, &resp, http.StatusOK)
+}
+
+func (h *handler) challenge(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	challenge := h.games.ChallengeByID(vars["id"])
+	if challenge == nil {
+		api.Error(w, "No challenge with the given ID.", http.StatusNotFound)
+		return
+	}
+
+	resp := newChallengeResponse(challenge)
+	api.Response(w, &resp, http.StatusOK)
+}
+
+func (h *handler) enterChallenge(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	rawConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("error occurred while upgrading to ws conn: %s", err)
+		return
+	}
+
+	h.games.AddRawConnToChallenge(rawConn, vars["id"])
+}
+
+func newChallengeResponse(challenge *ChallengeGame) challengeResponse {
+	var host string
+	if challenge.Host != nil {
+		host = challenge.Host.Username
+	}
+
+	joined, finished := challenge.PlayerCounts()
+	return challengeResponse{
+		ID:              challenge.ID,
+		Host:            host,
+		QuestionCount:   challenge.QuestionCount(),
+		AnswerMillis:    int64(challenge.options.QuestionAnswerDuration / time.Millisecond),
+		MaxPlayers:      challenge.options.MaxParticipants,
+		PlayersJoined:   joined,
+		PlayersFinished: finished,
+		CreatedAt:       challenge.CreatedAt.Unix(),
+		Deadline:        challenge.Deadline.Unix(),
+		Revealed:        challenge.Revealed(),
+		Results:         challenge.Results(),
+	}
+}
+
+func (h *handler) schedule(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := api.RequirePagination(w, r, 20, 100)
+	if !ok {
+		return
+	}
+
+	// games that have already started stay on the calendar until they can no longer be joined.
+	games, err := h.scheduledGames.UpcomingScheduledGames(h.clock.Now().Add(-scheduledMissedAfter), limit, offset)
+	if err != nil {
+		logger.Error("error occurred while getting scheduled games: %s", err)
+		api.Error(w, "Unknown error occurred while getting scheduled games.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := scheduleResponse{Games: make([]scheduledGameResponse, len(games))}
+	for idx := range games {
+		resp.Games[idx] = newScheduledGameResponse(&games[idx])
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
+func (h *handler) createScheduledGame(w http.ResponseWriter, r *http.Request) {
+	host, err := api.RequireRequestUser(w, r, h.games.tokenService)
+	if err != nil {
+		return
+	}
+
+	if host.Guest {
+		api.Error(w, "Guests cannot schedule games.", http.StatusForbidden)
+		return
+	}
+
+	type createScheduledGameBody struct {
+		Title    string `json:"title"`
+		StartsAt int64  `json:"startsAt"`
+		trivia.ScheduledGameOptions
+	}
+
+	body := createScheduledGameBody{
+		ScheduledGameOptions: trivia.ScheduledGameOptions{
+			MinParticipants: 2,
+			MaxParticipants: 50,
+			QuestionCount:   10,
+			AnswerSeconds:   10,
+		},
+	}
+	if err := api.RequireJSONBody(w, r, &body); err != nil {
+		return
+	}
+
+	body.Title = strings.TrimSpace(body.Title)
+	if len(body.Title) < 1 || len(body.Title) > 128 {
+		api.Error(w, "Title must be from 1 to 128 characters long.", http.StatusBadRequest)
+		return
+	}
+
+	startsAt := time.Unix(body.StartsAt, 0)
+	if !validScheduleStart(startsAt, h.clock.Now()) {
+		api.Error(w, "Start time must be from 1 minute to 30 days from now.", http.StatusBadRequest)
+		return
+	}
+	if body.MaxParticipants < 2 || body.MaxParticipants > 100 {
+		api.Error(w, "Max participants must be from 2 to 100.", http.StatusBadRequest)
+		return
+	}
+	if body.MinParticipants < 1 || body.MinParticipants > body.MaxParticipants {
+		api.Error(w, "Min participants must be from 1 to max participants.", http.StatusBadRequest)
+		return
+	}
+	if body.QuestionCount < 1 || body.QuestionCount > 50 {
+		api.Error(w, "Question count must be from 1 to 50.", http.StatusBadRequest)
+		return
+	}
+	if body.AnswerSeconds < 5 || body.AnswerSeconds > 60 {
+		api.Error(w, "Answer time must be from 5 to 60 seconds.", http.StatusBadRequest)
+		return
+	}
+
+	scheduled := trivia.ScheduledGame{
+		Title:        body.Title,
+		StartsAt:     startsAt,
+		Options:      body.ScheduledGameOptions,
+		HostUserID:   null.NewInt64(host.ID),
+		HostUsername: null.NewString(host.Username),
+	}
+	if err := h.scheduledGames.CreateScheduledGame(&scheduled); err != nil {
+		logger.Error("error occurred while creating scheduled game: %s", err)
+		api.Error(w, "Unknown error occurred while scheduling game.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := newScheduledGameResponse(&scheduled)
+	api.Response(w, &resp, http.StatusOK)
+}
+
+// validScheduleStart returns true if a game can be scheduled to start at startsAt.
+func validScheduleStart(startsAt time.Time, now time.Time) bool {
+	return !startsAt.Before(now.Add(time.Minute)) && !startsAt.After(now.Add(maxScheduleAhead))
+}
+
+// scheduledLobbyOpen returns true if the lobby for a scheduled game has been opened or should
+// have been by now.
+func scheduledLobbyOpen(scheduled *trivia.ScheduledGame, now time.Time) bool {
+	return scheduled.GameID.Valid || now.After(scheduled.StartsAt.Add(-ScheduledLobbyLead))
+}
+
+// requireScheduledGame gets the scheduled game using the ID in the request's path.
+// If there is no such game an error is written to the response and nil is returned.
+func (h *handler) requireScheduledGame(w http.ResponseWriter, r *http.Request) *trivia.ScheduledGame {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		api.Error(w, "No scheduled game with the given ID.", http.StatusNotFound)
+		return nil
+	}
+
+	scheduled, err := h.scheduledGames.ScheduledGameByID(id)
+	if err != nil {
+		logger.Error("error occurred while getting scheduled game: %s", err)
+		api.Error(w, "Unknown error occurred while getting scheduled game.", http.StatusInternalServerError)
+		return nil
+	}
+
+	if scheduled == nil {
+		api.Error(w, "No scheduled game with the given ID.", http.StatusNotFound)
+	}
+	return scheduled
+}
+
+func (h *handler) rsvp(w http.ResponseWriter, r *http.Request) {
+	user, err := api.RequireRequestUser(w, r, h.games.tokenService)
+	if err != nil {
+		return
+	}
+
+	if user.Guest {
+		api.Error(w, "Guests cannot RSVP to scheduled games.", http.StatusForbidden)
+		return
+	}
+
+	scheduled := h.requireScheduledGame(w, r)
+	if scheduled == nil {
+		return
+	}
+
+	// once the lobby is open the reserved seats have already been handed out.
+	if scheduledLobbyOpen(scheduled, h.clock.Now()) {
+		api.Error(w, "The lobby for this game is already open.", http.StatusConflict)
+		return
+	}
+
+	added, err := h.scheduledGames.AddRSVP(scheduled.ID, user.ID, scheduled.Options.MaxParticipants)
+	if err != nil {
+		logger.Error("error occurred while adding RSVP: %s", err)
+		api.Error(w, "Unknown error occurred while adding RSVP.", http.StatusInternalServerError)
+		return
+	}
+
+	if added {
+		scheduled.RSVPCount++
+	} else if scheduled.RSVPCount >= scheduled.Options.MaxParticipants {
+		api.Error(w, "This game is full.", http.StatusConflict)
+		return
+	}
+
+	resp := newScheduledGameResponse(scheduled)
+	api.Response(w, &resp, http.StatusOK)
+}
+
+func (h *handler) removeRSVP(w http.ResponseWriter, r *http.Request) {
+	user, err := api.RequireRequestUser(w, r, h.games.tokenService)
+	if err != nil {
+		return
+	}
+
+	scheduled := h.requireScheduledGame(w, r)
+	if scheduled == nil {
+		return
+	}
+
+	removed, err := h.scheduledGames.RemoveRSVP(scheduled.ID, user.ID)
+	if err != nil {
+		logger.Error("error occurred while removing RSVP: %s", err)
+		api.Error(w, "Unknown error occurred while removing RSVP.", http.StatusInternalServerError)
+		return
+	}
+
+	if removed {
+		scheduled.RSVPCount--
+	}
+
+	resp := newScheduledGameResponse(scheduled)
+	api.Response(w, &resp, http.StatusOK)
+}
+
+func newScheduledGameResponse(scheduled *trivia.ScheduledGame) scheduledGameResponse {
+	return scheduledGameResponse{
+		ID:              scheduled.ID,
+		Title:           scheduled.Title,
+		StartsAt:        scheduled.StartsAt.Unix(),
+		LobbyOpensAt:    scheduled.StartsAt.Add(-ScheduledLobbyLead).Unix(),
+		Host:            scheduled.HostUsername,
+		GameID:          scheduled.GameID,
+		RSVPCount:       scheduled.RSVPCount,
+		MinParticipants: scheduled.Options.MinParticipants,
+		MaxParticipants: scheduled.Options.MaxParticipants,
+		QuestionCount:   scheduled.Options.QuestionCount,
+		AnswerSeconds:   scheduled.Options.AnswerSeconds,
+	}
+}
+
+func (h *handler) gameResult(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		api.Error(w, "No game results with the given ID.", http.StatusNotFound)
+		return
+	}
+
+	result, err := h.results.GameResultByID(id)
+	if err != nil {
+		logger.Error("error occurred while getting game result: %s", err)
+		api.Error(w, "Unknown error occurred while getting game results.", http.StatusInternalServerError)
+		return
+	}
+
+	if result == nil {
+		api.Error(w, "No game results with the given ID.", http.StatusNotFound)
+		return
+	}
+
+	resp := gameResultResponse{
+		ID:            result.ID,
+		GameID:        result.GameID,
+		QuestionCount: result.QuestionCount,
+		FinishedAt:    result.FinishedAt.Unix(),
+		Participants:  make([]gameParticipantResponse, len(result.Participants)),
+	}
+	for idx, p := range result.Participants {
+		resp.Participants[idx] = gameParticipantResponse{
+			Username:       p.Username,
+			Guest:          p.Guest,
+			Placement:      p.Placement,
+			Score:          p.Score,
+			CorrectAnswers: p.CorrectAnswers,
+		}
+	}
+	api.Response(w, &resp, http.StatusOK)
+}
+
 // NewHandler creates a new handler for the game endpoint/
-func NewHandler(tokenService trivia.AuthTokenService, questionService trivia.QuestionService) http.Handler {
+func NewHandler(games *TriviaGamesSet, scheduledGames trivia.ScheduledGameService, matchmaker *Matchmaker,
+	results trivia.GameResultService, clock Clock) http.Handler {
 	h := handler{
-		games: NewGameSet(tokenService, questionService),
+		games:          games,
+		scheduledGames: scheduledGames,
+		matchmaker:     matchmaker,
+		results:        results,
+		clock:          clock,
 	}
 
 	// #TODO remove this test code once I have a way to create games from
@@ -57,6 +440,10 @@ func NewHandler(tokenService trivia.AuthTokenService, questionService trivia.Que
 		GameStartDelay:         10 * time.Second,
 		QuestionCount:          10,
 		QuestionAnswerDuration: 10 * time.Second,
+		FiftyFiftyLifelines:    1,
+		DoublePointsLifelines:  1,
+		SkipLifelines:          1,
+		Clock:                  h.clock,
 	})
 
 	h.games.CreateGame("test-2", &TriviaGameOptions{
@@ -65,9 +452,22 @@ func NewHandler(tokenService trivia.AuthTokenService, questionService trivia.Que
 		GameStartDelay:         10 * time.Second,
 		QuestionCount:          10,
 		QuestionAnswerDuration: 10 * time.Second,
+		FiftyFiftyLifelines:    1,
+		DoublePointsLifelines:  1,
+		SkipLifelines:          1,
+		Clock:                  h.clock,
 	})
 
 	r := mux.NewRouter()
 	r.HandleFunc("/v1/game/ws/{id}", h.enterGame).Methods("GET")
-	return r
+	r.HandleFunc("/v1/game/matchmaking/ws", h.enterMatchmaking).Methods("GET")
+	r.HandleFunc("/v1/game/challenge", h.createChallenge).Methods("POST")
+	r.HandleFunc("/v1/game/challenge/{id}", h.challenge).Methods("GET")
+	r.HandleFunc("/v1/game/challenge/ws/{id}", h.enterChallenge).Methods("GET")
+	r.HandleFunc("/v1/game/schedule", h.schedule).Methods("GET")
+	r.HandleFunc("/v1/game/schedule", h.createScheduledGame).Methods("POST")
+	r.HandleFunc("/v1/game/schedule/{id}/rsvp", h.rsvp).Methods("POST")
+	r.HandleFunc("/v1/game/schedule/{id}/rsvp", h.removeRSVP).Methods("DELETE")
+	r.HandleFunc("/v1/game/results/{id}", h.gameResult).Methods("GET")
+	return api.WrapAPIHandler(r)
 }
@@ -0,0 +1,182 @@
+package game
+
+import (
+	"math/rand"
+
+	"github.com/expixel/actual-trivia-server/trivia/game/message"
+)
+
+// lifeline names used when a lifeline is rejected.
+const (
+	lifelineFiftyFifty   = "fifty-fifty"
+	lifelineDoublePoints = "double-points"
+	lifelineSkip         = "skip"
+)
+
+// fiftyFiftyRemoveCount is the number of wrong choices removed by a 50/50 lifeline.
+const fiftyFiftyRemoveCount = 2
+
+// canUseLifeline checks that a client is allowed to use a lifeline on the given question right now.
+// It returns an empty string if the lifeline can be used, or the reason it can't be.
+func (g *TriviaGame) canUseLifeline(client *TriviaGameClient, questionIndex int, remaining int) string {
+	if g.currentState != gameStateStartQuestionCountdown && g.currentState != gameStateQuestionCountdown {
+		return "Lifelines can only be used while a question is being answered."
+	}
+
+	if questionIndex != g.currentQuestion || questionIndex != client.CurrentQuestion {
+		return "Lifelines can only be used on the current question."
+	}
+
+	if remaining < 1 {
+		return "No uses of this lifeline remaining."
+	}
+
+	if client.SelectedAnswer >= 0 {
+		return "An answer has already been selected for this question."
+	}
+
+	if client.Skipped {
+		return "This question has already been skipped."
+	}
+
+	return ""
+}
+
+func (g *TriviaGame) rejectLifeline(client *TriviaGameClient, lifeline string, questionIndex int, reason string) {
+	g.sendMessage(client, &message.LifelineRejected{
+		Lifeline:      lifeline,
+		QuestionIndex: questionIndex,
+		Reason:        reason,
+	})
+}
+
+// useFiftyFifty removes two wrong choices from the current question for a single client.
+func (g *TriviaGame) useFiftyFifty(client *TriviaGameClient, questionIndex int) {
+	if reason := g.canUseLifeline(client, questionIndex, client.Lifelines.FiftyFifty); reason != "" {
+		g.rejectLifeline(client, lifelineFiftyFifty, questionIndex, reason)
+		return
+	}
+
+	if client.RemovedChoices != nil {
+		g.rejectLifeline(client, lifelineFiftyFifty, questionIndex, "A 50/50 has already been used on this question.")
+		return
+	}
+
+	q := g.questions[g.currentQuestion]
+	removed := pickFiftyFiftyRemovals(len(q.Choices), q.CorrectChoice)
+	if len(removed) < 1 {
+		g.rejectLifeline(client, lifelineFiftyFifty, questionIndex, "This question does not have enough choices for a 50/50.")
+		return
+	}
+
+	client.Lifelines.FiftyFifty--
+	client.RemovedChoices = removed
+	g.sendMessage(client, &message.FiftyFifty{
+		QuestionIndex:  questionIndex,
+		RemovedChoices: removed,
+		Remaining:      client.Lifelines.FiftyFifty,
+	})
+}
+
+// useDoublePoints doubles the points a client will get for correctly answering the current question.
+func (g *TriviaGame) useDoublePoints(client *TriviaGameClient, questionIndex int) {
+	if reason := g.canUseLifeline(client, questionIndex, client.Lifelines.DoublePoints); reason != "" {
+		g.rejectLifeline(client, lifelineDoublePoints, questionIndex, reason)
+		return
+	}
+
+	if client.DoublePoints {
+		g.rejectLifeline(client, lifelineDoublePoints, questionIndex, "Double points has already been used on this question.")
+		return
+	}
+
+	client.Lifelines.DoublePoints--
+	client.DoublePoints = true
+	g.sendMessage(client, &message.DoublePoints{
+		QuestionIndex: questionIndex,
+		Remaining:     client.Lifelines.DoublePoints,
+	})
+}
+
+// useSkip has a client sit out the current question.
+func (g *TriviaGame) useSkip(client *TriviaGameClient, questionIndex int) {
+	if reason := g.canUseLifeline(client, questionIndex, client.Lifelines.Skip); reason != "" {
+		g.rejectLifeline(client, lifelineSkip, questionIndex, reason)
+		return
+	}
+
+	client.Lifelines.Skip--
+	client.Skipped = true
+	g.sendMessage(client, &message.SkipQuestion{
+		QuestionIndex: questionIndex,
+		Remaining:     client.Lifelines.Skip,
+	})
+}
+
+// sendLifelines sends each client the number of lifelines that they have remaining.
+func (g *TriviaGame) sendLifelines() {
+	for _, client := range g.clients {
+		g.sendMessage(client, &client.Lifelines)
+	}
+}
+
+// appendQuestionLifelines appends the lifelines that a client has used on the current question
+// to a multi message so that they can be restored after a reconnect.
+func (g *TriviaGame) appendQuestionLifelines(multi *message.Multi, client *TriviaGameClient) {
+	if client.RemovedChoices != nil {
+		multi.Append(&message.FiftyFifty{
+			QuestionIndex:  g.currentQuestion,
+			RemovedChoices: client.RemovedChoices,
+			Remaining:      client.Lifelines.FiftyFifty,
+		})
+	}
+
+	if client.DoublePoints {
+		multi.Append(&message.DoublePoints{
+			QuestionIndex: g.currentQuestion,
+			Remaining:     client.Lifelines.DoublePoints,
+		})
+	}
+
+	if client.Skipped {
+		multi.Append(&message.SkipQuestion{
+			QuestionIndex: g.currentQuestion,
+			Remaining:     client.Lifelines.Skip,
+		})
+	}
+}
+
+// isChoiceRemoved returns true if a choice has been removed for a client by a 50/50.
+func isChoiceRemoved(client *TriviaGameClient, choice int) bool {
+	for _, removed := range client.RemovedChoices {
+		if removed == choice {
+			return true
+		}
+	}
+	return false
+}
+
+// pickFiftyFiftyRemovals picks the wrong choices that should be removed by a 50/50 lifeline.
+// At least one wrong choice is always left so that questions with fewer than four choices
+// don't just give away the answer. This returns an empty slice if nothing can be removed.
+func pickFiftyFiftyRemovals(choiceCount int, correctChoice int) []int {
+	removeCount := fiftyFiftyRemoveCount
+	if choiceCount-2 < removeCount {
+		removeCount = choiceCount - 2
+	}
+	if removeCount < 1 {
+		return []int{}
+	}
+
+	removed := make([]int, 0, removeCount)
+	for _, choice := range rand.Perm(choiceCount) {
+		if choice == correctChoice {
+			continue
+		}
+		removed = append(removed, choice)
+		if len(removed) >= removeCount {
+			break
+		}
+	}
+	return removed
+}
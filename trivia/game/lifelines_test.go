@@ -0,0 +1,35 @@
+package game
+
+import "testing"
+
+func TestFiftyFiftyRemovals(t *testing.T) {
+	for i := 0; i < 64; i++ {
+		removed := pickFiftyFiftyRemovals(4, 2)
+		if len(removed) != 2 {
+			t.Fatalf("expected 2 choices to be removed from a 4 choice question but %d were removed", len(removed))
+		}
+		if removed[0] == removed[1] {
+			t.Fatalf("the same choice was removed twice: %v", removed)
+		}
+		for _, choice := range removed {
+			if choice == 2 {
+				t.Fatalf("the correct choice was removed: %v", removed)
+			}
+			if choice < 0 || choice >= 4 {
+				t.Fatalf("removed choice is out of range: %v", removed)
+			}
+		}
+	}
+}
+
+func TestFiftyFiftyRemovalsFewChoices(t *testing.T) {
+	removed := pickFiftyFiftyRemovals(3, 0)
+	if len(removed) != 1 || removed[0] == 0 {
+		t.Errorf("expected a single wrong choice to be removed from a 3 choice question: %v", removed)
+	}
+
+	removed = pickFiftyFiftyRemovals(2, 1)
+	if len(removed) != 0 {
+		t.Errorf("expected no choices to be removed from a 2 choice question: %v", removed)
+	}
+}
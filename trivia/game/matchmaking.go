@@ -0,0 +1,517 @@
+package game
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/game/message"
+	"github.com/expixel/actual-trivia-server/trivia/periodic"
+)
+
+// DefaultMatchmakingRating is the rating used for players that do not have a rating yet.
+const DefaultMatchmakingRating = 1500.0
+
+// defaultMatchmakingLanguage is the language used for players that do not ask for one.
+const defaultMatchmakingLanguage = "en"
+
+// maxQueueCategories is the maximum number of categories a player can ask for when entering the queue.
+const maxQueueCategories = 16
+
+// matchmakerInterval is how often the matchmaker tries to match players and sends queue statuses.
+const matchmakerInterval = time.Second
+
+// matchJoinTime is how long matched players have to connect to their game before it starts.
+const matchJoinTime = 15 * time.Second
+
+// averageWaitWeight is the weight given to the wait of each newly matched player when
+// updating the average wait used for estimating wait times.
+const averageWaitWeight = 0.2
+
+// A RatingFunc looks up the rating used to match a user with players of a similar skill.
+type RatingFunc func(user *trivia.User) (float64, error)
+
+// MatchmakingOptions are the options used by a Matchmaker to group players into games.
+type MatchmakingOptions struct {
+	// MinPlayers is the smallest number of players that a game will be created with once
+	// the oldest player in the group has waited for MaxWait.
+	MinPlayers int
+
+	// MaxPlayers is the number of players that are put into a game as soon as that many
+	// compatible players are waiting.
+	MaxPlayers int
+
+	// MaxWait is how long a player waits for a full game before a game is created with
+	// at least MinPlayers players.
+	MaxWait time.Duration
+
+	// InitialRatingWindow is how far apart the ratings of two players can be for them to
+	// be matched when they first enter the queue.
+	InitialRatingWindow float64
+
+	// RatingWindowGrowth is how much a player's rating window widens for every second that
+	// they spend in the queue.
+	RatingWindowGrowth float64
+
+	// MaxRatingWindow is the widest that a player's rating window can get.
+	MaxRatingWindow float64
+
+	// RequireVerifiedEmail keeps users that haven't verified their email address out of the queue.
+	// Guests can still play since their games are never rated. Other games can still be joined, so
+	// the same setting has to be given to the rating updater to keep those users' games unrated.
+	RequireVerifiedEmail bool
+
+	// Game contains the options used for matched games. The participant limits and the
+	// start time are set by the matchmaker.
+	Game TriviaGameOptions
+}
+
+// DefaultMatchmakingOptions returns the options used for the public matchmaking queue.
+func DefaultMatchmakingOptions() MatchmakingOptions {
+	return MatchmakingOptions{
+		MinPlayers:          2,
+		MaxPlayers:          8,
+		MaxWait:             30 * time.Second,
+		InitialRatingWindow: 100,
+		RatingWindowGrowth:  10,
+		MaxRatingWindow:     1000,
+		Game: TriviaGameOptions{
+			GameStartDelay:         5 * time.Second,
+			QuestionCount:          10,
+			QuestionAnswerDuration: 10 * time.Second,
+			FiftyFiftyLifelines:    1,
+			DoublePointsLifelines:  1,
+			SkipLifelines:          1,
+		},
+	}
+}
+
+// queuedPlayer is a player waiting in the matchmaking queue.
+type queuedPlayer struct {
+	User       *trivia.User
+	Rating     float64
+	Categories []string
+	Language   string
+	EnteredAt  time.Time
+
+	// matchChan receives the ID of the game the player was matched into. An empty
+	// ID means that the player was removed from the queue without being matched.
+	matchChan chan string
+
+	// statusChan receives the latest queue status of the player. Only the most recent
+	// status is kept if the player's goroutine falls behind.
+	statusChan chan *message.QueueStatus
+}
+
+// playerMatch is a group of players that are put into a game together.
+type playerMatch struct {
+	Players    []*queuedPlayer
+	Categories []string
+}
+
+// Matchmaker groups players waiting in a queue by rating, preferred categories, and language and
+// creates games for them in a TriviaGamesSet. The rating window of each player widens the longer
+// they wait so that nobody is stuck in the queue forever.
+type Matchmaker struct {
+	games        gameCreator
+	tokenService trivia.AuthTokenService
+	sessions     *sessionConns
+	ratings      RatingFunc
+	clock        Clock
+	options      MatchmakingOptions
+
+	// lock should be held while accessing queue or averageWait.
+	lock *sync.Mutex
+
+	// queue contains the players waiting for a match ordered by the time they entered the queue.
+	queue []*queuedPlayer
+
+	// averageWait is a moving average of how long matched players waited in the queue.
+	// This is zero until the first players are matched.
+	averageWait time.Duration
+
+	runner *periodic.Runner
+}
+
+// NewMatchmaker creates a new matchmaker that creates games in the given set. If ratings is nil
+// every player is given the DefaultMatchmakingRating.
+func NewMatchmaker(games *TriviaGamesSet, ratings RatingFunc, clock Clock, options MatchmakingOptions) *Matchmaker {
+	m := newMatchmaker(games, games.tokenService, ratings, clock, options)
+	m.sessions = games.sessions
+	return m
+}
+
+func newMatchmaker(games gameCreator, tokenService trivia.AuthTokenService, ratings RatingFunc, clock Clock, options MatchmakingOptions) *Matchmaker {
+	m := &Matchmaker{
+		games:        games,
+		tokenService: tokenService,
+		ratings:      ratings,
+		clock:        clock,
+		options:      options,
+		lock:         &sync.Mutex{},
+		queue:        make([]*queuedPlayer, 0),
+	}
+	m.runner = periodic.NewRunner(matchmakerInterval, false, func() { m.Tick() })
+	return m
+}
+
+// Start starts matching players on its own goroutine.
+func (m *Matchmaker) Start() {
+	m.runner.Start()
+}
+
+// Stop stops the matchmaker and removes every player from the queue.
+func (m *Matchmaker) Stop() {
+	m.runner.Stop()
+
+	m.lock.Lock()
+	for _, player := range m.queue {
+		player.matchChan <- ""
+	}
+	m.queue = m.queue[:0]
+	m.lock.Unlock()
+}
+
+// Serve runs a single player's connection to the matchmaking queue. This blocks until the
+// player is matched or leaves the queue so it should be run on its own goroutine.
+func (m *Matchmaker) Serve(conn *Conn) {
+	defer conn.Close()
+
+	conn.WriteBytes(message.MustEncodeBytes(&message.ClientInfoRequest{}))
+	user := waitForAuth(conn, m.tokenService, m.sessions)
+	if user == nil {
+		return
+	}
+	if m.options.RequireVerifiedEmail && !user.Guest && !user.EmailVerified {
+		writeConnMessage(conn, &message.QueueClosed{Reason: "Verify your email address to play ranked games."})
+		return
+	}
+
+	enter := m.waitForEnterQueue(conn)
+	if enter == nil {
+		return
+	}
+
+	rating := DefaultMatchmakingRating
+	if m.ratings != nil {
+		var err error
+		if rating, err = m.ratings(user); err != nil {
+			logger.Error("error occurred while getting matchmaking rating for %s: %s", user.Username, err)
+			writeConnMessage(conn, &message.QueueClosed{Reason: "Unknown error occurred while entering the queue."})
+			return
+		}
+	}
+
+	player := m.enqueue(user, rating, enter.Categories, enter.Language)
+	logger.Debug("matchmaking: %s entered the queue with rating %.0f", user.Username, rating)
+
+	for {
+		select {
+		case msg := <-conn.recvChan:
+			switch msg.(type) {
+			case *message.SocketClosed:
+				m.dequeue(player)
+				return
+			case *message.LeaveQueue:
+				if m.dequeue(player) {
+					writeConnMessage(conn, &message.QueueClosed{Reason: "Left the queue."})
+					return
+				}
+			}
+		case status := <-player.statusChan:
+			writeConnMessage(conn, status)
+		case gameID := <-player.matchChan:
+			if gameID == "" {
+				writeConnMessage(conn, &message.QueueClosed{Reason: "Removed from the queue."})
+			} else {
+				writeConnMessage(conn, &message.MatchFound{GameID: gameID})
+			}
+			return
+		}
+	}
+}
+
+// waitForEnterQueue waits for a connection to send an EnterQueue message and returns it, or
+// nil if the connection was closed or did not send one in time.
+func (m *Matchmaker) waitForEnterQueue(conn *Conn) *message.EnterQueue {
+	timeout := time.NewTimer(authTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case msg := <-conn.recvChan:
+			switch msg := msg.(type) {
+			case *message.EnterQueue:
+				if len(msg.Categories) > maxQueueCategories {
+					writeConnMessage(conn, &message.QueueClosed{Reason: "Too many categories."})
+					return nil
+				}
+				return msg
+			case *message.SocketClosed:
+				return nil
+			}
+		case <-timeout.C:
+			return nil
+		}
+	}
+}
+
+// enqueue adds a user to the end of the queue. If the user is already waiting in the
+// queue from another connection that connection is removed from the queue.
+func (m *Matchmaker) enqueue(user *trivia.User, rating float64, categories []string, language string) *queuedPlayer {
+	player := &queuedPlayer{
+		User:       user,
+		Rating:     rating,
+		Categories: normalizeCategories(categories),
+		Language:   strings.ToLower(strings.TrimSpace(language)),
+		EnteredAt:  m.clock.Now(),
+		matchChan:  make(chan string, 1),
+		statusChan: make(chan *message.QueueStatus, 1),
+	}
+	if player.Language == "" {
+		player.Language = defaultMatchmakingLanguage
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for idx, queued := range m.queue {
+		if queued.User.ID == user.ID {
+			m.queue = append(m.queue[:idx], m.queue[idx+1:]...)
+			queued.matchChan <- ""
+			break
+		}
+	}
+	m.queue = append(m.queue, player)
+	return player
+}
+
+// dequeue removes a player from the queue and returns true if they were still waiting.
+func (m *Matchmaker) dequeue(player *queuedPlayer) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for idx, queued := range m.queue {
+		if queued == player {
+			m.queue = append(m.queue[:idx], m.queue[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Tick creates games for every group of players that can be matched, sends the players left
+// in the queue their status, and returns the number of games that were created.
+func (m *Matchmaker) Tick() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.clock.Now()
+	matches := m.findMatches(now)
+
+	created := 0
+	for _, match := range matches {
+		if m.createMatchGame(match, now) {
+			created++
+		}
+	}
+
+	m.sendQueueStatuses(now)
+	return created
+}
+
+// createMatchGame creates the game for a group of matched players with seats reserved for each of
+// them and removes them from the queue. If the game can't be created the players stay in the queue.
+func (m *Matchmaker) createMatchGame(match *playerMatch, now time.Time) bool {
+	gameID, err := generateGameID()
+	if err != nil {
+		logger.Error("error occurred while generating matchmaking game ID: %s", err)
+		return false
+	}
+	gameID = "match-" + gameID
+
+	reserved := make([]int64, len(match.Players))
+	for idx, player := range match.Players {
+		reserved[idx] = player.User.ID
+	}
+
+	gameOptions := m.options.Game
+	gameOptions.MinParticipants = m.options.MinPlayers
+	if gameOptions.MinParticipants > len(match.Players) {
+		gameOptions.MinParticipants = len(match.Players)
+	}
+	gameOptions.MaxParticipants = len(match.Players)
+	gameOptions.StartAt = now.Add(matchJoinTime)
+	gameOptions.ReservedSeats = reserved
+	gameOptions.Categories = match.Categories
+	gameOptions.Unlisted = true
+	gameOptions.RemoveOnFinish = true
+	gameOptions.Clock = m.clock
+
+	if err := m.games.CreateGame(gameID, &gameOptions); err != nil {
+		logger.Error("error occurred while creating matchmaking game: %s", err)
+		return false
+	}
+
+	for _, player := range match.Players {
+		for idx, queued := range m.queue {
+			if queued == player {
+				m.queue = append(m.queue[:idx], m.queue[idx+1:]...)
+				break
+			}
+		}
+
+		m.recordWait(now.Sub(player.EnteredAt))
+		player.matchChan <- gameID
+	}
+
+	logger.Info("matchmaking: created game %s with %d players", gameID, len(match.Players))
+	return true
+}
+
+// findMatches finds the groups of players in the queue that should be put into games right now.
+// The players that have waited the longest get to pick their opponents first.
+func (m *Matchmaker) findMatches(now time.Time) []*playerMatch {
+	matches := make([]*playerMatch, 0)
+	matched := make(map[*queuedPlayer]bool)
+
+	for _, anchor := range m.queue {
+		if matched[anchor] {
+			continue
+		}
+
+		candidates := make([]*queuedPlayer, 0)
+		for _, other := range m.queue {
+			if other == anchor || matched[other] || other.Language != anchor.Language {
+				continue
+			}
+
+			window := math.Max(m.ratingWindow(anchor, now), m.ratingWindow(other, now))
+			if math.Abs(anchor.Rating-other.Rating) <= window {
+				candidates = append(candidates, other)
+			}
+		}
+
+		// the closest ratings are matched first.
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return math.Abs(anchor.Rating-candidates[i].Rating) < math.Abs(anchor.Rating-candidates[j].Rating)
+		})
+
+		match := &playerMatch{Players: []*queuedPlayer{anchor}, Categories: anchor.Categories}
+		for _, candidate := range candidates {
+			if len(match.Players) >= m.options.MaxPlayers {
+				break
+			}
+
+			if shared, ok := sharedCategories(match.Categories, candidate.Categories); ok {
+				match.Players = append(match.Players, candidate)
+				match.Categories = shared
+			}
+		}
+
+		full := len(match.Players) >= m.options.MaxPlayers
+		waitedLongEnough := now.Sub(anchor.EnteredAt) >= m.options.MaxWait && len(match.Players) >= m.options.MinPlayers
+		if full || waitedLongEnough {
+			for _, player := range match.Players {
+				matched[player] = true
+			}
+			matches = append(matches, match)
+		}
+	}
+
+	return matches
+}
+
+// ratingWindow returns how far from a player's rating other players can be for them to be matched.
+func (m *Matchmaker) ratingWindow(player *queuedPlayer, now time.Time) float64 {
+	window := m.options.InitialRatingWindow + m.options.RatingWindowGrowth*now.Sub(player.EnteredAt).Seconds()
+	return math.Min(window, m.options.MaxRatingWindow)
+}
+
+// recordWait adds the wait of a matched player to the average used for estimating wait times.
+func (m *Matchmaker) recordWait(wait time.Duration) {
+	if m.averageWait == 0 {
+		m.averageWait = wait
+		return
+	}
+	m.averageWait = time.Duration(averageWaitWeight*float64(wait) + (1-averageWaitWeight)*float64(m.averageWait))
+}
+
+// estimatedWait returns how much longer a player that has already waited for the given
+// amount of time is expected to wait.
+func (m *Matchmaker) estimatedWait(waited time.Duration) time.Duration {
+	expected := m.averageWait
+	if expected == 0 {
+		expected = m.options.MaxWait
+	}
+
+	if remaining := expected - waited; remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// sendQueueStatuses sends every player left in the queue their position and estimated wait.
+func (m *Matchmaker) sendQueueStatuses(now time.Time) {
+	for idx, player := range m.queue {
+		status := &message.QueueStatus{
+			Position:      idx + 1,
+			QueueSize:     len(m.queue),
+			EstimatedWait: int64(m.estimatedWait(now.Sub(player.EnteredAt)) / time.Millisecond),
+			RatingWindow:  int(m.ratingWindow(player, now)),
+		}
+
+		// replace the last status if the player hasn't gotten to it yet.
+		select {
+		case <-player.statusChan:
+		default:
+		}
+		player.statusChan <- status
+	}
+}
+
+// writeConnMessage encodes and writes a message to a connection.
+func writeConnMessage(conn *Conn, msg interface{}) {
+	conn.WriteBytes(message.MustEncodeBytes(msg))
+}
+
+// normalizeCategories trims and removes duplicates from a list of categories.
+func normalizeCategories(categories []string) []string {
+	normalized := make([]string, 0, len(categories))
+	seen := make(map[string]bool)
+	for _, category := range categories {
+		category = strings.TrimSpace(category)
+		if category == "" || seen[strings.ToLower(category)] {
+			continue
+		}
+		seen[strings.ToLower(category)] = true
+		normalized = append(normalized, category)
+	}
+	return normalized
+}
+
+// sharedCategories returns the categories that two players or groups can both play. An empty
+// list of categories means that any category is fine. ok is false if there is no category
+// that both can play.
+func sharedCategories(a []string, b []string) (shared []string, ok bool) {
+	if len(a) == 0 {
+		return b, true
+	}
+	if len(b) == 0 {
+		return a, true
+	}
+
+	shared = make([]string, 0)
+	for _, categoryA := range a {
+		for _, categoryB := range b {
+			if strings.EqualFold(categoryA, categoryB) {
+				shared = append(shared, categoryA)
+				break
+			}
+		}
+	}
+	return shared, len(shared) > 0
+}
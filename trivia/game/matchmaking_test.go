@@ -0,0 +1,155 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+func newTestMatchmaker(clock Clock, minPlayers int, maxPlayers int) (*Matchmaker, *fakeGameCreator) {
+	creator := &fakeGameCreator{created: make(map[string]*TriviaGameOptions)}
+	options := DefaultMatchmakingOptions()
+	options.MinPlayers = minPlayers
+	options.MaxPlayers = maxPlayers
+	return newMatchmaker(creator, nil, nil, clock, options), creator
+}
+
+func enqueueTestPlayer(m *Matchmaker, id int64, rating float64, language string, categories ...string) *queuedPlayer {
+	return m.enqueue(&trivia.User{ID: id, Username: "user"}, rating, categories, language)
+}
+
+func matchedGameID(t *testing.T, player *queuedPlayer) string {
+	select {
+	case gameID := <-player.matchChan:
+		return gameID
+	default:
+		t.Fatalf("expected user %d to be matched", player.User.ID)
+		return ""
+	}
+}
+
+func assertNotMatched(t *testing.T, player *queuedPlayer) {
+	select {
+	case gameID := <-player.matchChan:
+		t.Fatalf("expected user %d to not be matched but they were matched into %q", player.User.ID, gameID)
+	default:
+	}
+}
+
+func TestMatchmakerMatchesFullGroups(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)}
+	m, creator := newTestMatchmaker(clock, 2, 3)
+
+	a := enqueueTestPlayer(m, 1, 1500, "en")
+	far := enqueueTestPlayer(m, 2, 1900, "en")
+	b := enqueueTestPlayer(m, 3, 1550, "en")
+	french := enqueueTestPlayer(m, 4, 1500, "fr")
+	if created := m.Tick(); created != 0 {
+		t.Fatalf("expected no games before there are enough compatible players but %d were created", created)
+	}
+
+	c := enqueueTestPlayer(m, 5, 1450, "EN ")
+	if created := m.Tick(); created != 1 {
+		t.Fatalf("expected 1 game to be created but %d were", created)
+	}
+
+	gameID := matchedGameID(t, a)
+	if matchedGameID(t, b) != gameID || matchedGameID(t, c) != gameID {
+		t.Fatalf("expected users 1, 3, and 5 to be matched into the same game")
+	}
+	assertNotMatched(t, far)
+	assertNotMatched(t, french)
+
+	opts := creator.created[gameID]
+	if opts == nil {
+		t.Fatalf("expected game %s to be created", gameID)
+	}
+	if opts.MaxParticipants != 3 || len(opts.ReservedSeats) != 3 || !opts.Unlisted {
+		t.Errorf("matched game has the wrong options: %+v", opts)
+	}
+	if len(m.queue) != 2 {
+		t.Errorf("expected 2 players to be left in the queue but there are %d", len(m.queue))
+	}
+}
+
+func TestMatchmakerWidensRatingWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)}
+	m, _ := newTestMatchmaker(clock, 2, 2)
+
+	a := enqueueTestPlayer(m, 1, 1500, "en")
+	b := enqueueTestPlayer(m, 2, 1800, "en")
+
+	m.Tick()
+	assertNotMatched(t, a)
+
+	// the window starts at 100 and grows by 10 every second so a 300 point gap takes 20 seconds.
+	clock.now = clock.now.Add(19 * time.Second)
+	m.Tick()
+	assertNotMatched(t, a)
+
+	clock.now = clock.now.Add(time.Second)
+	m.Tick()
+	if matchedGameID(t, a) != matchedGameID(t, b) {
+		t.Fatalf("expected both users to be matched into the same game")
+	}
+}
+
+func TestMatchmakerStartsSmallerGamesAfterMaxWait(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)}
+	m, creator := newTestMatchmaker(clock, 2, 8)
+
+	alone := enqueueTestPlayer(m, 1, 1500, "en", "Science")
+	a := enqueueTestPlayer(m, 2, 1500, "en", "History", "Sports")
+	b := enqueueTestPlayer(m, 3, 1500, "en", "Music", "SPORTS")
+	c := enqueueTestPlayer(m, 4, 1500, "en", "sports")
+
+	clock.now = clock.now.Add(m.options.MaxWait - time.Second)
+	m.Tick()
+	assertNotMatched(t, a)
+
+	clock.now = clock.now.Add(time.Second)
+	if created := m.Tick(); created != 1 {
+		t.Fatalf("expected 1 game to be created but %d were", created)
+	}
+
+	gameID := matchedGameID(t, a)
+	if matchedGameID(t, b) != gameID || matchedGameID(t, c) != gameID {
+		t.Fatalf("expected users 2, 3, and 4 to be matched into the same game")
+	}
+	assertNotMatched(t, alone)
+
+	opts := creator.created[gameID]
+	if len(opts.Categories) != 1 || opts.Categories[0] != "Sports" {
+		t.Errorf("expected the game to use the shared category Sports but got %v", opts.Categories)
+	}
+	if opts.MinParticipants != 2 || opts.MaxParticipants != 3 {
+		t.Errorf("expected the game to allow from 2 to 3 participants: %+v", opts)
+	}
+}
+
+func TestMatchmakerQueueStatus(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)}
+	m, _ := newTestMatchmaker(clock, 2, 8)
+
+	a := enqueueTestPlayer(m, 1, 1500, "en")
+	clock.now = clock.now.Add(10 * time.Second)
+	b := enqueueTestPlayer(m, 2, 1500, "fr")
+	m.Tick()
+
+	statusA := <-a.statusChan
+	statusB := <-b.statusChan
+	if statusA.Position != 1 || statusB.Position != 2 || statusB.QueueSize != 2 {
+		t.Errorf("unexpected queue positions: %+v %+v", statusA, statusB)
+	}
+	if statusA.EstimatedWait != int64((m.options.MaxWait-10*time.Second)/time.Millisecond) {
+		t.Errorf("unexpected estimated wait %d", statusA.EstimatedWait)
+	}
+	if statusA.RatingWindow != 200 || statusB.RatingWindow != 100 {
+		t.Errorf("unexpected rating windows %d and %d", statusA.RatingWindow, statusB.RatingWindow)
+	}
+
+	if !m.dequeue(a) || m.dequeue(a) {
+		t.Errorf("expected a player to only be dequeued once")
+	}
+}
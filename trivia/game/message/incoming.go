@@ -24,6 +24,13 @@ const (
 	tagSocketClose = IncomingMessageType("@socket-closed")
 
 	tagSelectAnswer = IncomingMessageType("select-answer")
+
+	tagUseFiftyFifty   = IncomingMessageType("use-fifty-fifty")
+	tagUseDoublePoints = IncomingMessageType("use-double-points")
+	tagUseSkip         = IncomingMessageType("use-skip")
+
+	tagEnterQueue = IncomingMessageType("mm-enter")
+	tagLeaveQueue = IncomingMessageType("mm-leave")
 )
 
 // ClientAuth is a message carrying the client auth token.
@@ -54,6 +61,39 @@ type SelectAnswer struct {
 	Index         int `json:"index"`
 }
 
+// UseFiftyFifty is an incoming message sent when a user wants to use a 50/50 lifeline
+// to remove two wrong choices from the current question.
+type UseFiftyFifty struct {
+	// QuestionIndex is the index of the question that the lifeline is being used on.
+	QuestionIndex int `json:"questionIndex"`
+}
+
+// UseDoublePoints is an incoming message sent when a user wants to use a double points lifeline
+// on the current question. This must be sent before the user selects an answer.
+type UseDoublePoints struct {
+	// QuestionIndex is the index of the question that the lifeline is being used on.
+	QuestionIndex int `json:"questionIndex"`
+}
+
+// UseSkip is an incoming message sent when a user wants to sit out the current question.
+type UseSkip struct {
+	// QuestionIndex is the index of the question that the lifeline is being used on.
+	QuestionIndex int `json:"questionIndex"`
+}
+
+// EnterQueue is an incoming message sent when a user wants to enter the matchmaking queue.
+type EnterQueue struct {
+	// Categories are the question categories that the user would like to play. The user
+	// can be matched into a game with any category if this is empty.
+	Categories []string `json:"categories"`
+
+	// Language is the language that the user would like to play in.
+	Language string `json:"language"`
+}
+
+// LeaveQueue is an incoming message sent when a user wants to leave the matchmaking queue.
+type LeaveQueue struct{}
+
 // #NOTE should only define incoming messages in here
 func unmarshalIncomingPayload(incoming *incomingJSONMessage) (msg interface{}, err error) {
 	switch incoming.Tag {
@@ -66,6 +106,21 @@ func unmarshalIncomingPayload(incoming *incomingJSONMessage) (msg interface{}, e
 	case tagSelectAnswer:
 		msg = &SelectAnswer{}
 		unmarshalPayloadRequired(incoming.Payload, &msg)
+	case tagUseFiftyFifty:
+		msg = &UseFiftyFifty{}
+		unmarshalPayloadRequired(incoming.Payload, &msg)
+	case tagUseDoublePoints:
+		msg = &UseDoublePoints{}
+		unmarshalPayloadRequired(incoming.Payload, &msg)
+	case tagUseSkip:
+		msg = &UseSkip{}
+		unmarshalPayloadRequired(incoming.Payload, &msg)
+	case tagEnterQueue:
+		msg = &EnterQueue{}
+		unmarshalPayloadRequired(incoming.Payload, &msg)
+	case tagLeaveQueue:
+		msg = &LeaveQueue{}
+		unmarshalPayloadOptional(incoming.Payload, &msg)
 	default:
 		return nil, fmt.Errorf("trivia: unknown incoming message tag '%s'", incoming.Tag)
 	}
@@ -17,6 +17,10 @@ const (
 
 	tagGameStartCountdownTick = OutgoingMessageType("g-start-countdown-tick")
 	tagGameStart              = OutgoingMessageType("g-start")
+	tagGameResults            = OutgoingMessageType("g-results")
+	tagGameEnded              = OutgoingMessageType("g-ended")
+	tagAchievementsUnlocked   = OutgoingMessageType("g-achievements")
+	tagXPGained               = OutgoingMessageType("g-xp")
 
 	tagQuestionCountdownTick = OutgoingMessageType("q-countdown-tick")
 	tagSetPrompt             = OutgoingMessageType("q-set-prompt")
@@ -27,6 +31,20 @@ const (
 	tagSetParticipant    = OutgoingMessageType("p-list-set")
 	tagParticipantsList  = OutgoingMessageType("p-list-full")
 
+	tagLifelines        = OutgoingMessageType("l-remaining")
+	tagFiftyFifty       = OutgoingMessageType("l-fifty-fifty")
+	tagDoublePoints     = OutgoingMessageType("l-double-points")
+	tagSkipQuestion     = OutgoingMessageType("l-skip")
+	tagLifelineRejected = OutgoingMessageType("l-rejected")
+
+	tagChallengeFinished = OutgoingMessageType("c-finished")
+	tagChallengeResults  = OutgoingMessageType("c-results")
+	tagChallengeClosed   = OutgoingMessageType("c-closed")
+
+	tagQueueStatus = OutgoingMessageType("mm-status")
+	tagMatchFound  = OutgoingMessageType("mm-found")
+	tagQueueClosed = OutgoingMessageType("mm-closed")
+
 	tagMulti = OutgoingMessageType("multi")
 )
 
@@ -114,6 +132,166 @@ type Participant struct {
 	Disconnected bool   `json:"disconnected"`
 }
 
+// Lifelines is an outgoing message that tells a client how many of each lifeline they have remaining.
+type Lifelines struct {
+	FiftyFifty   int `json:"fiftyFifty"`
+	DoublePoints int `json:"doublePoints"`
+	Skip         int `json:"skip"`
+}
+
+// FiftyFifty is an outgoing message sent only to the client that used a 50/50 lifeline
+// with the choices that have been removed from the question for them.
+type FiftyFifty struct {
+	QuestionIndex  int   `json:"questionIndex"`
+	RemovedChoices []int `json:"removedChoices"`
+
+	// Remaining is the number of 50/50 lifelines the client has left.
+	Remaining int `json:"remaining"`
+}
+
+// DoublePoints is an outgoing message sent only to the client that used a double points lifeline
+// to confirm that their answer to the question will be worth double.
+type DoublePoints struct {
+	QuestionIndex int `json:"questionIndex"`
+
+	// Remaining is the number of double points lifelines the client has left.
+	Remaining int `json:"remaining"`
+}
+
+// SkipQuestion is an outgoing message sent only to the client that used a skip lifeline
+// to confirm that they are sitting out the question.
+type SkipQuestion struct {
+	QuestionIndex int `json:"questionIndex"`
+
+	// Remaining is the number of skip lifelines the client has left.
+	Remaining int `json:"remaining"`
+}
+
+// LifelineRejected is an outgoing message sent to a client when a lifeline they tried to
+// use could not be used.
+type LifelineRejected struct {
+	// Lifeline is the name of the lifeline: "fifty-fifty", "double-points", or "skip"
+	Lifeline      string `json:"lifeline"`
+	QuestionIndex int    `json:"questionIndex"`
+	Reason        string `json:"reason"`
+}
+
+// ChallengeFinished is an outgoing message sent to a player once they have answered every
+// question in a challenge. The results of the challenge are sent with a ChallengeResults message
+// once every player has finished or the challenge's deadline has passed.
+type ChallengeFinished struct {
+	Score          int `json:"score"`
+	CorrectAnswers int `json:"correctAnswers"`
+
+	// PlayersFinished is the number of players that have finished the challenge so far.
+	PlayersFinished int `json:"playersFinished"`
+
+	// MaxPlayers is the number of players that can take part in the challenge.
+	MaxPlayers int `json:"maxPlayers"`
+
+	// Deadline is the unix timestamp (in milliseconds) at which the results will be revealed
+	// if not every player has finished.
+	Deadline int64 `json:"deadline"`
+}
+
+// ChallengeResults is an outgoing message containing the final standings of a challenge.
+type ChallengeResults struct {
+	Results []ChallengeResult `json:"results"`
+}
+
+// ChallengeResult is a single player's result in a challenge.
+type ChallengeResult struct {
+	Placement      int    `json:"placement"`
+	Username       string `json:"username"`
+	Score          int    `json:"score"`
+	CorrectAnswers int    `json:"correctAnswers"`
+
+	// Finished is false if the player did not answer every question before the deadline.
+	Finished bool `json:"finished"`
+}
+
+// ChallengeClosed is an outgoing message sent when a player cannot join or continue a challenge.
+type ChallengeClosed struct {
+	Reason string `json:"reason"`
+}
+
+// GameEnded is an outgoing message sent when a game is ended by a moderator before it finished.
+// The game's results are not recorded and clients are disconnected after this message.
+type GameEnded struct {
+	Reason string `json:"reason"`
+}
+
+// GameResults is an outgoing message containing the final standings of a game once it has ended.
+// Registered participants are sent their XPGained separately once their XP has been awarded.
+type GameResults struct {
+	Results []GameResult `json:"results"`
+}
+
+// GameResult is a single participant's final standing in a game.
+type GameResult struct {
+	Placement      int    `json:"placement"`
+	Username       string `json:"username"`
+	Score          int    `json:"score"`
+	CorrectAnswers int    `json:"correctAnswers"`
+}
+
+// AchievementsUnlocked is an outgoing message sent to a participant at the end of a game
+// with the achievements they unlocked by playing it.
+type AchievementsUnlocked struct {
+	Achievements []Achievement `json:"achievements"`
+}
+
+// Achievement is a single achievement that was unlocked by a participant.
+type Achievement struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// XPGained is an outgoing message sent to a registered participant at the end of a game with the
+// XP they earned by playing it and their new level progress. It always comes after GameResults
+// while the results are being shown, since XP is awarded after the game's result is stored, and
+// clients should add it to the results screen when it arrives. Guests are never sent this.
+type XPGained struct {
+	Gained int64 `json:"gained"`
+
+	// Capped is true if the participant earned less XP than usual because of the daily cap on solo games.
+	Capped bool `json:"capped"`
+
+	XP          int64 `json:"xp"`
+	Level       int   `json:"level"`
+	LevelXP     int64 `json:"levelXP"`
+	NextLevelXP int64 `json:"nextLevelXP"`
+	LevelUp     bool  `json:"levelUp"`
+}
+
+// QueueStatus is an outgoing message sent periodically to users waiting in the matchmaking queue.
+type QueueStatus struct {
+	// Position is the user's position in the queue starting at 1.
+	Position int `json:"position"`
+
+	// QueueSize is the number of users waiting in the queue.
+	QueueSize int `json:"queueSize"`
+
+	// EstimatedWait is the estimated number of milliseconds until the user is matched into a game.
+	EstimatedWait int64 `json:"estimatedWait"`
+
+	// RatingWindow is how far from the user's rating other players can be to be matched with them.
+	RatingWindow int `json:"ratingWindow"`
+}
+
+// MatchFound is an outgoing message sent when a user has been matched into a game. The client
+// should connect to the game with the given ID where it has a seat reserved.
+type MatchFound struct {
+	GameID string `json:"gameID"`
+}
+
+// QueueClosed is an outgoing message sent when a user is removed from the matchmaking queue
+// without being matched.
+type QueueClosed struct {
+	Reason string `json:"reason"`
+}
+
 // Multi is an outgoing messages used to send a bundle of multiple outgoing messages at once.
 type Multi struct {
 	Messages []interface{} `json:"messages"`
@@ -161,6 +339,36 @@ func getTagForOutgoingPayload(payload interface{}) (OutgoingMessageType, error)
 		return tagSetParticipant, nil
 	case *ParticipantsList:
 		return tagParticipantsList, nil
+	case *Lifelines:
+		return tagLifelines, nil
+	case *FiftyFifty:
+		return tagFiftyFifty, nil
+	case *DoublePoints:
+		return tagDoublePoints, nil
+	case *SkipQuestion:
+		return tagSkipQuestion, nil
+	case *LifelineRejected:
+		return tagLifelineRejected, nil
+	case *ChallengeFinished:
+		return tagChallengeFinished, nil
+	case *ChallengeResults:
+		return tagChallengeResults, nil
+	case *ChallengeClosed:
+		return tagChallengeClosed, nil
+	case *GameResults:
+		return tagGameResults, nil
+	case *GameEnded:
+		return tagGameEnded, nil
+	case *AchievementsUnlocked:
+		return tagAchievementsUnlocked, nil
+	case *XPGained:
+		return tagXPGained, nil
+	case *QueueStatus:
+		return tagQueueStatus, nil
+	case *MatchFound:
+		return tagMatchFound, nil
+	case *QueueClosed:
+		return tagQueueClosed, nil
 	case *Multi:
 		return tagMulti, nil
 	}
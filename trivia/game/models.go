@@ -0,0 +1,54 @@
+package game
+
+import (
+	"github.com/expixel/actual-trivia-server/trivia/game/message"
+	"github.com/expixel/actual-trivia-server/trivia/null"
+)
+
+type challengeResponse struct {
+	ID              string                    `json:"id"`
+	Host            string                    `json:"host"`
+	QuestionCount   int                       `json:"questionCount"`
+	AnswerMillis    int64                     `json:"answerMillis"`
+	MaxPlayers      int                       `json:"maxPlayers"`
+	PlayersJoined   int                       `json:"playersJoined"`
+	PlayersFinished int                       `json:"playersFinished"`
+	CreatedAt       int64                     `json:"createdAt"`
+	Deadline        int64                     `json:"deadline"`
+	Revealed        bool                      `json:"revealed"`
+	Results         []message.ChallengeResult `json:"results"`
+}
+
+type scheduledGameResponse struct {
+	ID              int64       `json:"id"`
+	Title           string      `json:"title"`
+	StartsAt        int64       `json:"startsAt"`
+	LobbyOpensAt    int64       `json:"lobbyOpensAt"`
+	Host            null.String `json:"host"`
+	GameID          null.String `json:"gameID"`
+	RSVPCount       int         `json:"rsvpCount"`
+	MinParticipants int         `json:"minParticipants"`
+	MaxParticipants int         `json:"maxParticipants"`
+	QuestionCount   int         `json:"questionCount"`
+	AnswerSeconds   int         `json:"answerSeconds"`
+}
+
+type scheduleResponse struct {
+	Games []scheduledGameResponse `json:"games"`
+}
+
+type gameParticipantResponse struct {
+	Username       string `json:"username"`
+	Guest          bool   `json:"guest"`
+	Placement      int    `json:"placement"`
+	Score          int    `json:"score"`
+	CorrectAnswers int    `json:"correctAnswers"`
+}
+
+type gameResultResponse struct {
+	ID            int64                     `json:"id"`
+	GameID        string                    `json:"gameID"`
+	QuestionCount int                       `json:"questionCount"`
+	FinishedAt    int64                     `json:"finishedAt"`
+	Participants  []gameParticipantResponse `json:"participants"`
+}
@@ -0,0 +1,85 @@
+package game
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/game/message"
+)
+
+// finalResult collects the final standings of every participant in the game, including
+// participants that were disconnected when the game ended.
+func (g *TriviaGame) finalResult() *trivia.GameResult {
+	participants := make([]*TriviaGameClient, 0, len(g.clients)+len(g.disconnectedClients))
+	for _, client := range g.clients {
+		if client.Participant {
+			participants = append(participants, client)
+		}
+	}
+	for _, client := range g.disconnectedClients {
+		if client.Participant {
+			participants = append(participants, client)
+		}
+	}
+
+	return &trivia.GameResult{
+		GameID:          g.ID,
+		QuestionCount:   len(g.questions),
+		FinishedAt:      g.now(),
+		MinParticipants: g.options.MinParticipants,
+		Participants:    rankGameClients(participants),
+	}
+}
+
+// rankGameClients orders participants by their score and assigns them placements. Participants
+// with the same score share a placement.
+func rankGameClients(clients []*TriviaGameClient) []trivia.GameParticipantResult {
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Score != clients[j].Score {
+			return clients[i].Score > clients[j].Score
+		}
+		return strings.ToLower(clients[i].User.Username) < strings.ToLower(clients[j].User.Username)
+	})
+
+	results := make([]trivia.GameParticipantResult, len(clients))
+	for idx, client := range clients {
+		placement := idx + 1
+		if idx > 0 && client.Score == clients[idx-1].Score {
+			placement = results[idx-1].Placement
+		}
+
+		results[idx] = trivia.GameParticipantResult{
+			UserID:         client.User.ID,
+			Username:       client.User.Username,
+			Guest:          client.User.Guest,
+			EmailVerified:  client.User.EmailVerified,
+			Placement:      placement,
+			Score:          client.Score,
+			CorrectAnswers: client.CorrectAnswers,
+
+			FastestCorrectAnswer: client.FastestCorrectAnswer,
+			Categories:           make([]trivia.CategoryScore, 0, len(client.CategoryScores)),
+		}
+		for _, categoryScore := range client.CategoryScores {
+			results[idx].Categories = append(results[idx].Categories, *categoryScore)
+		}
+		sort.Slice(results[idx].Categories, func(i, j int) bool {
+			return results[idx].Categories[i].Category < results[idx].Categories[j].Category
+		})
+	}
+	return results
+}
+
+func newGameResultsMessage(result *trivia.GameResult) *message.GameResults {
+	msg := &message.GameResults{Results: make([]message.GameResult, len(result.Participants))}
+	for idx, participant := range result.Participants {
+		msg.Results[idx] = message.GameResult{
+			Placement:      participant.Placement,
+			Username:       participant.Username,
+			Score:          participant.Score,
+			CorrectAnswers: participant.CorrectAnswers,
+		}
+	}
+	return msg
+}
@@ -0,0 +1,47 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+func TestRankGameClients(t *testing.T) {
+	clients := []*TriviaGameClient{
+		{User: &trivia.User{ID: 1, Username: "carol"}, Score: 300, CorrectAnswers: 3},
+		{User: &trivia.User{ID: -4, Username: "#Guest4", Guest: true}, Score: 500, CorrectAnswers: 4},
+		{User: &trivia.User{ID: 2, Username: "Alice", EmailVerified: true}, Score: 300, CorrectAnswers: 2},
+		{User: &trivia.User{ID: 3, Username: "bob"}, Score: 0},
+	}
+
+	clients[0].recordCategoryAnswer("Science", 100, true)
+	clients[0].recordCategoryAnswer("History", 200, true)
+	clients[0].recordCategoryAnswer("Science", 0, false)
+	clients[0].recordCategoryAnswer("Science", 100, true)
+
+	results := rankGameClients(clients)
+	expected := []struct {
+		userID    int64
+		placement int
+	}{
+		{-4, 1},
+		{2, 2},
+		{1, 2},
+		{3, 4},
+	}
+
+	for idx, e := range expected {
+		if results[idx].UserID != e.userID || results[idx].Placement != e.placement {
+			t.Errorf("result %d: expected user %d in placement %d but got %+v", idx, e.userID, e.placement, results[idx])
+		}
+	}
+
+	if !results[0].Guest || results[1].CorrectAnswers != 2 || !results[1].EmailVerified || results[2].EmailVerified {
+		t.Errorf("results are missing participant details: %+v", results)
+	}
+
+	categories := results[2].Categories
+	if len(categories) != 2 || categories[0].Category != "History" || categories[1].Score != 200 || categories[1].CorrectAnswers != 2 || categories[1].Questions != 3 {
+		t.Errorf("unexpected category scores: %+v", categories)
+	}
+}
@@ -0,0 +1,135 @@
+package game
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/periodic"
+)
+
+// ScheduledLobbyLead is how long before a scheduled game's start time its lobby is opened by
+// creating the actual game. Users that RSVP'd get their seats reserved at this point.
+const ScheduledLobbyLead = 5 * time.Minute
+
+// scheduledMissedAfter is how long after its start time a scheduled game will still be
+// created. Games that are missed (usually because the server was down) are skipped.
+const scheduledMissedAfter = 15 * time.Minute
+
+// scheduledGameStartDelay is the start countdown used for scheduled games.
+const scheduledGameStartDelay = 10 * time.Second
+
+// schedulerInterval is how often the scheduler checks for games with lobbies that should be opened.
+const schedulerInterval = 15 * time.Second
+
+// A Clock is used to get the current time. It can be swapped out to control time in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is a clock that uses the system's time.
+var SystemClock Clock = systemClock{}
+
+// gameCreator creates running games. This is implemented by TriviaGamesSet.
+type gameCreator interface {
+	CreateGame(gameID string, gameOptions *TriviaGameOptions) error
+}
+
+// Scheduler opens the lobbies of scheduled games by creating them in a TriviaGamesSet shortly
+// before they are supposed to start.
+type Scheduler struct {
+	games   gameCreator
+	service trivia.ScheduledGameService
+	clock   Clock
+	runner  *periodic.Runner
+}
+
+// NewScheduler creates a new scheduler that creates scheduled games in the given set.
+func NewScheduler(games *TriviaGamesSet, service trivia.ScheduledGameService, clock Clock) *Scheduler {
+	return newScheduler(games, service, clock)
+}
+
+func newScheduler(games gameCreator, service trivia.ScheduledGameService, clock Clock) *Scheduler {
+	s := &Scheduler{
+		games:   games,
+		service: service,
+		clock:   clock,
+	}
+	s.runner = periodic.NewRunner(schedulerInterval, true, func() { s.Tick() })
+	return s
+}
+
+// ScheduledGameID returns the ID of the game that is created for a scheduled game.
+func ScheduledGameID(scheduledGameID int64) string {
+	return "scheduled-" + strconv.FormatInt(scheduledGameID, 10)
+}
+
+// Start starts checking for scheduled games on its own goroutine.
+func (s *Scheduler) Start() {
+	s.runner.Start()
+}
+
+// Stop stops the scheduler and waits for it to finish whatever it is doing.
+func (s *Scheduler) Stop() {
+	s.runner.Stop()
+}
+
+// Tick creates the games for every scheduled game whose lobby should be open and returns the
+// number of games that were created.
+func (s *Scheduler) Tick() int {
+	now := s.clock.Now()
+	due, err := s.service.DueScheduledGames(now.Add(-scheduledMissedAfter), now.Add(ScheduledLobbyLead))
+	if err != nil {
+		logger.Error("error occurred while getting due scheduled games: %s", err)
+		return 0
+	}
+
+	created := 0
+	for idx := range due {
+		if s.openLobby(&due[idx]) {
+			created++
+		}
+	}
+	return created
+}
+
+// openLobby creates the game for a scheduled game with seats reserved for the users that RSVP'd.
+func (s *Scheduler) openLobby(scheduled *trivia.ScheduledGame) bool {
+	reserved, err := s.service.RSVPUserIDs(scheduled.ID)
+	if err != nil {
+		logger.Error("error occurred while getting RSVPs for scheduled game %d: %s", scheduled.ID, err)
+		return false
+	}
+
+	gameID := ScheduledGameID(scheduled.ID)
+	err = s.games.CreateGame(gameID, &TriviaGameOptions{
+		MinParticipants:        scheduled.Options.MinParticipants,
+		MaxParticipants:        scheduled.Options.MaxParticipants,
+		GameStartDelay:         scheduledGameStartDelay,
+		QuestionCount:          scheduled.Options.QuestionCount,
+		QuestionAnswerDuration: time.Duration(scheduled.Options.AnswerSeconds) * time.Second,
+		StartAt:                scheduled.StartsAt,
+		ReservedSeats:          reserved,
+		RemoveOnFinish:         true,
+		Clock:                  s.clock,
+	})
+
+	// if the game already exists we probably failed to store its ID last time.
+	if err != nil && err != ErrGameIDInUse {
+		logger.Error("error occurred while creating scheduled game %d: %s", scheduled.ID, err)
+		return false
+	}
+
+	if err = s.service.SetScheduledGameID(scheduled.ID, gameID); err != nil {
+		logger.Error("error occurred while setting game ID of scheduled game %d: %s", scheduled.ID, err)
+	}
+
+	logger.Info("opened lobby for scheduled game %d (%s) with %d reserved seats", scheduled.ID, gameID, len(reserved))
+	return true
+}
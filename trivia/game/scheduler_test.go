@@ -0,0 +1,165 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/null"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+type fakeGameCreator struct {
+	created map[string]*TriviaGameOptions
+}
+
+func (c *fakeGameCreator) CreateGame(gameID string, gameOptions *TriviaGameOptions) error {
+	if _, ok := c.created[gameID]; ok {
+		return ErrGameIDInUse
+	}
+	c.created[gameID] = gameOptions
+	return nil
+}
+
+// fakeScheduledGameService only implements what the scheduler needs.
+type fakeScheduledGameService struct {
+	trivia.ScheduledGameService
+	games []trivia.ScheduledGame
+	rsvps map[int64][]int64
+}
+
+func (s *fakeScheduledGameService) DueScheduledGames(from time.Time, to time.Time) ([]trivia.ScheduledGame, error) {
+	due := make([]trivia.ScheduledGame, 0)
+	for _, g := range s.games {
+		if !g.GameID.Valid && !g.StartsAt.Before(from) && !g.StartsAt.After(to) {
+			due = append(due, g)
+		}
+	}
+	return due, nil
+}
+
+func (s *fakeScheduledGameService) SetScheduledGameID(id int64, gameID string) error {
+	for idx := range s.games {
+		if s.games[idx].ID == id {
+			s.games[idx].GameID = null.NewString(gameID)
+		}
+	}
+	return nil
+}
+
+func (s *fakeScheduledGameService) RSVPUserIDs(scheduledGameID int64) ([]int64, error) {
+	return s.rsvps[scheduledGameID], nil
+}
+
+func TestSchedulerOpensLobbies(t *testing.T) {
+	start := time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)
+	options := trivia.ScheduledGameOptions{MinParticipants: 2, MaxParticipants: 8, QuestionCount: 10, AnswerSeconds: 10}
+	service := &fakeScheduledGameService{
+		games: []trivia.ScheduledGame{
+			{ID: 1, Title: "Noon", StartsAt: start, Options: options},
+			{ID: 2, Title: "Later", StartsAt: start.Add(time.Hour), Options: options},
+			{ID: 3, Title: "Missed", StartsAt: start.Add(-time.Hour), Options: options},
+		},
+		rsvps: map[int64][]int64{1: {10, 11}},
+	}
+	creator := &fakeGameCreator{created: make(map[string]*TriviaGameOptions)}
+	clock := &fakeClock{now: start.Add(-ScheduledLobbyLead - time.Second)}
+	scheduler := newScheduler(creator, service, clock)
+
+	if created := scheduler.Tick(); created != 0 {
+		t.Fatalf("expected no lobbies to be opened before the lobby lead time but %d were", created)
+	}
+
+	clock.now = start.Add(-ScheduledLobbyLead)
+	if created := scheduler.Tick(); created != 1 {
+		t.Fatalf("expected 1 lobby to be opened but %d were", created)
+	}
+
+	opts, ok := creator.created[ScheduledGameID(1)]
+	if !ok {
+		t.Fatalf("expected the game for scheduled game 1 to be created: %v", creator.created)
+	}
+	if !opts.StartAt.Equal(start) {
+		t.Errorf("expected the game to start at %s but it starts at %s", start, opts.StartAt)
+	}
+	if len(opts.ReservedSeats) != 2 || opts.ReservedSeats[0] != 10 || opts.ReservedSeats[1] != 11 {
+		t.Errorf("expected seats to be reserved for users 10 and 11 but got %v", opts.ReservedSeats)
+	}
+	if opts.MaxParticipants != 8 || opts.QuestionCount != 10 || opts.QuestionAnswerDuration != 10*time.Second {
+		t.Errorf("scheduled game options were not carried over: %+v", opts)
+	}
+
+	clock.now = start
+	if created := scheduler.Tick(); created != 0 {
+		t.Fatalf("expected lobbies to only be opened once but %d were opened again", created)
+	}
+
+	if _, ok := creator.created[ScheduledGameID(3)]; ok {
+		t.Errorf("missed scheduled games should not be created")
+	}
+}
+
+func TestScheduledGameCountdown(t *testing.T) {
+	start := time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start.Add(-time.Minute)}
+	g := &TriviaGame{
+		options: &TriviaGameOptions{
+			MinParticipants: 1,
+			GameStartDelay:  scheduledGameStartDelay,
+			StartAt:         start,
+			Clock:           clock,
+		},
+		currentState:      gameStateWaitForStart,
+		participantsCount: 1,
+		gameTickTimer:     time.NewTimer(time.Hour),
+	}
+	defer g.gameTickTimer.Stop()
+
+	if wait := g.untilScheduledCountdown(); wait != time.Minute-scheduledGameStartDelay {
+		t.Errorf("expected to wait %s for the countdown but got %s", time.Minute-scheduledGameStartDelay, wait)
+	}
+	g.gameTick()
+	if g.currentState != gameStateWaitForStart || !g.gameTickWaiting {
+		t.Fatalf("expected the game to wait for its start time but it is in state %d", g.currentState)
+	}
+
+	clock.now = start.Add(-scheduledGameStartDelay)
+	g.gameTick()
+	if g.currentState != gameStateFetchQuestions {
+		t.Fatalf("expected the countdown to start but the game is in state %d", g.currentState)
+	}
+	if !g.gameCountdownEnd.Equal(start) {
+		t.Errorf("expected the countdown to end at %s but it ends at %s", start, g.gameCountdownEnd)
+	}
+}
+
+func TestScheduledLobbyOpen(t *testing.T) {
+	start := time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)
+	scheduled := &trivia.ScheduledGame{ID: 1, StartsAt: start}
+
+	if scheduledLobbyOpen(scheduled, start.Add(-ScheduledLobbyLead-time.Second)) {
+		t.Errorf("expected RSVPs to be open before the lobby lead time")
+	}
+	if !scheduledLobbyOpen(scheduled, start.Add(-ScheduledLobbyLead+time.Second)) {
+		t.Errorf("expected RSVPs to be closed once the lobby should be open")
+	}
+
+	scheduled.GameID = null.NewString(ScheduledGameID(1))
+	if !scheduledLobbyOpen(scheduled, start.Add(-time.Hour)) {
+		t.Errorf("expected RSVPs to be closed once the lobby was opened")
+	}
+
+	if validScheduleStart(start, start.Add(-30*time.Second)) || !validScheduleStart(start, start.Add(-time.Hour)) {
+		t.Errorf("expected games to be scheduled at least a minute ahead")
+	}
+	if validScheduleStart(start, start.Add(-maxScheduleAhead-time.Hour)) {
+		t.Errorf("expected games not to be scheduled more than %s ahead", maxScheduleAhead)
+	}
+}
@@ -0,0 +1,114 @@
+package game
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sessionRevokedCloseCode is the websocket close code sent to connections that are closed because
+// the session they were authenticated with was revoked.
+const sessionRevokedCloseCode = 4001
+
+// sessionCloseTimeout is how long writing the close message to a revoked connection can take.
+const sessionCloseTimeout = 2 * time.Second
+
+// sessionConns keeps track of which connections were authenticated with tokens from each session
+// (refresh token family) so that they can be closed when the session is revoked. A nil
+// *sessionConns is valid and doesn't track anything.
+type sessionConns struct {
+	lock  *sync.Mutex
+	conns map[string]map[*Conn]struct{}
+}
+
+func newSessionConns() *sessionConns {
+	return &sessionConns{
+		lock:  &sync.Mutex{},
+		conns: make(map[string]map[*Conn]struct{}),
+	}
+}
+
+// track associates an authenticated connection with a session. The connection stops being tracked
+// once it is done.
+func (s *sessionConns) track(family string, conn *Conn) {
+	if s == nil || family == "" {
+		return
+	}
+
+	s.lock.Lock()
+	conns, ok := s.conns[family]
+	if !ok {
+		conns = make(map[*Conn]struct{})
+		s.conns[family] = conns
+	}
+	conns[conn] = struct{}{}
+	s.lock.Unlock()
+
+	conn.whenDone(func() { s.untrack(family, conn) })
+}
+
+func (s *sessionConns) untrack(family string, conn *Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if conns, ok := s.conns[family]; ok {
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(s.conns, family)
+		}
+	}
+}
+
+// disconnect closes every connection that was authenticated with a token from one of the given
+// sessions. The connections are closed from the calling goroutine, which is safe because closing
+// doesn't go through the goroutine that owns the connection's writes. Whatever is using the
+// connection then sees it close like any other disconnect.
+func (s *sessionConns) disconnect(families []string) int {
+	if s == nil {
+		return 0
+	}
+
+	closing := make([]*Conn, 0)
+	s.lock.Lock()
+	for _, family := range families {
+		for conn := range s.conns[family] {
+			closing = append(closing, conn)
+		}
+		delete(s.conns, family)
+	}
+	s.lock.Unlock()
+
+	closeMessage := websocket.FormatCloseMessage(sessionRevokedCloseCode, "session revoked")
+	for _, conn := range closing {
+		conn.wsConn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(sessionCloseTimeout))
+		conn.Close()
+	}
+	return len(closing)
+}
+
+// rename moves the connections of a session to another session.
+func (s *sessionConns) rename(from string, to string) {
+	if s == nil || from == to {
+		return
+	}
+
+	s.lock.Lock()
+	conns := s.conns[from]
+	delete(s.conns, from)
+	s.lock.Unlock()
+
+	// the connections are tracked again so that their done callbacks untrack the new session.
+	for conn := range conns {
+		s.track(to, conn)
+	}
+}
+
+// DisconnectSessions closes every game, challenge, and matchmaking connection that was authenticated
+// with a token from one of the given refresh token families. This is used when sessions are revoked
+// so that a revoked token can't stay in a game.
+func (set *TriviaGamesSet) DisconnectSessions(families []string) {
+	if n := set.sessions.disconnect(families); n > 0 {
+		logger.Info("closed %d connections from %d revoked sessions", n, len(families))
+	}
+}
@@ -0,0 +1,58 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/null"
+)
+
+func TestSessionConnsUntrackDoneConns(t *testing.T) {
+	sessions := newSessionConns()
+	first := &Conn{}
+	second := &Conn{}
+	sessions.track("family", first)
+	sessions.track("family", second)
+
+	first.finish()
+	if _, ok := sessions.conns["family"][first]; ok {
+		t.Errorf("expected the finished connection to stop being tracked")
+	}
+	if _, ok := sessions.conns["family"][second]; !ok {
+		t.Errorf("expected the other connection to still be tracked")
+	}
+
+	second.finish()
+	if len(sessions.conns) != 0 {
+		t.Errorf("expected the session to be removed once all of its connections are done")
+	}
+
+	// connections that are already done are never tracked.
+	sessions.track("family", first)
+	if len(sessions.conns) != 0 {
+		t.Errorf("expected a finished connection to not be tracked")
+	}
+}
+
+func TestUpgradeGuestClient(t *testing.T) {
+	guest := trivia.NewGuestUser(null.NewInt64(3))
+	client := &TriviaGameClient{User: guest, Participant: true}
+	g := &TriviaGame{
+		clients:             make(map[int64]*TriviaGameClient),
+		disconnectedClients: map[int64]*TriviaGameClient{guest.ID: client},
+	}
+	g.addParticipantToList(client)
+
+	user := &trivia.User{ID: 12, Username: "upgraded"}
+	g.upgradeGuestClient(3, user)
+
+	if g.disconnectedClients[user.ID] != client || client.User != user {
+		t.Fatalf("expected the guest's client to be moved to the new user")
+	}
+	if _, ok := g.disconnectedClients[guest.ID]; ok {
+		t.Errorf("expected the guest's client to be removed")
+	}
+	if g.participantsList.Participants[0].Username != user.Username {
+		t.Errorf("expected the participant to be renamed but got %s", g.participantsList.Participants[0].Username)
+	}
+}
@@ -2,8 +2,10 @@ package game
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
-	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -16,6 +18,9 @@ import (
 // ErrGameNotFound is returned when trying to use a Game ID that does not exist.
 var ErrGameNotFound = errors.New("no game with the given ID was found")
 
+// ErrGameIDInUse is returned when trying to create a game with an ID that is already in use.
+var ErrGameIDInUse = errors.New("a game with the given ID already exists")
+
 // TriviaGamesSet contains a set of trivia games that are currently running.
 type TriviaGamesSet struct {
 	// gamesMapLock is a lock on the map of games that are currently running.
@@ -26,10 +31,26 @@ type TriviaGamesSet struct {
 	games     map[string]*TriviaGameSetGame
 	gamesLock *sync.Mutex
 
+	// challenges is a map of asynchronous challenge games using their IDs as keys.
+	// This is also guarded by gamesLock.
+	challenges map[string]*ChallengeGame
+
+	// resultHandlers are called with the result of every finished game. This is also
+	// guarded by gamesLock.
+	resultHandlers []GameResultHandler
+
+	// sessions tracks the connections that were authenticated with each session so that they
+	// can be closed when it is revoked.
+	sessions *sessionConns
+
 	tokenService    trivia.AuthTokenService
 	questionService trivia.QuestionService
 }
 
+// A GameResultHandler is called with the result of a finished game. Handlers are called on
+// their own goroutine so they can take as long as they need without holding up the game.
+type GameResultHandler func(result *trivia.GameResult)
+
 // TriviaGameSetGame is a game that is in a set. It contains the actual game and then some extra
 // information used by the trivia set.
 type TriviaGameSetGame struct {
@@ -47,12 +68,42 @@ type TriviaGameSetGame struct {
 	MaxParticipants int
 }
 
+// LiveGame describes a game that is running in a set.
+type LiveGame struct {
+	ID                  string
+	ParticipantsCount   int
+	MaxParticipants     int
+	ParticipationClosed bool
+	QuestionCount       int
+	Categories          []string
+	Unlisted            bool
+
+	// StartAt is the time that a scheduled game starts at. This is zero for other games.
+	StartAt time.Time
+}
+
+func newLiveGame(id string, setGame *TriviaGameSetGame) LiveGame {
+	options := setGame.Game.options
+	return LiveGame{
+		ID:                  id,
+		ParticipantsCount:   setGame.ParticipantsCount,
+		MaxParticipants:     setGame.MaxParticipants,
+		ParticipationClosed: setGame.ParticipationClosed,
+		QuestionCount:       options.QuestionCount,
+		Categories:          options.Categories,
+		Unlisted:            options.Unlisted,
+		StartAt:             options.StartAt,
+	}
+}
+
 // NewGameSet creates a new set of trivia games.
 func NewGameSet(tokenService trivia.AuthTokenService, questionService trivia.QuestionService) *TriviaGamesSet {
 	return &TriviaGamesSet{
 		gamesMapLock:    &sync.Mutex{},
 		games:           make(map[string]*TriviaGameSetGame),
 		gamesLock:       &sync.Mutex{},
+		challenges:      make(map[string]*ChallengeGame),
+		sessions:        newSessionConns(),
 		tokenService:    tokenService,
 		questionService: questionService,
 	}
@@ -67,10 +118,14 @@ func (set *TriviaGamesSet) AddRawConnToGame(rawConn *websocket.Conn, gameID stri
 	if gameID == "" {
 		var lastSet *TriviaGameSetGame
 		for _, setGame := range set.games {
+			if setGame.Game.options.Unlisted {
+				continue
+			}
+
 			if !setGame.ParticipationClosed {
 				// new particicipants get placed in the game with the highest number
 				// of participants this way.
-				if lastSet == nil || lastSet.ParticipantsCount > setGame.ParticipantsCount {
+				if lastSet == nil || setGame.ParticipantsCount > lastSet.ParticipantsCount {
 					game = setGame.Game
 					lastSet = setGame
 				}
@@ -121,6 +176,9 @@ func (set *TriviaGamesSet) CreateGame(gameID string, gameOptions *TriviaGameOpti
 		disconnectedClients: make(map[int64]*TriviaGameClient),
 		clientConnectedChan: make(chan *Conn, 16),
 		stopGameChan:        make(chan bool, 1),
+		userMessageChan:     make(chan userMessage, 16),
+		guestUpgradeChan:    make(chan guestUpgrade, 4),
+		endGameChan:         make(chan string, 1),
 		MsgPendingCond:      msgPendingCond,
 		options:             gameOptions,
 		tokenService:        set.tokenService,
@@ -137,7 +195,8 @@ func (set *TriviaGamesSet) CreateGame(gameID string, gameOptions *TriviaGameOpti
 
 	set.gamesLock.Lock()
 	if _, ok := set.games[gameID]; ok {
-		return fmt.Errorf("cannot create game, the ID %s is already in use", gameID)
+		set.gamesLock.Unlock()
+		return ErrGameIDInUse
 	}
 	set.games[gameID] = &TriviaGameSetGame{
 		Game:                game,
@@ -152,3 +211,194 @@ func (set *TriviaGamesSet) CreateGame(gameID string, gameOptions *TriviaGameOpti
 	logger.Debug("created game with ID %s", gameID) // #TODO remove debug code.
 	return nil
 }
+
+// OnGameFinished adds a handler that is called with the result of every game that finishes.
+func (set *TriviaGamesSet) OnGameFinished(handler GameResultHandler) {
+	set.gamesLock.Lock()
+	set.resultHandlers = append(set.resultHandlers, handler)
+	set.gamesLock.Unlock()
+}
+
+// reportGameResult passes the result of a finished game to every result handler.
+func (set *TriviaGamesSet) reportGameResult(result *trivia.GameResult) {
+	set.gamesLock.Lock()
+	handlers := set.resultHandlers
+	set.gamesLock.Unlock()
+
+	for _, handler := range handlers {
+		go handler(result)
+	}
+}
+
+// SendToUser sends a message to a user connected to the game with the given ID. This returns
+// ErrGameNotFound if the game has already been removed from the set.
+func (set *TriviaGamesSet) SendToUser(gameID string, userID int64, msg interface{}) error {
+	set.gamesLock.Lock()
+	setGame, ok := set.games[gameID]
+	set.gamesLock.Unlock()
+
+	if !ok {
+		return ErrGameNotFound
+	}
+	setGame.Game.SendToUser(userID, msg)
+	return nil
+}
+
+// UpgradeGuest moves a guest's clients in every game to the user that the guest upgraded to.
+// Connections that were authenticated with the guest's session are moved to the user's new session
+// so that they are still closed if it is revoked.
+func (set *TriviaGamesSet) UpgradeGuest(guestID int64, user *trivia.User, guestSession string, session string) {
+	set.sessions.rename(guestSession, session)
+
+	set.gamesLock.Lock()
+	defer set.gamesLock.Unlock()
+	for _, setGame := range set.games {
+		setGame.Game.UpgradeGuest(guestID, user)
+	}
+}
+
+// LiveGames returns the games that are running in the set ordered by ID.
+func (set *TriviaGamesSet) LiveGames() []LiveGame {
+	set.gamesLock.Lock()
+	games := make([]LiveGame, 0, len(set.games))
+	for id, setGame := range set.games {
+		games = append(games, newLiveGame(id, setGame))
+	}
+	set.gamesLock.Unlock()
+
+	sort.Slice(games, func(i, j int) bool { return games[i].ID < games[j].ID })
+	return games
+}
+
+// LiveGameByID returns the game with the given ID or nil if there isn't one.
+func (set *TriviaGamesSet) LiveGameByID(gameID string) *LiveGame {
+	set.gamesLock.Lock()
+	defer set.gamesLock.Unlock()
+	if setGame, ok := set.games[gameID]; ok {
+		game := newLiveGame(gameID, setGame)
+		return &game
+	}
+	return nil
+}
+
+// EndGame ends a running game without recording its results as described by TriviaGame.End. This
+// returns ErrGameNotFound if there is no game with the ID.
+func (set *TriviaGamesSet) EndGame(gameID string, reason string) error {
+	set.gamesLock.Lock()
+	setGame, ok := set.games[gameID]
+	set.gamesLock.Unlock()
+
+	if !ok {
+		return ErrGameNotFound
+	}
+	setGame.Game.End(reason)
+	return nil
+}
+
+func (set *TriviaGamesSet) removeGame(gameID string) {
+	set.gamesLock.Lock()
+	delete(set.games, gameID)
+	set.gamesLock.Unlock()
+}
+
+// CreateChallenge creates a new asynchronous challenge hosted by the given user. The questions for
+// the challenge are selected immediately so that every player gets the same set.
+func (set *TriviaGamesSet) CreateChallenge(host *trivia.User, gameOptions *TriviaGameOptions, deadline time.Time) (*ChallengeGame, error) {
+	questions, err := set.questionService.GetRandomQuestions(gameOptions.QuestionCount)
+	if err != nil {
+		return nil, err
+	}
+
+	challengeID, err := generateGameID()
+	if err != nil {
+		return nil, err
+	}
+
+	return set.CreateChallengeWithQuestions(challengeID, host, gameOptions, questions, deadline, ChallengeHooks{})
+}
+
+// CreateChallengeWithQuestions creates a new asynchronous challenge with the given ID and
+// set of questions. The host may be nil for challenges that are not created by a user.
+// ErrGameIDInUse is returned if there is already a challenge with the ID.
+func (set *TriviaGamesSet) CreateChallengeWithQuestions(challengeID string, host *trivia.User, gameOptions *TriviaGameOptions,
+	questions []trivia.Question, deadline time.Time, hooks ChallengeHooks) (*ChallengeGame, error) {
+	challenge := &ChallengeGame{
+		ID:           challengeID,
+		OwningSet:    set,
+		Host:         host,
+		CreatedAt:    time.Now(),
+		Deadline:     deadline,
+		options:      gameOptions,
+		questions:    questions,
+		tokenService: set.tokenService,
+		sessions:     set.sessions,
+		lock:         &sync.Mutex{},
+		players:      make(map[int64]*challengePlayer),
+		revealedChan: make(chan struct{}),
+		hooks:        hooks,
+	}
+
+	set.gamesLock.Lock()
+	if _, ok := set.challenges[challengeID]; ok {
+		set.gamesLock.Unlock()
+		return nil, ErrGameIDInUse
+	}
+	set.challenges[challengeID] = challenge
+	set.gamesLock.Unlock()
+
+	challenge.startDeadline()
+
+	logger.Info("created challenge with ID %s", challengeID)
+	return challenge, nil
+}
+
+// ChallengeByID returns the challenge with the given ID or nil if there isn't one.
+func (set *TriviaGamesSet) ChallengeByID(challengeID string) *ChallengeGame {
+	set.gamesLock.Lock()
+	defer set.gamesLock.Unlock()
+	return set.challenges[challengeID]
+}
+
+// AddRawConnToChallenge adds a raw connection to the requested challenge.
+func (set *TriviaGamesSet) AddRawConnToChallenge(rawConn *websocket.Conn, challengeID string) error {
+	challenge := set.ChallengeByID(challengeID)
+	if challenge == nil {
+		conn := NewWSConn(rawConn, nil)
+		conn.WriteBytes(bmGameNotFound)
+		conn.Close()
+		return ErrGameNotFound
+	}
+
+	conn := NewWSConn(rawConn, nil)
+	go conn.StartReadLoop()
+	go challenge.Play(conn)
+	return nil
+}
+
+// Challenges returns the challenges in the set ordered by when they were created.
+func (set *TriviaGamesSet) Challenges() []*ChallengeGame {
+	set.gamesLock.Lock()
+	challenges := make([]*ChallengeGame, 0, len(set.challenges))
+	for _, challenge := range set.challenges {
+		challenges = append(challenges, challenge)
+	}
+	set.gamesLock.Unlock()
+
+	sort.Slice(challenges, func(i, j int) bool { return challenges[i].CreatedAt.Before(challenges[j].CreatedAt) })
+	return challenges
+}
+
+func (set *TriviaGamesSet) removeChallenge(challengeID string) {
+	set.gamesLock.Lock()
+	delete(set.challenges, challengeID)
+	set.gamesLock.Unlock()
+}
+
+// generateGameID generates a random ID for a game.
+func generateGameID() (string, error) {
+	buffer := make([]byte, 8)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
@@ -0,0 +1,159 @@
+package game
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/game/message"
+)
+
+// newTestConn returns a game connection along with the client's end of its websocket.
+func newTestConn(t *testing.T) (*Conn, *websocket.Conn) {
+	t.Helper()
+	serverConns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serverConns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return NewWSConn(<-serverConns, nil), client
+}
+
+// newTestGame adds a game to a set without starting its loop so that the loop's steps can be run
+// directly.
+func newTestGame(set *TriviaGamesSet, gameID string, options *TriviaGameOptions) *TriviaGame {
+	g := &TriviaGame{
+		ID:                  gameID,
+		OwningSet:           set,
+		pendingClients:      make([]*Conn, 0),
+		clients:             make(map[int64]*TriviaGameClient),
+		disconnectedClients: make(map[int64]*TriviaGameClient),
+		endGameChan:         make(chan string, 1),
+		MsgPendingCond:      &sync.Cond{L: &sync.Mutex{}},
+		options:             options,
+		currentQuestion:     -1,
+		participantsList:    message.ParticipantsList{Participants: make([]message.Participant, 0)},
+		gameTickTimer:       time.NewTimer(time.Hour),
+	}
+	set.games[gameID] = &TriviaGameSetGame{Game: g, MaxParticipants: options.MaxParticipants}
+	return g
+}
+
+// addTestClient adds a participant with a connection to a game and returns the client's end of it.
+func addTestClient(t *testing.T, g *TriviaGame, user *trivia.User) *websocket.Conn {
+	t.Helper()
+	conn, remote := newTestConn(t)
+	client := &TriviaGameClient{User: user, Conn: conn, Participant: true}
+	g.clients[user.ID] = client
+	g.addParticipantToList(client)
+	g.participantsCount++
+	return remote
+}
+
+// expectGameEnded checks that a client was sent the reason that its game ended and was then
+// disconnected.
+func expectGameEnded(t *testing.T, remote *websocket.Conn, reason string) {
+	t.Helper()
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	_, b, err := remote.ReadMessage()
+	if err != nil {
+		t.Fatalf("expected the client to be sent the end of the game but got %s", err)
+	}
+	if expected := string(message.MustEncodeBytes(&message.GameEnded{Reason: reason})); string(b) != expected {
+		t.Errorf("expected the client to be sent %s but got %s", expected, b)
+	}
+
+	if _, _, err = remote.ReadMessage(); err == nil {
+		t.Errorf("expected the client to be disconnected after the game ended")
+	}
+}
+
+func TestEndGame(t *testing.T) {
+	set := NewGameSet(nil, nil)
+	g := newTestGame(set, "game", &TriviaGameOptions{MaxParticipants: 4})
+
+	if err := set.EndGame("missing", "reason"); err != ErrGameNotFound {
+		t.Errorf("expected ErrGameNotFound for a game that doesn't exist but got %v", err)
+	}
+	if err := set.EndGame("game", "Cheating."); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.EndGame("game", "Ended twice."); err != nil {
+		t.Fatal(err)
+	}
+
+	// the game loop ends the game, and only the first reason is kept.
+	select {
+	case reason := <-g.endGameChan:
+		if reason != "Cheating." {
+			t.Errorf("expected the game to be ended with the first reason but got %q", reason)
+		}
+	default:
+		t.Fatalf("expected ending the game to be queued for its loop")
+	}
+}
+
+func TestEndRemovesGame(t *testing.T) {
+	set := NewGameSet(nil, nil)
+	g := newTestGame(set, "game", &TriviaGameOptions{MaxParticipants: 4, RemoveOnFinish: true})
+	first := addTestClient(t, g, &trivia.User{ID: 1, Username: "first"})
+	second := addTestClient(t, g, &trivia.User{ID: 2, Username: "second"})
+	pending, pendingRemote := newTestConn(t)
+	g.pendingClients = append(g.pendingClients, pending)
+
+	if !g.end("Cheating.") {
+		t.Errorf("expected the loop of a game that is removed when it finishes to stop")
+	}
+	expectGameEnded(t, first, "Cheating.")
+	expectGameEnded(t, second, "Cheating.")
+	expectGameEnded(t, pendingRemote, "Cheating.")
+
+	if set.LiveGameByID("game") != nil {
+		t.Errorf("expected the game to be removed from its set")
+	}
+	if len(g.clients) != 0 || len(g.pendingClients) != 0 {
+		t.Errorf("expected the game's clients to be removed")
+	}
+}
+
+func TestEndResetsGame(t *testing.T) {
+	set := NewGameSet(nil, nil)
+	g := newTestGame(set, "game", &TriviaGameOptions{MaxParticipants: 4})
+	remote := addTestClient(t, g, &trivia.User{ID: 1, Username: "player"})
+	g.currentState = gameStateQuestion
+	g.updateSetParticipation()
+
+	if g.end("Cheating.") {
+		t.Errorf("expected the loop of a game that isn't removed when it finishes to keep running")
+	}
+	expectGameEnded(t, remote, "Cheating.")
+
+	live := set.LiveGameByID("game")
+	if live == nil {
+		t.Fatalf("expected the game to stay in its set")
+	}
+	if live.ParticipantsCount != 0 || live.ParticipationClosed {
+		t.Errorf("expected the game to be open to new participants but got %+v", live)
+	}
+	if g.currentState != gameStateWaitForStart || len(g.clients) != 0 || len(g.participantsList.Participants) != 0 {
+		t.Errorf("expected the game to start over empty")
+	}
+}
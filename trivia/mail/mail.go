@@ -0,0 +1,137 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	netmail "net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/eplog"
+)
+
+var logger = eplog.NewPrefixLogger("mail")
+
+// ErrInvalidHeader is returned when a message's recipient or subject contains a line break, which
+// would let it add its own headers to the message.
+var ErrInvalidHeader = errors.New("mail: header values cannot contain line breaks")
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// A Mailer sends emails.
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// format formats a message with the headers needed to send it.
+func format(from string, msg *Message, date time.Time) ([]byte, error) {
+	for _, v := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+
+	// lines in the body must end with CRLF.
+	body := strings.Replace(msg.Body, "\r\n", "\n", -1)
+	buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return buf.Bytes(), nil
+}
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+
+	// sender is the bare address in from that is given to the server as the sender.
+	sender string
+}
+
+func (m *smtpMailer) Send(msg *Message) error {
+	data, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.sender, []string{msg.To}, data)
+}
+
+// NewSMTPMailer creates a mailer that sends emails from the given address through an SMTP server.
+// addr is the host and port of the server. The server is only authenticated with if a username is given.
+func NewSMTPMailer(addr string, username string, password string, from string) Mailer {
+	m := &smtpMailer{addr: addr, from: from, sender: from}
+	if parsed, err := netmail.ParseAddress(from); err == nil {
+		m.sender = parsed.Address
+	}
+	if username != "" {
+		host := addr
+		if idx := strings.LastIndex(addr, ":"); idx >= 0 {
+			host = addr[:idx]
+		}
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// unsafeFileChars matches the characters that are replaced in the names of outbox files.
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]+`)
+
+type outboxMailer struct {
+	dir  string
+	from string
+
+	// lock guards count.
+	lock  sync.Mutex
+	count int
+}
+
+func (m *outboxMailer) Send(msg *Message) error {
+	data, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if m.dir == "" {
+		logger.Info("outbox:\n%s", data)
+		return nil
+	}
+
+	// the count keeps messages sent at the same time from overwriting each other.
+	m.lock.Lock()
+	m.count++
+	count := m.count
+	m.lock.Unlock()
+
+	name := fmt.Sprintf("%d-%d-%s.eml", time.Now().UnixNano(), count, unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	return ioutil.WriteFile(filepath.Join(m.dir, name), data, 0600)
+}
+
+// NewOutboxMailer creates a mailer for local development and tests that never actually sends
+// anything. Messages are written to files in dir, or to the log if dir is empty.
+func NewOutboxMailer(dir string, from string) (Mailer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &outboxMailer{dir: dir, from: from}, nil
+}
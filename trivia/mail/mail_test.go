@@ -0,0 +1,70 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	date := time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC)
+	data, err := format("trivia@example.com", &Message{To: "user@example.com", Subject: "Hello", Body: "line one\nline two"}, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "From: trivia@example.com\r\n" +
+		"To: user@example.com\r\n" +
+		"Subject: Hello\r\n" +
+		"Date: Sun, 04 Mar 2018 05:06:07 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		"line one\r\nline two"
+	if string(data) != expected {
+		t.Errorf("unexpected message:\n%q", data)
+	}
+}
+
+func TestFormatRejectsHeaderInjection(t *testing.T) {
+	_, err := format("trivia@example.com", &Message{To: "user@example.com\r\nBcc: other@example.com", Subject: "Hello"}, time.Now())
+	if err != ErrInvalidHeader {
+		t.Errorf("expected ErrInvalidHeader but got %v", err)
+	}
+}
+
+func TestOutboxMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mailer, err := NewOutboxMailer(dir, "trivia@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = mailer.Send(&Message{To: "user@example.com", Subject: "Hello", Body: "body"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 messages in the outbox but found %d", len(files))
+	}
+
+	data, err := ioutil.ReadFile(dir + "/" + files[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "To: user@example.com\r\n") {
+		t.Errorf("expected the outbox file to contain the message but got %q", data)
+	}
+}
@@ -0,0 +1,161 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// minKeyRefetchInterval is how long to wait before fetching a provider's keys again when a token is
+// signed with a key that isn't known. Providers rotate their keys rarely, so this mostly stops
+// tokens with made up key IDs from causing a request to the provider every time.
+const minKeyRefetchInterval = time.Minute
+
+// jsonWebKey is a public key from a provider's JWKS document.
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+
+	// RSA keys:
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC keys:
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// publicKey is a parsed key from a provider.
+type publicKey struct {
+	id        string
+	algorithm string
+	key       crypto.PublicKey
+}
+
+// keySet is the set of keys that a provider signs tokens with.
+type keySet struct {
+	keys      []publicKey
+	fetchedAt time.Time
+}
+
+// find returns the key with the given ID that can be used with an algorithm. If the token has no
+// key ID then the only key that can be used with the algorithm is returned.
+func (s *keySet) find(id string, algorithm string) crypto.PublicKey {
+	var found crypto.PublicKey
+	matches := 0
+	for _, k := range s.keys {
+		if k.algorithm != algorithm || (id != "" && k.id != id) {
+			continue
+		}
+		found = k.key
+		matches++
+	}
+	if matches != 1 {
+		return nil
+	}
+	return found
+}
+
+// key returns the provider's key for a token, fetching the provider's keys if they haven't been
+// fetched yet or if the key isn't known.
+func (p *Provider) key(id string, algorithm string) (crypto.PublicKey, error) {
+	m, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.keys != nil {
+		if key := p.keys.find(id, algorithm); key != nil {
+			return key, nil
+		}
+		if p.now().Sub(p.keys.fetchedAt) < minKeyRefetchInterval {
+			return nil, invalidIDToken("signed with unknown key %q", id)
+		}
+	}
+
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = p.getJSON(m.JWKSURI, &document); err != nil {
+		return nil, err
+	}
+
+	keys := &keySet{fetchedAt: p.now()}
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// keys that can't be used are skipped so that one unusual key doesn't stop logins.
+		if parsed, err := parseKey(jwk); err == nil {
+			keys.keys = append(keys.keys, parsed)
+		}
+	}
+	p.keys = keys
+
+	if key := keys.find(id, algorithm); key != nil {
+		return key, nil
+	}
+	return nil, invalidIDToken("signed with unknown key %q", id)
+}
+
+var errUnsupportedKey = errors.New("oidc: unsupported key")
+
+// parseKey parses an RSA or P-256 key from a JWKS document.
+func parseKey(jwk jsonWebKey) (publicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		if jwk.Algorithm != "" && jwk.Algorithm != "RS256" {
+			return publicKey{}, errUnsupportedKey
+		}
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return publicKey{}, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return publicKey{}, errUnsupportedKey
+		}
+		return publicKey{id: jwk.KeyID, algorithm: "RS256", key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if jwk.Curve != "P-256" || (jwk.Algorithm != "" && jwk.Algorithm != "ES256") {
+			return publicKey{}, errUnsupportedKey
+		}
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return publicKey{}, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return publicKey{}, err
+		}
+		curve := elliptic.P256()
+		if !curve.IsOnCurve(x, y) {
+			return publicKey{}, errUnsupportedKey
+		}
+		return publicKey{id: jwk.KeyID, algorithm: "ES256", key: &ecdsa.PublicKey{Curve: curve, X: x, Y: y}}, nil
+	}
+	return publicKey{}, errUnsupportedKey
+}
+
+// decodeInt decodes a base64url encoded big-endian integer.
+func decodeInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errUnsupportedKey
+	}
+	return new(big.Int).SetBytes(data), nil
+}
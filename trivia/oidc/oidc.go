@@ -0,0 +1,281 @@
+// Package oidc implements the parts of OpenID Connect that are needed for logging users in with an
+// external provider: discovery, the authorization code flow with PKCE, and ID token validation.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultScopes are the scopes requested when a provider has none configured.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// clockLeeway is how far the clocks of the server and a provider can be apart before the times in
+// an ID token are considered invalid.
+const clockLeeway = time.Minute
+
+// maxResponseSize is the largest response that is read from a provider.
+const maxResponseSize = 1 << 20
+
+// ErrInvalidGrant is returned when a provider rejects an authorization code, usually because it was
+// already used or has expired.
+var ErrInvalidGrant = errors.New("oidc: authorization code was rejected")
+
+// ErrInvalidIDToken is returned when an ID token can't be trusted. The returned errors wrap this
+// error with the reason, so errors.Is should be used to check for it.
+var ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+
+// invalidIDToken returns an ErrInvalidIDToken with a reason.
+func invalidIDToken(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidIDToken, fmt.Sprintf(format, args...))
+}
+
+// Config is the configuration of a single OpenID Connect provider.
+type Config struct {
+	// Name identifies the provider in URLs and in stored identities, so it shouldn't change once
+	// users have logged in with the provider.
+	Name string
+
+	// Issuer is the issuer URL of the provider. The provider's endpoints are discovered from
+	// Issuer + "/.well-known/openid-configuration".
+	Issuer string
+
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the page that the provider sends users back to after they log in.
+	RedirectURL string
+
+	// Scopes are the scopes that are requested. DefaultScopes are used if this is empty.
+	Scopes []string
+}
+
+// metadata is the part of a provider's discovery document that is used.
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Provider logs users in with an OpenID Connect provider. The provider's endpoints and keys are
+// fetched the first time that they are needed.
+type Provider struct {
+	config Config
+	client *http.Client
+	now    func() time.Time
+
+	// lock guards metadata and keys.
+	lock     *sync.Mutex
+	metadata *metadata
+	keys     *keySet
+}
+
+// NewProvider creates a provider that makes its requests with the given HTTP client.
+func NewProvider(config Config, client *http.Client) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = DefaultScopes
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	return &Provider{
+		config: config,
+		client: client,
+		now:    time.Now,
+		lock:   &sync.Mutex{},
+	}
+}
+
+// Name returns the name of the provider.
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// discover returns the provider's metadata, fetching it if it hasn't been yet.
+func (p *Provider) discover() (*metadata, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var m metadata
+	if err := p.getJSON(p.config.Issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("oidc: provider %s claims to be the issuer %s", p.config.Issuer, m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovery document of %s is missing endpoints", p.config.Issuer)
+	}
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// getJSON fetches a JSON document from the provider.
+func (p *Provider) getJSON(u string, target interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s returned status %d", u, resp.StatusCode)
+	}
+	return json.Unmarshal(body, target)
+}
+
+// AuthRequest is a login that was started with a provider. State, Verifier, and Nonce have to be
+// kept until the user comes back from the provider so that the login can be finished.
+type AuthRequest struct {
+	// URL is the provider's page that the user should be sent to.
+	URL string
+
+	// State is sent back along with the authorization code and identifies the login.
+	State string
+
+	// Verifier is the PKCE code verifier that the authorization code is exchanged with.
+	Verifier string
+
+	// Nonce is included in the ID token so that it can't be replayed for another login.
+	Nonce string
+}
+
+// NewAuthRequest starts a login with the provider.
+func (p *Provider) NewAuthRequest() (*AuthRequest, error) {
+	m, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &AuthRequest{}
+	for _, s := range []*string{&req.State, &req.Verifier, &req.Nonce} {
+		if *s, err = randomString(); err != nil {
+			return nil, err
+		}
+	}
+
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", req.State)
+	query.Set("nonce", req.Nonce)
+	query.Set("code_challenge", CodeChallenge(req.Verifier))
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+	req.URL = u.String()
+	return req, nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge of a code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomString generates a random string for states, nonces, and code verifiers.
+func randomString() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange exchanges an authorization code for an ID token and returns the token's claims once it
+// has been validated. verifier and nonce are the ones from the AuthRequest that the code is for.
+// This returns ErrInvalidGrant if the provider rejects the code or an error wrapping
+// ErrInvalidIDToken if the ID token isn't valid.
+func (p *Provider) Exchange(code string, verifier string, nonce string) (*Claims, error) {
+	m, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.config.ClientID)
+
+	// client_secret_basic is the default when a provider doesn't say what it supports.
+	useBasic := len(m.TokenAuthMethods) == 0 || contains(m.TokenAuthMethods, "client_secret_basic")
+	if !useBasic {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequest("POST", m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var token tokenResponse
+	if err = json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("oidc: token endpoint returned status %d and invalid JSON", resp.StatusCode)
+	}
+	if token.Error == "invalid_grant" {
+		return nil, ErrInvalidGrant
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("oidc: token endpoint returned status %d: %s %s", resp.StatusCode, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, invalidIDToken("token response has no ID token")
+	}
+	return p.VerifyIDToken(token.IDToken, nonce)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
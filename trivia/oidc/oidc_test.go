@@ -0,0 +1,199 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia/oidc/oidctest"
+)
+
+var testIdentity = oidctest.Identity{
+	Subject:           "248289761001",
+	Email:             "jane@example.com",
+	EmailVerified:     true,
+	Name:              "Jane Doe",
+	PreferredUsername: "jane",
+}
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Provider) {
+	t.Helper()
+	mock := oidctest.NewProvider("trivia-client", "trivia secret")
+	t.Cleanup(mock.Close)
+
+	p := NewProvider(Config{
+		Name:         "mock",
+		Issuer:       mock.Issuer(),
+		ClientID:     "trivia-client",
+		ClientSecret: "trivia secret",
+		RedirectURL:  "https://trivia.example.com/login/mock",
+	}, &http.Client{Timeout: 5 * time.Second})
+	return p, mock
+}
+
+func TestLoginFlow(t *testing.T) {
+	p, mock := newTestProvider(t)
+
+	req, err := p.NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge := u.Query().Get("code_challenge"); challenge != CodeChallenge(req.Verifier) {
+		t.Errorf("expected the authorization URL to contain the code challenge but got %q", challenge)
+	}
+
+	code, state, err := mock.Authorize(req.URL, testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != req.State {
+		t.Errorf("expected the provider to send back state %q but got %q", req.State, state)
+	}
+
+	claims, err := p.Exchange(code, req.Verifier, req.Nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != testIdentity.Subject || claims.Email != testIdentity.Email || !bool(claims.EmailVerified) {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	if _, err = p.Exchange(code, req.Verifier, req.Nonce); err != ErrInvalidGrant {
+		t.Errorf("expected a code to only work once but got %v", err)
+	}
+}
+
+func TestExchangeRequiresVerifier(t *testing.T) {
+	p, mock := newTestProvider(t)
+
+	req, err := p.NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _, err := mock.Authorize(req.URL, testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// someone who intercepted the code doesn't have the verifier.
+	if _, err = p.Exchange(code, "stolen code", req.Nonce); err != ErrInvalidGrant {
+		t.Errorf("expected ErrInvalidGrant but got %v", err)
+	}
+}
+
+func TestVerifyIDTokenRejectsInvalidTokens(t *testing.T) {
+	p, mock := newTestProvider(t)
+	if _, err := p.VerifyIDToken(mock.SignIDToken(mock.Claims(testIdentity, "nonce")), "nonce"); err != nil {
+		t.Fatalf("expected a valid token to be accepted but got %v", err)
+	}
+
+	modified := func(key string, value interface{}) string {
+		claims := mock.Claims(testIdentity, "nonce")
+		claims[key] = value
+		return mock.SignIDToken(claims)
+	}
+
+	valid := mock.SignIDToken(mock.Claims(testIdentity, "nonce"))
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"someone else"}`)) + "." + parts[2]
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+
+	tokens := map[string]string{
+		"wrong audience":   modified("aud", "another-client"),
+		"wrong issuer":     modified("iss", "https://evil.example.com"),
+		"expired":          modified("exp", time.Now().Add(-time.Hour).Unix()),
+		"issued in future": modified("iat", time.Now().Add(time.Hour).Unix()),
+		"wrong nonce":      modified("nonce", "another nonce"),
+		"no subject":       modified("sub", ""),
+		"tampered":         tampered,
+		"unsigned":         unsigned,
+		"not a JWT":        "not.a-jwt",
+	}
+	for name, token := range tokens {
+		if _, err := p.VerifyIDToken(token, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: expected ErrInvalidIDToken but got %v", name, err)
+		}
+	}
+}
+
+func TestVerifyIDTokenAudienceArray(t *testing.T) {
+	p, mock := newTestProvider(t)
+
+	claims := mock.Claims(testIdentity, "nonce")
+	claims["aud"] = []string{"trivia-client", "another-client"}
+	if _, err := p.VerifyIDToken(mock.SignIDToken(claims), "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("expected a token with several audiences and no azp to be rejected but got %v", err)
+	}
+
+	claims["azp"] = "trivia-client"
+	if _, err := p.VerifyIDToken(mock.SignIDToken(claims), "nonce"); err != nil {
+		t.Errorf("expected a token authorized for this client to be accepted but got %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	p, mock := newTestProvider(t)
+	if _, err := p.VerifyIDToken(mock.SignIDToken(mock.Claims(testIdentity, "nonce")), "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.RotateKey()
+	rotated := mock.SignIDToken(mock.Claims(testIdentity, "nonce"))
+
+	// the keys were just fetched so they aren't fetched again right away.
+	if _, err := p.VerifyIDToken(rotated, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("expected the new key to be unknown until the keys can be fetched again but got %v", err)
+	}
+
+	p.now = func() time.Time { return time.Now().Add(minKeyRefetchInterval + time.Second) }
+	if _, err := p.VerifyIDToken(rotated, "nonce"); err != nil {
+		t.Errorf("expected the keys to be fetched again for a new key but got %v", err)
+	}
+	if mock.JWKSRequests != 2 {
+		t.Errorf("expected the keys to be fetched twice but they were fetched %d times", mock.JWKSRequests)
+	}
+}
+
+func TestES256Signatures(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := parseKey(jsonWebKey{
+		KeyType: "EC",
+		Curve:   "P-256",
+		X:       base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:       base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte("header.payload"))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	if !verifySignature(parsed.key, digest[:], signature) {
+		t.Errorf("expected a valid ES256 signature to be accepted")
+	}
+	signature[0] ^= 1
+	if verifySignature(parsed.key, digest[:], signature) {
+		t.Errorf("expected a modified ES256 signature to be rejected")
+	}
+}
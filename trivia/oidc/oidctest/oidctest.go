@@ -0,0 +1,268 @@
+// Package oidctest provides a local OpenID Connect provider for tests.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Identity is the user that logs in at the provider.
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// grant is an authorization code that was issued but not exchanged yet.
+type grant struct {
+	identity      Identity
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+}
+
+// Provider is an OpenID Connect provider that runs on a local test server. Logging in at the
+// provider is simulated with Authorize instead of going through a browser.
+type Provider struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	// KeyID is the ID of the key that tokens are currently signed with.
+	KeyID string
+
+	lock   *sync.Mutex
+	keys   map[string]*rsa.PrivateKey
+	grants map[string]*grant
+
+	// JWKSRequests is the number of times that the provider's keys have been fetched.
+	JWKSRequests int
+}
+
+// NewProvider starts a provider that accepts the given client credentials.
+func NewProvider(clientID string, clientSecret string) *Provider {
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		lock:         &sync.Mutex{},
+		keys:         make(map[string]*rsa.PrivateKey),
+		grants:       make(map[string]*grant),
+	}
+	p.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Issuer returns the issuer URL of the provider.
+func (p *Provider) Issuer() string {
+	return p.Server.URL
+}
+
+// Close shuts the provider down.
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+// RotateKey generates a new signing key. Tokens signed with the old keys stay valid.
+func (p *Provider) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.KeyID = fmt.Sprintf("key-%d", len(p.keys)+1)
+	p.keys[p.KeyID] = key
+}
+
+// Authorize simulates a user logging in at the provider with the authorization URL that a client
+// sent them to. This returns the authorization code and state that the provider would send the user
+// back to the client with.
+func (p *Provider) Authorize(authURL string, identity Identity) (code string, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasPrefix(authURL, p.Issuer()+"/authorize") {
+		return "", "", errors.New("oidctest: not an authorization URL of this provider")
+	}
+
+	query := u.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != p.ClientID {
+		return "", "", errors.New("oidctest: invalid authorization request")
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		return "", "", errors.New("oidctest: authorization request is missing a PKCE challenge")
+	}
+	if !strings.Contains(" "+query.Get("scope")+" ", " openid ") {
+		return "", "", errors.New("oidctest: authorization request is missing the openid scope")
+	}
+
+	code = randomString()
+	p.lock.Lock()
+	p.grants[code] = &grant{
+		identity:      identity,
+		redirectURI:   query.Get("redirect_uri"),
+		codeChallenge: query.Get("code_challenge"),
+		nonce:         query.Get("nonce"),
+	}
+	p.lock.Unlock()
+	return code, query.Get("state"), nil
+}
+
+// Claims returns the claims of an ID token for an identity.
+func (p *Provider) Claims(identity Identity, nonce string) map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            p.Issuer(),
+		"sub":            identity.Subject,
+		"aud":            p.ClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email_verified": identity.EmailVerified,
+	}
+	if identity.Email != "" {
+		claims["email"] = identity.Email
+	}
+	if identity.Name != "" {
+		claims["name"] = identity.Name
+	}
+	if identity.PreferredUsername != "" {
+		claims["preferred_username"] = identity.PreferredUsername
+	}
+	return claims
+}
+
+// SignIDToken signs an ID token with the given claims using the current key.
+func (p *Provider) SignIDToken(claims map[string]interface{}) string {
+	p.lock.Lock()
+	keyID := p.KeyID
+	key := p.keys[keyID]
+	p.lock.Unlock()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.Issuer() + "/authorize",
+		"token_endpoint":                        p.Issuer() + "/token",
+		"jwks_uri":                              p.Issuer() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.JWKSRequests++
+
+	keys := make([]map[string]string, 0, len(p.keys))
+	for id, key := range p.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": id,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	}
+	if !ok || clientID != p.ClientID || clientSecret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	// codes can only be used once.
+	code := r.PostFormValue("code")
+	p.lock.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.lock.Unlock()
+
+	if !ok || g.redirectURI != r.PostFormValue("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	verifier := r.PostFormValue("code_verifier")
+	sum := sha256.Sum256([]byte(verifier))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     p.SignIDToken(p.Claims(g.identity, g.nonce)),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+func randomString() string {
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer)
+}
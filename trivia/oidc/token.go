@@ -0,0 +1,167 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Claims are the claims of a validated ID token that are used to identify users.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	Nonce     string   `json:"nonce"`
+
+	// AuthorizedParty is the client that the token was issued to if it has more than one audience.
+	AuthorizedParty string `json:"azp"`
+
+	Email             string `json:"email"`
+	EmailVerified     flag   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// audience is the aud claim, which is either a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = audience(multiple)
+	return nil
+}
+
+// flag is a boolean claim. Some providers send booleans as the strings "true" and "false".
+type flag bool
+
+func (f *flag) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*f = flag(b)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*f = flag(s == "true")
+	return nil
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// VerifyIDToken validates an ID token issued by the provider and returns its claims. The token has
+// to be signed by one of the provider's keys, be issued to this client, not be expired, and contain
+// the given nonce. This returns an error wrapping ErrInvalidIDToken if the token isn't valid.
+func (p *Provider) VerifyIDToken(token string, nonce string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalidIDToken("token is not a signed JWT")
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalidIDToken("malformed header")
+	}
+	if header.Algorithm != "RS256" && header.Algorithm != "ES256" {
+		return nil, invalidIDToken("unsupported signing algorithm %q", header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidIDToken("malformed signature")
+	}
+
+	key, err := p.key(header.KeyID, header.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(key, digest[:], signature) {
+		return nil, invalidIDToken("signature does not match")
+	}
+
+	var claims Claims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalidIDToken("malformed claims")
+	}
+	if err = p.checkClaims(&claims, nonce); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// checkClaims makes sure that the claims of a token with a valid signature are meant for this login.
+func (p *Provider) checkClaims(claims *Claims, nonce string) error {
+	if strings.TrimSuffix(claims.Issuer, "/") != p.config.Issuer {
+		return invalidIDToken("issued by %s", claims.Issuer)
+	}
+	if claims.Subject == "" {
+		return invalidIDToken("no subject")
+	}
+	if !contains(claims.Audience, p.config.ClientID) {
+		return invalidIDToken("not issued to this client")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return invalidIDToken("authorized party is %q", claims.AuthorizedParty)
+	}
+
+	now := p.now()
+	if now.Add(-clockLeeway).After(time.Unix(claims.ExpiresAt, 0)) {
+		return invalidIDToken("expired")
+	}
+	if now.Add(clockLeeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return invalidIDToken("issued in the future")
+	}
+	if claims.Nonce != nonce {
+		return invalidIDToken("nonce does not match")
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a JWT.
+func decodeSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// verifySignature checks the signature of a SHA-256 digest.
+func verifySignature(key crypto.PublicKey, digest []byte, signature []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// ES256 signatures are the two 32 byte integers r and s one after the other.
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
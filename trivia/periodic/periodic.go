@@ -0,0 +1,62 @@
+package periodic
+
+import (
+	"sync"
+	"time"
+)
+
+// Runner calls a function every interval on its own goroutine until it is stopped.
+type Runner struct {
+	interval  time.Duration
+	immediate bool
+	run       func()
+	stopChan  chan bool
+	wg        *sync.WaitGroup
+}
+
+// NewRunner creates a runner that calls run every interval once it is started. If immediate is
+// true run is also called as soon as the runner is started instead of waiting for the first interval.
+func NewRunner(interval time.Duration, immediate bool, run func()) *Runner {
+	return &Runner{
+		interval:  interval,
+		immediate: immediate,
+		run:       run,
+		stopChan:  make(chan bool),
+		wg:        &sync.WaitGroup{},
+	}
+}
+
+// Start starts calling the function on its own goroutine.
+func (r *Runner) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		if r.immediate {
+			r.run()
+		}
+		for {
+			select {
+			case <-ticker.C:
+				r.run()
+			case <-r.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the runner and waits for the function to return if it is running.
+func (r *Runner) Stop() {
+	close(r.stopChan)
+	r.wg.Wait()
+}
+
+// Stopping returns a channel that is closed once the runner is stopped, so that a function that
+// takes a while can return early.
+func (r *Runner) Stopping() <-chan bool {
+	return r.stopChan
+}
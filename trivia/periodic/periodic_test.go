@@ -0,0 +1,43 @@
+package periodic
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunnerImmediate(t *testing.T) {
+	var runs int32
+	r := NewRunner(time.Hour, true, func() { atomic.AddInt32(&runs, 1) })
+	r.Start()
+	r.Stop()
+
+	// Stop waits for the goroutine, which always runs once before it checks for the stop.
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("expected the function to run once when the runner started but it ran %d times", n)
+	}
+
+	select {
+	case <-r.Stopping():
+	default:
+		t.Errorf("expected Stopping to be closed once the runner is stopped")
+	}
+}
+
+func TestRunnerInterval(t *testing.T) {
+	ran := make(chan bool, 1)
+	r := NewRunner(time.Millisecond, false, func() {
+		select {
+		case ran <- true:
+		default:
+		}
+	})
+	r.Start()
+	defer r.Stop()
+
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the function to run every interval")
+	}
+}
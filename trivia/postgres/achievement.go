@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/lib/pq"
+)
+
+type achievementService struct {
+	db *sql.DB
+}
+
+func (s *achievementService) AwardAchievements(userID int64, achievementIDs []string, awardedAt time.Time) ([]string, error) {
+	// achievements the user already has are skipped by the conflict clause so only new ones are returned.
+	rows, err := s.db.Query(`
+		INSERT INTO user_achievements (user_id, achievement_id, awarded_at)
+		SELECT $1, a, $3 FROM unnest($2::varchar[]) a
+		ON CONFLICT (user_id, achievement_id) DO NOTHING
+		RETURNING achievement_id;
+	`, userID, pq.Array(achievementIDs), awardedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	awarded := make([]string, 0, len(achievementIDs))
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		awarded = append(awarded, id)
+	}
+	return awarded, rows.Err()
+}
+
+func (s *achievementService) UserBadges(userID int64) ([]trivia.Badge, error) {
+	rows, err := s.db.Query(`
+		SELECT achievement_id, awarded_at
+		FROM user_achievements
+		WHERE user_id = $1
+		ORDER BY awarded_at ASC, achievement_id ASC;
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	badges := make([]trivia.Badge, 0)
+	for rows.Next() {
+		var b trivia.Badge
+		if err = rows.Scan(&b.AchievementID, &b.AwardedAt); err != nil {
+			return nil, err
+		}
+		badges = append(badges, b)
+	}
+	return badges, rows.Err()
+}
+
+// NewAchievementService creates a new service for storing awarded achievements in postgres.
+func NewAchievementService(db *sql.DB) trivia.AchievementService {
+	return &achievementService{db: db}
+}
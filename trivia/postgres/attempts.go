@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+type loginAttemptService struct {
+	db *sql.DB
+}
+
+func (s *loginAttemptService) LoginAttempts(key string) (*trivia.LoginAttempts, error) {
+	attempts := &trivia.LoginAttempts{Key: key}
+	err := s.db.QueryRow(`
+		SELECT failures, last_failure FROM login_attempts WHERE key = $1;
+	`, key).Scan(&attempts.Failures, &attempts.LastFailure)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return attempts, nil
+}
+
+func (s *loginAttemptService) RecordLoginAttempt(key string, at time.Time, resetAfter time.Duration,
+	allow func(current trivia.LoginAttempts) bool) (*trivia.LoginAttempts, bool, error) {
+	attempts := &trivia.LoginAttempts{Key: key}
+	allowed := false
+	err := transact(s.db, func(tx *sql.Tx) error {
+		// the row is created first so that it can be locked, which makes logins on different servers
+		// wait for each other instead of all being allowed before any of them is counted.
+		_, err := tx.Exec(`
+			INSERT INTO login_attempts (key, failures, last_failure) VALUES ($1, 0, $2)
+			ON CONFLICT (key) DO NOTHING;
+		`, key, at)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(`
+			SELECT failures, last_failure FROM login_attempts WHERE key = $1 FOR UPDATE;
+		`, key).Scan(&attempts.Failures, &attempts.LastFailure)
+		if err != nil {
+			return err
+		}
+		if attempts.LastFailure.Before(at.Add(-resetAfter)) {
+			attempts.Failures = 0
+		}
+		if !allow(*attempts) {
+			return nil
+		}
+
+		allowed = true
+		return tx.QueryRow(`
+			UPDATE login_attempts SET failures = $2, last_failure = GREATEST(last_failure, $3)
+			WHERE key = $1
+			RETURNING failures, last_failure;
+		`, key, attempts.Failures+1, at).Scan(&attempts.Failures, &attempts.LastFailure)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return attempts, allowed, nil
+}
+
+func (s *loginAttemptService) UndoLoginFailure(key string) error {
+	_, err := s.db.Exec(`
+		UPDATE login_attempts SET failures = failures - 1 WHERE key = $1 AND failures > 0;
+	`, key)
+	return err
+}
+
+func (s *loginAttemptService) ClearLoginFailures(key string) error {
+	_, err := s.db.Exec(`DELETE FROM login_attempts WHERE key = $1;`, key)
+	return err
+}
+
+func (s *loginAttemptService) DeleteStaleLoginAttempts(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM login_attempts WHERE last_failure < $1;`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// NewLoginAttemptService creates a new service for counting failed logins in postgres. The counts
+// are shared by every server that uses the same database.
+func NewLoginAttemptService(db *sql.DB) trivia.LoginAttemptService {
+	return &loginAttemptService{db: db}
+}
@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+type dailyService struct {
+	db        *sql.DB
+	questions trivia.QuestionService
+}
+
+func (s *dailyService) DailyChallengeForDay(day time.Time) (*trivia.DailyChallenge, error) {
+	var questionIDsRaw string
+	err := s.db.QueryRow(`SELECT question_ids FROM daily_challenges WHERE day = $1;`, day).Scan(&questionIDsRaw)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	questionIDs := make([]int64, 0)
+	if err = json.Unmarshal([]byte(questionIDsRaw), &questionIDs); err != nil {
+		return nil, err
+	}
+
+	questions, err := s.questions.QuestionsByIDs(questionIDs)
+	if err != nil {
+		return nil, err
+	}
+	return &trivia.DailyChallenge{Day: day, Questions: questions}, nil
+}
+
+func (s *dailyService) CreateDailyChallenge(day time.Time, questionIDs []int64) error {
+	questionIDsRaw, err := json.Marshal(questionIDs)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO daily_challenges (day, question_ids) VALUES ($1, $2)
+		ON CONFLICT (day) DO NOTHING;
+	`, day, string(questionIDsRaw))
+	return err
+}
+
+func (s *dailyService) DailyAttempt(day time.Time, userID int64) (*trivia.DailyAttempt, error) {
+	attempt := &trivia.DailyAttempt{Day: day, UserID: userID}
+	err := s.db.QueryRow(`
+		SELECT score, correct_answers, finished, started_at, finished_at
+		FROM daily_attempts
+		WHERE day = $1 AND user_id = $2;
+	`, day, userID).Scan(&attempt.Score, &attempt.CorrectAnswers, &attempt.Finished, &attempt.StartedAt, &attempt.FinishedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return attempt, nil
+}
+
+func (s *dailyService) StartDailyAttempt(day time.Time, userID int64) (bool, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO daily_attempts (day, user_id) VALUES ($1, $2)
+		ON CONFLICT (day, user_id) DO NOTHING;
+	`, day, userID)
+	if err != nil {
+		return false, err
+	}
+
+	aff, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return aff > 0, nil
+}
+
+func (s *dailyService) FinishDailyAttempt(attempt *trivia.DailyAttempt) error {
+	return transact(s.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE daily_attempts
+			SET score = $3, correct_answers = $4, finished = $5, finished_at = now()
+			WHERE day = $1 AND user_id = $2;
+		`, attempt.Day, attempt.UserID, attempt.Score, attempt.CorrectAnswers, attempt.Finished)
+		if err != nil {
+			return err
+		}
+
+		// the streak continues if the user's last attempt was the day before this one.
+		_, err = tx.Exec(`
+			INSERT INTO daily_streaks (user_id, current_streak, longest_streak, last_day)
+			VALUES ($1, 1, 1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET
+				current_streak = CASE
+					WHEN daily_streaks.last_day = $2::date THEN daily_streaks.current_streak
+					WHEN daily_streaks.last_day = $2::date - 1 THEN daily_streaks.current_streak + 1
+					ELSE 1
+				END,
+				longest_streak = GREATEST(daily_streaks.longest_streak, CASE
+					WHEN daily_streaks.last_day = $2::date THEN daily_streaks.current_streak
+					WHEN daily_streaks.last_day = $2::date - 1 THEN daily_streaks.current_streak + 1
+					ELSE 1
+				END),
+				last_day = $2;
+		`, attempt.UserID, attempt.Day)
+		return err
+	})
+}
+
+func (s *dailyService) DailyLeaderboard(day time.Time, limit int, offset int) ([]trivia.DailyLeaderboardEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT
+			rank() OVER (ORDER BY a.score DESC),
+			a.user_id, u.username, a.score, a.correct_answers
+		FROM daily_attempts a
+		INNER JOIN users u ON (a.user_id = u.id)
+		WHERE a.day = $1 AND a.finished
+		ORDER BY a.score DESC, a.finished_at ASC
+		LIMIT $2 OFFSET $3;
+	`, day, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]trivia.DailyLeaderboardEntry, 0)
+	for rows.Next() {
+		var e trivia.DailyLeaderboardEntry
+		if err = rows.Scan(&e.Rank, &e.UserID, &e.Username, &e.Score, &e.CorrectAnswers); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (s *dailyService) DailyStreak(userID int64) (*trivia.DailyStreak, error) {
+	streak := &trivia.DailyStreak{UserID: userID}
+	err := s.db.QueryRow(`
+		SELECT current_streak, longest_streak, last_day FROM daily_streaks WHERE user_id = $1;
+	`, userID).Scan(&streak.Current, &streak.Longest, &streak.LastDay)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return streak, nil
+		}
+		return nil, err
+	}
+	return streak, nil
+}
+
+// NewDailyService creates a new service for storing daily challenges in postgres.
+func NewDailyService(db *sql.DB, questions trivia.QuestionService) trivia.DailyService {
+	return &dailyService{db: db, questions: questions}
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"database/sql"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+type externalIdentityService struct {
+	db *sql.DB
+}
+
+func (s *externalIdentityService) IdentityBySubject(provider string, subject string) (*trivia.ExternalIdentity, error) {
+	identity := &trivia.ExternalIdentity{Provider: provider, Subject: subject}
+	err := s.db.QueryRow(`
+		SELECT user_id, email, created FROM external_identities WHERE provider = $1 AND subject = $2;
+	`, provider, subject).Scan(&identity.UserID, &identity.Email, &identity.Created)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (s *externalIdentityService) LinkIdentity(identity *trivia.ExternalIdentity) error {
+	err := s.db.QueryRow(`
+		INSERT INTO external_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)
+		RETURNING created;
+	`, identity.Provider, identity.Subject, identity.UserID, identity.Email).Scan(&identity.Created)
+	if isUniqueViolation(err) {
+		return trivia.ErrIdentityInUse
+	}
+	return err
+}
+
+func (s *externalIdentityService) UserIdentities(userID int64) ([]trivia.ExternalIdentity, error) {
+	rows, err := s.db.Query(`
+		SELECT provider, subject, user_id, email, created FROM external_identities
+		WHERE user_id = $1 ORDER BY created ASC;
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]trivia.ExternalIdentity, 0)
+	for rows.Next() {
+		var identity trivia.ExternalIdentity
+		if err = rows.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.Created); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// NewExternalIdentityService creates a new service for storing the external identities of users in postgres.
+func NewExternalIdentityService(db *sql.DB) trivia.ExternalIdentityService {
+	return &externalIdentityService{db: db}
+}
@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/rating"
+)
+
+// ErrUnsupportedLeaderboard is returned when a leaderboard query asks for a leaderboard that isn't kept.
+var ErrUnsupportedLeaderboard = errors.New("unsupported leaderboard")
+
+type leaderboardService struct {
+	db *sql.DB
+}
+
+// leaderboardSelect returns the select statement for a leaderboard without its WHERE clause
+// and the expression that the leaderboard is ordered by. The first argument of the statement
+// is always the category.
+func leaderboardSelect(query trivia.LeaderboardQuery) (statement string, order string, err error) {
+	if query.RankBy == trivia.RankByRating {
+		// ratings aren't kept per category or period.
+		if query.Period != trivia.LeaderboardAllTime || query.Category != "" {
+			return "", "", ErrUnsupportedLeaderboard
+		}
+
+		return `
+			SELECT
+				(SELECT count(*) FROM player_ratings o WHERE o.rating > r.rating) + 1,
+				r.user_id, u.username, r.rating,
+				coalesce(t.score, 0), coalesce(t.wins, 0), coalesce(t.games, 0)
+			FROM player_ratings r
+			INNER JOIN users u ON (u.id = r.user_id)
+			LEFT JOIN leaderboard_totals t ON (t.user_id = r.user_id AND t.category = $1)
+		`, "r.rating", nil
+	}
+
+	var source string
+	switch query.Period {
+	case trivia.LeaderboardAllTime:
+		source = "leaderboard_totals"
+	case trivia.LeaderboardWeekly:
+		source = "leaderboard_weekly"
+	default:
+		return "", "", ErrUnsupportedLeaderboard
+	}
+
+	var column string
+	switch query.RankBy {
+	case trivia.RankByScore:
+		column = "score"
+	case trivia.RankByWins:
+		column = "wins"
+	default:
+		return "", "", ErrUnsupportedLeaderboard
+	}
+
+	// the rank of each row is counted using the index on (category, column) so that ranks
+	// stay cheap to find even at the bottom of a big leaderboard.
+	return `
+		SELECT
+			(SELECT count(*) FROM ` + source + ` o WHERE o.category = t.category AND o.` + column + ` > t.` + column + `) + 1,
+			t.user_id, u.username, coalesce(r.rating, $2), t.score, t.wins, t.games
+		FROM ` + source + ` t
+		INNER JOIN users u ON (u.id = t.user_id)
+		LEFT JOIN player_ratings r ON (r.user_id = t.user_id)
+	`, "t." + column, nil
+}
+
+func (s *leaderboardService) Leaderboard(query trivia.LeaderboardQuery, limit int, offset int) ([]trivia.LeaderboardEntry, error) {
+	statement, order, err := leaderboardSelect(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows *sql.Rows
+	if query.RankBy == trivia.RankByRating {
+		rows, err = s.db.Query(statement+`
+			ORDER BY `+order+` DESC, r.user_id ASC
+			LIMIT $2 OFFSET $3;
+		`, query.Category, limit, offset)
+	} else {
+		rows, err = s.db.Query(statement+`
+			WHERE t.category = $1
+			ORDER BY `+order+` DESC, t.user_id ASC
+			LIMIT $3 OFFSET $4;
+		`, query.Category, rating.Initial, limit, offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]trivia.LeaderboardEntry, 0)
+	for rows.Next() {
+		var e trivia.LeaderboardEntry
+		if err = rows.Scan(&e.Rank, &e.UserID, &e.Username, &e.Rating, &e.Score, &e.Wins, &e.Games); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (s *leaderboardService) LeaderboardEntry(query trivia.LeaderboardQuery, userID int64) (*trivia.LeaderboardEntry, error) {
+	statement, _, err := leaderboardSelect(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var row *sql.Row
+	if query.RankBy == trivia.RankByRating {
+		row = s.db.QueryRow(statement+`WHERE r.user_id = $2;`, query.Category, userID)
+	} else {
+		row = s.db.QueryRow(statement+`WHERE t.category = $1 AND t.user_id = $3;`, query.Category, rating.Initial, userID)
+	}
+
+	var e trivia.LeaderboardEntry
+	if err = row.Scan(&e.Rank, &e.UserID, &e.Username, &e.Rating, &e.Score, &e.Wins, &e.Games); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (s *leaderboardService) RefreshLeaderboards() error {
+	// daily totals that are too old to count towards the weekly leaderboards are no longer needed.
+	_, err := s.db.Exec(`DELETE FROM leaderboard_daily WHERE day <= current_date - 7;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`REFRESH MATERIALIZED VIEW CONCURRENTLY leaderboard_weekly;`)
+	return err
+}
+
+// NewLeaderboardService creates a new service for ranking users using leaderboards stored in postgres.
+func NewLeaderboardService(db *sql.DB) trivia.LeaderboardService {
+	return &leaderboardService{db: db}
+}
@@ -1,7 +1,10 @@
 package migrations
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"strings"
 )
 
 // initializes the database with some functions
@@ -150,3 +153,631 @@ func mg006CreateQuestionsTable(tx *sql.Tx) (err error) {
 	`)
 	return
 }
+
+func mg007CreateDailyChallengeTables(tx *sql.Tx) (err error) {
+	// question_ids is an ordered JSON array of question IDs.
+	_, err = tx.Exec(`
+		CREATE TABLE daily_challenges (
+			day DATE PRIMARY KEY,
+			question_ids jsonb NOT NULL,
+			created TIMESTAMPTZ DEFAULT now()
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE daily_attempts (
+			day DATE NOT NULL REFERENCES daily_challenges(day) ON DELETE CASCADE,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			score INTEGER NOT NULL DEFAULT 0,
+			correct_answers INTEGER NOT NULL DEFAULT 0,
+			finished BOOLEAN NOT NULL DEFAULT FALSE,
+			started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			finished_at TIMESTAMPTZ,
+			PRIMARY KEY (day, user_id)
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		CREATE INDEX daily_attempts_leaderboard ON daily_attempts(day, score DESC) WHERE finished;
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE daily_streaks (
+			user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			current_streak INTEGER NOT NULL DEFAULT 0,
+			longest_streak INTEGER NOT NULL DEFAULT 0,
+			last_day DATE NOT NULL
+		);
+	`)
+	return
+}
+
+func mg008CreateScheduledGamesTables(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`
+		CREATE TABLE scheduled_games (
+			id BIGSERIAL PRIMARY KEY,
+			title VARCHAR(128) NOT NULL,
+			starts_at TIMESTAMPTZ NOT NULL,
+			options jsonb NOT NULL,
+			host_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+			game_id VARCHAR(64),
+			created TIMESTAMPTZ DEFAULT now()
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX scheduled_games_starts_at ON scheduled_games(starts_at);`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE scheduled_game_rsvps (
+			scheduled_game_id BIGINT NOT NULL REFERENCES scheduled_games(id) ON DELETE CASCADE,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created TIMESTAMPTZ DEFAULT now(),
+			PRIMARY KEY (scheduled_game_id, user_id)
+		);
+	`)
+	return
+}
+
+func mg009CreateRatingTables(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`
+		CREATE TABLE player_ratings (
+			user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			rating DOUBLE PRECISION NOT NULL,
+			games_played INTEGER NOT NULL DEFAULT 0,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE rating_history (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			game_id VARCHAR(64) NOT NULL,
+			placement INTEGER NOT NULL,
+			rating_before DOUBLE PRECISION NOT NULL,
+			rating_after DOUBLE PRECISION NOT NULL,
+			created TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX rating_history_user ON rating_history(user_id, created DESC);`)
+	return
+}
+
+func mg010CreateGameResultsTables(tx *sql.Tx) (err error) {
+	// game IDs are reused by games that reset after they finish so results get their own ID.
+	_, err = tx.Exec(`
+		CREATE TABLE game_results (
+			id BIGSERIAL PRIMARY KEY,
+			game_id VARCHAR(64) NOT NULL,
+			question_count INTEGER NOT NULL,
+			participant_count INTEGER NOT NULL,
+			finished_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	// user_id is null for guests.
+	_, err = tx.Exec(`
+		CREATE TABLE game_participants (
+			game_result_id BIGINT NOT NULL REFERENCES game_results(id) ON DELETE CASCADE,
+			user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+			username VARCHAR(128) NOT NULL,
+			placement INTEGER NOT NULL,
+			score INTEGER NOT NULL,
+			correct_answers INTEGER NOT NULL
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX game_participants_game ON game_participants(game_result_id);`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		CREATE INDEX game_participants_user ON game_participants(user_id, game_result_id DESC)
+		WHERE user_id IS NOT NULL;
+	`)
+	return
+}
+
+func mg011CreateLeaderboardTables(tx *sql.Tx) (err error) {
+	// the totals of every user are kept up to date as games finish. The empty
+	// category holds the totals for every category.
+	_, err = tx.Exec(`
+		CREATE TABLE leaderboard_totals (
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			category VARCHAR(128) NOT NULL,
+			score BIGINT NOT NULL DEFAULT 0,
+			wins INTEGER NOT NULL DEFAULT 0,
+			games INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (user_id, category)
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX leaderboard_totals_score ON leaderboard_totals(category, score DESC);`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX leaderboard_totals_wins ON leaderboard_totals(category, wins DESC);`)
+	if err != nil {
+		return
+	}
+
+	// daily totals are only kept for as long as they are needed for the weekly leaderboards.
+	_, err = tx.Exec(`
+		CREATE TABLE leaderboard_daily (
+			day DATE NOT NULL,
+			category VARCHAR(128) NOT NULL,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			score BIGINT NOT NULL DEFAULT 0,
+			wins INTEGER NOT NULL DEFAULT 0,
+			games INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (day, category, user_id)
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		CREATE MATERIALIZED VIEW leaderboard_weekly AS
+		SELECT user_id, category, sum(score)::bigint AS score, sum(wins)::integer AS wins, sum(games)::integer AS games
+		FROM leaderboard_daily
+		WHERE day > current_date - 7
+		GROUP BY user_id, category;
+	`)
+	if err != nil {
+		return
+	}
+
+	// the unique index is required to refresh the view concurrently.
+	_, err = tx.Exec(`CREATE UNIQUE INDEX leaderboard_weekly_user ON leaderboard_weekly(category, user_id);`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX leaderboard_weekly_score ON leaderboard_weekly(category, score DESC);`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX leaderboard_weekly_wins ON leaderboard_weekly(category, wins DESC);`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX player_ratings_rating ON player_ratings(rating DESC);`)
+	return
+}
+
+func mg012AddLeaderboardAccuracyColumns(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`
+		ALTER TABLE leaderboard_totals
+			ADD COLUMN correct_answers INTEGER NOT NULL DEFAULT 0,
+			ADD COLUMN questions INTEGER NOT NULL DEFAULT 0;
+	`)
+	return
+}
+
+func mg013CreateAchievementsTable(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`
+		CREATE TABLE user_achievements (
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			achievement_id VARCHAR(64) NOT NULL,
+			awarded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (user_id, achievement_id)
+		);
+	`)
+	return
+}
+
+func mg014CreatePlayerXPTable(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`
+		CREATE TABLE player_xp (
+			user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			xp BIGINT NOT NULL DEFAULT 0,
+			solo_day DATE NOT NULL DEFAULT current_date,
+			solo_xp BIGINT NOT NULL DEFAULT 0
+		);
+	`)
+	return
+}
+
+func mg015AddRefreshTokenFamilies(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`
+		ALTER TABLE refresh_tokens
+			ADD COLUMN family VARCHAR(64),
+			ADD COLUMN used_at TIMESTAMPTZ;
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`ALTER TABLE auth_tokens ADD COLUMN family VARCHAR(64);`)
+	if err != nil {
+		return
+	}
+
+	// every existing token pair starts its own family. families are random so that they never
+	// contain anything that could be used as a token.
+	_, err = tx.Exec(`UPDATE refresh_tokens SET family = md5(random()::text || clock_timestamp()::text || token);`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE auth_tokens a SET family = r.family
+		FROM refresh_tokens r WHERE r.auth_token = a.token;
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE auth_tokens SET family = md5(random()::text || clock_timestamp()::text || token)
+		WHERE family IS NULL;
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`ALTER TABLE refresh_tokens ALTER COLUMN family SET NOT NULL;`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`ALTER TABLE auth_tokens ALTER COLUMN family SET NOT NULL;`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX refresh_tokens_family ON refresh_tokens(family);`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX auth_tokens_family ON auth_tokens(family);`)
+	return
+}
+
+func mg016AddTokenExpirationIndexes(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`CREATE INDEX auth_tokens_expires_at ON auth_tokens(expires_at);`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX refresh_tokens_expires_at ON refresh_tokens(expires_at);`)
+	return
+}
+
+func mg017HashStoredTokens(tx *sql.Tx) (err error) {
+	// expired tokens are useless so there's no need to hash them.
+	_, err = tx.Exec(`DELETE FROM auth_tokens WHERE expires_at < now();`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM refresh_tokens WHERE expires_at < now();`)
+	if err != nil {
+		return
+	}
+
+	if err = mgHashTokenColumn(tx, "auth_tokens", "token"); err != nil {
+		return
+	}
+
+	if err = mgHashTokenColumn(tx, "refresh_tokens", "token"); err != nil {
+		return
+	}
+
+	err = mgHashTokenColumn(tx, "refresh_tokens", "auth_token")
+	return
+}
+
+// mgHashTokenColumn replaces every token in a column with the digest that is stored in its place:
+// the hex encoded SHA-256 of the token followed by everything after the first '.' in the token.
+func mgHashTokenColumn(tx *sql.Tx, table string, column string) (err error) {
+	rows, err := tx.Query(`SELECT DISTINCT ` + column + ` FROM ` + table + `;`)
+	if err != nil {
+		return
+	}
+
+	// the rows have to be closed before anything else can be run in the transaction.
+	tokens := make([]string, 0)
+	for rows.Next() {
+		var token string
+		if err = rows.Scan(&token); err != nil {
+			rows.Close()
+			return
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	for _, token := range tokens {
+		digest := sha256.Sum256([]byte(token))
+		hashed := hex.EncodeToString(digest[:])
+		if idx := strings.IndexByte(token, '.'); idx >= 0 {
+			hashed += token[idx:]
+		}
+
+		_, err = tx.Exec(`UPDATE `+table+` SET `+column+` = $1 WHERE `+column+` = $2;`, hashed, token)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func mg018CreateSessionsTable(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`
+		CREATE TABLE sessions (
+			id BIGSERIAL PRIMARY KEY,
+			family VARCHAR(64) UNIQUE NOT NULL,
+			user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+			guest_id BIGINT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			last_used_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip VARCHAR(64) NOT NULL DEFAULT ''
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX sessions_user_id_idx ON sessions (user_id);`)
+	if err != nil {
+		return
+	}
+
+	// logins from before sessions were recorded get sessions without any client information.
+	_, err = tx.Exec(`
+		INSERT INTO sessions (family, user_id, guest_id)
+		SELECT DISTINCT ON (family) family, user_id, guest_id FROM refresh_tokens
+		ORDER BY family, expires_at DESC;
+	`)
+	return
+}
+
+func mg019AddGameParticipantGuestIDs(tx *sql.Tx) (err error) {
+	// questions is null for participants stored before it was added.
+	_, err = tx.Exec(`
+		ALTER TABLE game_participants
+			ADD COLUMN guest_id BIGINT,
+			ADD COLUMN questions INTEGER;
+	`)
+	if err != nil {
+		return
+	}
+
+	// guest participants were only stored with their usernames, which contain their guest IDs.
+	_, err = tx.Exec(`
+		UPDATE game_participants SET guest_id = substring(username FROM 7)::BIGINT
+		WHERE user_id IS NULL AND username ~ '^#Guest[0-9]+$';
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		CREATE INDEX game_participants_guest ON game_participants(guest_id)
+		WHERE guest_id IS NOT NULL;
+	`)
+	return
+}
+
+func mg020CreatePasswordResetTokensTable(tx *sql.Tx) (err error) {
+	// token is a digest of the token that was emailed to the user.
+	_, err = tx.Exec(`
+		CREATE TABLE password_reset_tokens (
+			token VARCHAR(128) PRIMARY KEY,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX password_reset_tokens_user_id_idx ON password_reset_tokens (user_id);`)
+	return
+}
+
+func mg021AddEmailVerification(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`ALTER TABLE user_creds ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;`)
+	if err != nil {
+		return
+	}
+
+	// token is a digest of the token that was emailed to the user.
+	_, err = tx.Exec(`
+		CREATE TABLE email_verification_tokens (
+			token VARCHAR(128) PRIMARY KEY,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(128) NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX email_verification_tokens_user_id_idx ON email_verification_tokens (user_id);`)
+	return
+}
+
+func mg022AddUsernameChanges(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`ALTER TABLE users ADD COLUMN username_changed_at TIMESTAMPTZ;`)
+	if err != nil {
+		return
+	}
+
+	// old usernames are kept here for a while after they are changed so that nobody else can take
+	// them and pretend to be the previous owner.
+	_, err = tx.Exec(`
+		CREATE TABLE reserved_usernames (
+			username VARCHAR(128) NOT NULL,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			reserved_until TIMESTAMPTZ NOT NULL
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE UNIQUE INDEX unique_lower_reserved_username ON reserved_usernames(lower(username));`)
+	return
+}
+
+func mg023KeepReservedUsernamesOfDeletedUsers(tx *sql.Tx) (err error) {
+	// the usernames of deleted users stay reserved so that nobody can take them right away and
+	// pretend to be the user. user_id is null for these reservations.
+	_, err = tx.Exec(`ALTER TABLE reserved_usernames ALTER COLUMN user_id DROP NOT NULL;`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`ALTER TABLE reserved_usernames DROP CONSTRAINT reserved_usernames_user_id_fkey;`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		ALTER TABLE reserved_usernames ADD CONSTRAINT reserved_usernames_user_id_fkey
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+	`)
+	return
+}
+
+func mg024CreateExternalIdentitiesTable(tx *sql.Tx) (err error) {
+	// provider is the configured name of an OpenID Connect provider and subject is the ID that the
+	// provider gave the user's account.
+	_, err = tx.Exec(`
+		CREATE TABLE external_identities (
+			provider VARCHAR(64) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(128) NOT NULL DEFAULT '',
+			created TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (provider, subject)
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX external_identities_user_id_idx ON external_identities (user_id);`)
+	return
+}
+
+func mg025CreateLoginAttemptsTable(tx *sql.Tx) (err error) {
+	// key is what the failures are counted for, such as an IP address or an account.
+	_, err = tx.Exec(`
+		CREATE TABLE login_attempts (
+			key VARCHAR(255) PRIMARY KEY,
+			failures INTEGER NOT NULL,
+			last_failure TIMESTAMPTZ NOT NULL
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX login_attempts_last_failure_idx ON login_attempts (last_failure);`)
+	return
+}
+
+func mg026AddTwoFactorAuthentication(tx *sql.Tx) (err error) {
+	// secret is encrypted with a pepper key and last_counter is the counter of the last code that
+	// was used so that codes can't be used twice.
+	_, err = tx.Exec(`
+		CREATE TABLE two_factor (
+			user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			secret BYTEA NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT false,
+			last_counter BIGINT NOT NULL DEFAULT 0,
+			created TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	// only digests of recovery codes and challenge tokens are stored.
+	_, err = tx.Exec(`
+		CREATE TABLE recovery_codes (
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code VARCHAR(128) NOT NULL,
+			PRIMARY KEY (user_id, code)
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE login_challenges (
+			token VARCHAR(128) PRIMARY KEY,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			expires_at TIMESTAMPTZ NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0
+		);
+	`)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(`CREATE INDEX login_challenges_expires_at_idx ON login_challenges (expires_at);`)
+	return
+}
+
+func mg027AddUserRoles(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`ALTER TABLE users ADD COLUMN role VARCHAR(32) NOT NULL DEFAULT 'player';`)
+	if err != nil {
+		return
+	}
+
+	// only the few users with other roles are ever looked up by role.
+	_, err = tx.Exec(`CREATE INDEX users_role_idx ON users (role) WHERE role <> 'player';`)
+	return
+}
+
+func mg028AddDisabledQuestions(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(`ALTER TABLE questions ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT false;`)
+	return
+}
@@ -17,6 +17,28 @@ func init() {
 	register(4, "create_auth_tokens_table", mg004CreateAuthTokensTable)
 	register(5, "create_guest_id_sequence", mg005CreateGuestSequence)
 	register(6, "create_questions_table", mg006CreateQuestionsTable)
+	register(7, "create_daily_challenge_tables", mg007CreateDailyChallengeTables)
+	register(8, "create_scheduled_games_tables", mg008CreateScheduledGamesTables)
+	register(9, "create_rating_tables", mg009CreateRatingTables)
+	register(10, "create_game_results_tables", mg010CreateGameResultsTables)
+	register(11, "create_leaderboard_tables", mg011CreateLeaderboardTables)
+	register(12, "add_leaderboard_accuracy_columns", mg012AddLeaderboardAccuracyColumns)
+	register(13, "create_achievements_table", mg013CreateAchievementsTable)
+	register(14, "create_player_xp_table", mg014CreatePlayerXPTable)
+	register(15, "add_refresh_token_families", mg015AddRefreshTokenFamilies)
+	register(16, "add_token_expiration_indexes", mg016AddTokenExpirationIndexes)
+	register(17, "hash_stored_tokens", mg017HashStoredTokens)
+	register(18, "create_sessions_table", mg018CreateSessionsTable)
+	register(19, "add_game_participant_guest_ids", mg019AddGameParticipantGuestIDs)
+	register(20, "create_password_reset_tokens_table", mg020CreatePasswordResetTokensTable)
+	register(21, "add_email_verification", mg021AddEmailVerification)
+	register(22, "add_username_changes", mg022AddUsernameChanges)
+	register(23, "keep_reserved_usernames_of_deleted_users", mg023KeepReservedUsernamesOfDeletedUsers)
+	register(24, "create_external_identities_table", mg024CreateExternalIdentitiesTable)
+	register(25, "create_login_attempts_table", mg025CreateLoginAttemptsTable)
+	register(26, "add_two_factor_authentication", mg026AddTwoFactorAuthentication)
+	register(27, "add_user_roles", mg027AddUserRoles)
+	register(28, "add_disabled_questions", mg028AddDisabledQuestions)
 }
 
 // MigrationFunc is a function that executes a migration on a transaction.
@@ -6,8 +6,10 @@ import (
 	"errors"
 	"math/rand"
 	"sort"
+	"strings"
 
 	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/lib/pq"
 )
 
 // ErrMaxQuestionFetches is returned when too many trips have to be made to the database to retrieve questions.
@@ -64,6 +66,7 @@ func (s *questionService) GetRandomQuestions(count int) ([]trivia.Question, erro
 			LIMIT $3									-- hint for query planner
 		) r
 		JOIN questions q USING(id)						-- eliminate misses
+		WHERE NOT q.disabled
 	
 		UNION											-- eliminate dupes
 		SELECT q.*
@@ -74,6 +77,7 @@ func (s *questionService) GetRandomQuestions(count int) ([]trivia.Question, erro
 		) r
 	
 		JOIN questions q USING (id)						-- eliminate misses
+		WHERE NOT q.disabled
 	)
 	SELECT id, category, difficulty, prompt, choices, correct_choice, source
 	FROM random_pick
@@ -137,6 +141,154 @@ func (s *questionService) GetRandomQuestions(count int) ([]trivia.Question, erro
 	return questionsSlice, nil
 }
 
+func (s *questionService) QuestionIDs() ([]int64, error) {
+	rows, err := s.db.Query(`SELECT id FROM questions WHERE NOT disabled ORDER BY id ASC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *questionService) GetRandomQuestionsInCategories(count int, categories []string) ([]trivia.Question, error) {
+	lowered := make([]string, len(categories))
+	for idx, category := range categories {
+		lowered[idx] = strings.ToLower(category)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, category, difficulty, prompt, choices, correct_choice, source
+		FROM questions
+		WHERE lower(category) = ANY($1) AND NOT disabled
+		ORDER BY random()
+		LIMIT $2;
+	`, pq.Array(lowered), count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := make([]trivia.Question, 0, count)
+	for rows.Next() {
+		var choicesRaw string
+		var q trivia.Question
+		if err = rows.Scan(&q.ID, &q.Category, &q.Difficulty, &q.Prompt,
+			&choicesRaw, &q.CorrectChoice, &q.Source); err != nil {
+			return nil, err
+		}
+
+		q.Choices = make([]string, 0)
+		json.Unmarshal([]byte(choicesRaw), &q.Choices)
+		questions = append(questions, q)
+	}
+	return questions, rows.Err()
+}
+
+func (s *questionService) QuestionsByIDs(ids []int64) ([]trivia.Question, error) {
+	rows, err := s.db.Query(`
+		SELECT id, category, difficulty, prompt, choices, correct_choice, source, disabled
+		FROM questions
+		WHERE id = ANY($1);
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := make(map[int64]trivia.Question)
+	for rows.Next() {
+		var choicesRaw string
+		var q trivia.Question
+		if err = rows.Scan(&q.ID, &q.Category, &q.Difficulty, &q.Prompt,
+			&choicesRaw, &q.CorrectChoice, &q.Source, &q.Disabled); err != nil {
+			return nil, err
+		}
+
+		q.Choices = make([]string, 0)
+		json.Unmarshal([]byte(choicesRaw), &q.Choices)
+		questions[q.ID] = q
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the questions are put back in the same order as the IDs that were requested.
+	ordered := make([]trivia.Question, 0, len(ids))
+	for _, id := range ids {
+		if q, ok := questions[id]; ok {
+			ordered = append(ordered, q)
+		}
+	}
+	return ordered, nil
+}
+
+func (s *questionService) ListQuestions(search string, category string, limit int, offset int) ([]trivia.Question, error) {
+	pattern := "%" + escapeLike(strings.ToLower(search)) + "%"
+	rows, err := s.db.Query(`
+		SELECT id, category, difficulty, prompt, choices, correct_choice, source, disabled
+		FROM questions
+		WHERE lower(prompt) LIKE $1 AND ($2 = '' OR lower(category) = lower($2))
+		ORDER BY id
+		LIMIT $3 OFFSET $4;
+	`, pattern, category, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := make([]trivia.Question, 0, limit)
+	for rows.Next() {
+		var choicesRaw string
+		var q trivia.Question
+		if err = rows.Scan(&q.ID, &q.Category, &q.Difficulty, &q.Prompt,
+			&choicesRaw, &q.CorrectChoice, &q.Source, &q.Disabled); err != nil {
+			return nil, err
+		}
+
+		q.Choices = make([]string, 0)
+		json.Unmarshal([]byte(choicesRaw), &q.Choices)
+		questions = append(questions, q)
+	}
+	return questions, rows.Err()
+}
+
+func (s *questionService) UpdateQuestion(question *trivia.Question) (bool, error) {
+	choices, err := json.Marshal(question.Choices)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := s.db.Exec(`
+		UPDATE questions
+		SET category = $2, difficulty = $3, prompt = $4, choices = $5, correct_choice = $6, source = $7
+		WHERE id = $1;
+	`, question.ID, question.Category, question.Difficulty, question.Prompt, string(choices), question.CorrectChoice,
+		question.Source)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *questionService) SetQuestionDisabled(id int64, disabled bool) (bool, error) {
+	res, err := s.db.Exec(`UPDATE questions SET disabled = $2 WHERE id = $1;`, id, disabled)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
 // NewQuestionService creates a new service for fetching questions from postgres.
 func NewQuestionService(db *sql.DB) trivia.QuestionService {
 	return &questionService{db: db}
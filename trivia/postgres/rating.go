@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"database/sql"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/lib/pq"
+)
+
+type ratingService struct {
+	db *sql.DB
+}
+
+func (s *ratingService) PlayerRatings(userIDs []int64) (map[int64]trivia.PlayerRating, error) {
+	rows, err := s.db.Query(`
+		SELECT user_id, rating, games_played, updated_at
+		FROM player_ratings
+		WHERE user_id = ANY($1);
+	`, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := make(map[int64]trivia.PlayerRating)
+	for rows.Next() {
+		var r trivia.PlayerRating
+		if err = rows.Scan(&r.UserID, &r.Rating, &r.GamesPlayed, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		ratings[r.UserID] = r
+	}
+	return ratings, rows.Err()
+}
+
+func (s *ratingService) ApplyRatingChanges(userIDs []int64, initial float64,
+	compute func(current map[int64]trivia.PlayerRating) []trivia.RatingChange) error {
+	return transact(s.db, func(tx *sql.Tx) error {
+		// missing ratings are created first so that every row can be locked, otherwise two games
+		// could both give a new player their first rating.
+		_, err := tx.Exec(`
+			INSERT INTO player_ratings (user_id, rating, games_played, updated_at)
+			SELECT user_id, $2, 0, now() FROM unnest($1::BIGINT[]) AS user_id
+			ON CONFLICT (user_id) DO NOTHING;
+		`, pq.Array(userIDs), initial)
+		if err != nil {
+			return err
+		}
+
+		// rows are locked in the same order by every game so that they can't deadlock.
+		rows, err := tx.Query(`
+			SELECT user_id, rating, games_played, updated_at
+			FROM player_ratings
+			WHERE user_id = ANY($1)
+			ORDER BY user_id
+			FOR UPDATE;
+		`, pq.Array(userIDs))
+		if err != nil {
+			return err
+		}
+		current := make(map[int64]trivia.PlayerRating)
+		for rows.Next() {
+			var r trivia.PlayerRating
+			if err = rows.Scan(&r.UserID, &r.Rating, &r.GamesPlayed, &r.UpdatedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			current[r.UserID] = r
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, change := range compute(current) {
+			_, err := tx.Exec(`
+				UPDATE player_ratings
+				SET rating = $2, games_played = games_played + 1, updated_at = now()
+				WHERE user_id = $1;
+			`, change.UserID, change.After)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`
+				INSERT INTO rating_history (user_id, game_id, placement, rating_before, rating_after)
+				VALUES ($1, $2, $3, $4, $5);
+			`, change.UserID, change.GameID, change.Placement, change.Before, change.After)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ratingService) RatingHistory(userID int64, limit int, offset int) ([]trivia.RatingChange, error) {
+	rows, err := s.db.Query(`
+		SELECT user_id, game_id, placement, rating_before, rating_after, created
+		FROM rating_history
+		WHERE user_id = $1
+		ORDER BY created DESC, id DESC
+		LIMIT $2 OFFSET $3;
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]trivia.RatingChange, 0)
+	for rows.Next() {
+		var c trivia.RatingChange
+		if err = rows.Scan(&c.UserID, &c.GameID, &c.Placement, &c.Before, &c.After, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// NewRatingService creates a new service for storing player ratings in postgres.
+func NewRatingService(db *sql.DB) trivia.RatingService {
+	return &ratingService{db: db}
+}
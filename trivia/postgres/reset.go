@@ -0,0 +1,45 @@
+package postgres
+
+import (
+	"database/sql"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+type passwordResetService struct {
+	db *sql.DB
+}
+
+func (s *passwordResetService) CreatePasswordResetToken(token *trivia.PasswordResetToken) error {
+	return transact(s.db, func(tx *sql.Tx) error {
+		// expired tokens of every user are cleaned up here since nothing else needs them.
+		_, err := tx.Exec(`DELETE FROM password_reset_tokens WHERE user_id = $1 OR expires_at < now();`, token.UserID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO password_reset_tokens (token, user_id, expires_at) VALUES ($1, $2, $3);
+		`, hashToken(token.Token), token.UserID, token.ExpiresAt)
+		return err
+	})
+}
+
+func (s *passwordResetService) UsePasswordResetToken(tokenString string) (*trivia.PasswordResetToken, error) {
+	token := &trivia.PasswordResetToken{Token: tokenString}
+	err := s.db.QueryRow(`
+		DELETE FROM password_reset_tokens WHERE token = $1 RETURNING user_id, expires_at;
+	`, hashToken(tokenString)).Scan(&token.UserID, &token.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// NewPasswordResetService creates a new service for storing password reset tokens in postgres.
+func NewPasswordResetService(db *sql.DB) trivia.PasswordResetService {
+	return &passwordResetService{db: db}
+}
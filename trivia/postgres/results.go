@@ -0,0 +1,280 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/null"
+)
+
+type gameResultService struct {
+	db *sql.DB
+}
+
+func (s *gameResultService) StoreGameResult(result *trivia.GameResult) error {
+	day := result.FinishedAt.UTC().Truncate(24 * time.Hour)
+
+	return transact(s.db, func(tx *sql.Tx) error {
+		var resultID int64
+		err := tx.QueryRow(`
+			INSERT INTO game_results (game_id, question_count, participant_count, finished_at)
+			VALUES ($1, $2, $3, $4) RETURNING id;
+		`, result.GameID, result.QuestionCount, len(result.Participants), result.FinishedAt).Scan(&resultID)
+		if err != nil {
+			return err
+		}
+
+		for idx := range result.Participants {
+			participant := &result.Participants[idx]
+
+			var userID, guestID null.Int64
+			username := participant.Username
+			if participant.Guest {
+				guestID = null.NewInt64(-participant.UserID)
+			} else {
+				// a user can delete their account while they are still in a game, in which case they
+				// are stored like the participants of older games that were deleted.
+				var exists bool
+				err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1);`, participant.UserID).Scan(&exists)
+				if err != nil {
+					return err
+				}
+				if exists {
+					userID = null.NewInt64(participant.UserID)
+				} else {
+					username = trivia.DeletedUsername
+				}
+			}
+
+			_, err = tx.Exec(`
+				INSERT INTO game_participants (game_result_id, user_id, guest_id, username, placement, score, correct_answers, questions)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+			`, resultID, userID, guestID, username, participant.Placement, participant.Score,
+				participant.CorrectAnswers, participant.Questions())
+			if err != nil {
+				return err
+			}
+
+			// guests and deleted users don't show up on leaderboards.
+			if !userID.Valid {
+				continue
+			}
+
+			wins := 0
+			if result.Won(participant) {
+				wins = 1
+			}
+			err = addLeaderboardTotals(tx, participant.UserID, day, "", participant.Score, wins,
+				participant.CorrectAnswers, participant.Questions())
+			if err != nil {
+				return err
+			}
+
+			for _, c := range participant.Categories {
+				err = addLeaderboardTotals(tx, participant.UserID, day, c.Category, c.Score, 0, c.CorrectAnswers, c.Questions)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// addLeaderboardTotals adds a single game to a user's all-time and daily leaderboard totals.
+// Answer totals are only kept all-time since they are only used for profile stats.
+func addLeaderboardTotals(tx *sql.Tx, userID int64, day time.Time, category string, score int, wins int,
+	correctAnswers int, questions int) error {
+	_, err := tx.Exec(`
+		INSERT INTO leaderboard_totals (user_id, category, score, wins, games, correct_answers, questions)
+		VALUES ($1, $2, $3, $4, 1, $5, $6)
+		ON CONFLICT (user_id, category) DO UPDATE SET
+			score = leaderboard_totals.score + EXCLUDED.score,
+			wins = leaderboard_totals.wins + EXCLUDED.wins,
+			games = leaderboard_totals.games + 1,
+			correct_answers = leaderboard_totals.correct_answers + EXCLUDED.correct_answers,
+			questions = leaderboard_totals.questions + EXCLUDED.questions;
+	`, userID, category, score, wins, correctAnswers, questions)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO leaderboard_daily (day, category, user_id, score, wins, games)
+		VALUES ($1, $2, $3, $4, $5, 1)
+		ON CONFLICT (day, category, user_id) DO UPDATE SET
+			score = leaderboard_daily.score + EXCLUDED.score,
+			wins = leaderboard_daily.wins + EXCLUDED.wins,
+			games = leaderboard_daily.games + 1;
+	`, day, category, userID, score, wins)
+	return err
+}
+
+func (s *gameResultService) GameResultByID(id int64) (*trivia.GameResult, error) {
+	var result trivia.GameResult
+	err := s.db.QueryRow(`
+		SELECT id, game_id, question_count, finished_at FROM game_results WHERE id = $1;
+	`, id).Scan(&result.ID, &result.GameID, &result.QuestionCount, &result.FinishedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT user_id, username, placement, score, correct_answers
+		FROM game_participants
+		WHERE game_result_id = $1
+		ORDER BY placement ASC, lower(username) ASC;
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result.Participants = make([]trivia.GameParticipantResult, 0)
+	for rows.Next() {
+		var p trivia.GameParticipantResult
+		var userID null.Int64
+		if err = rows.Scan(&userID, &p.Username, &p.Placement, &p.Score, &p.CorrectAnswers); err != nil {
+			return nil, err
+		}
+		p.UserID = userID.Int64
+		p.Guest = !userID.Valid
+		result.Participants = append(result.Participants, p)
+	}
+	return &result, rows.Err()
+}
+
+func (s *gameResultService) UserStats(userID int64) (*trivia.UserStats, error) {
+	rows, err := s.db.Query(`
+		SELECT category, games, wins, score, correct_answers, questions
+		FROM leaderboard_totals
+		WHERE user_id = $1
+		ORDER BY questions DESC, category ASC;
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := trivia.UserStats{Categories: make([]trivia.CategoryStats, 0)}
+	for rows.Next() {
+		var c trivia.CategoryStats
+		var wins int
+		if err = rows.Scan(&c.Category, &c.Games, &wins, &c.Score, &c.CorrectAnswers, &c.Questions); err != nil {
+			return nil, err
+		}
+
+		// the empty category holds the totals for every category.
+		if c.Category == "" {
+			stats.GamesPlayed = c.Games
+			stats.Wins = wins
+			stats.Score = c.Score
+			stats.CorrectAnswers = c.CorrectAnswers
+			stats.Questions = c.Questions
+		} else {
+			stats.Categories = append(stats.Categories, c)
+		}
+	}
+	return &stats, rows.Err()
+}
+
+func (s *gameResultService) MatchHistory(userID int64, limit int, offset int) ([]trivia.MatchHistoryEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT r.id, r.game_id, r.finished_at, r.question_count, r.participant_count,
+			p.placement, p.score, p.correct_answers
+		FROM game_participants p
+		INNER JOIN game_results r ON (r.id = p.game_result_id)
+		WHERE p.user_id = $1
+		ORDER BY p.game_result_id DESC
+		LIMIT $2 OFFSET $3;
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]trivia.MatchHistoryEntry, 0)
+	for rows.Next() {
+		var e trivia.MatchHistoryEntry
+		if err = rows.Scan(&e.ResultID, &e.GameID, &e.FinishedAt, &e.QuestionCount, &e.ParticipantCount,
+			&e.Placement, &e.Score, &e.CorrectAnswers); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (s *gameResultService) TransferGuestResults(guestID int64, userID int64, username string) (int64, error) {
+	type guestGame struct {
+		score          int
+		won            bool
+		correctAnswers int
+		questions      int
+		finishedAt     time.Time
+	}
+
+	var transferred int64
+	err := transact(s.db, func(tx *sql.Tx) error {
+		// participants stored before questions were recorded count every question in the game.
+		rows, err := tx.Query(`
+			SELECT p.score, p.placement = 1 AND r.participant_count > 1, p.correct_answers,
+				COALESCE(p.questions, r.question_count), r.finished_at
+			FROM game_participants p
+			INNER JOIN game_results r ON (r.id = p.game_result_id)
+			WHERE p.guest_id = $1 AND p.user_id IS NULL
+			FOR UPDATE OF p;
+		`, guestID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		games := make([]guestGame, 0)
+		for rows.Next() {
+			var g guestGame
+			if err = rows.Scan(&g.score, &g.won, &g.correctAnswers, &g.questions, &g.finishedAt); err != nil {
+				return err
+			}
+			games = append(games, g)
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for _, g := range games {
+			wins := 0
+			if g.won {
+				wins = 1
+			}
+			day := g.finishedAt.UTC().Truncate(24 * time.Hour)
+			if err = addLeaderboardTotals(tx, userID, day, "", g.score, wins, g.correctAnswers, g.questions); err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.Exec(`
+			UPDATE game_participants SET user_id = $2, username = $3, guest_id = NULL
+			WHERE guest_id = $1 AND user_id IS NULL;
+		`, guestID, userID, username)
+		if err != nil {
+			return err
+		}
+		transferred, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return transferred, nil
+}
+
+// NewGameResultService creates a new service for storing game results in postgres.
+func NewGameResultService(db *sql.DB) trivia.GameResultService {
+	return &gameResultService{db: db}
+}
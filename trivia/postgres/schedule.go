@@ -0,0 +1,181 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+type scheduledGameService struct {
+	db *sql.DB
+}
+
+// scheduledGameColumns are the columns selected by scanScheduledGame.
+const scheduledGameColumns = `
+	g.id, g.title, g.starts_at, g.options, g.host_user_id, u.username, g.game_id,
+	(SELECT count(*) FROM scheduled_game_rsvps r WHERE r.scheduled_game_id = g.id)
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanScheduledGame(row rowScanner) (*trivia.ScheduledGame, error) {
+	var game trivia.ScheduledGame
+	var optionsRaw string
+	err := row.Scan(&game.ID, &game.Title, &game.StartsAt, &optionsRaw, &game.HostUserID,
+		&game.HostUsername, &game.GameID, &game.RSVPCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(optionsRaw), &game.Options); err != nil {
+		return nil, err
+	}
+	return &game, nil
+}
+
+func (s *scheduledGameService) queryScheduledGames(query string, args ...interface{}) ([]trivia.ScheduledGame, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	games := make([]trivia.ScheduledGame, 0)
+	for rows.Next() {
+		game, err := scanScheduledGame(rows)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, *game)
+	}
+	return games, rows.Err()
+}
+
+func (s *scheduledGameService) CreateScheduledGame(game *trivia.ScheduledGame) error {
+	optionsRaw, err := json.Marshal(&game.Options)
+	if err != nil {
+		return err
+	}
+
+	return s.db.QueryRow(`
+		INSERT INTO scheduled_games (title, starts_at, options, host_user_id)
+		VALUES ($1, $2, $3, $4) RETURNING id;
+	`, game.Title, game.StartsAt, string(optionsRaw), game.HostUserID).Scan(&game.ID)
+}
+
+func (s *scheduledGameService) ScheduledGameByID(id int64) (*trivia.ScheduledGame, error) {
+	game, err := scanScheduledGame(s.db.QueryRow(`
+		SELECT `+scheduledGameColumns+`
+		FROM scheduled_games g
+		LEFT JOIN users u ON (g.host_user_id = u.id)
+		WHERE g.id = $1;
+	`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return game, nil
+}
+
+func (s *scheduledGameService) UpcomingScheduledGames(after time.Time, limit int, offset int) ([]trivia.ScheduledGame, error) {
+	return s.queryScheduledGames(`
+		SELECT `+scheduledGameColumns+`
+		FROM scheduled_games g
+		LEFT JOIN users u ON (g.host_user_id = u.id)
+		WHERE g.starts_at > $1
+		ORDER BY g.starts_at ASC, g.id ASC
+		LIMIT $2 OFFSET $3;
+	`, after, limit, offset)
+}
+
+func (s *scheduledGameService) DueScheduledGames(from time.Time, to time.Time) ([]trivia.ScheduledGame, error) {
+	return s.queryScheduledGames(`
+		SELECT `+scheduledGameColumns+`
+		FROM scheduled_games g
+		LEFT JOIN users u ON (g.host_user_id = u.id)
+		WHERE g.game_id IS NULL AND g.starts_at >= $1 AND g.starts_at <= $2
+		ORDER BY g.starts_at ASC;
+	`, from, to)
+}
+
+func (s *scheduledGameService) SetScheduledGameID(id int64, gameID string) error {
+	_, err := s.db.Exec(`UPDATE scheduled_games SET game_id = $2 WHERE id = $1;`, id, gameID)
+	return err
+}
+
+func (s *scheduledGameService) AddRSVP(scheduledGameID int64, userID int64, maxRSVPs int) (bool, error) {
+	added := false
+	err := transact(s.db, func(tx *sql.Tx) error {
+		// the scheduled game is locked so that concurrent RSVPs are counted one at a time and
+		// can't overbook the game.
+		var id int64
+		err := tx.QueryRow(`SELECT id FROM scheduled_games WHERE id = $1 FOR UPDATE;`, scheduledGameID).Scan(&id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return err
+		}
+
+		result, err := tx.Exec(`
+			INSERT INTO scheduled_game_rsvps (scheduled_game_id, user_id)
+			SELECT $1, $2
+			WHERE (SELECT count(*) FROM scheduled_game_rsvps WHERE scheduled_game_id = $1) < $3
+			ON CONFLICT (scheduled_game_id, user_id) DO NOTHING;
+		`, scheduledGameID, userID, maxRSVPs)
+		if err != nil {
+			return err
+		}
+
+		aff, err := result.RowsAffected()
+		added = aff > 0
+		return err
+	})
+	return added, err
+}
+
+func (s *scheduledGameService) RemoveRSVP(scheduledGameID int64, userID int64) (bool, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM scheduled_game_rsvps WHERE scheduled_game_id = $1 AND user_id = $2;
+	`, scheduledGameID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	aff, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return aff > 0, nil
+}
+
+func (s *scheduledGameService) RSVPUserIDs(scheduledGameID int64) ([]int64, error) {
+	rows, err := s.db.Query(`
+		SELECT user_id FROM scheduled_game_rsvps WHERE scheduled_game_id = $1 ORDER BY created ASC;
+	`, scheduledGameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := make([]int64, 0)
+	for rows.Next() {
+		var userID int64
+		if err = rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// NewScheduledGameService creates a new service for storing scheduled games in postgres.
+func NewScheduledGameService(db *sql.DB) trivia.ScheduledGameService {
+	return &scheduledGameService{db: db}
+}
@@ -1,9 +1,14 @@
 package postgres
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
 
 	"github.com/expixel/actual-trivia-server/trivia/null"
+	"github.com/lib/pq"
 
 	"github.com/expixel/actual-trivia-server/trivia"
 )
@@ -12,13 +17,26 @@ type tokenService struct {
 	db *sql.DB
 }
 
+// hashToken returns the digest of a token that is stored in place of the token itself so that reading
+// the token tables doesn't give anyone a working token. Everything after the first '.' in a token only
+// identifies the user that it belongs to, so that part is kept as is to make stored tokens easy to trace
+// back to users while debugging.
+func hashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	hashed := hex.EncodeToString(digest[:])
+	if idx := strings.IndexByte(token, '.'); idx >= 0 {
+		hashed += token[idx:]
+	}
+	return hashed
+}
+
 func (s *tokenService) AuthTokenByString(tokenString string) (*trivia.AuthToken, error) {
-	token := &trivia.AuthToken{}
-	err := s.db.QueryRow("SELECT token, user_id, guest_id, expires_at FROM auth_tokens WHERE token = $1;", tokenString).Scan(
-		&token.Token,
+	token := &trivia.AuthToken{Token: tokenString}
+	err := s.db.QueryRow("SELECT user_id, guest_id, expires_at, family FROM auth_tokens WHERE token = $1;", hashToken(tokenString)).Scan(
 		&token.UserID,
 		&token.GuestID,
-		&token.ExpiresAt)
+		&token.ExpiresAt,
+		&token.Family)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -28,28 +46,192 @@ func (s *tokenService) AuthTokenByString(tokenString string) (*trivia.AuthToken,
 	return token, nil
 }
 
-func (s *tokenService) CreateTokenPair(auth *trivia.AuthToken, refresh *trivia.RefreshToken) error {
+func (s *tokenService) CreateTokenPair(auth *trivia.AuthToken, refresh *trivia.RefreshToken, client trivia.ClientInfo) error {
 	return transact(s.db, func(tx *sql.Tx) error {
 		_, err := tx.Exec(
-			`INSERT INTO auth_tokens (token, user_id, guest_id, expires_at) VALUES ($1, $2, $3, $4)`,
-			auth.Token, auth.UserID, auth.GuestID, auth.ExpiresAt)
+			`INSERT INTO sessions (family, user_id, guest_id, user_agent, ip) VALUES ($1, $2, $3, $4, $5);`,
+			refresh.Family, refresh.UserID, refresh.GuestID, client.UserAgent, client.IP)
 		if err != nil {
 			return err
 		}
+		return insertTokenPair(tx, auth, refresh)
+	})
+}
 
-		_, err = tx.Exec(
-			`INSERT INTO refresh_tokens (token, auth_token, user_id, guest_id, expires_at) VALUES ($1, $2, $3, $4, $5)`,
-			refresh.Token, refresh.AuthToken, refresh.UserID, refresh.GuestID, refresh.ExpiresAt)
+func insertTokenPair(tx *sql.Tx, auth *trivia.AuthToken, refresh *trivia.RefreshToken) error {
+	_, err := tx.Exec(
+		`INSERT INTO auth_tokens (token, user_id, guest_id, expires_at, family) VALUES ($1, $2, $3, $4, $5)`,
+		hashToken(auth.Token), auth.UserID, auth.GuestID, auth.ExpiresAt, auth.Family)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO refresh_tokens (token, auth_token, user_id, guest_id, expires_at, family) VALUES ($1, $2, $3, $4, $5, $6)`,
+		hashToken(refresh.Token), hashToken(refresh.AuthToken), refresh.UserID, refresh.GuestID, refresh.ExpiresAt, refresh.Family)
+	return err
+}
+
+func (s *tokenService) RefreshTokenByString(tokenString string) (*trivia.RefreshToken, error) {
+	// only the digest of the auth token is stored so AuthToken is left empty.
+	token := &trivia.RefreshToken{Token: tokenString}
+	var usedAt pq.NullTime
+	err := s.db.QueryRow(`
+		SELECT user_id, guest_id, expires_at, family, used_at
+		FROM refresh_tokens WHERE token = $1;
+	`, hashToken(tokenString)).Scan(&token.UserID, &token.GuestID, &token.ExpiresAt, &token.Family, &usedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	token.Used = usedAt.Valid
+	return token, nil
+}
+
+func (s *tokenService) RotateTokenPair(used *trivia.RefreshToken, auth *trivia.AuthToken, refresh *trivia.RefreshToken, client trivia.ClientInfo) (bool, error) {
+	rotated := false
+	err := transact(s.db, func(tx *sql.Tx) error {
+		// the used_at check makes sure that only one of two concurrent refreshes with the same token wins.
+		usedDigest := hashToken(used.Token)
+		result, err := tx.Exec(`UPDATE refresh_tokens SET used_at = now() WHERE token = $1 AND used_at IS NULL;`, usedDigest)
 		if err != nil {
 			return err
 		}
 
+		aff, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if aff < 1 {
+			return nil
+		}
+
+		_, err = tx.Exec(`
+			DELETE FROM auth_tokens WHERE token = (SELECT auth_token FROM refresh_tokens WHERE token = $1);
+		`, usedDigest)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE sessions SET last_used_at = now(), user_agent = $2, ip = $3 WHERE family = $1;
+		`, used.Family, client.UserAgent, client.IP)
+		if err != nil {
+			return err
+		}
+
+		if err = insertTokenPair(tx, auth, refresh); err != nil {
+			return err
+		}
+		rotated = true
 		return nil
 	})
+	return rotated && err == nil, err
+}
+
+func (s *tokenService) RevokeTokenFamily(family string) error {
+	return transact(s.db, func(tx *sql.Tx) error {
+		return revokeTokenFamilies(tx, []string{family})
+	})
+}
+
+// revokeTokenFamilies deletes the tokens and sessions of token families.
+func revokeTokenFamilies(tx *sql.Tx, families []string) error {
+	_, err := tx.Exec(`DELETE FROM auth_tokens WHERE family = ANY($1);`, pq.Array(families))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM refresh_tokens WHERE family = ANY($1);`, pq.Array(families))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM sessions WHERE family = ANY($1);`, pq.Array(families))
+	return err
+}
+
+const sessionColumns = `id, family, user_id, guest_id, created_at, last_used_at, user_agent, ip`
+
+func scanSession(row rowScanner) (*trivia.Session, error) {
+	session := &trivia.Session{}
+	err := row.Scan(&session.ID, &session.Family, &session.UserID, &session.GuestID, &session.CreatedAt,
+		&session.LastUsedAt, &session.UserAgent, &session.IP)
+	return session, err
+}
+
+func (s *tokenService) UserSessions(userID int64) ([]trivia.Session, error) {
+	rows, err := s.db.Query(`
+		SELECT `+sessionColumns+` FROM sessions WHERE user_id = $1 ORDER BY last_used_at DESC, id DESC;
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]trivia.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *tokenService) SessionByID(id int64) (*trivia.Session, error) {
+	session, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = $1;`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *tokenService) RevokeUserSessions(userID int64) ([]string, error) {
+	var families []string
+	err := transact(s.db, func(tx *sql.Tx) error {
+		families = make([]string, 0)
+
+		// the families of the user's tokens are included even if they somehow lost their session.
+		rows, err := tx.Query(`
+			SELECT family FROM sessions WHERE user_id = $1
+			UNION SELECT family FROM refresh_tokens WHERE user_id = $1
+			UNION SELECT family FROM auth_tokens WHERE user_id = $1;
+		`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var family string
+			if err := rows.Scan(&family); err != nil {
+				return err
+			}
+			families = append(families, family)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		return revokeTokenFamilies(tx, families)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return families, nil
 }
 
 func (s *tokenService) AuthTokenExists(token string) (bool, error) {
-	err := s.db.QueryRow("SELECT user_id FROM auth_tokens WHERE token = $1", token).Scan()
+	var userID null.Int64
+	err := s.db.QueryRow("SELECT user_id FROM auth_tokens WHERE token = $1", hashToken(token)).Scan(&userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
@@ -60,7 +242,8 @@ func (s *tokenService) AuthTokenExists(token string) (bool, error) {
 }
 
 func (s *tokenService) RefreshTokenExists(token string) (bool, error) {
-	err := s.db.QueryRow("SELECT user_id FROM refresh_tokens WHERE token = $1", token).Scan()
+	var userID null.Int64
+	err := s.db.QueryRow("SELECT user_id FROM refresh_tokens WHERE token = $1", hashToken(token)).Scan(&userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
@@ -74,15 +257,19 @@ func (s *tokenService) GetAuthTokenAndUser(token string) (*trivia.AuthToken, *tr
 	authToken := &trivia.AuthToken{}
 	var nullUserID null.Int64
 	var nullUsername null.String
+	var emailVerified sql.NullBool
+	var role sql.NullString
 
 	err := s.db.QueryRow(`
 		SELECT
-			a.user_id, a.guest_id, a.expires_at,
-			u.id, u.username
+			a.user_id, a.guest_id, a.expires_at, a.family,
+			u.id, u.username, u.role, c.email_verified
 		FROM auth_tokens a
 		LEFT JOIN users u ON (a.user_id = u.id)
+		LEFT JOIN user_creds c ON (a.user_id = c.user_id)
 		WHERE a.token = $1;
-	`, token).Scan(&authToken.UserID, &authToken.GuestID, &authToken.ExpiresAt, &nullUserID, &nullUsername)
+	`, hashToken(token)).Scan(&authToken.UserID, &authToken.GuestID, &authToken.ExpiresAt, &authToken.Family,
+		&nullUserID, &nullUsername, &role, &emailVerified)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil, nil
@@ -97,7 +284,8 @@ func (s *tokenService) GetAuthTokenAndUser(token string) (*trivia.AuthToken, *tr
 		}
 		return authToken, nil, trivia.ErrUserNotFound
 	}
-	user := &trivia.User{ID: nullUserID.Int64, Username: nullUsername.String}
+	user := &trivia.User{ID: nullUserID.Int64, Username: nullUsername.String, EmailVerified: emailVerified.Bool,
+		Role: trivia.Role(role.String)}
 
 	return authToken, user, nil
 }
@@ -105,7 +293,7 @@ func (s *tokenService) GetAuthTokenAndUser(token string) (*trivia.AuthToken, *tr
 func (s *tokenService) DeleteToken(token string, deleteRefresh bool) (bool, error) {
 	deletedAToken := false
 	err := transact(s.db, func(tx *sql.Tx) error {
-		result, err := tx.Exec(`DELETE FROM auth_tokens WHERE token = $1;`, token)
+		result, err := tx.Exec(`DELETE FROM auth_tokens WHERE token = $1;`, hashToken(token))
 		if err != nil {
 			return err
 		}
@@ -117,7 +305,7 @@ func (s *tokenService) DeleteToken(token string, deleteRefresh bool) (bool, erro
 		deletedAToken = aff > 0
 
 		if deleteRefresh {
-			_, err = tx.Exec(`DELETE FROM refresh_tokens WHERE auth_token = $1;`, token)
+			_, err = tx.Exec(`DELETE FROM refresh_tokens WHERE auth_token = $1;`, hashToken(token))
 			if err != nil {
 				return err
 			}
@@ -132,6 +320,43 @@ func (s *tokenService) DeleteToken(token string, deleteRefresh bool) (bool, erro
 	return deletedAToken, err
 }
 
+func (s *tokenService) DeleteExpiredTokens(before time.Time, limit int) (authDeleted int64, refreshDeleted int64, err error) {
+	// the subqueries keep each batch small enough that it doesn't hold locks on the tables for long.
+	result, err := s.db.Exec(`
+		DELETE FROM auth_tokens WHERE token IN (
+			SELECT token FROM auth_tokens WHERE expires_at < $1 LIMIT $2
+		);
+	`, before, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+	if authDeleted, err = result.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+
+	result, err = s.db.Exec(`
+		DELETE FROM refresh_tokens WHERE token IN (
+			SELECT token FROM refresh_tokens WHERE expires_at < $1 LIMIT $2
+		);
+	`, before, limit)
+	if err != nil {
+		return authDeleted, 0, err
+	}
+	if refreshDeleted, err = result.RowsAffected(); err != nil {
+		return authDeleted, 0, err
+	}
+
+	// sessions are deleted once the last of their refresh tokens has expired.
+	_, err = s.db.Exec(`
+		DELETE FROM sessions WHERE id IN (
+			SELECT s.id FROM sessions s
+			WHERE NOT EXISTS (SELECT 1 FROM refresh_tokens r WHERE r.family = s.family)
+			LIMIT $1
+		);
+	`, limit)
+	return authDeleted, refreshDeleted, err
+}
+
 // NewTokenService creats a use AuthTokenService
 func NewTokenService(db *sql.DB) trivia.AuthTokenService {
 	return &tokenService{db: db}
@@ -0,0 +1,26 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashToken(t *testing.T) {
+	token := "5f0c1e2a9b7d44a3c1e0f9b8a7d6c5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8.2s"
+	hashed := hashToken(token)
+
+	if hashed == token || strings.Contains(hashed, token[:64]) {
+		t.Errorf("expected the secret part of the token to not be stored: %s", hashed)
+	}
+	if !strings.HasSuffix(hashed, ".2s") || len(hashed) != 64+len(".2s") {
+		t.Errorf("expected the user ID suffix to be kept: %s", hashed)
+	}
+	if hashToken(token) != hashed {
+		t.Errorf("expected hashing to be deterministic")
+	}
+
+	// guest tokens have a second '.' in their suffix.
+	if guest := hashToken("abcdef.0.7"); !strings.HasSuffix(guest, ".0.7") {
+		t.Errorf("expected the guest ID suffix to be kept: %s", guest)
+	}
+}
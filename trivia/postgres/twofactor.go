@@ -0,0 +1,197 @@
+package postgres
+
+import (
+	"database/sql"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+type twoFactorService struct {
+	db *sql.DB
+}
+
+func (s *twoFactorService) TwoFactor(userID int64) (*trivia.TwoFactor, error) {
+	tf := &trivia.TwoFactor{UserID: userID}
+	err := s.db.QueryRow(`
+		SELECT secret, enabled, last_counter, created FROM two_factor WHERE user_id = $1;
+	`, userID).Scan(&tf.Secret, &tf.Enabled, &tf.LastCounter, &tf.Created)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return tf, nil
+}
+
+func (s *twoFactorService) StartTwoFactor(userID int64, secret []byte) (bool, error) {
+	res, err := s.db.Exec(`
+		INSERT INTO two_factor (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_counter = 0, created = now()
+		WHERE NOT two_factor.enabled;
+	`, userID, secret)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *twoFactorService) EnableTwoFactor(userID int64, counter int64, recoveryCodes []string) (bool, error) {
+	enabled := false
+	err := transact(s.db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+			UPDATE two_factor SET enabled = true, last_counter = $2 WHERE user_id = $1 AND NOT enabled;
+		`, userID, counter)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil || affected == 0 {
+			return err
+		}
+
+		enabled = true
+		return replaceRecoveryCodes(tx, userID, recoveryCodes)
+	})
+	return enabled, err
+}
+
+func (s *twoFactorService) UseTwoFactorCounter(userID int64, counter int64) (bool, error) {
+	// the counter is compared in the update so that two requests with the same code can't both use it.
+	res, err := s.db.Exec(`
+		UPDATE two_factor SET last_counter = $2 WHERE user_id = $1 AND enabled AND last_counter < $2;
+	`, userID, counter)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *twoFactorService) ReplaceTwoFactorSecret(userID int64, old []byte, secret []byte) (bool, error) {
+	res, err := s.db.Exec(`
+		UPDATE two_factor SET secret = $3 WHERE user_id = $1 AND secret = $2;
+	`, userID, old, secret)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *twoFactorService) DisableTwoFactor(userID int64) error {
+	return transact(s.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1;`, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM two_factor WHERE user_id = $1;`, userID)
+		return err
+	})
+}
+
+func (s *twoFactorService) ReplaceRecoveryCodes(userID int64, codes []string) error {
+	return transact(s.db, func(tx *sql.Tx) error {
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+}
+
+// replaceRecoveryCodes replaces a user's recovery codes inside of a transaction.
+func replaceRecoveryCodes(tx *sql.Tx, userID int64, codes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1;`, userID); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO recovery_codes (user_id, code) VALUES ($1, $2) ON CONFLICT DO NOTHING;`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, code := range codes {
+		if _, err = stmt.Exec(userID, hashToken(code)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *twoFactorService) UseRecoveryCode(userID int64, code string) (bool, error) {
+	res, err := s.db.Exec(`
+		DELETE FROM recovery_codes WHERE user_id = $1 AND code = $2;
+	`, userID, hashToken(code))
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *twoFactorService) RecoveryCodesLeft(userID int64) (int, error) {
+	var left int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1;`, userID).Scan(&left)
+	return left, err
+}
+
+// NewTwoFactorService creates a new service for storing two-factor authentication secrets and
+// recovery codes in postgres.
+func NewTwoFactorService(db *sql.DB) trivia.TwoFactorService {
+	return &twoFactorService{db: db}
+}
+
+type loginChallengeService struct {
+	db *sql.DB
+}
+
+func (s *loginChallengeService) CreateLoginChallenge(challenge *trivia.LoginChallenge) error {
+	return transact(s.db, func(tx *sql.Tx) error {
+		// expired challenges are cleaned up here since nothing else needs them.
+		if _, err := tx.Exec(`DELETE FROM login_challenges WHERE expires_at < now();`); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO login_challenges (token, user_id, expires_at) VALUES ($1, $2, $3);
+		`, hashToken(challenge.Token), challenge.UserID, challenge.ExpiresAt)
+		return err
+	})
+}
+
+func (s *loginChallengeService) LoginChallenge(token string) (*trivia.LoginChallenge, error) {
+	challenge := &trivia.LoginChallenge{Token: token}
+	err := s.db.QueryRow(`
+		SELECT user_id, expires_at, attempts FROM login_challenges WHERE token = $1 AND expires_at > now();
+	`, hashToken(token)).Scan(&challenge.UserID, &challenge.ExpiresAt, &challenge.Attempts)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func (s *loginChallengeService) CountLoginChallengeAttempt(token string) (int, error) {
+	var attempts int
+	err := s.db.QueryRow(`
+		UPDATE login_challenges SET attempts = attempts + 1 WHERE token = $1 RETURNING attempts;
+	`, hashToken(token)).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return attempts, err
+}
+
+func (s *loginChallengeService) DeleteLoginChallenge(token string) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM login_challenges WHERE token = $1;`, hashToken(token))
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// NewLoginChallengeService creates a new service for storing login challenges in postgres.
+func NewLoginChallengeService(db *sql.DB) trivia.LoginChallengeService {
+	return &loginChallengeService{db: db}
+}
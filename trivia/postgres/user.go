@@ -3,42 +3,56 @@ package postgres
 import (
 	"database/sql"
 	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 
 	"github.com/expixel/actual-trivia-server/trivia"
 )
 
+// uniqueViolation is the postgres error code for a unique constraint violation.
+const uniqueViolation = "23505"
+
+// isUniqueViolation returns true if an error was caused by a unique constraint violation.
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == uniqueViolation
+}
+
 type userService struct {
 	db *sql.DB
 }
 
-func (s *userService) UserByID(id int64) (*trivia.User, error) {
+const userColumns = `id, username, created, username_changed_at, role`
+
+func scanUser(row rowScanner) (*trivia.User, error) {
 	var user trivia.User
-	row := s.db.QueryRow(`SELECT id, username FROM users WHERE id = $1`, id)
-	if err := row.Scan(&user.ID, &user.Username); err != nil {
+	var usernameChanged pq.NullTime
+	if err := row.Scan(&user.ID, &user.Username, &user.Created, &usernameChanged, &user.Role); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
+	if usernameChanged.Valid {
+		user.UsernameChanged = usernameChanged.Time
+	}
 	return &user, nil
 }
 
+func (s *userService) UserByID(id int64) (*trivia.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+}
+
 func (s *userService) UserByUsername(username string) (*trivia.User, error) {
-	var user trivia.User
-	row := s.db.QueryRow(`SELECT id, username FROM users WHERE lower(username) = lower($1)`, username)
-	if err := row.Scan(&user.ID, &user.Username); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
-	}
-	return &user, nil
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE lower(username) = lower($1)`, username))
 }
 
 func (s *userService) CredByEmail(email string) (*trivia.UserCred, error) {
 	var cred trivia.UserCred
-	row := s.db.QueryRow(`SELECT user_id, email, password FROM user_creds WHERE lower(email) = lower($1)`, email)
-	if err := row.Scan(&cred.UserID, &cred.Email, &cred.Password); err != nil {
+	row := s.db.QueryRow(`SELECT user_id, email, password, email_verified FROM user_creds WHERE lower(email) = lower($1)`, email)
+	if err := row.Scan(&cred.UserID, &cred.Email, &cred.Password, &cred.EmailVerified); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -50,12 +64,24 @@ func (s *userService) CredByEmail(email string) (*trivia.UserCred, error) {
 func (s *userService) CredByUsername(username string) (*trivia.UserCred, error) {
 	var cred trivia.UserCred
 	row := s.db.QueryRow(`
-		SELECT c.user_id, c.email, c.password
+		SELECT c.user_id, c.email, c.password, c.email_verified
 		FROM users u
 		LEFT JOIN user_creds c ON (u.id = c.user_id)
 		WHERE lower(u.username) = lower($1)
 	`, username)
-	if err := row.Scan(&cred.UserID, &cred.Email, &cred.Password); err != nil {
+	if err := row.Scan(&cred.UserID, &cred.Email, &cred.Password, &cred.EmailVerified); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cred, nil
+}
+
+func (s *userService) CredByUserID(userID int64) (*trivia.UserCred, error) {
+	var cred trivia.UserCred
+	row := s.db.QueryRow(`SELECT user_id, email, password, email_verified FROM user_creds WHERE user_id = $1`, userID)
+	if err := row.Scan(&cred.UserID, &cred.Email, &cred.Password, &cred.EmailVerified); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -66,45 +92,287 @@ func (s *userService) CredByUsername(username string) (*trivia.UserCred, error)
 
 func (s *userService) CreateUser(user *trivia.User, cred *trivia.UserCred) error {
 	return transact(s.db, func(tx *sql.Tx) error {
-		var userID int64
-		err := tx.QueryRow(`INSERT INTO users (username) VALUES ($1) RETURNING id`, user.Username).Scan(&userID)
+		return createUser(tx, user, cred)
+	})
+}
+
+func (s *userService) CreateUserWithIdentity(user *trivia.User, cred *trivia.UserCred, identity *trivia.ExternalIdentity) error {
+	return transact(s.db, func(tx *sql.Tx) error {
+		if err := createUser(tx, user, cred); err != nil {
+			return err
+		}
+
+		identity.UserID = user.ID
+		err := tx.QueryRow(`
+			INSERT INTO external_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)
+			RETURNING created;
+		`, identity.Provider, identity.Subject, identity.UserID, identity.Email).Scan(&identity.Created)
+		if isUniqueViolation(err) {
+			return trivia.ErrIdentityInUse
+		}
+		return err
+	})
+}
+
+// createUser inserts a user and their credentials as part of a transaction.
+func createUser(tx *sql.Tx, user *trivia.User, cred *trivia.UserCred) error {
+	var userID int64
+	err := tx.QueryRow(`INSERT INTO users (username) VALUES ($1) RETURNING id`, user.Username).Scan(&userID)
+	if err != nil {
+		return err
+	}
+
+	user.ID = userID
+	cred.UserID = userID
+
+	_, err = tx.Exec(`INSERT INTO user_creds (user_id, email, password) VALUES ($1, $2, $3)`, cred.UserID, cred.Email, cred.Password)
+	return err
+}
+
+func (s *userService) DeleteUser(id int64, reservedUntil time.Time) (bool, error) {
+	deleted := false
+	err := transact(s.db, func(tx *sql.Tx) error {
+		var username string
+		err := tx.QueryRow(`SELECT username FROM users WHERE id = $1 FOR UPDATE`, id).Scan(&username)
 		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
 			return err
 		}
 
-		user.ID = userID
-		cred.UserID = userID
+		// game results are kept for the other players but nothing in them identifies the user anymore.
+		_, err = tx.Exec(`UPDATE game_participants SET username = $2 WHERE user_id = $1;`, id, trivia.DeletedUsername)
+		if err != nil {
+			return err
+		}
 
-		_, err = tx.Exec(`INSERT INTO user_creds (user_id, email, password) VALUES ($1, $2, $3)`, cred.UserID, cred.Email, cred.Password)
+		_, err = tx.Exec(`
+			INSERT INTO reserved_usernames (username, user_id, reserved_until) VALUES ($1, NULL, $2)
+			ON CONFLICT ((lower(username))) DO UPDATE SET user_id = NULL, reserved_until = $2;
+		`, username, reservedUntil)
 		if err != nil {
 			return err
 		}
 
+		res, err := tx.Exec(`DELETE FROM users WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+		aff, err := res.RowsAffected()
+		if err != nil {
+			// we shouldn't encounter this error ever so for now it's just logged and ignored.
+			log.Println("error occurred while checking rows affected: ", err)
+			return nil
+		}
+		deleted = aff > 0
 		return nil
 	})
+	return deleted, err
+}
+
+func (s *userService) NextGuestID() (int64, error) {
+	var gid int64
+	err := s.db.QueryRow("SELECT nextval('guest_id_sequence');").Scan(&gid)
+	if err != nil {
+		return 0, err
+	}
+	return gid, nil
+}
+
+func (s *userService) UpdatePassword(userID int64, password []byte) error {
+	_, err := s.db.Exec(`UPDATE user_creds SET password = $2, modified = now() WHERE user_id = $1;`, userID, password)
+	return err
+}
+
+func (s *userService) ReplacePassword(userID int64, old []byte, password []byte) (bool, error) {
+	res, err := s.db.Exec(`
+		UPDATE user_creds SET password = $3, modified = now() WHERE user_id = $1 AND password = $2;
+	`, userID, old, password)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *userService) CredsWithPasswords(afterUserID int64, limit int) ([]*trivia.UserCred, error) {
+	rows, err := s.db.Query(`
+		SELECT user_id, email, password, email_verified FROM user_creds
+		WHERE user_id > $1 AND password IS NOT NULL
+		ORDER BY user_id LIMIT $2;
+	`, afterUserID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := make([]*trivia.UserCred, 0, limit)
+	for rows.Next() {
+		var cred trivia.UserCred
+		if err := rows.Scan(&cred.UserID, &cred.Email, &cred.Password, &cred.EmailVerified); err != nil {
+			return nil, err
+		}
+		creds = append(creds, &cred)
+	}
+	return creds, rows.Err()
 }
 
-func (s *userService) DeleteUser(id int64) (bool, error) {
-	res, err := s.db.Exec(`DELETE FROM users WHERE id = $1`, id)
+func (s *userService) MarkEmailVerified(userID int64, email string) (bool, error) {
+	res, err := s.db.Exec(`
+		UPDATE user_creds SET email_verified = TRUE, modified = now()
+		WHERE user_id = $1 AND lower(email) = lower($2);
+	`, userID, email)
 	if err != nil {
 		return false, err
 	}
 	aff, err := res.RowsAffected()
 	if err != nil {
-		// we shouldn't encounter this error ever so for now it's just logged and ignored.
-		log.Println("error occurred while checking rows affected: ", err)
-		return false, nil
+		return false, err
 	}
 	return aff > 0, nil
 }
 
-func (s *userService) NextGuestID() (int64, error) {
-	var gid int64
-	err := s.db.QueryRow("SELECT nextval('guest_id_sequence');").Scan(&gid)
+func (s *userService) UpdateEmail(userID int64, email string) error {
+	_, err := s.db.Exec(`
+		UPDATE user_creds SET email = $2, email_verified = FALSE, modified = now()
+		WHERE user_id = $1;
+	`, userID, email)
+	if isUniqueViolation(err) {
+		return trivia.ErrEmailInUse
+	}
+	return err
+}
+
+func (s *userService) ChangeUsername(userID int64, username string, reservedUntil time.Time) error {
+	return transact(s.db, func(tx *sql.Tx) error {
+		var oldUsername string
+		err := tx.QueryRow(`SELECT username FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&oldUsername)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return trivia.ErrUserNotFound
+			}
+			return err
+		}
+
+		// the user gets their own reservation back if they change back to an old username.
+		_, err = tx.Exec(`
+			DELETE FROM reserved_usernames
+			WHERE reserved_until < now() OR (user_id = $1 AND lower(username) = lower($2));
+		`, userID, username)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO reserved_usernames (username, user_id, reserved_until) VALUES ($1, $2, $3)
+			ON CONFLICT ((lower(username))) DO UPDATE SET user_id = $2, reserved_until = $3;
+		`, oldUsername, userID, reservedUntil)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE users SET username = $2, username_changed_at = now(), modified = now()
+			WHERE id = $1;
+		`, userID, username)
+		if isUniqueViolation(err) {
+			return trivia.ErrUsernameInUse
+		}
+		return err
+	})
+}
+
+func (s *userService) UsernameReservation(username string) (*trivia.UsernameReservation, error) {
+	var reservation trivia.UsernameReservation
+	row := s.db.QueryRow(`
+		SELECT username, user_id, reserved_until FROM reserved_usernames
+		WHERE lower(username) = lower($1) AND reserved_until > now();
+	`, username)
+	if err := row.Scan(&reservation.Username, &reservation.UserID, &reservation.ReservedUntil); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+func (s *userService) ListUsers(search string, role trivia.Role, limit int, offset int) ([]trivia.UserListing, error) {
+	pattern := escapeLike(strings.ToLower(search)) + "%"
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, u.created, u.username_changed_at, u.role, c.email, c.email_verified
+		FROM users u
+		LEFT JOIN user_creds c ON (u.id = c.user_id)
+		WHERE (lower(u.username) LIKE $1 OR lower(c.email) LIKE $1) AND ($2 = '' OR u.role = $2)
+		ORDER BY u.id
+		LIMIT $3 OFFSET $4;
+	`, pattern, string(role), limit, offset)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return gid, nil
+	defer rows.Close()
+
+	users := make([]trivia.UserListing, 0, limit)
+	for rows.Next() {
+		var listing trivia.UserListing
+		var usernameChanged pq.NullTime
+		var email sql.NullString
+		var emailVerified sql.NullBool
+		err = rows.Scan(&listing.ID, &listing.Username, &listing.Created, &usernameChanged, &listing.Role,
+			&email, &emailVerified)
+		if err != nil {
+			return nil, err
+		}
+		if usernameChanged.Valid {
+			listing.UsernameChanged = usernameChanged.Time
+		}
+		listing.Email = email.String
+		listing.EmailVerified = emailVerified.Bool
+		users = append(users, listing)
+	}
+	return users, rows.Err()
+}
+
+func (s *userService) SetUserRole(userID int64, role trivia.Role) error {
+	return transact(s.db, func(tx *sql.Tx) error {
+		// the admins are locked so that two admins can't take away each other's role at the same time.
+		rows, err := tx.Query(`SELECT id FROM users WHERE role = $1 FOR UPDATE;`, string(trivia.RoleAdmin))
+		if err != nil {
+			return err
+		}
+		isAdmin := false
+		admins := 0
+		for rows.Next() {
+			var id int64
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			admins++
+			isAdmin = isAdmin || id == userID
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if isAdmin && role != trivia.RoleAdmin && admins == 1 {
+			return trivia.ErrLastAdmin
+		}
+
+		res, err := tx.Exec(`UPDATE users SET role = $2, modified = now() WHERE id = $1;`, userID, string(role))
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return trivia.ErrUserNotFound
+		}
+		return nil
+	})
 }
 
 // NewUserService returns a new user service backed by a postgres database.
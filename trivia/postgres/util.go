@@ -3,8 +3,12 @@ package postgres
 import (
 	"database/sql"
 	"errors"
+	"strings"
 )
 
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 // tryRollback attempts to rollback a transaction after an error.
 // If an error occurs during rollback, tryRollback will return a new
 // error with information from the original error and the rollback error merged.
@@ -29,3 +33,8 @@ func transact(db *sql.DB, transactionFn func(*sql.Tx) error) error {
 
 	return tx.Commit()
 }
+
+// escapeLike escapes s so that it only matches itself in a LIKE pattern.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,45 @@
+package postgres
+
+import (
+	"database/sql"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+type emailVerificationService struct {
+	db *sql.DB
+}
+
+func (s *emailVerificationService) CreateEmailVerificationToken(token *trivia.EmailVerificationToken) error {
+	return transact(s.db, func(tx *sql.Tx) error {
+		// expired tokens of every user are cleaned up here since nothing else needs them.
+		_, err := tx.Exec(`DELETE FROM email_verification_tokens WHERE user_id = $1 OR expires_at < now();`, token.UserID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO email_verification_tokens (token, user_id, email, expires_at) VALUES ($1, $2, $3, $4);
+		`, hashToken(token.Token), token.UserID, token.Email, token.ExpiresAt)
+		return err
+	})
+}
+
+func (s *emailVerificationService) UseEmailVerificationToken(tokenString string) (*trivia.EmailVerificationToken, error) {
+	token := &trivia.EmailVerificationToken{Token: tokenString}
+	err := s.db.QueryRow(`
+		DELETE FROM email_verification_tokens WHERE token = $1 RETURNING user_id, email, expires_at;
+	`, hashToken(tokenString)).Scan(&token.UserID, &token.Email, &token.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// NewEmailVerificationService creates a new service for storing email verification tokens in postgres.
+func NewEmailVerificationService(db *sql.DB) trivia.EmailVerificationService {
+	return &emailVerificationService{db: db}
+}
@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+type xpService struct {
+	db *sql.DB
+}
+
+func (s *xpService) PlayerXP(userID int64) (*trivia.PlayerXP, error) {
+	p := trivia.PlayerXP{UserID: userID}
+	err := s.db.QueryRow(`
+		SELECT xp, solo_day, solo_xp FROM player_xp WHERE user_id = $1;
+	`, userID).Scan(&p.XP, &p.SoloDay, &p.SoloXP)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *xpService) AddXP(userID int64, xp int64, soloXP int64, soloCap int64, day time.Time) (int64, int64, error) {
+	var added, total int64
+	err := transact(s.db, func(tx *sql.Tx) error {
+		// the row is created first so that it can be locked even for a user's first game.
+		_, err := tx.Exec(`
+			INSERT INTO player_xp (user_id, xp, solo_day, solo_xp) VALUES ($1, 0, $2, 0)
+			ON CONFLICT (user_id) DO NOTHING;
+		`, userID, day)
+		if err != nil {
+			return err
+		}
+
+		// solo XP from an earlier day doesn't count towards today's cap.
+		var earnedToday int64
+		err = tx.QueryRow(`
+			SELECT CASE WHEN solo_day = $2 THEN solo_xp ELSE 0 END
+			FROM player_xp WHERE user_id = $1 FOR UPDATE;
+		`, userID, day).Scan(&earnedToday)
+		if err != nil {
+			return err
+		}
+
+		if remaining := soloCap - earnedToday; soloXP > remaining {
+			soloXP = remaining
+		}
+		if soloXP < 0 {
+			soloXP = 0
+		}
+		added = xp + soloXP
+
+		return tx.QueryRow(`
+			UPDATE player_xp SET
+				xp = xp + $2,
+				solo_xp = CASE
+					WHEN $3 = 0 THEN solo_xp
+					WHEN solo_day = $4 THEN solo_xp + $3
+					ELSE $3
+				END,
+				solo_day = CASE WHEN $3 = 0 THEN solo_day ELSE $4 END
+			WHERE user_id = $1
+			RETURNING xp;
+		`, userID, added, soloXP, day).Scan(&total)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return added, total, nil
+}
+
+// NewXPService creates a new service for storing experience in postgres.
+func NewXPService(db *sql.DB) trivia.XPService {
+	return &xpService{db: db}
+}
@@ -0,0 +1,80 @@
+package rating
+
+import (
+	"math"
+)
+
+// Initial is the rating given to players that have not played any rated games.
+const Initial = 1500.0
+
+// ProvisionalGames is the number of rated games a player has to play before their rating
+// is considered established. Provisional ratings move faster so that new players quickly
+// end up close to their actual skill.
+const ProvisionalGames = 30
+
+const (
+	provisionalK = 40.0
+	establishedK = 20.0
+)
+
+// Player is a single player's standing in a finished game.
+type Player struct {
+	// Rating is the player's rating before the game.
+	Rating float64
+
+	// GamesPlayed is the number of rated games the player played before this one.
+	GamesPlayed int
+
+	// Placement is the player's final placement starting at 1. Players that tied share a placement.
+	Placement int
+}
+
+// KFactor returns the maximum amount a player's rating can change in a two player game.
+func KFactor(gamesPlayed int) float64 {
+	if gamesPlayed < ProvisionalGames {
+		return provisionalK
+	}
+	return establishedK
+}
+
+// Expected returns the expected score (from 0 to 1) of a player with rating a
+// against a player with rating b.
+func Expected(a float64, b float64) float64 {
+	return 1.0 / (1.0 + math.Pow(10, (b-a)/400.0))
+}
+
+// Update calculates the new ratings of the players in a game from their final placements and returns
+// them in the same order as the players. This is a multiplayer generalization of Elo where every
+// player plays an Elo match against every other player: a better placement is a win and the same
+// placement is a draw. The K factor is split between the N-1 matches so that a player's rating
+// moves about as much in a big game as it would in a single two player game.
+func Update(players []Player) []float64 {
+	ratings := make([]float64, len(players))
+	if len(players) < 2 {
+		for idx, player := range players {
+			ratings[idx] = player.Rating
+		}
+		return ratings
+	}
+
+	opponents := float64(len(players) - 1)
+	for i, player := range players {
+		delta := 0.0
+		for j, opponent := range players {
+			if i == j {
+				continue
+			}
+
+			actual := 0.5
+			if player.Placement < opponent.Placement {
+				actual = 1.0
+			} else if player.Placement > opponent.Placement {
+				actual = 0.0
+			}
+			delta += actual - Expected(player.Rating, opponent.Rating)
+		}
+
+		ratings[i] = player.Rating + KFactor(player.GamesPlayed)/opponents*delta
+	}
+	return ratings
+}
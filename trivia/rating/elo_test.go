@@ -0,0 +1,106 @@
+package rating
+
+import (
+	"math"
+	"testing"
+)
+
+const epsilon = 0.0001
+
+func assertRatings(t *testing.T, expected []float64, actual []float64) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Fatalf("expected %d ratings but got %d", len(expected), len(actual))
+	}
+
+	for idx := range expected {
+		if math.Abs(expected[idx]-actual[idx]) > epsilon {
+			t.Errorf("rating %d: expected %.4f but got %.4f", idx, expected[idx], actual[idx])
+		}
+	}
+}
+
+func TestExpected(t *testing.T) {
+	if e := Expected(1500, 1500); math.Abs(e-0.5) > epsilon {
+		t.Errorf("expected equal ratings to have an expected score of 0.5 but got %.4f", e)
+	}
+
+	// a 400 point lead makes a player 10 times as likely to win.
+	if e := Expected(1900, 1500); math.Abs(e-10.0/11.0) > epsilon {
+		t.Errorf("expected a 400 point lead to have an expected score of 10/11 but got %.4f", e)
+	}
+
+	if sum := Expected(1712, 1433) + Expected(1433, 1712); math.Abs(sum-1) > epsilon {
+		t.Errorf("expected scores of both players should add up to 1 but got %.4f", sum)
+	}
+}
+
+func TestKFactor(t *testing.T) {
+	if KFactor(0) != provisionalK || KFactor(ProvisionalGames-1) != provisionalK {
+		t.Errorf("expected provisional players to use a K factor of %.0f", provisionalK)
+	}
+	if KFactor(ProvisionalGames) != establishedK {
+		t.Errorf("expected established players to use a K factor of %.0f", establishedK)
+	}
+}
+
+func TestUpdateTwoPlayers(t *testing.T) {
+	// equal ratings, established players: the winner gets K/2 and the loser loses K/2.
+	assertRatings(t, []float64{1510, 1490}, Update([]Player{
+		{Rating: 1500, GamesPlayed: 50, Placement: 1},
+		{Rating: 1500, GamesPlayed: 50, Placement: 2},
+	}))
+
+	// a draw between equal players changes nothing.
+	assertRatings(t, []float64{1500, 1500}, Update([]Player{
+		{Rating: 1500, GamesPlayed: 50, Placement: 1},
+		{Rating: 1500, GamesPlayed: 50, Placement: 1},
+	}))
+
+	// the favourite winning gains little: 20 * (1 - 10/11).
+	assertRatings(t, []float64{1901.8182, 1498.1818}, Update([]Player{
+		{Rating: 1900, GamesPlayed: 50, Placement: 1},
+		{Rating: 1500, GamesPlayed: 50, Placement: 2},
+	}))
+
+	// the underdog winning gains a lot, and provisional players move twice as fast.
+	assertRatings(t, []float64{1536.3636, 1881.8182}, Update([]Player{
+		{Rating: 1500, GamesPlayed: 0, Placement: 1},
+		{Rating: 1900, GamesPlayed: 50, Placement: 2},
+	}))
+}
+
+func TestUpdateMultiplayer(t *testing.T) {
+	// four equal established players finishing in order: each match is worth K/3 = 6.6667
+	// and each player is 0.5 above or below expectation in each of their 3 matches.
+	assertRatings(t, []float64{1510, 1503.3333, 1496.6667, 1490}, Update([]Player{
+		{Rating: 1500, GamesPlayed: 50, Placement: 1},
+		{Rating: 1500, GamesPlayed: 50, Placement: 2},
+		{Rating: 1500, GamesPlayed: 50, Placement: 3},
+		{Rating: 1500, GamesPlayed: 50, Placement: 4},
+	}))
+
+	// tied players share a placement and draw against each other.
+	assertRatings(t, []float64{1505, 1505, 1490}, Update([]Player{
+		{Rating: 1500, GamesPlayed: 50, Placement: 1},
+		{Rating: 1500, GamesPlayed: 50, Placement: 1},
+		{Rating: 1500, GamesPlayed: 50, Placement: 3},
+	}))
+
+	// the results don't depend on the order the players are given in.
+	forward := Update([]Player{
+		{Rating: 1620, GamesPlayed: 12, Placement: 2},
+		{Rating: 1480, GamesPlayed: 80, Placement: 1},
+		{Rating: 1550, GamesPlayed: 31, Placement: 3},
+	})
+	backward := Update([]Player{
+		{Rating: 1550, GamesPlayed: 31, Placement: 3},
+		{Rating: 1480, GamesPlayed: 80, Placement: 1},
+		{Rating: 1620, GamesPlayed: 12, Placement: 2},
+	})
+	assertRatings(t, []float64{forward[2], forward[1], forward[0]}, backward)
+}
+
+func TestUpdateSinglePlayer(t *testing.T) {
+	assertRatings(t, []float64{1500}, Update([]Player{{Rating: 1500, Placement: 1}}))
+}
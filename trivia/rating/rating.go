@@ -0,0 +1,110 @@
+package rating
+
+import (
+	"github.com/expixel/actual-trivia-server/eplog"
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+var logger = eplog.NewPrefixLogger("rating")
+
+// Updater updates the ratings of registered players from the results of their games.
+type Updater struct {
+	service              trivia.RatingService
+	requireVerifiedEmail bool
+}
+
+// NewUpdater creates a new updater that stores ratings using the given service. If
+// requireVerifiedEmail is true, only users that have verified their email address are rated.
+func NewUpdater(service trivia.RatingService, requireVerifiedEmail bool) *Updater {
+	return &Updater{service: service, requireVerifiedEmail: requireVerifiedEmail}
+}
+
+// ProcessGameResult updates the ratings of the registered participants of a finished game.
+// This is meant to be used as a handler for finished games so errors are only logged.
+func (u *Updater) ProcessGameResult(result *trivia.GameResult) {
+	if err := u.UpdateRatings(result); err != nil {
+		logger.Error("error occurred while updating ratings for game %s: %s", result.GameID, err)
+	}
+}
+
+// UpdateRatings updates the ratings of the registered participants of a finished game using their
+// placements. Guests don't have ratings and are left out as if they never played, and so are
+// users that haven't verified their email address if that is required. Nothing is updated if
+// fewer than two rated users took part in the game.
+func (u *Updater) UpdateRatings(result *trivia.GameResult) error {
+	participants := make([]trivia.GameParticipantResult, 0, len(result.Participants))
+	for _, participant := range result.Participants {
+		if participant.Guest || (u.requireVerifiedEmail && !participant.EmailVerified) {
+			continue
+		}
+		participants = append(participants, participant)
+	}
+
+	if len(participants) < 2 {
+		return nil
+	}
+
+	userIDs := make([]int64, len(participants))
+	for idx, participant := range participants {
+		userIDs[idx] = participant.UserID
+	}
+
+	return u.service.ApplyRatingChanges(userIDs, Initial, func(current map[int64]trivia.PlayerRating) []trivia.RatingChange {
+		return ratingChanges(result.GameID, participants, current)
+	})
+}
+
+// ratingChanges works out the new ratings of a game's registered participants from their
+// current ratings.
+func ratingChanges(gameID string, participants []trivia.GameParticipantResult,
+	current map[int64]trivia.PlayerRating) []trivia.RatingChange {
+	players := make([]Player, len(participants))
+	for idx, participant := range participants {
+		players[idx] = Player{Rating: Initial, Placement: participant.Placement}
+		if r, ok := current[participant.UserID]; ok {
+			players[idx].Rating = r.Rating
+			players[idx].GamesPlayed = r.GamesPlayed
+		}
+	}
+
+	updated := Update(players)
+	changes := make([]trivia.RatingChange, len(participants))
+	for idx, participant := range participants {
+		changes[idx] = trivia.RatingChange{
+			UserID:    participant.UserID,
+			GameID:    gameID,
+			Placement: participant.Placement,
+			Before:    players[idx].Rating,
+			After:     updated[idx],
+		}
+	}
+	return changes
+}
+
+// PlayerRating returns a user's current rating. Users that have not played any rated
+// games yet get the initial rating.
+func (u *Updater) PlayerRating(userID int64) (trivia.PlayerRating, error) {
+	ratings, err := u.service.PlayerRatings([]int64{userID})
+	if err != nil {
+		return trivia.PlayerRating{}, err
+	}
+
+	if r, ok := ratings[userID]; ok {
+		return r, nil
+	}
+	return trivia.PlayerRating{UserID: userID, Rating: Initial}, nil
+}
+
+// MatchmakingRating returns the rating used to match a user with players of a similar skill.
+// Guests always get the initial rating.
+func (u *Updater) MatchmakingRating(user *trivia.User) (float64, error) {
+	if user.Guest {
+		return Initial, nil
+	}
+
+	r, err := u.PlayerRating(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	return r.Rating, nil
+}
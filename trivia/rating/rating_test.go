@@ -0,0 +1,154 @@
+package rating
+
+import (
+	"testing"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+type fakeRatingService struct {
+	trivia.RatingService
+	ratings map[int64]trivia.PlayerRating
+	applied []trivia.RatingChange
+}
+
+func (s *fakeRatingService) PlayerRatings(userIDs []int64) (map[int64]trivia.PlayerRating, error) {
+	ratings := make(map[int64]trivia.PlayerRating)
+	for _, userID := range userIDs {
+		if r, ok := s.ratings[userID]; ok {
+			ratings[userID] = r
+		}
+	}
+	return ratings, nil
+}
+
+func (s *fakeRatingService) ApplyRatingChanges(userIDs []int64, initial float64,
+	compute func(current map[int64]trivia.PlayerRating) []trivia.RatingChange) error {
+	if s.ratings == nil {
+		s.ratings = make(map[int64]trivia.PlayerRating)
+	}
+	for _, userID := range userIDs {
+		if _, ok := s.ratings[userID]; !ok {
+			s.ratings[userID] = trivia.PlayerRating{UserID: userID, Rating: initial}
+		}
+	}
+
+	current, _ := s.PlayerRatings(userIDs)
+	changes := compute(current)
+	for _, change := range changes {
+		r := s.ratings[change.UserID]
+		r.Rating = change.After
+		r.GamesPlayed++
+		s.ratings[change.UserID] = r
+	}
+	s.applied = append(s.applied, changes...)
+	return nil
+}
+
+func TestUpdateRatingsExcludesGuests(t *testing.T) {
+	service := &fakeRatingService{
+		ratings: map[int64]trivia.PlayerRating{
+			2: {UserID: 2, Rating: 1500, GamesPlayed: 50},
+		},
+	}
+	updater := NewUpdater(service, false)
+
+	err := updater.UpdateRatings(&trivia.GameResult{
+		GameID: "game",
+		Participants: []trivia.GameParticipantResult{
+			{UserID: -7, Username: "#Guest7", Guest: true, Placement: 1},
+			{UserID: 1, Username: "new", Placement: 2},
+			{UserID: 2, Username: "old", Placement: 3},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(service.applied) != 2 {
+		t.Fatalf("expected 2 rating changes but got %d", len(service.applied))
+	}
+
+	// the guest is ignored so the new player beat the old player head to head.
+	assertRatings(t, []float64{1520, 1490}, []float64{service.applied[0].After, service.applied[1].After})
+	if service.applied[0].UserID != 1 || service.applied[0].Before != Initial || service.applied[0].Placement != 2 {
+		t.Errorf("unexpected rating change for the new player: %+v", service.applied[0])
+	}
+}
+
+func TestUpdateRatingsExcludesUnverifiedUsers(t *testing.T) {
+	result := &trivia.GameResult{
+		GameID: "game",
+		Participants: []trivia.GameParticipantResult{
+			{UserID: 1, Username: "unverified", Placement: 1},
+			{UserID: 2, Username: "verified", EmailVerified: true, Placement: 2},
+			{UserID: 3, Username: "also verified", EmailVerified: true, Placement: 3},
+		},
+	}
+
+	service := &fakeRatingService{}
+	if err := NewUpdater(service, true).UpdateRatings(result); err != nil {
+		t.Fatal(err)
+	}
+	if len(service.applied) != 2 || service.applied[0].UserID != 2 || service.applied[1].UserID != 3 {
+		t.Fatalf("expected only the verified users to be rated but got %+v", service.applied)
+	}
+	if _, ok := service.ratings[1]; ok {
+		t.Errorf("expected the unverified user not to get a rating")
+	}
+
+	// everyone is rated when verification isn't required.
+	service = &fakeRatingService{}
+	if err := NewUpdater(service, false).UpdateRatings(result); err != nil {
+		t.Fatal(err)
+	}
+	if len(service.applied) != 3 {
+		t.Errorf("expected every user to be rated but got %d rating changes", len(service.applied))
+	}
+}
+
+func TestUpdateRatingsNeedsTwoRegisteredPlayers(t *testing.T) {
+	service := &fakeRatingService{}
+	updater := NewUpdater(service, false)
+
+	err := updater.UpdateRatings(&trivia.GameResult{
+		GameID: "game",
+		Participants: []trivia.GameParticipantResult{
+			{UserID: 1, Username: "solo", Placement: 1},
+			{UserID: -7, Username: "#Guest7", Guest: true, Placement: 2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(service.applied) != 0 {
+		t.Errorf("expected no rating changes but got %d", len(service.applied))
+	}
+}
+
+func TestUpdateRatingsUsesStoredRatings(t *testing.T) {
+	service := &fakeRatingService{}
+	updater := NewUpdater(service, false)
+	result := &trivia.GameResult{
+		GameID: "game",
+		Participants: []trivia.GameParticipantResult{
+			{UserID: 1, Username: "winner", Placement: 1},
+			{UserID: 2, Username: "loser", Placement: 2},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := updater.UpdateRatings(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the second game is worked out from the ratings that the first game stored.
+	if service.applied[2].Before != service.applied[0].After || service.applied[3].Before != service.applied[1].After {
+		t.Errorf("expected the second game to start from the first game's ratings: %+v", service.applied)
+	}
+	if r := service.ratings[1]; r.GamesPlayed != 2 || r.Rating != service.applied[2].After {
+		t.Errorf("unexpected stored rating for the winner: %+v", r)
+	}
+}
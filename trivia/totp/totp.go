@@ -0,0 +1,155 @@
+// Package totp implements time-based one-time passwords (RFC 6238) and the HMAC-based one-time
+// passwords (RFC 4226) that they are built on, as used by authenticator apps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SecretLength is the length of secrets generated by NewSecret, which is the length of a SHA-1
+// output as recommended by RFC 4226.
+const SecretLength = 20
+
+// Algorithm is the HMAC hash function that codes are generated with.
+type Algorithm string
+
+// The algorithms allowed by RFC 6238. Most authenticator apps only support SHA1.
+const (
+	SHA1   Algorithm = "SHA1"
+	SHA256 Algorithm = "SHA256"
+	SHA512 Algorithm = "SHA512"
+)
+
+func (a Algorithm) hash() func() hash.Hash {
+	switch a {
+	case SHA256:
+		return sha256.New
+	case SHA512:
+		return sha512.New
+	default:
+		return sha1.New
+	}
+}
+
+// Options are how codes are generated and validated.
+type Options struct {
+	// Digits is the number of digits in a code.
+	Digits int
+
+	// Period is how long each code is valid for.
+	Period time.Duration
+
+	Algorithm Algorithm
+
+	// Skew is the number of periods before and after the current one whose codes are also accepted
+	// so that codes still work when the clocks of the server and the app are slightly off.
+	Skew int
+}
+
+// DefaultOptions returns the options that authenticator apps use when a provisioning URI doesn't
+// say otherwise, with one period of skew in either direction.
+func DefaultOptions() Options {
+	return Options{Digits: 6, Period: 30 * time.Second, Algorithm: SHA1, Skew: 1}
+}
+
+// NewSecret generates a random secret.
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, SecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeSecret encodes a secret in base32 without padding, which is how users type it into
+// authenticator apps.
+func EncodeSecret(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// HOTP returns the code for a counter as defined in RFC 4226.
+func HOTP(secret []byte, counter uint64, digits int, algorithm Algorithm) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+	mac := hmac.New(algorithm.hash(), secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation takes 31 bits from the offset given by the last nibble.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulus)
+}
+
+// Counter returns the counter of the period that a time is in.
+func (o Options) Counter(t time.Time) uint64 {
+	return uint64(t.Unix()) / uint64(o.Period/time.Second)
+}
+
+// Code returns the code for a time.
+func (o Options) Code(secret []byte, t time.Time) string {
+	return HOTP(secret, o.Counter(t), o.Digits, o.Algorithm)
+}
+
+// Validate checks a code against the periods around a time and returns the counter of the period
+// that it matched. Callers should remember the counter and reject codes for counters that aren't
+// after it so that a code can't be used twice.
+func (o Options) Validate(secret []byte, code string, t time.Time) (uint64, bool) {
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	if len(code) != o.Digits {
+		return 0, false
+	}
+
+	current := o.Counter(t)
+	var matched uint64
+	found := 0
+	for i := -o.Skew; i <= o.Skew; i++ {
+		if i < 0 && uint64(-i) > current {
+			continue
+		}
+		counter := current + uint64(i)
+
+		// every period is checked so that the time taken doesn't say which one matched.
+		if subtle.ConstantTimeCompare([]byte(HOTP(secret, counter, o.Digits, o.Algorithm)), []byte(code)) == 1 && found == 0 {
+			matched = counter
+			found = 1
+		}
+	}
+	return matched, found == 1
+}
+
+// ProvisioningURI returns the otpauth URI that authenticator apps read from a QR code to add an
+// account. issuer is the name of the service and account is the name of the user's account.
+func (o Options) ProvisioningURI(issuer string, account string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", EncodeSecret(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", string(o.Algorithm))
+	query.Set("digits", fmt.Sprint(o.Digits))
+	query.Set("period", fmt.Sprint(int(o.Period/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHOTPVectors(t *testing.T) {
+	// test values from RFC 4226 appendix D.
+	secret := []byte("12345678901234567890")
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range expected {
+		if actual := HOTP(secret, uint64(counter), 6, SHA1); actual != code {
+			t.Errorf("counter %d: expected %s but got %s", counter, code, actual)
+		}
+	}
+}
+
+func TestTOTPVectors(t *testing.T) {
+	// test values from RFC 6238 appendix B. The seed of each algorithm is the ASCII digits repeated
+	// to the length of the hash output.
+	secrets := map[Algorithm][]byte{
+		SHA1:   []byte("12345678901234567890"),
+		SHA256: []byte("12345678901234567890123456789012"),
+		SHA512: []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	vectors := []struct {
+		time      int64
+		algorithm Algorithm
+		code      string
+	}{
+		{59, SHA1, "94287082"},
+		{59, SHA256, "46119246"},
+		{59, SHA512, "90693936"},
+		{1111111109, SHA1, "07081804"},
+		{1111111109, SHA256, "68084774"},
+		{1111111109, SHA512, "25091201"},
+		{1111111111, SHA1, "14050471"},
+		{1111111111, SHA256, "67062674"},
+		{1111111111, SHA512, "99943326"},
+		{1234567890, SHA1, "89005924"},
+		{1234567890, SHA256, "91819424"},
+		{1234567890, SHA512, "93441116"},
+		{2000000000, SHA1, "69279037"},
+		{2000000000, SHA256, "90698825"},
+		{2000000000, SHA512, "38618901"},
+		{20000000000, SHA1, "65353130"},
+		{20000000000, SHA256, "77737706"},
+		{20000000000, SHA512, "47863826"},
+	}
+
+	for _, v := range vectors {
+		o := Options{Digits: 8, Period: 30 * time.Second, Algorithm: v.algorithm}
+		if code := o.Code(secrets[v.algorithm], time.Unix(v.time, 0)); code != v.code {
+			t.Errorf("%s at %d: expected %s but got %s", v.algorithm, v.time, v.code, code)
+		}
+		if _, ok := o.Validate(secrets[v.algorithm], v.code, time.Unix(v.time, 0)); !ok {
+			t.Errorf("%s at %d: expected %s to be valid", v.algorithm, v.time, v.code)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	o := DefaultOptions()
+	now := time.Unix(1111111111, 0)
+	current := o.Counter(now)
+
+	for _, offset := range []int64{-1, 0, 1} {
+		code := HOTP(secret, uint64(int64(current)+offset), o.Digits, o.Algorithm)
+		counter, ok := o.Validate(secret, code, now)
+		if !ok || counter != uint64(int64(current)+offset) {
+			t.Errorf("expected a code from %d periods away to be valid for its own counter", offset)
+		}
+	}
+	for _, offset := range []int64{-2, 2} {
+		code := HOTP(secret, uint64(int64(current)+offset), o.Digits, o.Algorithm)
+		if _, ok := o.Validate(secret, code, now); ok {
+			t.Errorf("expected a code from %d periods away to be rejected", offset)
+		}
+	}
+
+	code := o.Code(secret, now)
+	if _, ok := o.Validate(secret, code[:3]+" "+code[3:], now); !ok {
+		t.Errorf("expected spaces in a code to be ignored")
+	}
+	if _, ok := o.Validate(secret, "12345", now); ok {
+		t.Errorf("expected a code with too few digits to be rejected")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	u, err := url.Parse(DefaultOptions().ProvisioningURI("Actual Trivia", "player", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Actual Trivia:player" {
+		t.Errorf("unexpected provisioning URI: %s", u)
+	}
+
+	query := u.Query()
+	if query.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || query.Get("issuer") != "Actual Trivia" {
+		t.Errorf("unexpected provisioning URI query: %s", u.RawQuery)
+	}
+	if query.Get("digits") != "6" || query.Get("period") != "30" || query.Get("algorithm") != "SHA1" {
+		t.Errorf("unexpected provisioning URI options: %s", u.RawQuery)
+	}
+}
@@ -15,6 +15,20 @@ type User struct {
 	ID       int64
 	Username string
 
+	// Created is the time at which the user signed up. This is zero for guests.
+	Created time.Time
+
+	// EmailVerified is true if the user has confirmed that they own their email address. This is
+	// only set on users that were loaded using an auth token.
+	EmailVerified bool
+
+	// UsernameChanged is the last time that the user changed their username. This is zero if they
+	// never have.
+	UsernameChanged time.Time
+
+	// Role decides what the user is allowed to do besides playing. Guests are always players.
+	Role Role
+
 	// these properties don't get saved to the DB:
 
 	// Guest is a flag that is set during authentication and denotes this particular
@@ -26,11 +40,57 @@ type User struct {
 	GuestID null.Int64
 }
 
+// Role is a set of permissions that a user is given.
+type Role string
+
+// The roles that users can have. Every user is a player unless they are given another role.
+const (
+	RolePlayer    Role = "player"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Roles are the roles that users can have, ordered from the least to the most permissions.
+var Roles = []Role{RolePlayer, RoleModerator, RoleAdmin}
+
+// Permission is something that a role allows users to do.
+type Permission string
+
+// The permissions that roles can give.
+const (
+	// PermissionViewUsers allows listing users and seeing their account details.
+	PermissionViewUsers Permission = "users.view"
+
+	// PermissionManageRoles allows changing the roles of users.
+	PermissionManageRoles Permission = "users.roles"
+
+	// PermissionManageGames allows inspecting live games and ending them.
+	PermissionManageGames Permission = "games.manage"
+
+	// PermissionModerateQuestions allows editing questions and taking them out of games.
+	PermissionModerateQuestions Permission = "questions.moderate"
+)
+
+// rolePermissions are the permissions that each role gives. Players don't have any.
+var rolePermissions = map[Role][]Permission{
+	RoleModerator: {PermissionViewUsers, PermissionManageGames, PermissionModerateQuestions},
+	RoleAdmin:     {PermissionViewUsers, PermissionManageRoles, PermissionManageGames, PermissionModerateQuestions},
+}
+
+// UserListing is a user as shown to the staff that manage users. EmailVerified is always set.
+type UserListing struct {
+	User
+	Email string
+}
+
 // UserCred is a representation of a user's login credentials.
 type UserCred struct {
 	UserID   int64
 	Email    string
 	Password []byte
+
+	// EmailVerified is true if the user has confirmed that they own the email address.
+	EmailVerified bool
 }
 
 // Question is a representation of a single trivia question.
@@ -42,6 +102,10 @@ type Question struct {
 	Choices       []string
 	CorrectChoice int
 	Source        string
+
+	// Disabled questions were taken out of games by a moderator. They are kept so that the results
+	// and daily challenges that already used them still make sense.
+	Disabled bool
 }
 
 // AuthToken is a representation of an authentication used for signing and verifying requests to the API.
@@ -50,18 +114,34 @@ type AuthToken struct {
 	UserID    null.Int64
 	GuestID   null.Int64
 	ExpiresAt time.Time
+
+	// Family is the family of the refresh token that was issued alongside this token.
+	Family string
+}
+
+// Expired returns true if the token can no longer be used to authenticate at the given time.
+func (t *AuthToken) Expired(now time.Time) bool {
+	return now.After(t.ExpiresAt)
 }
 
 // RefreshToken is a representation of a token used for getting a new auth token after it has expired.
 type RefreshToken struct {
 	Token string
 
-	// AuthToken is the auth token that this refresh token is for.
+	// AuthToken is the auth token that this refresh token is for. This is not set on tokens loaded
+	// from storage since only a digest of the auth token is kept.
 	AuthToken string
 
 	UserID    null.Int64
 	GuestID   null.Int64
 	ExpiresAt time.Time
+
+	// Family is shared by every refresh token that was rotated from the same login. If a
+	// rotated refresh token is ever used again the whole family is revoked.
+	Family string
+
+	// Used is true if the token has already been rotated for a new token pair.
+	Used bool
 }
 
 // TokenPair is a pair of auth and refresh tokens
@@ -70,6 +150,338 @@ type TokenPair struct {
 	Refresh *RefreshToken
 }
 
+// PasswordResetToken is a token that was emailed to a user to let them choose a new password.
+type PasswordResetToken struct {
+	Token     string
+	UserID    int64
+	ExpiresAt time.Time
+}
+
+// EmailVerificationToken is a token that was emailed to a user to confirm that they own their email address.
+type EmailVerificationToken struct {
+	Token  string
+	UserID int64
+
+	// Email is the address that the token was sent to. Only that address is verified by the token
+	// in case the user's email address changes in the meantime.
+	Email     string
+	ExpiresAt time.Time
+}
+
+// ExternalIdentity links a user to an account at an OpenID Connect provider so that they can log in
+// with the provider.
+type ExternalIdentity struct {
+	// Provider is the configured name of the provider and Subject is the ID of the account at the
+	// provider. Together they identify the account.
+	Provider string
+	Subject  string
+	UserID   int64
+
+	// Email is the email address that the provider had for the account when it was linked.
+	Email   string
+	Created time.Time
+}
+
+// TwoFactor is a user's secret for time-based one-time passwords (TOTP) from an authenticator app.
+type TwoFactor struct {
+	UserID int64
+
+	// Secret is encrypted with a pepper key.
+	Secret []byte
+
+	// Enabled is false until the user confirms that their app works by entering a code from it.
+	Enabled bool
+
+	// LastCounter is the counter of the last code that was used so that codes can't be used twice.
+	LastCounter int64
+	Created     time.Time
+}
+
+// LoginChallenge is a login with a correct password that still has to be finished with a code from
+// the user's authenticator app or a recovery code.
+type LoginChallenge struct {
+	Token     string
+	UserID    int64
+	ExpiresAt time.Time
+
+	// Attempts is the number of codes that were tried with the challenge.
+	Attempts int
+}
+
+// LoginResult is the result of logging in. Pair is set if the user was logged in, and otherwise
+// Challenge is set and the user has to finish logging in with a second factor.
+type LoginResult struct {
+	Pair      *TokenPair
+	Challenge *LoginChallenge
+}
+
+// LoginAttempts are the recent failed logins for an IP address or account.
+type LoginAttempts struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+}
+
+// ClientInfo describes the client that a token pair was issued to.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// Session is a login on a single client. Every token pair rotated from the same login shares a
+// refresh token family and belongs to the same session.
+type Session struct {
+	ID        int64
+	Family    string
+	UserID    null.Int64
+	GuestID   null.Int64
+	CreatedAt time.Time
+
+	// LastUsedAt is the last time the session's tokens were refreshed. Auth tokens are short lived
+	// so an active client refreshes at least once per auth token lifetime.
+	LastUsedAt time.Time
+
+	// UserAgent and IP are the user agent and IP address of the client that last logged in or
+	// refreshed the session's tokens.
+	UserAgent string
+	IP        string
+}
+
+// DailyChallenge is the set of questions that every user gets for a single UTC day.
+type DailyChallenge struct {
+	// Day is midnight (UTC) of the day that this challenge is for.
+	Day       time.Time
+	Questions []Question
+}
+
+// DailyAttempt is a single user's attempt at a daily challenge.
+type DailyAttempt struct {
+	Day            time.Time
+	UserID         int64
+	Score          int
+	CorrectAnswers int
+
+	// Finished is true if the user answered every question in the daily challenge.
+	Finished   bool
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// DailyStreak is the number of consecutive days that a user has played the daily challenge.
+type DailyStreak struct {
+	UserID  int64
+	Current int
+	Longest int
+
+	// LastDay is the last day that the user played the daily challenge.
+	LastDay time.Time
+}
+
+// DailyLeaderboardEntry is a single user's position on a daily challenge leaderboard.
+type DailyLeaderboardEntry struct {
+	Rank           int
+	UserID         int64
+	Username       string
+	Score          int
+	CorrectAnswers int
+}
+
+// ScheduledGameOptions are the options used to create a scheduled game once its lobby opens.
+type ScheduledGameOptions struct {
+	MinParticipants int `json:"minParticipants"`
+	MaxParticipants int `json:"maxParticipants"`
+	QuestionCount   int `json:"questionCount"`
+	AnswerSeconds   int `json:"answerSeconds"`
+}
+
+// ScheduledGame is a game that has been announced in advance and starts at a set time.
+type ScheduledGame struct {
+	ID       int64
+	Title    string
+	StartsAt time.Time
+	Options  ScheduledGameOptions
+
+	HostUserID   null.Int64
+	HostUsername null.String
+
+	// GameID is the ID of the running game that was created for this scheduled game once
+	// its lobby opened. This is null until then.
+	GameID null.String
+
+	// RSVPCount is the number of users that have RSVP'd to this game.
+	RSVPCount int
+}
+
+// GameResult is the final outcome of a finished multiplayer game.
+type GameResult struct {
+	// ID is only set on results that have been loaded from storage.
+	ID            int64
+	GameID        string
+	QuestionCount int
+	FinishedAt    time.Time
+
+	// MinParticipants is the number of participants that the game needed to start. It is not set
+	// on stored results.
+	MinParticipants int
+
+	// Participants are ordered by their placement.
+	Participants []GameParticipantResult
+}
+
+// GameParticipantResult is the final standing of a single participant in a game.
+type GameParticipantResult struct {
+	// UserID is the ID of the participant. Like User.ID this is negative for guests.
+	UserID   int64
+	Username string
+	Guest    bool
+
+	// EmailVerified is true if the participant had verified their email address when they played.
+	// It is not set on stored results.
+	EmailVerified bool
+
+	// Placement starts at 1. Participants with the same score share a placement.
+	Placement      int
+	Score          int
+	CorrectAnswers int
+
+	// FastestCorrectAnswer is how quickly the participant answered their fastest correct answer after
+	// the question was asked. This is 0 if they had no correct answers. It is not set on stored results.
+	FastestCorrectAnswer time.Duration
+
+	// Categories are the participant's totals for each category of question in the game.
+	Categories []CategoryScore
+}
+
+// Questions returns the number of questions that the participant answered without skipping.
+func (p *GameParticipantResult) Questions() int {
+	questions := 0
+	for _, categoryScore := range p.Categories {
+		questions += categoryScore.Questions
+	}
+	return questions
+}
+
+// Won returns true if the participant won a game against at least one other participant.
+func (r *GameResult) Won(participant *GameParticipantResult) bool {
+	return participant.Placement == 1 && len(r.Participants) > 1
+}
+
+// CategoryScore is a participant's totals for the questions in a single category of a game.
+type CategoryScore struct {
+	Category       string
+	Score          int
+	CorrectAnswers int
+
+	// Questions is the number of questions in the category that the participant answered
+	// or let run out. Skipped questions are not counted.
+	Questions int
+}
+
+// UserStats are the totals of every game a user has finished.
+type UserStats struct {
+	GamesPlayed    int
+	Wins           int
+	Score          int64
+	CorrectAnswers int
+	Questions      int
+
+	// Categories are ordered by the number of questions the user has answered in them.
+	Categories []CategoryStats
+}
+
+// CategoryStats are a user's totals for every question they have answered in a single category.
+type CategoryStats struct {
+	Category       string
+	Games          int
+	Score          int64
+	CorrectAnswers int
+	Questions      int
+}
+
+// Badge is an achievement that has been awarded to a user.
+type Badge struct {
+	AchievementID string
+	AwardedAt     time.Time
+}
+
+// PlayerXP is the experience that a user has earned from playing games.
+type PlayerXP struct {
+	UserID int64
+	XP     int64
+
+	// SoloXP is the XP the user earned from solo games on SoloDay. This is used to cap the XP
+	// that can be earned from solo games each day.
+	SoloDay time.Time
+	SoloXP  int64
+}
+
+// MatchHistoryEntry is a single finished game in a user's match history.
+type MatchHistoryEntry struct {
+	ResultID         int64
+	GameID           string
+	FinishedAt       time.Time
+	QuestionCount    int
+	ParticipantCount int
+	Placement        int
+	Score            int
+	CorrectAnswers   int
+}
+
+// LeaderboardPeriod is the period of time that a leaderboard covers.
+type LeaderboardPeriod string
+
+// leaderboard periods:
+const (
+	LeaderboardAllTime = LeaderboardPeriod("all")
+	LeaderboardWeekly  = LeaderboardPeriod("weekly")
+)
+
+// LeaderboardRanking is what the players on a leaderboard are ranked by.
+type LeaderboardRanking string
+
+// leaderboard rankings:
+const (
+	RankByRating = LeaderboardRanking("rating")
+	RankByScore  = LeaderboardRanking("score")
+	RankByWins   = LeaderboardRanking("wins")
+)
+
+// LeaderboardQuery selects a leaderboard. An empty category is the leaderboard for every category.
+type LeaderboardQuery struct {
+	Period   LeaderboardPeriod
+	Category string
+	RankBy   LeaderboardRanking
+}
+
+// LeaderboardEntry is a single user's place on a leaderboard.
+type LeaderboardEntry struct {
+	Rank     int
+	UserID   int64
+	Username string
+	Rating   float64
+	Score    int64
+	Wins     int
+	Games    int
+}
+
+// PlayerRating is a user's current skill rating.
+type PlayerRating struct {
+	UserID      int64
+	Rating      float64
+	GamesPlayed int
+	UpdatedAt   time.Time
+}
+
+// RatingChange is a change to a user's rating caused by a single game.
+type RatingChange struct {
+	UserID    int64
+	GameID    string
+	Placement int
+	Before    float64
+	After     float64
+	CreatedAt time.Time
+}
+
 // A UserService contains methods for finding, creating, and modifying users.
 type UserService interface {
 	// UserById finds a user using their ID.
@@ -87,12 +499,183 @@ type UserService interface {
 	// CreateUser creates a user as well as their credentials.
 	CreateUser(user *User, cred *UserCred) error
 
+	// CreateUserWithIdentity creates a user and their credentials and links an account at an OpenID
+	// Connect provider to them, so that the user is never left without a way to log in. This returns
+	// ErrIdentityInUse if the account is already linked to a user, in which case no user is created.
+	CreateUserWithIdentity(user *User, cred *UserCred, identity *ExternalIdentity) error
+
 	// DeleteUser deletes a user from the data store by ID, and returns true if a user with the
-	// given ID did exist and was deleted.
-	DeleteUser(id int64) (bool, error)
+	// given ID did exist and was deleted. The results of the games they played are kept with
+	// DeletedUsername in place of their username, and their username is reserved until reservedUntil.
+	DeleteUser(id int64, reservedUntil time.Time) (bool, error)
 
 	// NextGuestID generates an ID that should be used by the next guest account.
 	NextGuestID() (int64, error)
+
+	// UpdatePassword replaces a user's password with an already prepared password.
+	UpdatePassword(userID int64, password []byte) error
+
+	// ReplacePassword replaces a user's password with an already prepared password only if their
+	// stored password is still old. This returns false if the password was changed in the meantime.
+	ReplacePassword(userID int64, old []byte, password []byte) (bool, error)
+
+	// CredsWithPasswords returns up to limit credentials that have a password, ordered by user ID
+	// and starting after the user with the ID afterUserID.
+	CredsWithPasswords(afterUserID int64, limit int) ([]*UserCred, error)
+
+	// CredByUserID finds a user's credentials using their ID.
+	CredByUserID(userID int64) (*UserCred, error)
+
+	// MarkEmailVerified marks a user's email address as verified if it is still the given address.
+	// This returns false if the user's email address has changed.
+	MarkEmailVerified(userID int64, email string) (bool, error)
+
+	// UpdateEmail changes a user's email address. The new address is not verified. This returns
+	// ErrEmailInUse if another user has the address.
+	UpdateEmail(userID int64, email string) error
+
+	// ChangeUsername changes a user's username and reserves their old username for them until
+	// reservedUntil. This returns ErrUsernameInUse if another user has the username.
+	ChangeUsername(userID int64, username string, reservedUntil time.Time) error
+
+	// UsernameReservation finds the reservation of a username that used to belong to someone. This
+	// returns nil if the username isn't reserved or the reservation is over.
+	UsernameReservation(username string) (*UsernameReservation, error)
+
+	// ListUsers returns users ordered by ID whose username or email address starts with search.
+	// Users are only returned if they have the given role, unless role is empty.
+	ListUsers(search string, role Role, limit int, offset int) ([]UserListing, error)
+
+	// SetUserRole changes a user's role. This returns ErrUserNotFound if there is no such user and
+	// ErrLastAdmin if the user is the only admin left.
+	SetUserRole(userID int64, role Role) error
+}
+
+// A UsernameReservation keeps a username that a user changed away from so that nobody else can
+// take it for a while.
+type UsernameReservation struct {
+	Username string
+
+	// UserID is null if the username belonged to a user that was deleted.
+	UserID        null.Int64
+	ReservedUntil time.Time
+}
+
+// DeletedUsername replaces the username of deleted users in the results of the games they played.
+const DeletedUsername = "[deleted]"
+
+// A PasswordResetService stores password reset tokens. Only a digest of each token is stored.
+type PasswordResetService interface {
+	// CreatePasswordResetToken stores a new reset token for a user. Any reset tokens that the user
+	// already had are deleted so that only the most recently emailed token works.
+	CreatePasswordResetToken(token *PasswordResetToken) error
+
+	// UsePasswordResetToken deletes a reset token and returns it so that it can't be used again.
+	// This returns nil if there is no such token.
+	UsePasswordResetToken(token string) (*PasswordResetToken, error)
+}
+
+// An EmailVerificationService stores email verification tokens. Only a digest of each token is stored.
+type EmailVerificationService interface {
+	// CreateEmailVerificationToken stores a new verification token for a user. Any verification tokens
+	// that the user already had are deleted so that only the most recently emailed token works.
+	CreateEmailVerificationToken(token *EmailVerificationToken) error
+
+	// UseEmailVerificationToken deletes a verification token and returns it so that it can't be used
+	// again. This returns nil if there is no such token.
+	UseEmailVerificationToken(token string) (*EmailVerificationToken, error)
+}
+
+// An ExternalIdentityService stores the accounts at OpenID Connect providers that users log in with.
+type ExternalIdentityService interface {
+	// IdentityBySubject finds a linked identity using the provider's name and the account's subject.
+	// This returns nil if the account isn't linked to a user.
+	IdentityBySubject(provider string, subject string) (*ExternalIdentity, error)
+
+	// LinkIdentity links an account at a provider to a user. This returns ErrIdentityInUse if the
+	// account is already linked to a user.
+	LinkIdentity(identity *ExternalIdentity) error
+
+	// UserIdentities returns the identities that are linked to a user.
+	UserIdentities(userID int64) ([]ExternalIdentity, error)
+}
+
+// A TwoFactorService stores the TOTP secrets and recovery codes of users. Only digests of recovery
+// codes are stored.
+type TwoFactorService interface {
+	// TwoFactor finds a user's TOTP secret. This returns nil if the user never started enabling
+	// two-factor authentication.
+	TwoFactor(userID int64) (*TwoFactor, error)
+
+	// StartTwoFactor stores a new secret for a user that isn't enabled yet, replacing any other
+	// secret that isn't enabled yet. This returns false if the user already has a secret enabled.
+	StartTwoFactor(userID int64, secret []byte) (bool, error)
+
+	// EnableTwoFactor enables a user's secret after it was confirmed with the code for counter and
+	// replaces their recovery codes. This returns false if the user has no secret that isn't enabled.
+	EnableTwoFactor(userID int64, counter int64, recoveryCodes []string) (bool, error)
+
+	// UseTwoFactorCounter records that a user used the code for counter. This returns false if the
+	// code for that counter or a later one was already used.
+	UseTwoFactorCounter(userID int64, counter int64) (bool, error)
+
+	// ReplaceTwoFactorSecret replaces a user's encrypted secret with the same secret encrypted
+	// differently only if it is still old.
+	ReplaceTwoFactorSecret(userID int64, old []byte, secret []byte) (bool, error)
+
+	// DisableTwoFactor deletes a user's secret and recovery codes.
+	DisableTwoFactor(userID int64) error
+
+	// ReplaceRecoveryCodes replaces a user's recovery codes.
+	ReplaceRecoveryCodes(userID int64, codes []string) error
+
+	// UseRecoveryCode deletes one of a user's recovery codes so that it can't be used again. This
+	// returns false if the user doesn't have the code.
+	UseRecoveryCode(userID int64, code string) (bool, error)
+
+	// RecoveryCodesLeft returns the number of recovery codes that a user hasn't used.
+	RecoveryCodesLeft(userID int64) (int, error)
+}
+
+// A LoginChallengeService stores logins that are waiting for a second factor. Only a digest of each
+// challenge token is stored.
+type LoginChallengeService interface {
+	// CreateLoginChallenge stores a new challenge.
+	CreateLoginChallenge(challenge *LoginChallenge) error
+
+	// LoginChallenge finds a challenge. This returns nil if there is no such challenge or it has expired.
+	LoginChallenge(token string) (*LoginChallenge, error)
+
+	// CountLoginChallengeAttempt counts an attempt at a challenge and returns how many attempts
+	// there have been, or 0 if there is no such challenge.
+	CountLoginChallengeAttempt(token string) (int, error)
+
+	// DeleteLoginChallenge deletes a challenge and returns false if there was no such challenge.
+	DeleteLoginChallenge(token string) (bool, error)
+}
+
+// A LoginAttemptService counts failed logins so that passwords can't be guessed indefinitely. Keys
+// identify what the failures are counted for, such as an IP address or an account.
+type LoginAttemptService interface {
+	// LoginAttempts returns the failed logins for a key. This returns nil if there are none.
+	LoginAttempts(key string) (*LoginAttempts, error)
+
+	// RecordLoginAttempt counts a login at the given time as a failure for a key before it is known
+	// whether it fails, unless allow returns false for the key's current failures. The key is locked
+	// while allow is called so that parallel logins are decided one at a time. The key's earlier
+	// failures are forgotten if its last failure was more than resetAfter before the given time.
+	// This returns the key's failures including the new one and whether it was counted.
+	RecordLoginAttempt(key string, at time.Time, resetAfter time.Duration, allow func(current LoginAttempts) bool) (*LoginAttempts, bool, error)
+
+	// UndoLoginFailure takes back one failure from a key for a login that turned out not to fail.
+	UndoLoginFailure(key string) error
+
+	// ClearLoginFailures forgets the failed logins for a key.
+	ClearLoginFailures(key string) error
+
+	// DeleteStaleLoginAttempts forgets the failed logins of every key whose last failure was before
+	// the given time and returns how many keys were forgotten.
+	DeleteStaleLoginAttempts(before time.Time) (int64, error)
 }
 
 // An AuthTokenService contains methods for creating and retrieving authentication and refresh tokens.
@@ -100,8 +683,9 @@ type AuthTokenService interface {
 	// AuthTokenByString finds an authentication token using the token string.
 	AuthTokenByString(token string) (*AuthToken, error)
 
-	// CreateTokenPair inserts both an auth token and refresh token into the database.
-	CreateTokenPair(auth *AuthToken, refresh *RefreshToken) error
+	// CreateTokenPair inserts both an auth token and refresh token into the database along with a
+	// new session for their family.
+	CreateTokenPair(auth *AuthToken, refresh *RefreshToken, client ClientInfo) error
 
 	// AuthTokenExists returns true if a the given token already exists in the database.
 	AuthTokenExists(token string) (bool, error)
@@ -116,6 +700,35 @@ type AuthTokenService interface {
 	// DeleteToken deletes an auth token (and optionally its refresh token) from the database.
 	// This returns true if a token was deleted, or false otherwise.
 	DeleteToken(token string, deleteRefresh bool) (bool, error)
+
+	// RefreshTokenByString finds a refresh token using the token string. This returns nil
+	// if there is no such token.
+	RefreshTokenByString(token string) (*RefreshToken, error)
+
+	// RotateTokenPair marks a refresh token as used, deletes the auth token it was issued with
+	// and inserts a new token pair in its place. The token family's session is marked as used by
+	// the given client. This returns false without changing anything if the refresh token was
+	// already used.
+	RotateTokenPair(used *RefreshToken, auth *AuthToken, refresh *RefreshToken, client ClientInfo) (bool, error)
+
+	// RevokeTokenFamily deletes every auth and refresh token in a refresh token family along with
+	// its session.
+	RevokeTokenFamily(family string) error
+
+	// UserSessions returns the sessions of a user ordered by when they were last used, most recent first.
+	UserSessions(userID int64) ([]Session, error)
+
+	// SessionByID finds a session using its ID. This returns nil if there is no such session.
+	SessionByID(id int64) (*Session, error)
+
+	// RevokeUserSessions revokes every session of a user and returns the token families that
+	// were revoked.
+	RevokeUserSessions(userID int64) ([]string, error)
+
+	// DeleteExpiredTokens deletes up to limit auth tokens and up to limit refresh tokens that expired
+	// before the given time. Up to limit sessions that have no refresh tokens left are deleted too.
+	// This returns the number of auth and refresh tokens that were deleted.
+	DeleteExpiredTokens(before time.Time, limit int) (authDeleted int64, refreshDeleted int64, err error)
 }
 
 // An AuthService contains methods for authenticating users.
@@ -123,22 +736,214 @@ type AuthService interface {
 	// LoginWithEmailOrUsername attempts to authenticate a user by matching the email address or username  and password
 	// with a user in the data store. Returns the found and authenticated user with authentication
 	// is successful. This may return one of the known errors: ErrUserNotFound, or ErrIncorrectPassword
-	// which are recoverable.
-	LoginWithEmailOrUsername(emailOrUsername string, password string) (*TokenPair, error)
+	// which are recoverable. Users with two-factor authentication get a challenge instead of tokens.
+	LoginWithEmailOrUsername(emailOrUsername string, password string, client ClientInfo) (*LoginResult, error)
+
+	// StartLogin logs in a user whose identity was checked some other way, such as through an
+	// OpenID Connect provider. Users with two-factor authentication get a challenge instead of tokens.
+	StartLogin(userID int64, client ClientInfo) (*LoginResult, error)
+
+	// FinishLogin finishes a login that was given a challenge with a code from the user's
+	// authenticator app or a recovery code. This returns ErrTokenNotFound if the challenge has
+	// expired or had too many attempts, and ErrIncorrectTwoFactorCode if the code is wrong.
+	FinishLogin(challenge string, code string, client ClientInfo) (*TokenPair, error)
 
 	// CreateUser creates a user and their credentials and adds them to the data store.
 	CreateUser(username string, email string, password string) (*User, *UserCred, error)
 
+	// CreateUserWithIdentity creates a user without a password that logs in with the given account
+	// at an OpenID Connect provider until they set a password with a password reset. This returns
+	// ErrIdentityInUse if the account is already linked to a user and otherwise the same errors as
+	// CreateUser.
+	CreateUserWithIdentity(username string, email string, identity *ExternalIdentity) (*User, *UserCred, error)
+
+	// LoginUser creates a pair of tokens for a user that was already authenticated some other way.
+	LoginUser(userID int64, client ClientInfo) (*TokenPair, error)
+
 	// LoginAsGuest creates a pair of tokens for a guest account.
-	LoginAsGuest() (*TokenPair, error)
+	LoginAsGuest(client ClientInfo) (*TokenPair, error)
 
 	// LogoutUserWithToken logs a user out using an auth token (invalidates the token)
 	LogoutUserWithToken(token string) error
+
+	// RefreshTokenPair exchanges a refresh token for a new token pair. The refresh token can only be
+	// used once; using it again returns ErrTokenReused and revokes every token that was issued from
+	// the same login. This may also return ErrTokenNotFound or ErrTokenExpired.
+	RefreshTokenPair(refreshToken string, client ClientInfo) (*TokenPair, error)
+
+	// UserSessions returns the sessions that a user is currently logged in with.
+	UserSessions(userID int64) ([]Session, error)
+
+	// RevokeSession logs a user out of one of their sessions. This returns ErrSessionNotFound if
+	// the user has no session with the given ID.
+	RevokeSession(userID int64, sessionID int64) error
+
+	// RevokeAllSessions logs a user out of every session.
+	RevokeAllSessions(userID int64) error
+
+	// UpgradeGuest creates a user for the guest that the given auth token belongs to and gives them
+	// the guest's games. The guest's tokens are replaced by a new token pair for the user. This
+	// returns ErrNotGuest if the token doesn't belong to a guest and otherwise returns the same
+	// errors as CreateUser.
+	UpgradeGuest(guest *AuthToken, username string, email string, password string, client ClientInfo) (*User, *TokenPair, error)
 }
 
 // A QuestionService contains methods for fetching and interacting with questions.
 type QuestionService interface {
+	// GetRandomQuestions returns random questions that aren't disabled, like every method that picks
+	// questions for games.
 	GetRandomQuestions(count int) ([]Question, error)
+
+	// GetRandomQuestionsInCategories returns random questions that are in one of the given categories.
+	// Fewer than count questions are returned if there are not enough questions in the categories.
+	GetRandomQuestionsInCategories(count int, categories []string) ([]Question, error)
+
+	// QuestionIDs returns the IDs of every question that isn't disabled in ascending order.
+	QuestionIDs() ([]int64, error)
+
+	// QuestionsByIDs returns the questions with the given IDs in the same order as the IDs. Disabled
+	// questions are included.
+	QuestionsByIDs(ids []int64) ([]Question, error)
+
+	// ListQuestions returns questions ordered by ID whose prompt contains search, including the
+	// disabled ones. Questions are only returned if they are in category, unless it is empty.
+	ListQuestions(search string, category string, limit int, offset int) ([]Question, error)
+
+	// UpdateQuestion replaces a question's category, difficulty, prompt, choices, correct choice and
+	// source. This returns false if there is no such question.
+	UpdateQuestion(question *Question) (bool, error)
+
+	// SetQuestionDisabled takes a question out of new games or puts it back. This returns false if
+	// there is no such question.
+	SetQuestionDisabled(id int64, disabled bool) (bool, error)
+}
+
+// A ScheduledGameService contains methods for storing scheduled games and their RSVPs.
+type ScheduledGameService interface {
+	// CreateScheduledGame stores a new scheduled game and sets its ID.
+	CreateScheduledGame(game *ScheduledGame) error
+
+	// ScheduledGameByID finds a scheduled game using its ID. This returns nil if there is no such game.
+	ScheduledGameByID(id int64) (*ScheduledGame, error)
+
+	// UpcomingScheduledGames returns the games that start after the given time ordered by their start time.
+	UpcomingScheduledGames(after time.Time, limit int, offset int) ([]ScheduledGame, error)
+
+	// DueScheduledGames returns the games without a GameID that start between from and to.
+	DueScheduledGames(from time.Time, to time.Time) ([]ScheduledGame, error)
+
+	// SetScheduledGameID sets the ID of the running game that was created for a scheduled game.
+	SetScheduledGameID(id int64, gameID string) error
+
+	// AddRSVP adds a user's RSVP to a scheduled game as long as the game has fewer than maxRSVPs.
+	// This returns false if the game is full or the user has already RSVP'd.
+	AddRSVP(scheduledGameID int64, userID int64, maxRSVPs int) (bool, error)
+
+	// RemoveRSVP removes a user's RSVP from a scheduled game and returns true if there was one.
+	RemoveRSVP(scheduledGameID int64, userID int64) (bool, error)
+
+	// RSVPUserIDs returns the IDs of the users that have RSVP'd to a scheduled game.
+	RSVPUserIDs(scheduledGameID int64) ([]int64, error)
+}
+
+// A DailyService contains methods for storing daily challenges and the users' attempts at them.
+type DailyService interface {
+	// DailyChallengeForDay finds the daily challenge for a UTC day. This returns nil
+	// if the challenge for that day has not been created yet.
+	DailyChallengeForDay(day time.Time) (*DailyChallenge, error)
+
+	// CreateDailyChallenge stores the questions for a UTC day. If questions have already been
+	// stored for that day they are left alone so that every server agrees on the day's questions.
+	CreateDailyChallenge(day time.Time, questionIDs []int64) error
+
+	// DailyAttempt finds a user's attempt at the daily challenge for a day. This returns
+	// nil if the user has not attempted that day's challenge.
+	DailyAttempt(day time.Time, userID int64) (*DailyAttempt, error)
+
+	// StartDailyAttempt records that a user has started the daily challenge for a day. This returns
+	// false if the user has already started that day's challenge.
+	StartDailyAttempt(day time.Time, userID int64) (bool, error)
+
+	// FinishDailyAttempt records the final score of a user's attempt and updates their streak.
+	FinishDailyAttempt(attempt *DailyAttempt) error
+
+	// DailyLeaderboard returns the finished attempts for a day ordered by rank.
+	DailyLeaderboard(day time.Time, limit int, offset int) ([]DailyLeaderboardEntry, error)
+
+	// DailyStreak returns a user's daily challenge streak.
+	DailyStreak(userID int64) (*DailyStreak, error)
+}
+
+// A RatingService contains methods for storing users' skill ratings and their history.
+type RatingService interface {
+	// PlayerRatings returns the current ratings of the given users. Users without a rating
+	// are left out of the returned map.
+	PlayerRatings(userIDs []int64) (map[int64]PlayerRating, error)
+
+	// ApplyRatingChanges locks the ratings of the given users, passes them to compute, and stores
+	// the changes that it returns and adds them to the users' rating history, all in one
+	// transaction so that games finishing at the same time can't overwrite each other's changes.
+	// Users without a rating are given the initial rating before compute is called.
+	ApplyRatingChanges(userIDs []int64, initial float64, compute func(current map[int64]PlayerRating) []RatingChange) error
+
+	// RatingHistory returns a user's rating changes starting with the most recent.
+	RatingHistory(userID int64, limit int, offset int) ([]RatingChange, error)
+}
+
+// A GameResultService contains methods for storing the results of finished games.
+type GameResultService interface {
+	// StoreGameResult stores the result of a finished game and adds it to the leaderboards.
+	StoreGameResult(result *GameResult) error
+
+	// GameResultByID finds a stored game result using its ID. This returns nil if there is no such result.
+	// Guest participants have a UserID of 0 since guests are not stored.
+	GameResultByID(id int64) (*GameResult, error)
+
+	// UserStats returns the totals of every game a user has finished.
+	UserStats(userID int64) (*UserStats, error)
+
+	// MatchHistory returns the games a user has finished starting with the most recent.
+	MatchHistory(userID int64, limit int, offset int) ([]MatchHistoryEntry, error)
+
+	// TransferGuestResults gives the games that a guest has finished to a user and adds them to the
+	// user's totals. This returns the number of games that were transferred. Guests don't have
+	// category totals so only the user's overall totals include the transferred games.
+	TransferGuestResults(guestID int64, userID int64, username string) (int64, error)
+}
+
+// An AchievementService stores the achievements that have been awarded to users.
+type AchievementService interface {
+	// AwardAchievements awards achievements to a user and returns the IDs of the achievements
+	// that the user did not already have.
+	AwardAchievements(userID int64, achievementIDs []string, awardedAt time.Time) ([]string, error)
+
+	// UserBadges returns every achievement that has been awarded to a user starting with the oldest.
+	UserBadges(userID int64) ([]Badge, error)
+}
+
+// An XPService stores the experience that users have earned.
+type XPService interface {
+	// PlayerXP returns the XP a user has earned. This returns nil if the user has never earned any.
+	PlayerXP(userID int64) (*PlayerXP, error)
+
+	// AddXP adds XP to a user and returns the XP that was actually added along with their new
+	// total. xp is always added, but soloXP is XP earned from a solo game that is only added up to
+	// what is left of soloCap for the given day. The cap is checked while the user's XP is locked
+	// so that solo games finishing at the same time can't go over it.
+	AddXP(userID int64, xp int64, soloXP int64, soloCap int64, day time.Time) (added int64, total int64, err error)
+}
+
+// A LeaderboardService contains methods for ranking users on leaderboards.
+type LeaderboardService interface {
+	// Leaderboard returns a page of a leaderboard ordered by rank.
+	Leaderboard(query LeaderboardQuery, limit int, offset int) ([]LeaderboardEntry, error)
+
+	// LeaderboardEntry returns a user's place on a leaderboard. This returns nil if the user
+	// is not on the leaderboard.
+	LeaderboardEntry(query LeaderboardQuery, userID int64) (*LeaderboardEntry, error)
+
+	// RefreshLeaderboards recalculates leaderboards that are not updated as soon as games finish.
+	RefreshLeaderboards() error
 }
 
 // A GameService is a service responsible for coordinating running games,
@@ -165,6 +970,10 @@ var ErrUserNotFound = errors.New("user not found for credentials")
 // login.
 var ErrIncorrectPassword = errors.New("password provided does not match user password")
 
+// ErrRecentLoginRequired is returned when a user without a password makes a change to their account
+// that needs them to confirm who they are, but didn't log in recently enough to do it.
+var ErrRecentLoginRequired = errors.New("user has to log in again to make this change")
+
 // ErrTokenExpired is an error retruned when a method that required a vlaid auth or refresh token
 // finds that a given token is no longer valid.
 var ErrTokenExpired = errors.New("token is expired")
@@ -172,6 +981,38 @@ var ErrTokenExpired = errors.New("token is expired")
 // ErrTokenNotFound is an error returned when an auth or refresh token cannot be found in the database.
 var ErrTokenNotFound = errors.New("token was not found")
 
+// ErrTokenReused is an error returned when a refresh token that has already been exchanged for
+// a new token pair is used again.
+var ErrTokenReused = errors.New("refresh token was already used")
+
+// ErrNotGuest is an error returned when an action that is only for guests is attempted by a registered user.
+var ErrNotGuest = errors.New("user is not a guest")
+
+// ErrEmailAlreadyVerified is returned when trying to verify an email address that is already verified.
+var ErrEmailAlreadyVerified = errors.New("email address is already verified")
+
+// ErrRateLimited is returned when an action has been attempted too many times recently.
+var ErrRateLimited = errors.New("too many attempts, try again later")
+
+// ErrIdentityInUse is returned when linking an external account that is already linked to a user.
+var ErrIdentityInUse = errors.New("external identity is already linked to a user")
+
+// ErrIncorrectTwoFactorCode is returned when a code from an authenticator app or a recovery code is wrong.
+var ErrIncorrectTwoFactorCode = errors.New("two-factor authentication code is incorrect")
+
+// ErrTwoFactorEnabled is returned when enabling two-factor authentication for a user that already has it.
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+
+// ErrTwoFactorNotEnabled is returned when a user doesn't have two-factor authentication set up.
+var ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+
+// ErrLastAdmin is returned when changing the role of the only admin, which would leave nobody able
+// to give out roles.
+var ErrLastAdmin = errors.New("cannot change the role of the last admin")
+
+// ErrSessionNotFound is an error returned when a session cannot be found.
+var ErrSessionNotFound = errors.New("session was not found")
+
 // ErrInvalidToken is an error returned when a provided auth token has an invalid format.
 var ErrInvalidToken = errors.New("malformed token")
 
@@ -192,3 +1033,41 @@ func NewGuestUser(guestID null.Int64) *User {
 		GuestID:  guestID,
 	}
 }
+
+// Valid returns true if the role is one of Roles.
+func (r Role) Valid() bool {
+	for _, role := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Can returns true if the role gives a permission.
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Can returns true if the user's role gives a permission. Guests can't do anything that needs a
+// permission.
+func (u *User) Can(permission Permission) bool {
+	return !u.Guest && u.Role.Can(permission)
+}
+
+// CurrentAsOf returns the number of days in a row that the user has played the daily challenge as
+// of the given UTC day. A streak is broken once a full day has passed without the user playing.
+func (s *DailyStreak) CurrentAsOf(day time.Time) int {
+	if s.LastDay.IsZero() {
+		return 0
+	}
+	if s.LastDay.UTC().Truncate(24 * time.Hour).Before(day.UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)) {
+		return 0
+	}
+	return s.Current
+}
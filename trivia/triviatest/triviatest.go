@@ -0,0 +1,165 @@
+// Package triviatest provides in-memory services from the trivia package for tests.
+package triviatest
+
+import (
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+)
+
+// TokenService is an in-memory trivia.AuthTokenService. Tokens are stored by their token string and
+// sessions by their family.
+type TokenService struct {
+	trivia.AuthTokenService
+	Auth     map[string]*trivia.AuthToken
+	Refresh  map[string]*trivia.RefreshToken
+	Sessions map[string]*trivia.Session
+
+	// Users are the users that auth tokens belong to by ID, for GetAuthTokenAndUser.
+	Users map[int64]*trivia.User
+}
+
+// NewTokenService creates an empty token service.
+func NewTokenService() *TokenService {
+	return &TokenService{
+		Auth:     make(map[string]*trivia.AuthToken),
+		Refresh:  make(map[string]*trivia.RefreshToken),
+		Sessions: make(map[string]*trivia.Session),
+		Users:    make(map[int64]*trivia.User),
+	}
+}
+
+func (s *TokenService) AuthTokenExists(token string) (bool, error) {
+	_, ok := s.Auth[token]
+	return ok, nil
+}
+
+func (s *TokenService) RefreshTokenExists(token string) (bool, error) {
+	_, ok := s.Refresh[token]
+	return ok, nil
+}
+
+func (s *TokenService) AuthTokenByString(token string) (*trivia.AuthToken, error) {
+	return s.Auth[token], nil
+}
+
+func (s *TokenService) RefreshTokenByString(token string) (*trivia.RefreshToken, error) {
+	if t, ok := s.Refresh[token]; ok {
+		copied := *t
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (s *TokenService) GetAuthTokenAndUser(token string) (*trivia.AuthToken, *trivia.User, error) {
+	auth, ok := s.Auth[token]
+	if !ok {
+		return nil, nil, nil
+	}
+	if auth.GuestID.Valid {
+		return auth, trivia.NewGuestUser(auth.GuestID), nil
+	}
+	user, ok := s.Users[auth.UserID.Int64]
+	if !ok {
+		return auth, nil, trivia.ErrUserNotFound
+	}
+	return auth, user, nil
+}
+
+func (s *TokenService) CreateTokenPair(auth *trivia.AuthToken, refresh *trivia.RefreshToken, client trivia.ClientInfo) error {
+	s.Sessions[refresh.Family] = &trivia.Session{
+		ID:        int64(len(s.Sessions) + 1),
+		Family:    refresh.Family,
+		UserID:    refresh.UserID,
+		GuestID:   refresh.GuestID,
+		CreatedAt: time.Now(),
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+	}
+	s.insertTokenPair(auth, refresh)
+	return nil
+}
+
+func (s *TokenService) insertTokenPair(auth *trivia.AuthToken, refresh *trivia.RefreshToken) {
+	s.Auth[auth.Token] = auth
+	s.Refresh[refresh.Token] = refresh
+}
+
+func (s *TokenService) RotateTokenPair(used *trivia.RefreshToken, auth *trivia.AuthToken, refresh *trivia.RefreshToken, client trivia.ClientInfo) (bool, error) {
+	stored, ok := s.Refresh[used.Token]
+	if !ok || stored.Used {
+		return false, nil
+	}
+	stored.Used = true
+	delete(s.Auth, used.AuthToken)
+	if session, ok := s.Sessions[used.Family]; ok {
+		session.UserAgent = client.UserAgent
+		session.IP = client.IP
+	}
+	s.insertTokenPair(auth, refresh)
+	return true, nil
+}
+
+func (s *TokenService) UserSessions(userID int64) ([]trivia.Session, error) {
+	sessions := make([]trivia.Session, 0)
+	for _, session := range s.Sessions {
+		if session.UserID.Valid && session.UserID.Int64 == userID {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *TokenService) SessionByID(id int64) (*trivia.Session, error) {
+	for _, session := range s.Sessions {
+		if session.ID == id {
+			return session, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *TokenService) RevokeUserSessions(userID int64) ([]string, error) {
+	families := make([]string, 0)
+	for family, session := range s.Sessions {
+		if session.UserID.Valid && session.UserID.Int64 == userID {
+			families = append(families, family)
+		}
+	}
+	for _, family := range families {
+		s.RevokeTokenFamily(family)
+	}
+	return families, nil
+}
+
+func (s *TokenService) RevokeTokenFamily(family string) error {
+	for token, t := range s.Auth {
+		if t.Family == family {
+			delete(s.Auth, token)
+		}
+	}
+	for token, t := range s.Refresh {
+		if t.Family == family {
+			delete(s.Refresh, token)
+		}
+	}
+	delete(s.Sessions, family)
+	return nil
+}
+
+func (s *TokenService) DeleteExpiredTokens(before time.Time, limit int) (int64, int64, error) {
+	var authDeleted, refreshDeleted int64
+	for token, t := range s.Auth {
+		if authDeleted < int64(limit) && t.ExpiresAt.Before(before) {
+			delete(s.Auth, token)
+			authDeleted++
+		}
+	}
+	for token, t := range s.Refresh {
+		if refreshDeleted < int64(limit) && t.ExpiresAt.Before(before) {
+			delete(s.Refresh, token)
+			refreshDeleted++
+		}
+	}
+	return authDeleted, refreshDeleted, nil
+}
@@ -0,0 +1,76 @@
+package xp
+
+import "math"
+
+// Curve decides how much XP is needed to reach each level. The total XP needed to reach level L
+// is Base * (L-1)^Exponent so level 1 starts at 0 XP and every level takes a little longer
+// than the one before it when Exponent is greater than 1.
+type Curve struct {
+	Base     float64
+	Exponent float64
+}
+
+// DefaultCurve returns the level curve used when none is configured.
+func DefaultCurve() Curve {
+	return Curve{Base: 100, Exponent: 1.5}
+}
+
+// TotalForLevel returns the total XP needed to reach a level.
+func (c Curve) TotalForLevel(level int) int64 {
+	if level <= 1 {
+		return 0
+	}
+	return int64(math.Ceil(c.Base * math.Pow(float64(level-1), c.Exponent)))
+}
+
+// Progress is a user's progress towards their next level.
+type Progress struct {
+	Level int
+	XP    int64
+
+	// LevelXP is the total XP at which the current level started and NextLevelXP is the
+	// total XP at which the next level starts.
+	LevelXP     int64
+	NextLevelXP int64
+}
+
+// Fraction returns how far the user is through their current level from 0 to 1.
+func (p Progress) Fraction() float64 {
+	if p.NextLevelXP <= p.LevelXP {
+		return 0
+	}
+	return float64(p.XP-p.LevelXP) / float64(p.NextLevelXP-p.LevelXP)
+}
+
+// Valid returns true if every level of the curve takes some XP to reach.
+func (c Curve) Valid() bool {
+	return c.Base > 0 && c.Exponent > 0
+}
+
+// Progress returns the level and level progress of a user with the given total XP.
+// Everyone stays at level 1 with an invalid curve.
+func (c Curve) Progress(xp int64) Progress {
+	if xp < 0 {
+		xp = 0
+	}
+
+	if !c.Valid() {
+		return Progress{Level: 1, XP: xp}
+	}
+
+	// start from the inverse of the curve and correct for rounding.
+	level := int(math.Pow(float64(xp)/c.Base, 1/c.Exponent)) + 1
+	for level > 1 && c.TotalForLevel(level) > xp {
+		level--
+	}
+	for c.TotalForLevel(level+1) <= xp {
+		level++
+	}
+
+	return Progress{
+		Level:       level,
+		XP:          xp,
+		LevelXP:     c.TotalForLevel(level),
+		NextLevelXP: c.TotalForLevel(level + 1),
+	}
+}
@@ -0,0 +1,64 @@
+package xp
+
+import "testing"
+
+func TestTotalForLevel(t *testing.T) {
+	curve := Curve{Base: 100, Exponent: 2}
+	expected := []int64{0, 0, 100, 400, 900}
+	for level, total := range expected {
+		if actual := curve.TotalForLevel(level); actual != total {
+			t.Errorf("level %d: expected a total of %d XP but got %d", level, total, actual)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	curve := Curve{Base: 100, Exponent: 2}
+	tests := []struct {
+		xp       int64
+		level    int
+		fraction float64
+	}{
+		{0, 1, 0},
+		{50, 1, 0.5},
+		{99, 1, 0.99},
+		{100, 2, 0},
+		{250, 2, 0.5},
+		{400, 3, 0},
+		{899, 3, 0.998},
+		{900, 4, 0},
+	}
+
+	for _, test := range tests {
+		p := curve.Progress(test.xp)
+		if p.Level != test.level {
+			t.Errorf("%d XP: expected level %d but got %d", test.xp, test.level, p.Level)
+		}
+		if p.LevelXP > test.xp || p.NextLevelXP <= test.xp {
+			t.Errorf("%d XP: expected XP to be inside of the level but got %+v", test.xp, p)
+		}
+		if f := p.Fraction(); f < test.fraction-0.001 || f > test.fraction+0.001 {
+			t.Errorf("%d XP: expected a progress of %.3f but got %.3f", test.xp, test.fraction, f)
+		}
+	}
+}
+
+func TestProgressMatchesCurve(t *testing.T) {
+	// rounding in the inverse of the curve should never put anyone on the wrong level.
+	curve := DefaultCurve()
+	for level := 1; level < 200; level++ {
+		total := curve.TotalForLevel(level)
+		if p := curve.Progress(total); p.Level != level {
+			t.Errorf("expected %d XP to be level %d but got %d", total, level, p.Level)
+		}
+		if p := curve.Progress(total - 1); level > 1 && p.Level != level-1 {
+			t.Errorf("expected %d XP to be level %d but got %d", total-1, level-1, p.Level)
+		}
+	}
+}
+
+func TestInvalidCurve(t *testing.T) {
+	if p := (Curve{}).Progress(5000); p.Level != 1 {
+		t.Errorf("expected an invalid curve to keep everyone at level 1 but got %d", p.Level)
+	}
+}
@@ -0,0 +1,165 @@
+package xp
+
+import (
+	"time"
+
+	"github.com/expixel/actual-trivia-server/eplog"
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/game/message"
+)
+
+var logger = eplog.NewPrefixLogger("xp")
+
+// Rewards are the amounts of XP awarded for each part of a finished game.
+type Rewards struct {
+	// Participation is awarded for finishing a game after answering at least one question.
+	Participation int64
+
+	// CorrectAnswer is awarded for every question answered correctly.
+	CorrectAnswer int64
+
+	// Win is awarded for winning a game against at least one other player.
+	Win int64
+
+	// Achievement is awarded for every achievement unlocked by a game.
+	Achievement int64
+
+	// SoloDailyCap is the most XP a user can earn from solo games in a single day (UTC).
+	// A game is solo if it could start with a single participant or if fewer than two
+	// registered users took part. Guests don't count since anyone can open another tab as a
+	// guest, and games with MinParticipants=1 could otherwise be played back to back for as
+	// much XP as anyone wants. XP from achievements doesn't count towards the cap since
+	// achievements are only unlocked once.
+	SoloDailyCap int64
+}
+
+// DefaultRewards returns the XP rewards used when none are configured.
+func DefaultRewards() Rewards {
+	return Rewards{
+		Participation: 20,
+		CorrectAnswer: 5,
+		Win:           25,
+		Achievement:   50,
+		SoloDailyCap:  100,
+	}
+}
+
+// A Notifier sends messages to users that are connected to a game.
+type Notifier interface {
+	SendToUser(gameID string, userID int64, msg interface{}) error
+}
+
+// Awarder awards XP to registered players from the results of their games.
+type Awarder struct {
+	service  trivia.XPService
+	curve    Curve
+	rewards  Rewards
+	notifier Notifier
+}
+
+// NewAwarder creates a new awarder that stores XP using the given service. XP gained from a game
+// is sent to its participants using the notifier.
+func NewAwarder(service trivia.XPService, curve Curve, rewards Rewards, notifier Notifier) *Awarder {
+	return &Awarder{service: service, curve: curve, rewards: rewards, notifier: notifier}
+}
+
+// Gain is the XP that a participant earned from a single game.
+type Gain struct {
+	// Game is the XP earned from playing the game and Achievements is the XP earned from
+	// achievements that the game unlocked.
+	Game         int64
+	Achievements int64
+
+	// Solo is true if the game XP counts towards the solo daily cap.
+	Solo bool
+}
+
+// GameXP calculates the XP a participant earned from a finished game before any caps are applied.
+func (a *Awarder) GameXP(result *trivia.GameResult, participant *trivia.GameParticipantResult, achievements int) Gain {
+	gain := Gain{
+		Achievements: a.rewards.Achievement * int64(achievements),
+		Solo:         isSolo(result),
+	}
+
+	if participant.Questions() > 0 {
+		gain.Game += a.rewards.Participation
+	}
+	gain.Game += a.rewards.CorrectAnswer * int64(participant.CorrectAnswers)
+	if result.Won(participant) {
+		gain.Game += a.rewards.Win
+	}
+	return gain
+}
+
+// isSolo returns true if the XP from a game counts towards the solo daily cap.
+func isSolo(result *trivia.GameResult) bool {
+	if result.MinParticipants < 2 {
+		return true
+	}
+
+	registered := 0
+	for _, participant := range result.Participants {
+		if !participant.Guest {
+			registered++
+		}
+	}
+	return registered < 2
+}
+
+// ProcessGameResult awards XP to the registered participants of a finished game and lets them
+// know how much they earned with a separate XPGained message, since XP is only awarded once
+// the game's results have already been sent. achievements is the number of achievements that each user unlocked
+// from the game. Errors are only logged.
+func (a *Awarder) ProcessGameResult(result *trivia.GameResult, achievements map[int64]int) {
+	for idx := range result.Participants {
+		participant := &result.Participants[idx]
+		if participant.Guest {
+			continue
+		}
+
+		gain := a.GameXP(result, participant, achievements[participant.UserID])
+		gained, total, err := a.Award(participant.UserID, gain, result.FinishedAt)
+		if err != nil {
+			logger.Error("error occurred while awarding XP to user %d for game %s: %s", participant.UserID, result.GameID, err)
+			continue
+		}
+
+		if a.notifier == nil {
+			continue
+		}
+
+		progress := a.curve.Progress(total)
+		a.notifier.SendToUser(result.GameID, participant.UserID, &message.XPGained{
+			Gained:      gained,
+			Capped:      gained < gain.Game+gain.Achievements,
+			XP:          progress.XP,
+			Level:       progress.Level,
+			LevelXP:     progress.LevelXP,
+			NextLevelXP: progress.NextLevelXP,
+			LevelUp:     a.curve.Progress(total-gained).Level < progress.Level,
+		})
+	}
+}
+
+// Award adds XP to a user, applying the solo daily cap, and returns the XP that was actually
+// gained along with the user's new total.
+func (a *Awarder) Award(userID int64, gain Gain, at time.Time) (gained int64, total int64, err error) {
+	day := at.UTC().Truncate(24 * time.Hour)
+	if gain.Solo {
+		return a.service.AddXP(userID, gain.Achievements, gain.Game, a.rewards.SoloDailyCap, day)
+	}
+	return a.service.AddXP(userID, gain.Game+gain.Achievements, 0, a.rewards.SoloDailyCap, day)
+}
+
+// Progress returns a user's level progress. Users that have never earned XP are at level 1.
+func (a *Awarder) Progress(userID int64) (Progress, error) {
+	current, err := a.service.PlayerXP(userID)
+	if err != nil {
+		return Progress{}, err
+	}
+
+	if current == nil {
+		return a.curve.Progress(0), nil
+	}
+	return a.curve.Progress(current.XP), nil
+}
@@ -0,0 +1,189 @@
+package xp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/expixel/actual-trivia-server/trivia"
+	"github.com/expixel/actual-trivia-server/trivia/game/message"
+)
+
+type fakeXPService struct {
+	players map[int64]*trivia.PlayerXP
+}
+
+func (s *fakeXPService) PlayerXP(userID int64) (*trivia.PlayerXP, error) {
+	return s.players[userID], nil
+}
+
+func (s *fakeXPService) AddXP(userID int64, xp int64, soloXP int64, soloCap int64, day time.Time) (int64, int64, error) {
+	p, ok := s.players[userID]
+	if !ok {
+		p = &trivia.PlayerXP{UserID: userID, SoloDay: day}
+		s.players[userID] = p
+	}
+	if !p.SoloDay.Equal(day) {
+		p.SoloDay = day
+		p.SoloXP = 0
+	}
+
+	if remaining := soloCap - p.SoloXP; soloXP > remaining {
+		soloXP = remaining
+	}
+	if soloXP < 0 {
+		soloXP = 0
+	}
+	p.SoloXP += soloXP
+	p.XP += xp + soloXP
+	return xp + soloXP, p.XP, nil
+}
+
+type fakeNotifier struct {
+	sent  map[int64][]interface{}
+	games map[int64][]string
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{sent: make(map[int64][]interface{}), games: make(map[int64][]string)}
+}
+
+func (n *fakeNotifier) SendToUser(gameID string, userID int64, msg interface{}) error {
+	n.sent[userID] = append(n.sent[userID], msg)
+	n.games[userID] = append(n.games[userID], gameID)
+	return nil
+}
+
+func soloResult(finishedAt time.Time) *trivia.GameResult {
+	return &trivia.GameResult{
+		GameID:          "solo",
+		FinishedAt:      finishedAt,
+		MinParticipants: 1,
+		Participants: []trivia.GameParticipantResult{{
+			UserID:         1,
+			Placement:      1,
+			CorrectAnswers: 10,
+			Categories:     []trivia.CategoryScore{{Category: "Science", CorrectAnswers: 10, Questions: 10}},
+		}},
+	}
+}
+
+func TestGameXP(t *testing.T) {
+	awarder := NewAwarder(&fakeXPService{}, DefaultCurve(), DefaultRewards(), nil)
+	result := &trivia.GameResult{
+		MinParticipants: 2,
+		Participants: []trivia.GameParticipantResult{
+			{UserID: 1, Placement: 1, CorrectAnswers: 4, Categories: []trivia.CategoryScore{{Questions: 5}}},
+			{UserID: 2, Placement: 2, CorrectAnswers: 0},
+		},
+	}
+
+	// participation + 4 correct answers + a win and 2 achievements.
+	gain := awarder.GameXP(result, &result.Participants[0], 2)
+	if gain.Game != 20+4*5+25 || gain.Achievements != 100 || gain.Solo {
+		t.Errorf("unexpected XP for the winner: %+v", gain)
+	}
+
+	// nothing is earned without answering anything.
+	if gain := awarder.GameXP(result, &result.Participants[1], 0); gain.Game != 0 || gain.Achievements != 0 {
+		t.Errorf("unexpected XP for a participant that didn't answer anything: %+v", gain)
+	}
+}
+
+func TestSoloDailyCap(t *testing.T) {
+	service := &fakeXPService{players: make(map[int64]*trivia.PlayerXP)}
+	notifier := newFakeNotifier()
+	awarder := NewAwarder(service, DefaultCurve(), DefaultRewards(), notifier)
+
+	// a solo game is worth 70 XP (no win bonus without an opponent) so the cap of 100
+	// is hit partway through the second game.
+	day := time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)
+	awarder.ProcessGameResult(soloResult(day), nil)
+	awarder.ProcessGameResult(soloResult(day.Add(time.Hour)), nil)
+	awarder.ProcessGameResult(soloResult(day.Add(2*time.Hour)), map[int64]int{1: 1})
+
+	gains := make([]int64, 0)
+	for _, msg := range notifier.sent[1] {
+		gains = append(gains, msg.(*message.XPGained).Gained)
+	}
+	if len(gains) != 3 || gains[0] != 70 || gains[1] != 30 || gains[2] != 50 {
+		t.Fatalf("expected gains of 70, 30 and 50 (achievements aren't capped) but got %v", gains)
+	}
+	second := notifier.sent[1][1].(*message.XPGained)
+	if !second.Capped || second.XP != 100 || second.Level != 2 || !second.LevelUp {
+		t.Errorf("expected the second game to be capped and reach level 2 but got %+v", second)
+	}
+
+	// the cap resets the next day.
+	awarder.ProcessGameResult(soloResult(day.Add(24*time.Hour)), nil)
+	last := notifier.sent[1][3].(*message.XPGained)
+	if last.Gained != 70 || last.XP != 220 {
+		t.Errorf("expected 70 XP the next day for a total of 220 but got %+v", last)
+	}
+	if last.Level != 2 || last.LevelUp || last.Capped {
+		t.Errorf("expected 220 XP to still be level 2 but got %+v", last)
+	}
+}
+
+func TestSoloDailyCapCountsRegisteredPlayers(t *testing.T) {
+	service := &fakeXPService{players: make(map[int64]*trivia.PlayerXP)}
+	awarder := NewAwarder(service, DefaultCurve(), DefaultRewards(), nil)
+
+	// a second guest tab doesn't make a game that could start alone stop being solo.
+	day := time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)
+	result := soloResult(day)
+	result.Participants = append(result.Participants, trivia.GameParticipantResult{UserID: -3, Guest: true, Placement: 2})
+	for i := 0; i < 3; i++ {
+		awarder.ProcessGameResult(result, nil)
+	}
+	if xp := service.players[1].XP; xp != DefaultRewards().SoloDailyCap {
+		t.Errorf("expected a user playing with a guest to hit the solo cap of %d but got %d XP",
+			DefaultRewards().SoloDailyCap, xp)
+	}
+
+	// games that need two players are still solo if the only other player is a guest.
+	result.MinParticipants = 2
+	if gain := awarder.GameXP(result, &result.Participants[0], 0); !gain.Solo {
+		t.Errorf("expected a game against a guest to be solo")
+	}
+
+	result.Participants[1] = trivia.GameParticipantResult{UserID: 2, Placement: 2}
+	if gain := awarder.GameXP(result, &result.Participants[0], 0); gain.Solo {
+		t.Errorf("expected a game between two registered users that needed two players not to be solo")
+	}
+	result.MinParticipants = 1
+	if gain := awarder.GameXP(result, &result.Participants[0], 0); !gain.Solo {
+		t.Errorf("expected a game that could start with one player to be solo")
+	}
+}
+
+func TestXPGainedMessages(t *testing.T) {
+	service := &fakeXPService{players: make(map[int64]*trivia.PlayerXP)}
+	notifier := newFakeNotifier()
+	awarder := NewAwarder(service, DefaultCurve(), DefaultRewards(), notifier)
+
+	awarder.ProcessGameResult(&trivia.GameResult{
+		GameID:          "game",
+		FinishedAt:      time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC),
+		MinParticipants: 2,
+		Participants: []trivia.GameParticipantResult{
+			{UserID: 1, Placement: 1, CorrectAnswers: 2, Categories: []trivia.CategoryScore{{Questions: 2}}},
+			{UserID: -4, Guest: true, Placement: 2},
+			{UserID: 2, Placement: 3, CorrectAnswers: 0, Categories: []trivia.CategoryScore{{Questions: 2}}},
+		},
+	}, map[int64]int{2: 1})
+
+	// every registered participant is sent exactly one message for the game, and guests none.
+	if len(notifier.sent[-4]) != 0 {
+		t.Errorf("expected guests not to be sent XP but got %v", notifier.sent[-4])
+	}
+	expected := map[int64]int64{1: 20 + 2*5 + 25, 2: 20 + 50}
+	for userID, gained := range expected {
+		if len(notifier.sent[userID]) != 1 || notifier.games[userID][0] != "game" {
+			t.Fatalf("expected user %d to be sent one message for the game but got %v", userID, notifier.games[userID])
+		}
+		msg := notifier.sent[userID][0].(*message.XPGained)
+		if msg.Gained != gained || msg.XP != gained || msg.Capped {
+			t.Errorf("expected user %d to gain %d XP but got %+v", userID, gained, msg)
+		}
+	}
+}